@@ -25,24 +25,34 @@ func (tree *BTreeIndex) Page(page_number uint64) *IndexPage {
 	return i
 }
 
+// 所有叶子记录放到一个slice里, 大表用Record_Iterator
 func (tree *BTreeIndex) Each_Record(dh *DataDictionary) []*Record {
 	var records []*Record
-	pages_at_level0 := tree.Each_Page_At_Level(0, dh)
-
-	for i := 0; i < len(pages_at_level0); i++ {
-		res := pages_at_level0[i].each_record()
-		for j := 0; j < len(res); j++ {
-			records = append(records, res[j])
+	it := tree.Record_Iterator(dh)
+	for {
+		record, err := it.Next()
+		if err != nil {
+			Log.Info("%v\n", err)
+			continue
+		}
+		if record == nil {
+			break
 		}
+		records = append(records, record)
 	}
-
 	return records
 }
 
 func (tree *BTreeIndex) Each_Page_At_Level(level int, dh *DataDictionary) []*IndexPage {
-	min_page := tree.Min_Page_At_Level(level)
-	min_page.dh = dh
-	pages := tree.Each_Page_From(min_page)
+	var pages []*IndexPage
+	it := tree.Page_Iterator(level, dh)
+	index, err := it.Next()
+	for ; index != nil; index, err = it.Next() {
+		pages = append(pages, index)
+	}
+	if err != nil {
+		Log.Info("%v\n", err)
+	}
 	return pages
 }
 
@@ -50,7 +60,7 @@ func (tree *BTreeIndex) Each_Page_From(idx *IndexPage) []*IndexPage {
 	var pages []*IndexPage
 	for {
 
-		if idx.Page.FileHeader.Page_type == 17855 {
+		if idx.Is_Index_Page() {
 			pages = append(pages, idx)
 		}
 		if idx.Page.FileHeader.Next == 4294967295 {
@@ -87,6 +97,23 @@ func (tree *BTreeIndex) Min_Page_At_Level(level int) *IndexPage {
 	return root_index_page
 }
 
+// 每层最右边的页, 沿着每个页的最大记录往下找
+func (tree *BTreeIndex) Max_Page_At_Level(level int) *IndexPage {
+	index := tree.Root
+	for index.PageHeader.Level > uint64(level) {
+		user_record, ok := index.Max_Record().record.(*UserRecord)
+		if !ok {
+			break
+		}
+		child := tree.Page(user_record.Child_page_number)
+		if child.PageHeader.Level+1 != index.PageHeader.Level {
+			break
+		}
+		index = child
+	}
+	return index
+}
+
 func (tree *BTreeIndex) Min_Record_In_Index(level int) *Record {
 	return tree.Min_Page_At_Level(0).Min_Record()
 }
@@ -0,0 +1,487 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// 离线统计索引的基数和字段的直方图, 用来和mysql.innodb_index_stats, information_schema.column_statistics对比
+// sample_pages是0或者叶子页不多的时候读所有叶子页, 算出来的是准确值
+// 否则和innodb_stats_persistent_sample_pages一样从root页随机往下走到叶子页, 用采样的页估算
+// delete mark的记录不算, 和dict_stats_analyze_index_level一样
+
+// mysql.innodb_index_stats中的一行, n_leaf_pages和size的sample_size是null
+type InnodbIndexStat struct {
+	Stat_name        string  `json:"stat_name"`
+	Stat_value       uint64  `json:"stat_value"`
+	Sample_size      *uint64 `json:"sample_size"`
+	Stat_description string  `json:"stat_description"`
+}
+
+type IndexCardinality struct {
+	Index            string             `json:"index"`
+	Root_page_number uint64             `json:"root_page_number"`
+	Method           string             `json:"method"` // exact或者sample
+	Seed             int64              `json:"seed,omitempty"`
+	N_leaf_pages     uint64             `json:"n_leaf_pages"`
+	Size             uint64             `json:"size"` // B+树上的页数, InnoDB统计的是段中分配的页, 会大一些
+	Sampled_pages    uint64             `json:"sampled_pages"`
+	Rows             uint64             `json:"rows"`
+	Stats            []*InnodbIndexStat `json:"stats"`
+}
+
+type HistogramBucket struct {
+	Lower_inclusive      interface{} `json:"lower_inclusive"`
+	Upper_inclusive      interface{} `json:"upper_inclusive"`
+	Cumulative_frequency float64     `json:"cumulative_frequency"`
+	Num_distinct         uint64      `json:"num_distinct"`
+}
+
+// 等高直方图, 相同的值不会分到两个桶里, 和ANALYZE TABLE ... UPDATE HISTOGRAM一样
+type ColumnHistogram struct {
+	Column                      string             `json:"column"`
+	Histogram_type              string             `json:"histogram-type"`
+	Number_of_buckets_specified int                `json:"number-of-buckets-specified"`
+	Sampling_rate               float64            `json:"sampling-rate"`
+	Null_values                 float64            `json:"null-values"`
+	Rows                        uint64             `json:"rows"`
+	Buckets                     []*HistogramBucket `json:"buckets"`
+}
+
+// 叶子页数和B+树的页数, 叶子页数是level 1上node pointer的个数, 不用读叶子页
+func (tree *BTreeIndex) Page_Counts() (uint64, uint64, error) {
+	height := tree.Root.PageHeader.Level + 1
+	if height == 1 {
+		return 1, 1, nil
+	}
+	var n_leaf_pages, size uint64
+	for level := uint64(1); level < height; level++ {
+		it := tree.Page_Iterator(int(level), nil)
+		index, err := it.Next()
+		for ; index != nil; index, err = it.Next() {
+			size++
+			if level == 1 {
+				n_leaf_pages += index.PageHeader.N_recs
+			}
+		}
+		if err != nil {
+			return n_leaf_pages, size + n_leaf_pages, err
+		}
+	}
+	return n_leaf_pages, size + n_leaf_pages, nil
+}
+
+// 页上没有delete mark的记录
+func (index *IndexPage) live_records() ([]*Record, error) {
+	var records []*Record
+	it := index.Record_Iterator()
+	record, err := it.Next()
+	for ; record != nil; record, err = it.Next() {
+		if !record.Is_Deleted() {
+			records = append(records, record)
+		}
+	}
+	return records, err
+}
+
+// 从root页往下, 每层随机选一个node pointer, 参考dict_stats_analyze_index_below_cur
+// 同一个叶子页只算一次, 叶子页不够的时候少采几个
+func (tree *BTreeIndex) sample_leaf_pages(n uint64, rng *rand.Rand) ([]*IndexPage, error) {
+	var pages []*IndexPage
+	seen := make(map[uint64]bool)
+	for dive := uint64(0); dive < 4*n && uint64(len(pages)) < n; dive++ {
+		index := tree.Root
+		for !index.IsLeaf() {
+			records, err := index.live_records()
+			if err != nil {
+				return pages, err
+			}
+			if len(records) == 0 {
+				return pages, fmt.Errorf("no node pointer on page %d", index.Page.Page_number)
+			}
+			user_record, ok := records[rng.Intn(len(records))].record.(*UserRecord)
+			if !ok {
+				return pages, fmt.Errorf("bad node pointer on page %d", index.Page.Page_number)
+			}
+			child := tree.Page(user_record.Child_page_number)
+			if child.Page.FileHeader.Page_type != FIL_PAGE_INDEX || child.PageHeader.Level+1 != index.PageHeader.Level {
+				return pages, fmt.Errorf("page %d is not a child of page %d", child.Page.Page_number, index.Page.Page_number)
+			}
+			index = child
+		}
+		if seen[index.Page.Page_number] {
+			continue
+		}
+		seen[index.Page.Page_number] = true
+		pages = append(pages, index)
+	}
+	return pages, nil
+}
+
+// 每个key前缀不同值的个数, 和前一条记录比较
+type prefixCounter struct {
+	n_diff []uint64
+	rows   uint64
+	last   []interface{}
+	err    error // key比较不了的时候第一个错误
+}
+
+func newPrefixCounter(n int) *prefixCounter {
+	return &prefixCounter{n_diff: make([]uint64, n)}
+}
+
+func (c *prefixCounter) same_prefix(a []interface{}, b []interface{}, n int) bool {
+	if len(a) < n || len(b) < n {
+		return false
+	}
+	r, err := Compare_Keys(a[:n], b[:n])
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return err == nil && r == 0
+}
+
+func (c *prefixCounter) add(key []interface{}) {
+	c.rows++
+	for i := range c.n_diff {
+		if c.last == nil || !c.same_prefix(c.last, key, i+1) {
+			c.n_diff[i]++
+		}
+	}
+	c.last = key
+}
+
+// 页上最后一组值在下一个页接着出现的时候不算, 下一个页会算
+func (c *prefixCounter) continues(next []interface{}) {
+	for i := range c.n_diff {
+		if c.last != nil && c.n_diff[i] > 0 && c.same_prefix(c.last, next, i+1) {
+			c.n_diff[i]--
+		}
+	}
+}
+
+// 下一个叶子页上第一条没有delete mark的记录的key
+func (tree *BTreeIndex) next_page_key(index *IndexPage) []interface{} {
+	for next := index.Page.FileHeader.Next; next != FIL_NULL && next != 0; {
+		page := tree.Page(next)
+		if page.Page.FileHeader.Page_type != FIL_PAGE_INDEX || !page.IsLeaf() {
+			return nil
+		}
+		records, _ := page.live_records()
+		if len(records) > 0 {
+			return records[0].Key()
+		}
+		next = page.Page.FileHeader.Next
+	}
+	return nil
+}
+
+func sample_rng(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// td是这个索引的describer, 二级索引用Secondary_Describer, 用来给n_diff_pfx起名字
+func (tree *BTreeIndex) Cardinality(td *TableDescriber, index_name string, sample_pages uint64, seed int64) (*IndexCardinality, error) {
+	names := td.Key_Names()
+	result := &IndexCardinality{Index: index_name, Root_page_number: tree.Root.Page.Page_number, Method: "exact"}
+	n_leaf_pages, size, err := tree.Page_Counts()
+	result.N_leaf_pages, result.Size = n_leaf_pages, size
+	if err != nil {
+		return result, err
+	}
+
+	counter := newPrefixCounter(len(names))
+	if sample_pages == 0 || n_leaf_pages <= sample_pages {
+		it := tree.Record_Iterator(nil)
+		record, err := it.Next()
+		for ; record != nil; record, err = it.Next() {
+			if !record.Is_Deleted() {
+				counter.add(record.Key())
+			}
+		}
+		if err != nil {
+			return result, err
+		}
+		if counter.err != nil {
+			return result, counter.err
+		}
+		result.Sampled_pages = n_leaf_pages
+		result.Rows = counter.rows
+	} else {
+		result.Method, result.Seed = "sample", seed
+		pages, err := tree.sample_leaf_pages(sample_pages, sample_rng(seed))
+		if err != nil {
+			return result, err
+		}
+		sum := newPrefixCounter(len(names))
+		for _, index := range pages {
+			records, err := index.live_records()
+			if err != nil {
+				return result, err
+			}
+			page_counter := newPrefixCounter(len(names))
+			for _, record := range records {
+				page_counter.add(record.Key())
+			}
+			page_counter.continues(tree.next_page_key(index))
+			if page_counter.err != nil {
+				return result, page_counter.err
+			}
+			sum.rows += page_counter.rows
+			for i := range sum.n_diff {
+				sum.n_diff[i] += page_counter.n_diff[i]
+			}
+		}
+		result.Sampled_pages = uint64(len(pages))
+		// 采样页的平均值乘以叶子页数
+		estimate := func(v uint64) uint64 {
+			if result.Sampled_pages == 0 {
+				return 0
+			}
+			return uint64(math.Round(float64(v) * float64(n_leaf_pages) / float64(result.Sampled_pages)))
+		}
+		result.Rows = estimate(sum.rows)
+		for i := range counter.n_diff {
+			n_diff := estimate(sum.n_diff[i])
+			if i > 0 && n_diff < counter.n_diff[i-1] {
+				n_diff = counter.n_diff[i-1]
+			}
+			if n_diff == 0 && result.Rows > 0 {
+				n_diff = 1
+			}
+			if n_diff > result.Rows {
+				n_diff = result.Rows
+			}
+			counter.n_diff[i] = n_diff
+		}
+	}
+
+	for i, n_diff := range counter.n_diff {
+		sample_size := result.Sampled_pages
+		result.Stats = append(result.Stats, &InnodbIndexStat{Stat_name: fmt.Sprintf("n_diff_pfx%02d", i+1),
+			Stat_value: n_diff, Sample_size: &sample_size, Stat_description: strings.Join(names[:i+1], ",")})
+	}
+	result.Stats = append(result.Stats,
+		&InnodbIndexStat{Stat_name: "n_leaf_pages", Stat_value: n_leaf_pages, Stat_description: "Number of leaf pages in the index"},
+		&InnodbIndexStat{Stat_name: "size", Stat_value: size, Stat_description: "Number of pages in the index"})
+	return result, nil
+}
+
+// 不按索引顺序读的时候最多在内存里放这么多个值, 多了用蓄水池采样, 和histogram_generation_max_mem_size的作用一样
+const HISTOGRAM_MAX_VALUES = 100000
+
+// 按顺序一组一组地加入相同的值, 桶里的值够了就关掉这个桶
+type histogramBuilder struct {
+	result     *ColumnHistogram
+	per_bucket uint64
+	total      float64 // cumulative_frequency的分母, 采样的时候是按采样比例放大以后的行数
+	cumulative uint64
+	bucket     *HistogramBucket
+	count      uint64
+}
+
+// n是加入的值的个数, 采样的时候比non_null少, 每个桶大约放n/buckets个值
+func newHistogramBuilder(result *ColumnHistogram, buckets int, n uint64, non_null uint64) *histogramBuilder {
+	builder := &histogramBuilder{result: result, per_bucket: (n + uint64(buckets) - 1) / uint64(buckets), total: float64(result.Rows)}
+	if n != non_null {
+		builder.total = float64(n) * float64(result.Rows) / float64(non_null)
+	}
+	return builder
+}
+
+func (builder *histogramBuilder) add_group(value interface{}, n uint64) {
+	if builder.bucket == nil {
+		builder.bucket = &HistogramBucket{Lower_inclusive: value}
+		builder.count = 0
+	}
+	builder.bucket.Upper_inclusive = value
+	builder.bucket.Num_distinct++
+	builder.count += n
+	if builder.count >= builder.per_bucket {
+		builder.close()
+	}
+}
+
+// 和MySQL一样null单独算, 最后一个桶的cumulative_frequency是1 - null_values
+func (builder *histogramBuilder) close() {
+	if builder.bucket == nil {
+		return
+	}
+	builder.cumulative += builder.count
+	builder.bucket.Cumulative_frequency = float64(builder.cumulative) / builder.total
+	builder.result.Buckets = append(builder.result.Buckets, builder.bucket)
+	builder.bucket = nil
+}
+
+// column的等高直方图, column要在这个索引的记录里, 聚簇索引有所有字段
+// 读所有叶子页的时候, column是索引的第一个字段就按索引顺序边读边分桶, 不是的话最多采样HISTOGRAM_MAX_VALUES个值
+func (tree *BTreeIndex) Column_Histogram(td *TableDescriber, column string, buckets int, sample_pages uint64, seed int64) (*ColumnHistogram, error) {
+	if td.Column(column) == nil {
+		return nil, fmt.Errorf("column %s not found in %s", column, td.Name)
+	}
+	if buckets <= 0 {
+		return nil, fmt.Errorf("number of buckets must be positive")
+	}
+	result := &ColumnHistogram{Column: column, Histogram_type: "equi-height", Number_of_buckets_specified: buckets, Sampling_rate: 1}
+	var compare_err error
+	compare := func(a interface{}, b interface{}) int {
+		c, err := Compare_Values(a, b)
+		if err != nil && compare_err == nil {
+			compare_err = err
+		}
+		return c
+	}
+
+	n_leaf_pages, _, err := tree.Page_Counts()
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	var nulls, n uint64
+	if sample_pages == 0 || n_leaf_pages <= sample_pages {
+		// 第一遍数行数, 同时做蓄水池采样, 看值是不是按顺序的
+		rng := sample_rng(seed)
+		sorted := len(td.Primary) > 0 && td.Primary[0] == column
+		var last interface{}
+		err := tree.each_column_value(column, func(value interface{}) {
+			if value == nil {
+				nulls++
+				return
+			}
+			n++
+			if sorted && last != nil && compare(last, value) > 0 {
+				sorted = false
+			}
+			last = value
+			if len(values) < HISTOGRAM_MAX_VALUES {
+				values = append(values, value)
+			} else if i := rng.Int63n(int64(n)); i < HISTOGRAM_MAX_VALUES {
+				values[i] = value
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if compare_err != nil {
+			return nil, compare_err
+		}
+		result.Rows = n + nulls
+		if result.Rows == 0 {
+			return result, nil
+		}
+		result.Null_values = float64(nulls) / float64(result.Rows)
+		if sorted && n > uint64(len(values)) {
+			if err := tree.stream_histogram(column, newHistogramBuilder(result, buckets, n, n), compare); err != nil {
+				return nil, err
+			}
+			if compare_err != nil {
+				return nil, compare_err
+			}
+			return result, nil
+		}
+		if n > uint64(len(values)) {
+			result.Sampling_rate = float64(len(values)) / float64(n)
+		}
+	} else {
+		pages, err := tree.sample_leaf_pages(sample_pages, sample_rng(seed))
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range pages {
+			records, err := index.live_records()
+			if err != nil {
+				return nil, err
+			}
+			for _, record := range records {
+				if value := record.Get_Fields_And_Value_Map()[column]; value == nil {
+					nulls++
+				} else {
+					values = append(values, value)
+				}
+			}
+		}
+		result.Sampling_rate = float64(len(pages)) / float64(n_leaf_pages)
+		result.Rows = uint64(len(values)) + nulls
+		if result.Rows == 0 {
+			return result, nil
+		}
+		result.Null_values = float64(nulls) / float64(result.Rows)
+	}
+
+	sort.SliceStable(values, func(i, j int) bool { return compare(values[i], values[j]) < 0 })
+	if compare_err != nil {
+		return nil, compare_err
+	}
+	builder := newHistogramBuilder(result, buckets, uint64(len(values)), result.Rows-nulls)
+	for i := 0; i < len(values); {
+		j := i + 1
+		for j < len(values) && compare(values[i], values[j]) == 0 {
+			j++
+		}
+		builder.add_group(values[i], uint64(j-i))
+		i = j
+	}
+	builder.close()
+	return result, nil
+}
+
+// 按索引顺序读所有没有delete mark的记录中column的值, null是nil
+func (tree *BTreeIndex) each_column_value(column string, f func(value interface{})) error {
+	it := tree.Record_Iterator(nil)
+	record, err := it.Next()
+	for ; record != nil; record, err = it.Next() {
+		if !record.Is_Deleted() {
+			f(record.Get_Fields_And_Value_Map()[column])
+		}
+	}
+	return err
+}
+
+// 第二遍按索引顺序读, 相同的值是连在一起的, 不用把值放在内存里
+func (tree *BTreeIndex) stream_histogram(column string, builder *histogramBuilder, compare func(a interface{}, b interface{}) int) error {
+	var group interface{}
+	var count uint64
+	err := tree.each_column_value(column, func(value interface{}) {
+		if value == nil {
+			return
+		}
+		if group != nil && compare(group, value) == 0 {
+			count++
+			return
+		}
+		if group != nil {
+			builder.add_group(group, count)
+		}
+		group, count = value, 1
+	})
+	if err != nil {
+		return err
+	}
+	if group != nil {
+		builder.add_group(group, count)
+	}
+	builder.close()
+	return nil
+}
+
+func (result *IndexCardinality) Dump() {
+	println("index cardinality:")
+
+	data, _ := json.Marshal(result)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
+
+func (result *ColumnHistogram) Dump() {
+	println("column histogram:")
+
+	data, _ := json.Marshal(result)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
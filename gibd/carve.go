@@ -0,0 +1,287 @@
+package gibd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tidwall/pretty"
+)
+
+// 页扫描, 文件系统坏了或者ibd被截断/删除以后, 从磁盘镜像或者损坏的文件里找出innodb的页
+// 按step(512的倍数)移动, 找到一个正确的页以后跳过整个页
+const CARVE_MIN_STEP = 512
+const CARVE_CHUNK_SIZE = 256 * DEFAULT_PAGE_SIZE
+
+// index页头中的位置, 相对页头开始
+const PAGE_LEVEL = 26
+const PAGE_INDEX_ID = 28
+const BTR_MAX_LEVELS = 100
+
+type CarvedPage struct {
+	Source      string `json:"source"`
+	File_offset uint64 `json:"file_offset"`
+	Space_id    uint64 `json:"space_id"`
+	Page_number uint64 `json:"page_number"`
+	Page_type   string `json:"page_type"`
+	Index_id    uint64 `json:"index_id,omitempty"`
+	Level       uint64 `json:"level"`
+	Lsn         uint64 `json:"lsn"`
+	Checksum    string `json:"checksum"`
+	Prev        uint64 `json:"prev"`
+	Next        uint64 `json:"next"`
+}
+
+// 一个表空间中找到的页, 同一个页有多个的时候用lsn最大的
+type CarvedSpace struct {
+	Space_id   uint64                 `json:"space_id"`
+	Pages      map[uint64]*CarvedPage `json:"-"`
+	N_pages    uint64                 `json:"pages"`
+	Duplicates uint64                 `json:"duplicates"`
+}
+
+// 一个索引中找到的页, root是level最高并且没有兄弟页的页
+type CarvedIndex struct {
+	Space_id         uint64   `json:"space_id"`
+	Index_id         uint64   `json:"index_id"`
+	Root_page_number uint64   `json:"root_page_number"`
+	Height           uint64   `json:"height"`
+	Pages            uint64   `json:"pages"`
+	Leaf_pages       []uint64 `json:"leaf_pages"`
+}
+
+// 只保留页的位置和页头中用到的字段, 页的内容用到的时候再从source中读
+func NewCarvedPage(source string, file_offset uint64, data []byte) *CarvedPage {
+	page := &CarvedPage{Source: source, File_offset: file_offset}
+	page.Space_id = uint64(BytesToUIntLittleEndian(data[34:38]))
+	page.Page_number = uint64(BytesToUIntLittleEndian(data[4:8]))
+	page.Page_type = PAGE_TYPE[BytesToUIntLittleEndian(data[24:26])]
+	page.Lsn = uint64(BytesToUIntLittleEndian(data[FIL_PAGE_LSN : FIL_PAGE_LSN+8]))
+	page.Checksum, _ = Page_Checksum(data)
+	page.Prev = uint64(BytesToUIntLittleEndian(data[8:12]))
+	page.Next = uint64(BytesToUIntLittleEndian(data[12:16]))
+	if page.Is_Index() {
+		page.Level = uint64(BytesToUIntLittleEndian(data[FIL_PAGE_DATA+PAGE_LEVEL : FIL_PAGE_DATA+PAGE_LEVEL+2]))
+		page.Index_id = uint64(BytesToUIntLittleEndian(data[FIL_PAGE_DATA+PAGE_INDEX_ID : FIL_PAGE_DATA+PAGE_INDEX_ID+8]))
+	}
+	return page
+}
+
+func (page *CarvedPage) Is_Index() bool {
+	return page.Page_type == PAGE_TYPE[FIL_PAGE_INDEX]
+}
+
+func (page *CarvedPage) Read_Data() ([]byte, error) {
+	file, err := os.Open(page.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data := make([]byte, DEFAULT_PAGE_SIZE)
+	if _, err := file.ReadAt(data, int64(page.File_offset)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// 文件头检查加上checksum, 全0的页和checksum不对的页都不要
+func Is_Carvable_Page(data []byte) bool {
+	if _, valid := Page_Checksum(data); !valid || Is_Zero_Page(data) {
+		return false
+	}
+	page_type := BytesToUIntLittleEndian(data[24:26])
+	if _, ok := PAGE_TYPE[page_type]; !ok {
+		return false
+	}
+	page_number := uint64(BytesToUIntLittleEndian(data[4:8]))
+	if page_number == FIL_NULL {
+		return false
+	}
+	// 只有INDEX页的prev和next是兄弟页, 其他页可能是0或者别的用途
+	if page_type == FIL_PAGE_INDEX {
+		prev := uint64(BytesToUIntLittleEndian(data[8:12]))
+		next := uint64(BytesToUIntLittleEndian(data[12:16]))
+		if prev == page_number || next == page_number {
+			return false
+		}
+		level := BytesToUIntLittleEndian(data[FIL_PAGE_DATA+PAGE_LEVEL : FIL_PAGE_DATA+PAGE_LEVEL+2])
+		heap_top := uint64(BytesToUIntLittleEndian(data[FIL_PAGE_DATA+2 : FIL_PAGE_DATA+4]))
+		if level >= BTR_MAX_LEVELS || heap_top > DEFAULT_PAGE_SIZE {
+			return false
+		}
+	}
+	return true
+}
+
+// 扫描文件或者块设备镜像, step是每次移动的字节数, 要能整除页大小
+func Carve_File(filename string, step uint64) ([]*CarvedPage, error) {
+	if step < CARVE_MIN_STEP || DEFAULT_PAGE_SIZE%step != 0 {
+		return nil, fmt.Errorf("step %d must be a multiple of %d that divides the page size", step, CARVE_MIN_STEP)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var pages []*CarvedPage
+	buffer := make([]byte, CARVE_CHUNK_SIZE)
+	var base uint64
+	for {
+		n, err := file.ReadAt(buffer, int64(base))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return pages, err
+		}
+		if uint64(n) < DEFAULT_PAGE_SIZE {
+			break
+		}
+		var pos uint64
+		for pos+DEFAULT_PAGE_SIZE <= uint64(n) {
+			data := buffer[pos : pos+DEFAULT_PAGE_SIZE]
+			if Is_Carvable_Page(data) {
+				pages = append(pages, NewCarvedPage(filename, base+pos, data))
+				pos += DEFAULT_PAGE_SIZE
+			} else {
+				pos += step
+			}
+		}
+		base += pos
+	}
+	return pages, nil
+}
+
+// 按space id分组, 按space id排序
+func Group_Carved_Pages(pages []*CarvedPage) []*CarvedSpace {
+	spaces := make(map[uint64]*CarvedSpace)
+	for _, page := range pages {
+		space, ok := spaces[page.Space_id]
+		if !ok {
+			space = &CarvedSpace{Space_id: page.Space_id, Pages: make(map[uint64]*CarvedPage)}
+			spaces[page.Space_id] = space
+		}
+		if current, ok := space.Pages[page.Page_number]; ok {
+			space.Duplicates++
+			if current.Lsn >= page.Lsn {
+				continue
+			}
+		} else {
+			space.N_pages++
+		}
+		space.Pages[page.Page_number] = page
+	}
+	var res []*CarvedSpace
+	for _, space := range spaces {
+		res = append(res, space)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Space_id < res[j].Space_id })
+	return res
+}
+
+func (cs *CarvedSpace) Page_Numbers() []uint64 {
+	var page_numbers []uint64
+	for page_number := range cs.Pages {
+		page_numbers = append(page_numbers, page_number)
+	}
+	sort.Slice(page_numbers, func(i, j int) bool { return page_numbers[i] < page_numbers[j] })
+	return page_numbers
+}
+
+// 按index id分组的INDEX页
+func (cs *CarvedSpace) Indexes() []*CarvedIndex {
+	indexes := make(map[uint64]*CarvedIndex)
+	var index_ids []uint64
+	for _, page_number := range cs.Page_Numbers() {
+		page := cs.Pages[page_number]
+		if !page.Is_Index() {
+			continue
+		}
+		index, ok := indexes[page.Index_id]
+		if !ok {
+			index = &CarvedIndex{Space_id: cs.Space_id, Index_id: page.Index_id, Root_page_number: FIL_NULL}
+			indexes[page.Index_id] = index
+			index_ids = append(index_ids, page.Index_id)
+		}
+		index.Pages++
+		if page.Level == 0 {
+			index.Leaf_pages = append(index.Leaf_pages, page_number)
+		}
+		if page.Prev == FIL_NULL && page.Next == FIL_NULL && page.Level+1 > index.Height {
+			index.Root_page_number = page_number
+			index.Height = page.Level + 1
+		}
+	}
+	sort.Slice(index_ids, func(i, j int) bool { return index_ids[i] < index_ids[j] })
+	var res []*CarvedIndex
+	for _, index_id := range index_ids {
+		res = append(res, indexes[index_id])
+	}
+	return res
+}
+
+// 页从扫描的文件中读的表空间, 可以交给BTreeIndex或者直接解析叶子页
+func (cs *CarvedSpace) Space() *Space {
+	pages := make(map[uint64]*PageLocation)
+	for page_number, page := range cs.Pages {
+		pages[page_number] = &PageLocation{Filename: page.Source, File_offset: page.File_offset}
+	}
+	return NewMappedSpace(fmt.Sprintf("carved space %d", cs.Space_id), cs.Space_id, pages)
+}
+
+// 找到了root页的时候可以用整棵树
+func (cs *CarvedSpace) Index_Tree(index *CarvedIndex, record_describer interface{}) *BTreeIndex {
+	if index.Root_page_number == FIL_NULL {
+		return nil
+	}
+	return cs.Space().Get_Index_Tree(index.Root_page_number, record_describer)
+}
+
+// root页或者中间的页丢了的时候树走不通, 一个一个读找到的叶子页
+func (cs *CarvedSpace) Leaf_Page_Iterator(index *CarvedIndex, record_describer interface{}) *LeafPageIterator {
+	space := cs.Space()
+	space.Record_describer = record_describer
+	return space.Leaf_Page_Iterator(index.Leaf_pages, record_describer)
+}
+
+// 解析不了的页返回已经解析的记录
+func (index *IndexPage) Try_Each_Record() []*Record {
+	records, err := index.Each_Record_Checked()
+	if err != nil {
+		Log.Info("%v\n", err)
+	}
+	return records
+}
+
+// 写成一个ibd文件, 页放在自己的页号的位置, 中间缺的页是全0的
+func (cs *CarvedSpace) Write_File(filename string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, page_number := range cs.Page_Numbers() {
+		data, err := cs.Pages[page_number].Read_Data()
+		if err != nil {
+			return err
+		}
+		if _, err := file.WriteAt(data, int64(page_number*DEFAULT_PAGE_SIZE)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *CarvedSpace) Dump() {
+	println("carved space:")
+
+	data, _ := json.Marshal(cs)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+
+	for _, index := range cs.Indexes() {
+		data, _ := json.Marshal(index)
+		fmt.Printf("%s\n", data)
+	}
+}
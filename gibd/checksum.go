@@ -0,0 +1,83 @@
+package gibd
+
+import "hash/crc32"
+
+// 页的checksum buf0checksum.cc
+// 文件头的前4个字节和文件尾的前4个字节都是checksum, crc32算法两个值一样, innodb算法文件尾是老的checksum
+const BUF_NO_CHECKSUM_MAGIC = 0xDEADBEEF
+const FIL_PAGE_LSN = 16
+const FIL_PAGE_FILE_FLUSH_LSN = 26
+const FIL_PAGE_DATA = 38
+const FIL_PAGE_END_LSN_OLD_CHKSUM = 8
+
+const UT_HASH_RANDOM_MASK = 1463735687
+const UT_HASH_RANDOM_MASK2 = 1653893711
+
+var crc32c_table = crc32.MakeTable(crc32.Castagnoli)
+
+// buf_calc_page_crc32
+func Page_Checksum_Crc32(data []byte) uint64 {
+	c1 := crc32.Checksum(data[4:FIL_PAGE_FILE_FLUSH_LSN], crc32c_table)
+	c2 := crc32.Checksum(data[FIL_PAGE_DATA:len(data)-FIL_PAGE_END_LSN_OLD_CHKSUM], crc32c_table)
+	return uint64(c1 ^ c2)
+}
+
+func ut_fold_ulint_pair(n1 uint64, n2 uint64) uint64 {
+	return ((((n1 ^ n2 ^ UT_HASH_RANDOM_MASK2) << 8) + n1) ^ UT_HASH_RANDOM_MASK) + n2
+}
+
+func ut_fold_binary(data []byte) uint64 {
+	var fold uint64
+	for _, b := range data {
+		fold = ut_fold_ulint_pair(fold, uint64(b))
+	}
+	return fold
+}
+
+// buf_calc_page_new_checksum, 存在文件头
+func Page_Checksum_Innodb(data []byte) uint64 {
+	return (ut_fold_binary(data[4:FIL_PAGE_FILE_FLUSH_LSN]) +
+		ut_fold_binary(data[FIL_PAGE_DATA:len(data)-FIL_PAGE_END_LSN_OLD_CHKSUM])) & 0xFFFFFFFF
+}
+
+// buf_calc_page_old_checksum, 存在文件尾
+func Page_Checksum_Innodb_Old(data []byte) uint64 {
+	return ut_fold_binary(data[:FIL_PAGE_FILE_FLUSH_LSN]) & 0xFFFFFFFF
+}
+
+func Is_Zero_Page(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// buf_page_is_corrupted: 文件头和文件尾的lsn低32位要一样, checksum要符合其中一种算法
+// 返回使用的算法和是否正确, 全0的页认为是正确的
+func Page_Checksum(data []byte) (string, bool) {
+	if uint64(len(data)) != DEFAULT_PAGE_SIZE {
+		return "short", false
+	}
+	if Is_Zero_Page(data) {
+		return "zero", true
+	}
+	size := uint64(len(data))
+	header := uint64(BytesToUIntLittleEndian(data[0:4]))
+	trailer := uint64(BytesToUIntLittleEndian(data[size-FIL_PAGE_END_LSN_OLD_CHKSUM : size-4]))
+	lsn_low := uint64(BytesToUIntLittleEndian(data[FIL_PAGE_LSN+4 : FIL_PAGE_LSN+8]))
+	if lsn_low != uint64(BytesToUIntLittleEndian(data[size-4:size])) {
+		return "torn", false
+	}
+	if header == BUF_NO_CHECKSUM_MAGIC && trailer == BUF_NO_CHECKSUM_MAGIC {
+		return "none", true
+	}
+	if crc := Page_Checksum_Crc32(data); header == crc && trailer == crc {
+		return "crc32", true
+	}
+	if header == Page_Checksum_Innodb(data) && trailer == Page_Checksum_Innodb_Old(data) {
+		return "innodb", true
+	}
+	return "invalid", false
+}
@@ -0,0 +1,324 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/tidwall/pretty"
+)
+
+// 二级索引和聚簇索引的一致性检查, 参考row_check_index
+// delete mark的二级索引记录可能还没有purge, 不检查
+const CONSISTENCY_MISSING_ROW = "missing_row"       // 二级索引记录在聚簇索引中找不到
+const CONSISTENCY_DELETED_ROW = "deleted_row"       // 二级索引记录没有删除, 聚簇索引记录delete mark了
+const CONSISTENCY_VALUE_MISMATCH = "value_mismatch" // 二级索引中的值和聚簇索引中的不一样
+const CONSISTENCY_MISSING_ENTRY = "missing_entry"   // 聚簇索引记录在二级索引中找不到
+
+type ConsistencyFinding struct {
+	Check       string                 `json:"check"`
+	Index       string                 `json:"index"`
+	Page_number uint64                 `json:"page_number"`
+	Primary_key []interface{}          `json:"primary_key"`
+	Values      map[string]interface{} `json:"values,omitempty"`
+	Message     string                 `json:"message"`
+}
+
+type SecondaryConsistency struct {
+	Index            string                `json:"index"`
+	Root_page_number uint64                `json:"root_page_number"`
+	Entries          uint64                `json:"entries"`
+	Deleted_entries  uint64                `json:"deleted_entries"`
+	Rows             uint64                `json:"rows"`
+	Deleted_rows     uint64                `json:"deleted_rows"`
+	Findings         []*ConsistencyFinding `json:"findings,omitempty"`
+}
+
+// 前缀索引中的值, 字符串按字符截断
+func Index_Prefix_Value(value interface{}, prefix int) interface{} {
+	s, ok := value.(string)
+	if !ok || prefix <= 0 {
+		return value
+	}
+	runes := []rune(s)
+	if len(runes) <= prefix {
+		return s
+	}
+	return string(runes[:prefix])
+}
+
+// 一个二级索引的检查, 记录的主键和对应的二级索引记录的key都从字段map里取
+type secondaryCheck struct {
+	clustered *BTreeIndex
+	secondary *BTreeIndex
+	td        *TableDescriber
+	index     *TableIndex
+	in_index  map[string]bool
+	result    *SecondaryConsistency
+}
+
+func (check *secondaryCheck) add(kind string, page_number uint64, primary_key []interface{}, values map[string]interface{}, format string, args ...interface{}) {
+	check.result.Findings = append(check.result.Findings, check.finding(kind, page_number, primary_key, values, format, args...))
+}
+
+func (check *secondaryCheck) finding(kind string, page_number uint64, primary_key []interface{}, values map[string]interface{}, format string, args ...interface{}) *ConsistencyFinding {
+	return &ConsistencyFinding{Check: kind, Index: check.index.Name, Page_number: page_number,
+		Primary_key: primary_key, Values: values, Message: fmt.Sprintf(format, args...)}
+}
+
+func (check *secondaryCheck) primary_key(fields map[string]interface{}) []interface{} {
+	var values []interface{}
+	for _, name := range check.td.Primary {
+		values = append(values, fields[name])
+	}
+	return values
+}
+
+func (check *secondaryCheck) prefix_value(fields map[string]interface{}, i int) interface{} {
+	prefix := 0
+	if i < len(check.index.Prefixes) {
+		prefix = check.index.Prefixes[i]
+	}
+	return Index_Prefix_Value(fields[check.index.Columns[i]], prefix)
+}
+
+// 聚簇索引记录对应的二级索引记录的key: 索引字段(前缀截断)加上不在索引里的主键字段, 和Secondary_Describer一样
+// 二级索引记录的字段已经是截断的, 再截断一次不变
+func (check *secondaryCheck) expected_entry(fields map[string]interface{}) []interface{} {
+	var values []interface{}
+	for i := range check.index.Columns {
+		values = append(values, check.prefix_value(fields, i))
+	}
+	for _, name := range check.td.Primary {
+		if !check.in_index[name] {
+			values = append(values, fields[name])
+		}
+	}
+	return values
+}
+
+// 二级索引记录中的字段和聚簇索引记录中的不一样的时候, 每个字段返回一个finding
+func (check *secondaryCheck) mismatches(record *Record, fields map[string]interface{}, row *Record) ([]*ConsistencyFinding, error) {
+	var findings []*ConsistencyFinding
+	row_fields := row.Get_Fields_And_Value_Map()
+	for i, name := range check.index.Columns {
+		expected := check.prefix_value(row_fields, i)
+		c, err := Compare_Values(fields[name], expected)
+		if err != nil {
+			return findings, err
+		}
+		if c != 0 {
+			findings = append(findings, check.finding(CONSISTENCY_VALUE_MISMATCH, record.Page.Page_number, check.primary_key(fields), fields,
+				"column %s is %v, clustered record on page %d has %v", name, fields[name], row.Page.Page_number, expected))
+		}
+	}
+	return findings, nil
+}
+
+// 主键和索引字段都可以按字节比较的时候才能在树上找, 参考Is_Binary_Ordered
+func (check *secondaryCheck) searchable() bool {
+	if check.td.Unordered_Key_Column(len(check.td.Primary)) != nil {
+		return false
+	}
+	for _, name := range check.index.Columns {
+		if column := check.td.Column(name); column != nil && !column.Is_Binary_Ordered() {
+			return false
+		}
+	}
+	return true
+}
+
+// 比较clustered(聚簇索引)和secondary(td中名字是index_name的二级索引)
+// 不把记录放到内存里: 二级索引记录用主键到聚簇索引里Find, 聚簇索引记录用索引字段加主键到二级索引里Find
+// 有非二进制collation的字符串字段的时候树上的顺序和字节顺序不一样, 不在树上找, 用match_entries
+func Check_Secondary_Index(clustered *BTreeIndex, secondary *BTreeIndex, td *TableDescriber, index_name string) (*SecondaryConsistency, error) {
+	index := td.Index(index_name)
+	if index == nil {
+		return nil, fmt.Errorf("index %s not found in table %s", index_name, td.Name)
+	}
+	if index.Spatial {
+		return nil, fmt.Errorf("index %s is a spatial index, use check-index", index.Name)
+	}
+	check := &secondaryCheck{clustered: clustered, secondary: secondary, td: td, index: index, in_index: make(map[string]bool),
+		result: &SecondaryConsistency{Index: index.Name, Root_page_number: secondary.Root.Page.Page_number}}
+	for _, name := range index.Columns {
+		check.in_index[name] = true
+	}
+	if !check.searchable() {
+		return check.result, check.match_entries()
+	}
+	result := check.result
+
+	it := secondary.Record_Iterator(nil)
+	record, err := it.Next()
+	for ; record != nil; record, err = it.Next() {
+		if record.Is_Deleted() {
+			result.Deleted_entries++
+			continue
+		}
+		result.Entries++
+		fields := record.Get_Fields_And_Value_Map()
+		pk := check.primary_key(fields)
+		row, err := clustered.Find(pk...)
+		if err != nil {
+			return result, err
+		}
+		if row == nil {
+			check.add(CONSISTENCY_MISSING_ROW, record.Page.Page_number, pk, fields, "no clustered record with this primary key")
+			continue
+		}
+		if row.Is_Deleted() {
+			check.add(CONSISTENCY_DELETED_ROW, record.Page.Page_number, pk, fields, "clustered record on page %d is delete-marked", row.Page.Page_number)
+			continue
+		}
+		findings, err := check.mismatches(record, fields, row)
+		result.Findings = append(result.Findings, findings...)
+		if err != nil {
+			return result, err
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+
+	it = clustered.Record_Iterator(nil)
+	record, err = it.Next()
+	for ; record != nil; record, err = it.Next() {
+		if record.Is_Deleted() {
+			result.Deleted_rows++
+			continue
+		}
+		result.Rows++
+		fields := record.Get_Fields_And_Value_Map()
+		entry, err := secondary.Find(check.expected_entry(fields)...)
+		if err != nil {
+			return result, err
+		}
+		if entry == nil || entry.Is_Deleted() {
+			check.add(CONSISTENCY_MISSING_ENTRY, record.Page.Page_number, check.primary_key(fields), fields, "no secondary index record for this row")
+		}
+	}
+	return result, err
+}
+
+// 值的类型和内容的hash, 不同类型的相同文本不会相等
+func hash_values(values []interface{}) uint64 {
+	h := fnv.New64a()
+	for _, value := range values {
+		fmt.Fprintf(h, "%T:%v\x00", value, value)
+	}
+	return h.Sum64()
+}
+
+// 和顺序无关的比较, 每条记录在内存里只占一个hash:
+// 1. 二级索引记录的key在net中加1, 聚簇索引记录对应的key减1, 都是0就没有问题
+// 2. 再读一遍二级索引, net大于0的记录是多出来的, 记下它们的主键(不多)
+// 3. 再读一遍聚簇索引, net小于0的是missing_entry, 和多出来的二级索引记录主键相同的行用来区分deleted_row和value_mismatch
+// 找不到主键相同的行的是missing_row. 值按解析出来的内容完全相等来比较, hash冲突的时候可能漏报
+func (check *secondaryCheck) match_entries() error {
+	result := check.result
+	net := make(map[uint64]int64)
+	each := func(tree *BTreeIndex, f func(record *Record, fields map[string]interface{})) error {
+		it := tree.Record_Iterator(nil)
+		record, err := it.Next()
+		for ; record != nil; record, err = it.Next() {
+			f(record, record.Get_Fields_And_Value_Map())
+		}
+		return err
+	}
+	err := each(check.secondary, func(record *Record, fields map[string]interface{}) {
+		if record.Is_Deleted() {
+			result.Deleted_entries++
+			return
+		}
+		result.Entries++
+		net[hash_values(check.expected_entry(fields))]++
+	})
+	if err != nil {
+		return err
+	}
+	err = each(check.clustered, func(record *Record, fields map[string]interface{}) {
+		if record.Is_Deleted() {
+			result.Deleted_rows++
+			return
+		}
+		result.Rows++
+		net[hash_values(check.expected_entry(fields))]--
+	})
+	if err != nil {
+		return err
+	}
+
+	type extra struct {
+		record   *Record
+		fields   map[string]interface{}
+		pk       []interface{}
+		resolved bool
+		findings []*ConsistencyFinding
+	}
+	var extras []*extra
+	by_pk := make(map[uint64][]*extra)
+	err = each(check.secondary, func(record *Record, fields map[string]interface{}) {
+		h := hash_values(check.expected_entry(fields))
+		if record.Is_Deleted() || net[h] <= 0 {
+			return
+		}
+		net[h]--
+		e := &extra{record: record, fields: fields, pk: check.primary_key(fields)}
+		extras = append(extras, e)
+		by_pk[hash_values(e.pk)] = append(by_pk[hash_values(e.pk)], e)
+	})
+	if err != nil {
+		return err
+	}
+
+	var missing []*ConsistencyFinding
+	var compare_err error
+	err = each(check.clustered, func(row *Record, fields map[string]interface{}) {
+		pk := check.primary_key(fields)
+		if !row.Is_Deleted() {
+			if h := hash_values(check.expected_entry(fields)); net[h] < 0 {
+				net[h]++
+				missing = append(missing, check.finding(CONSISTENCY_MISSING_ENTRY, row.Page.Page_number, pk, fields, "no secondary index record for this row"))
+			}
+		}
+		for _, e := range by_pk[hash_values(pk)] {
+			if e.resolved {
+				continue
+			}
+			if c, err := Compare_Keys(e.pk, pk); err != nil || c != 0 {
+				continue
+			}
+			e.resolved = true
+			if row.Is_Deleted() {
+				e.findings = append(e.findings, check.finding(CONSISTENCY_DELETED_ROW, e.record.Page.Page_number, e.pk, e.fields,
+					"clustered record on page %d is delete-marked", row.Page.Page_number))
+				continue
+			}
+			findings, err := check.mismatches(e.record, e.fields, row)
+			if err != nil && compare_err == nil {
+				compare_err = err
+			}
+			e.findings = append(e.findings, findings...)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range extras {
+		if !e.resolved {
+			check.add(CONSISTENCY_MISSING_ROW, e.record.Page.Page_number, e.pk, e.fields, "no clustered record with this primary key")
+		}
+		result.Findings = append(result.Findings, e.findings...)
+	}
+	result.Findings = append(result.Findings, missing...)
+	return compare_err
+}
+
+func (result *SecondaryConsistency) Dump() {
+	println("secondary consistency:")
+
+	data, _ := json.Marshal(result)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
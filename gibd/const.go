@@ -15,3 +15,6 @@ var SYSTEM_SPACE_PAGE_MAP = map[int]string{
 	6: "SYS",
 	7: "SYS",
 }
+
+// 空指针(页号),比如链表结束,没有使用的slot
+const FIL_NULL = 4294967295
@@ -51,40 +51,68 @@ func (rc *RecordCursor) record() *Record {
 }
 
 func (rc *RecordCursor) Next_Record() *Record {
-	// page_record_cursor_next_record = page_record_cursor_next_record + 1
-
-	rec := rc.Index.record(rc.Record.record.(*UserRecord).header.Next)
-
-	var next_record_offset uint64
-	var rc_record_offset uint64
-
-	supremum := rc.Index.Supremum()
-	rc_record_offset = rc.Record.record.(*UserRecord).offset
+	current, ok := rc.Record.record.(*UserRecord)
+	if !ok {
+		return nil
+	}
+	rec := rc.Index.record(current.header.Next)
 
+	//到了supremum就结束, next指回自己说明页已经损坏
 	switch rec.record.(type) {
 	case *UserRecord:
-
-		next_record_offset = rec.record.(*UserRecord).offset
-		next_record := rec.record.(*UserRecord)
-		if (next_record.header.Next == supremum.record.(*SystemRecord).header.Next) || next_record_offset == rc_record_offset {
+		if rec.record.(*UserRecord).offset == current.offset {
 			return nil
-		} else {
-			return rec
-		}
-	case *SystemRecord:
-		next_record_offset = rec.record.(*SystemRecord).offset
-		next_record := rec.record.(*SystemRecord)
-		if (next_record.header.Next == supremum.record.(*SystemRecord).header.Next) || next_record_offset == rc_record_offset {
-			return nil
-		} else {
-			return rec
 		}
+		return rec
 	}
 
 	return nil
 }
 
+// 往前走, 到了infimum就结束
 func (rc *RecordCursor) Prev_Record() *Record {
-	var records *Record
-	return records
+	var offset uint64
+	switch current := rc.Record.record.(type) {
+	case *UserRecord:
+		offset = current.offset
+	case *SystemRecord:
+		offset = current.offset
+	}
+	prev := rc.Index.Prev_Offset(offset)
+	if prev == 0 || prev == rc.Index.Pos_Infimum() {
+		return nil
+	}
+	return rc.Index.record(prev)
+}
+
+// 记录链表是单向的, 参考page_rec_get_prev
+// 先往后找到拥有这个记录的槽, 再从前一个槽的记录往后找, 最多走8条记录
+// infimum或者页上找不到的时候返回0
+func (index *IndexPage) Prev_Offset(offset uint64) uint64 {
+	if offset == index.Pos_Infimum() {
+		return 0
+	}
+	owner := offset
+	for i := 0; index.N_Owned(owner) == 0; i++ {
+		if i > PAGE_DIR_SLOT_MAX_N_OWNED {
+			return 0
+		}
+		owner = index.Next_Offset(owner)
+	}
+	slots := index.Directory_Slot_Offsets()
+	for i := 1; i < len(slots); i++ {
+		if slots[i] != owner {
+			continue
+		}
+		prev := slots[i-1]
+		for j := 0; j <= PAGE_DIR_SLOT_MAX_N_OWNED; j++ {
+			next := index.Next_Offset(prev)
+			if next == offset {
+				return prev
+			}
+			prev = next
+		}
+		return 0
+	}
+	return 0
 }
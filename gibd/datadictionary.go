@@ -104,21 +104,54 @@ func NewSysFieldsPrimary() *SysFieldsPrimary {
 	return &SysFieldsPrimary{"clustered", field_index_id, field_pos, field_col_name}
 }
 
+// 5.6以后的表空间信息, 根页不在数据字典头中, 需要通过SYS_TABLES和SYS_INDEXES查找
+type SysTablespacesPrimary struct {
+	TAB_TYPE string          `json:"tab_type"`
+	SPACE    RecordFieldMeta `json:"space"`
+	NAME     RecordFieldMeta `json:"name"`
+	FLAGS    RecordFieldMeta `json:"flags"`
+}
+
+func NewSysTablespacesPrimary() *SysTablespacesPrimary {
+	field_space := RecordFieldMeta{Name: "SPACE", DataType: "INT", Properties: "UNSIGNED", Nullable: false, Length: 0, IsKey: true}
+	field_name := RecordFieldMeta{Name: "NAME", DataType: "VARCHAR(100)", Properties: "", Nullable: false, Length: 100, IsKey: false}
+	field_flags := RecordFieldMeta{Name: "FLAGS", DataType: "INT", Properties: "UNSIGNED", Nullable: false, Length: 0, IsKey: false}
+
+	return &SysTablespacesPrimary{"clustered", field_space, field_name, field_flags}
+}
+
+type SysDatafilesPrimary struct {
+	TAB_TYPE string          `json:"tab_type"`
+	SPACE    RecordFieldMeta `json:"space"`
+	PATH     RecordFieldMeta `json:"path"`
+}
+
+func NewSysDatafilesPrimary() *SysDatafilesPrimary {
+	field_space := RecordFieldMeta{Name: "SPACE", DataType: "INT", Properties: "UNSIGNED", Nullable: false, Length: 0, IsKey: true}
+	field_path := RecordFieldMeta{Name: "PATH", DataType: "VARCHAR(4000)", Properties: "", Nullable: false, Length: 4000, IsKey: false}
+
+	return &SysDatafilesPrimary{"clustered", field_space, field_path}
+}
+
 var DATA_DICTIONARY_RECORD_DESCRIBERS = map[string]map[string]string{
 	"SYS_TABLES": {
 		"PRIMARY": "SysTablesPrimary",
 		"ID":      "SysTablesId",
 	},
-	"SYS_COLUMNS": {"PRIMARY": "SysColumnsPrimary"},
-	"SYS_INDEXES": {"PRIMARY": "SysIndexesPrimary"},
-	"SYS_FIELDS":  {"PRIMARY": "SysFieldsPrimary"},
+	"SYS_COLUMNS":     {"PRIMARY": "SysColumnsPrimary"},
+	"SYS_INDEXES":     {"PRIMARY": "SysIndexesPrimary"},
+	"SYS_FIELDS":      {"PRIMARY": "SysFieldsPrimary"},
+	"SYS_TABLESPACES": {"PRIMARY": "SysTablespacesPrimary"},
+	"SYS_DATAFILES":   {"PRIMARY": "SysDatafilesPrimary"},
 }
 
 var describer_struct_map = map[string]reflect.Type{
-	"SysTablesPrimary":  reflect.TypeOf(&SysTablesPrimary{}).Elem(),
-	"SysColumnsPrimary": reflect.TypeOf(&SysColumnsPrimary{}).Elem(),
-	"SysIndexesPrimary": reflect.TypeOf(&SysIndexesPrimary{}).Elem(),
-	"SysFieldsPrimary":  reflect.TypeOf(&SysFieldsPrimary{}).Elem(),
+	"SysTablesPrimary":      reflect.TypeOf(&SysTablesPrimary{}).Elem(),
+	"SysColumnsPrimary":     reflect.TypeOf(&SysColumnsPrimary{}).Elem(),
+	"SysIndexesPrimary":     reflect.TypeOf(&SysIndexesPrimary{}).Elem(),
+	"SysFieldsPrimary":      reflect.TypeOf(&SysFieldsPrimary{}).Elem(),
+	"SysTablespacesPrimary": reflect.TypeOf(&SysTablespacesPrimary{}).Elem(),
+	"SysDatafilesPrimary":   reflect.TypeOf(&SysDatafilesPrimary{}).Elem(),
 }
 
 func New(name string) (c interface{}, err error) {
@@ -167,6 +200,9 @@ func (dh *DataDictionary) Each_Record_From_Data_Dictionary_Index(table string, i
 
 	// root index tree
 	rootindex := dh.Get_Data_Dictionary_Index_Tree(table, index)
+	if rootindex == nil {
+		return nil
+	}
 
 	records := rootindex.Each_Record(dh)
 	// 对返回的每个记录进行处理
@@ -267,6 +303,12 @@ func (dh *DataDictionary) Get_Data_Dictionary_Index_Tree(table_name string, inde
 	} else if table_name == "SYS_INDEXES" {
 		table_entry := dh.data_dictionary_indexes().SYS_INDEXES
 		index_root_page = table_entry.PRIMARY
+	} else {
+		index_root_page = dh.Index_Root_Page_Number(table_name, index_name)
+	}
+	if index_root_page == 0 {
+		Log.Info("data dictionary index %s.%s not found\n", table_name, index_name)
+		return nil
 	}
 
 	//SYS_INDEXES PRIMARY root_page应该是11
@@ -287,6 +329,10 @@ func (dh *DataDictionary) Get_Data_Dictionary_Index_Tree(table_name string, inde
 		record_describer = NewSysIndexesPrimary()
 		// jsons, _ := json.Marshal(*res)
 		// println(string(jsons))
+	case *SysTablespacesPrimary:
+		record_describer = NewSysTablespacesPrimary()
+	case *SysDatafilesPrimary:
+		record_describer = NewSysDatafilesPrimary()
 	default:
 		fmt.Println("description is of a different type%T", value)
 	}
@@ -320,6 +366,50 @@ func (dh *DataDictionary) Each_Index_By_Space_Id(space_id uint64) []map[string]i
 
 }
 
+func (dh *DataDictionary) Table_By_Name(table_name string) map[string]interface{} {
+	for _, table := range dh.Get_Each_Table_Name() {
+		if table["NAME"].(string) == table_name {
+			return table
+		}
+	}
+	return nil
+}
+
+// 根据SYS_TABLES和SYS_INDEXES获取系统表索引的根页,PRIMARY表示聚簇索引(TYPE & 1)
+func (dh *DataDictionary) Index_Root_Page_Number(table_name string, index_name string) uint64 {
+	table := dh.Table_By_Name(table_name)
+	if table == nil {
+		return 0
+	}
+	table_id, _ := to_uint64(table["ID"])
+	for _, index := range dh.Each_Index_Record_Field("SYS_INDEXES", "PRIMARY") {
+		if index_table_id, _ := to_uint64(index["TABLE_ID"]); index_table_id != table_id {
+			continue
+		}
+		if index["NAME"].(string) == index_name || (index_name == "PRIMARY" && index["TYPE"].(int64)&1 == 1) {
+			return uint64(index["PAGE_NO"].(int64))
+		}
+	}
+	return 0
+}
+
+// SYS_TABLESPACES和SYS_DATAFILES的记录, 按space id合并
+func (dh *DataDictionary) Each_Tablespace() map[uint64]map[string]interface{} {
+	tablespaces := make(map[uint64]map[string]interface{})
+	for _, record := range dh.Each_Index_Record_Field("SYS_TABLESPACES", "PRIMARY") {
+		tablespaces[uint64(record["SPACE"].(int64))] = record
+	}
+	for _, record := range dh.Each_Index_Record_Field("SYS_DATAFILES", "PRIMARY") {
+		space_id := uint64(record["SPACE"].(int64))
+		if tablespaces[space_id] == nil {
+			tablespaces[space_id] = make(map[string]interface{})
+			tablespaces[space_id]["SPACE"] = record["SPACE"]
+		}
+		tablespaces[space_id]["PATH"] = record["PATH"]
+	}
+	return tablespaces
+}
+
 func Record_Describer_By_Index_Id(dh *DataDictionary, index_id uint64) interface{} {
 
 	defer func() {
@@ -0,0 +1,155 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// datadir中的ibd文件和space id的对应关系
+type SpaceFile struct {
+	Space_id  uint64 `json:"space_id"`
+	File      string `json:"file"`
+	Dict_name string `json:"dict_name"`
+	Dict_path string `json:"dict_path"`
+}
+
+type SpaceFileIssue struct {
+	Space_id uint64 `json:"space_id"`
+	File     string `json:"file"`
+	Name     string `json:"name"`
+	Reason   string `json:"reason"`
+}
+
+// 文件和数据字典(SYS_TABLESPACES/SYS_DATAFILES)交叉检查的结果
+// 8.0以后没有SYS_TABLESPACES, 和每个文件自己的SDI对照
+type DatadirCheck struct {
+	Datadir    string            `json:"datadir"`
+	Dictionary bool              `json:"dictionary"` // 数据字典中有没有表空间信息,8.0以后没有SYS_TABLESPACES
+	Spaces     []*SpaceFile      `json:"spaces"`
+	Orphans    []*SpaceFileIssue `json:"orphans"`   // 文件存在,数据字典中没有
+	Missing    []*SpaceFileIssue `json:"missing"`   // 数据字典中有,文件不存在
+	Conflicts  []*SpaceFileIssue `json:"conflicts"` // space id重复或者和数据字典对不上
+}
+
+func (system *System) Check_Datadir() *DatadirCheck {
+	datadir := system.config["datadir"]
+	check := &DatadirCheck{Datadir: datadir}
+
+	tablespaces := system.data_dictionary.Each_Tablespace()
+	check.Dictionary = len(tablespaces) > 0
+
+	//TRX_SYS和RSEG_ARRAY中的undo表空间不在SYS_TABLESPACES中
+	undo_space_ids := make(map[uint64]bool)
+	for _, space := range system.Each_Undo_Space() {
+		undo_space_ids[space.Space_id] = true
+	}
+
+	files_by_path := make(map[string]*Space)
+	for _, space := range system.Each_Space() {
+		file := space.Datafiles[0].filename
+		files_by_path[Normalize_Datafile_Path(datadir, file)] = space
+
+		space_file := &SpaceFile{Space_id: space.Space_id, File: file}
+		check.Spaces = append(check.Spaces, space_file)
+		if space.IsSystemSpace {
+			space_file.Dict_name = "innodb_system"
+			continue
+		}
+		if undo_space_ids[space.Space_id] {
+			space_file.Dict_name = "innodb_undo"
+			continue
+		}
+		if !check.Dictionary {
+			system.check_sdi_space(check, space_file, space)
+			continue
+		}
+
+		tablespace, ok := tablespaces[space.Space_id]
+		if !ok {
+			system.orphans = append(system.orphans, *space)
+			check.Orphans = append(check.Orphans, &SpaceFileIssue{Space_id: space.Space_id, File: file, Reason: "space id not found in SYS_TABLESPACES"})
+			continue
+		}
+		space_file.Dict_name, _ = tablespace["NAME"].(string)
+		space_file.Dict_path, _ = tablespace["PATH"].(string)
+		if space_file.Dict_path != "" && Normalize_Datafile_Path(datadir, space_file.Dict_path) != Normalize_Datafile_Path(datadir, file) {
+			check.Conflicts = append(check.Conflicts, &SpaceFileIssue{Space_id: space.Space_id, File: file, Name: space_file.Dict_name,
+				Reason: "SYS_DATAFILES path is " + space_file.Dict_path})
+		}
+	}
+
+	for _, space := range system.duplicates {
+		exist := system.spaces[space.Space_id]
+		check.Conflicts = append(check.Conflicts, &SpaceFileIssue{Space_id: space.Space_id, File: space.Datafiles[0].filename,
+			Reason: "space id already used by " + exist.Datafiles[0].filename})
+	}
+
+	var space_ids []uint64
+	for space_id := range tablespaces {
+		space_ids = append(space_ids, space_id)
+	}
+	sort.Slice(space_ids, func(i, j int) bool { return space_ids[i] < space_ids[j] })
+
+	for _, space_id := range space_ids {
+		if _, ok := system.spaces[space_id]; ok {
+			continue
+		}
+		tablespace := tablespaces[space_id]
+		name, _ := tablespace["NAME"].(string)
+		path, _ := tablespace["PATH"].(string)
+		if path == "" {
+			path = name + ".ibd"
+		}
+		//文件在,但是page 0 上的space id和数据字典的不一致
+		if space, ok := files_by_path[Normalize_Datafile_Path(datadir, path)]; ok {
+			check.Conflicts = append(check.Conflicts, &SpaceFileIssue{Space_id: space_id, File: space.Datafiles[0].filename, Name: name,
+				Reason: fmt.Sprintf("file has space id %d on page 0", space.Space_id)})
+			continue
+		}
+		check.Missing = append(check.Missing, &SpaceFileIssue{Space_id: space_id, File: path, Name: name, Reason: "file not found in datadir"})
+	}
+
+	return check
+}
+
+// 8.0的文件和自己的SDI对照, 没有SDI或者SDI中的space id和page 0上的不一样
+func (system *System) check_sdi_space(check *DatadirCheck, space_file *SpaceFile, space *Space) {
+	if space.SDI_Root_Page_Number() == 0 {
+		system.orphans = append(system.orphans, *space)
+		check.Orphans = append(check.Orphans, &SpaceFileIssue{Space_id: space.Space_id, File: space_file.File,
+			Reason: "no SYS_TABLESPACES and no SDI in the file"})
+		return
+	}
+	name, space_id, err := space.SDI_Tablespace()
+	space_file.Dict_name = name
+	if err != nil {
+		check.Conflicts = append(check.Conflicts, &SpaceFileIssue{Space_id: space.Space_id, File: space_file.File, Name: name,
+			Reason: "can not read SDI: " + err.Error()})
+		return
+	}
+	if space_id != 0 && space_id != space.Space_id {
+		check.Conflicts = append(check.Conflicts, &SpaceFileIssue{Space_id: space.Space_id, File: space_file.File, Name: name,
+			Reason: fmt.Sprintf("SDI has space id %d", space_id)})
+	}
+}
+
+// SYS_DATAFILES中的路径是相对datadir的(./db/t1.ibd)或者绝对路径, 分区名大小写在不同版本不一样(#P#,#p#)
+func Normalize_Datafile_Path(datadir string, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(datadir, path)
+	}
+	return strings.ToLower(filepath.Clean(path))
+}
+
+func (check *DatadirCheck) Dump() {
+	println("datadir check:")
+
+	data, _ := json.Marshal(check)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
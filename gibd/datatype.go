@@ -87,7 +87,7 @@ func NewIntegerType(base_type string, modifiers string, properties string) *Inte
 
 }
 
-// 这个是不对的，需要使用ParseMySQLInt 方法
+// 有符号整数存储的时候最高位取反, 无符号的直接按大端读取
 func (integer *IntegerType) Value(data []byte, index *IndexPage) int64 {
 	nbits := integer.width * 8
 	if integer.unsigned {
@@ -102,7 +102,8 @@ func (integer *IntegerType) Get_Uint(data []byte, nbits int, index *IndexPage) i
 	return int64(BytesToUIntLittleEndian(data))
 }
 func (integer *IntegerType) Get_Int(data []byte, nbits int, index *IndexPage) int64 {
-	return int64(BytesToIntLittleEndian(index.Page, data))
+	value := uint64(BytesToUIntLittleEndian(data)) ^ (1 << uint(nbits-1))
+	return int64(value<<uint(64-nbits)) >> uint(64-nbits)
 }
 
 type TransactionIdType struct {
@@ -124,17 +125,18 @@ func (t *TransactionIdType) Read(offset uint64, p *Page) uint64 {
 	return transaction_id
 }
 
+// 回滚指针 is_insert(1bit) rseg_id(7bit) undo页号(4字节) 页内偏移(2字节)
 type Pointer struct {
-	is_insert bool
-	rseg_id   uint64
-	undo_log  *Address
+	Is_insert bool     `json:"is_insert"`
+	Rseg_id   uint64   `json:"rseg_id"`
+	Undo_log  *Address `json:"undo_log"`
 }
 
 func NewPointer(is_insert bool, rseg_id uint64, undo_log *Address) *Pointer {
 	return &Pointer{
-		is_insert: is_insert,
-		rseg_id:   rseg_id,
-		undo_log:  undo_log,
+		Is_insert: is_insert,
+		Rseg_id:   rseg_id,
+		Undo_log:  undo_log,
 	}
 }
 
@@ -157,9 +159,8 @@ func (r *RollPointerType) Parse_Roll_Pointer(roll_ptr uint64) *Pointer {
 	is_insert := Read_Bits_At_Offset(roll_ptr, 1, 55) == 1
 	rseg_id := Read_Bits_At_Offset(roll_ptr, 7, 48)
 	page := Read_Bits_At_Offset(roll_ptr, 32, 16)
-	// offset := Read_Bits_At_Offset(roll_ptr, 16, 0)
-	// undo_log := NewAddress(page, offset)
-	undo_log := NewAddress(page)
+	offset := Read_Bits_At_Offset(roll_ptr, 16, 0)
+	undo_log := NewAddress(page, offset)
 	return NewPointer(is_insert, rseg_id, undo_log)
 }
 
@@ -177,24 +178,98 @@ func BytesToInt(b []byte) uint64 {
 }
 
 func (r *RollPointerType) Value(data []uint8) *Pointer {
-	roll_ptr := BytesToInt(data)
+	roll_ptr := uint64(BytesToUIntLittleEndian(data))
 	p := r.Parse_Roll_Pointer(roll_ptr)
 	r.p = p
 	return r.p
 }
 
-type VariableCharacterType struct {
+// 空间索引中的MBR, 4个小端存储的double, 参考mach_double_read
+type MbrType struct {
 	name  string
 	width int
 }
 
+func NewMbrType(base_type string, modifiers string, properties string) *MbrType {
+	name := Make_Name(base_type, modifiers, properties)
+	return &MbrType{name: name, width: DATA_MBR_LEN}
+}
+
+func (m *MbrType) Value(data []byte) *MBR {
+	return NewMBR(data)
+}
+
+// GEOMETRY, POINT这些类型和BLOB一样是变长的, 前4个字节是SRID(小端), 后面是WKB
+type GeometryType struct {
+	name string
+}
+
+type Geometry struct {
+	Srid uint32 `json:"srid"`
+	Wkb  string `json:"wkb"` // 十六进制
+}
+
+func NewGeometryType(base_type string, modifiers string, properties string) *GeometryType {
+	return &GeometryType{name: Make_Name(base_type, modifiers, properties)}
+}
+
+func (g *GeometryType) Value(data []byte) *Geometry {
+	if len(data) < 4 {
+		return &Geometry{Wkb: fmt.Sprintf("%x", data)}
+	}
+	return &Geometry{Srid: binary.LittleEndian.Uint32(data), Wkb: fmt.Sprintf("%x", data[4:])}
+}
+
+// BLOB和TEXT, compact格式中长度可能用2个字节存储, 长的时候放在BLOB页中
+type BlobType struct {
+	name string
+}
+
+func NewBlobType(base_type string, modifiers string, properties string) *BlobType {
+	return &BlobType{name: Make_Name(base_type, modifiers, properties)}
+}
+
+// 外部存储的时候只是记录中的前缀
+func (b *BlobType) Value(data []byte) string {
+	return string(data)
+}
+
+type VariableCharacterType struct {
+	name     string
+	width    int
+	mbmaxlen int // 字符集中一个字符最多占用的字节数
+}
+
 func NewVariableCharacterType(base_type string, modifiers string, properties string) *VariableCharacterType {
 	width, _ := strconv.Atoi(modifiers)
 	name := Make_Name(base_type, modifiers, properties)
 	return &VariableCharacterType{
-		name:  name,
-		width: width,
+		name:     name,
+		width:    width,
+		mbmaxlen: Charset_Mbmaxlen(properties),
+	}
+}
+
+// 最大的字节长度超过255的时候, compact格式中长度可能用2个字节存储
+func (r *VariableCharacterType) Is_Big() bool {
+	return r.width*r.mbmaxlen > 255
+}
+
+var CHARSET_MBMAXLEN = map[string]int{
+	"latin1": 1, "ascii": 1, "binary": 1, "gbk": 2, "gb2312": 2, "big5": 2,
+	"utf8": 3, "utf8mb3": 3, "utf8mb4": 4, "utf16": 4, "utf32": 4,
+}
+
+// properties中的CHARSET=xxx, 没有指定字符集的时候按单字节处理
+func Charset_Mbmaxlen(properties string) int {
+	for _, p := range strings.Fields(properties) {
+		if strings.HasPrefix(p, "CHARSET=") {
+			if n, ok := CHARSET_MBMAXLEN[strings.ToLower(p[len("CHARSET="):])]; ok {
+				return n
+			}
+		}
 	}
+	return 1
 }
 
 func (r *VariableCharacterType) Value(data string) string {
@@ -253,6 +328,8 @@ var TYPES = map[string]string{
 	"TIMESTAMP":  "TimeStampType",
 	"TRX_ID":     "TransactionIdType",
 	"ROLL_PTR":   "RollPointerType",
+	"MBR":        "MbrType",
+	"GEOMETRY":   "GeometryType",
 }
 
 var TYPE_STRUCT_MAP = map[string]reflect.Type{
@@ -288,6 +365,12 @@ func NewType(base_type string, modifiers string, properties string) (c interface
 		return NewTransactionIdType(base_type, modifiers, properties), nil
 	case "ROLL_PTR":
 		return NewRollPointerType(base_type, modifiers, properties), nil
+	case "MBR":
+		return NewMbrType(base_type, modifiers, properties), nil
+	case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "TEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT":
+		return NewBlobType(base_type, modifiers, properties), nil
+	case "GEOMETRY", "POINT", "LINESTRING", "POLYGON", "MULTIPOINT", "MULTILINESTRING", "MULTIPOLYGON", "GEOMETRYCOLLECTION", "GEOMCOLLECTION":
+		return NewGeometryType(base_type, modifiers, properties), nil
 	case "VARCHAR":
 		return NewVariableCharacterType(base_type, modifiers, properties), nil
 	}
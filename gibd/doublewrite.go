@@ -0,0 +1,148 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/tidwall/pretty"
+)
+
+// doublewrite buffer buf0dblwr.cc
+// 8.0.20以前是系统表空间中TRX_SYS页指向的两个extent(各64个页), 8.0.20以后是datadir下的#ib_<page size>_<n>.dblwr文件
+// 里面都是完整的页, 页自己的文件头中有space id和页号
+type DoublewritePage struct {
+	Source         string `json:"source"`
+	Slot           uint64 `json:"slot"` // 在doublewrite buffer中的第几个页
+	Space_id       uint64 `json:"space_id"`
+	Page_number    uint64 `json:"page_number"`
+	Page_type      string `json:"page_type"`
+	Lsn            uint64 `json:"lsn"`
+	Checksum       string `json:"checksum"`
+	Checksum_valid bool   `json:"checksum_valid"`
+	Data           []byte `json:"-"`
+}
+
+// 修复的结果
+type DoublewriteRepair struct {
+	Space_id    uint64 `json:"space_id"`
+	Page_number uint64 `json:"page_number"`
+	Checksum    string `json:"checksum"` // 表空间中的页原来的checksum状态
+	Lsn         uint64 `json:"lsn"`      // 写入的doublewrite页的lsn
+	Source      string `json:"source"`
+	Slot        uint64 `json:"slot"`
+	Repaired    bool   `json:"repaired"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+func NewDoublewritePage(source string, slot uint64, data []byte) *DoublewritePage {
+	dw_page := &DoublewritePage{Source: source, Slot: slot, Data: data}
+	dw_page.Space_id = uint64(BytesToUIntLittleEndian(data[34:38]))
+	dw_page.Page_number = uint64(BytesToUIntLittleEndian(data[4:8]))
+	dw_page.Page_type = PAGE_TYPE[BytesToUIntLittleEndian(data[24:26])]
+	dw_page.Lsn = uint64(BytesToUIntLittleEndian(data[FIL_PAGE_LSN : FIL_PAGE_LSN+8]))
+	dw_page.Checksum, dw_page.Checksum_valid = Page_Checksum(data)
+	return dw_page
+}
+
+// 系统表空间中的doublewrite buffer, 没有创建的时候返回nil
+func (trx_sys *TrxSys) Each_Doublewrite_Page() []*DoublewritePage {
+	if trx_sys.Doublewrite == nil || !trx_sys.Doublewrite.Is_Valid() {
+		return nil
+	}
+	space := trx_sys.Page.Space
+	var pages []*DoublewritePage
+	var slot uint64
+	for _, block := range []uint64{trx_sys.Doublewrite.Block1, trx_sys.Doublewrite.Block2} {
+		for i := uint64(0); i < TRX_SYS_DOUBLEWRITE_BLOCK_SIZE; i++ {
+			page_number := block + i
+			if page_number >= space.Pages {
+				break
+			}
+			data := space.Page_Data(page_number)
+			if !Is_Zero_Page(data) {
+				pages = append(pages, NewDoublewritePage(space.Name, slot, data))
+			}
+			slot++
+		}
+	}
+	return pages
+}
+
+// 8.0.20以后的dblwr文件, 整个文件都是页, 没有用过的页是全0的
+func Each_Doublewrite_File_Page(filename string) ([]*DoublewritePage, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var pages []*DoublewritePage
+	for slot := uint64(0); (slot+1)*DEFAULT_PAGE_SIZE <= uint64(len(data)); slot++ {
+		page := data[slot*DEFAULT_PAGE_SIZE : (slot+1)*DEFAULT_PAGE_SIZE]
+		if !Is_Zero_Page(page) {
+			pages = append(pages, NewDoublewritePage(filename, slot, page))
+		}
+	}
+	return pages, nil
+}
+
+// datadir(或者innodb_doublewrite_dir)下的dblwr文件
+func Each_Doublewrite_File(datadir string) []string {
+	filenames, _ := filepath.Glob(filepath.Join(datadir, "#ib_*.dblwr"))
+	sort.Strings(filenames)
+	return filenames
+}
+
+// 用doublewrite中的页修复表空间中checksum不对或者写了一半的页, 同一个页有多份的时候用lsn最大的
+// 表空间中的页是好的时候不修改, 即使doublewrite中的更新, 这和崩溃恢复时的处理一样
+// 会直接写space的文件, 调用的人要保证这是一个副本
+func (space *Space) Repair_From_Doublewrite(dw_pages []*DoublewritePage) []*DoublewriteRepair {
+	best := make(map[uint64]*DoublewritePage)
+	for _, dw_page := range dw_pages {
+		if dw_page.Space_id != space.Space_id || !dw_page.Checksum_valid {
+			continue
+		}
+		if current, ok := best[dw_page.Page_number]; !ok || dw_page.Lsn > current.Lsn {
+			best[dw_page.Page_number] = dw_page
+		}
+	}
+	var page_numbers []uint64
+	for page_number := range best {
+		page_numbers = append(page_numbers, page_number)
+	}
+	sort.Slice(page_numbers, func(i, j int) bool { return page_numbers[i] < page_numbers[j] })
+
+	var repairs []*DoublewriteRepair
+	for _, page_number := range page_numbers {
+		dw_page := best[page_number]
+		repair := &DoublewriteRepair{Space_id: space.Space_id, Page_number: page_number,
+			Lsn: dw_page.Lsn, Source: dw_page.Source, Slot: dw_page.Slot}
+		repairs = append(repairs, repair)
+		if page_number >= space.Pages {
+			repair.Checksum = "missing"
+			repair.Reason = "page is beyond the end of the file"
+			continue
+		}
+		var valid bool
+		repair.Checksum, valid = Page_Checksum(space.Page_Data(page_number))
+		if valid {
+			repair.Reason = "page is not corrupted"
+			continue
+		}
+		if err := space.Write_Page(page_number, dw_page.Data); err != nil {
+			repair.Reason = err.Error()
+			continue
+		}
+		repair.Repaired = true
+	}
+	return repairs
+}
+
+func (dw_page *DoublewritePage) Dump() {
+	println("doublewrite page:")
+
+	data, _ := json.Marshal(dw_page)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
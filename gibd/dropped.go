@@ -0,0 +1,156 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/tidwall/pretty"
+)
+
+// 共享表空间中删除的表
+// drop以后表的段被释放, 页回到free extent或者free_frag extent里, 在被重新分配之前数据还在
+// 索引id可以由用户指定, 也可以从SYS_INDEXES中已经purge但是还在PAGE_FREE链表中的记录里找
+const DROPPED_SOURCE_USER = "user"
+const DROPPED_SOURCE_SYS_INDEXES = "sys_indexes"
+
+type DroppedIndex struct {
+	Index_id   uint64   `json:"index_id"`
+	Table_id   uint64   `json:"table_id,omitempty"`
+	Table_name string   `json:"table_name,omitempty"`
+	Index_name string   `json:"index_name,omitempty"`
+	Root_page  uint64   `json:"root_page,omitempty"`
+	Source     string   `json:"source"`
+	Leaf_pages []uint64 `json:"leaf_pages"`
+	Free_pages uint64   `json:"free_pages"`
+	Used_pages uint64   `json:"used_pages"` // 还在用的页, 说明index id已经被别的索引用了或者不是删除的索引
+}
+
+func to_uint64(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint64:
+		return v, true
+	case int64:
+		return uint64(v), true
+	case int:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case int32:
+		return uint64(v), true
+	}
+	return 0, false
+}
+
+// SYS_INDEXES中删除的共享表空间的索引, 表名从SYS_TABLES中删除的记录里找
+func (dh *DataDictionary) Each_Dropped_Index() []*DroppedIndex {
+	table_names := make(map[uint64]string)
+	if tree := dh.Get_Data_Dictionary_Index_Tree("SYS_TABLES", "PRIMARY"); tree != nil {
+		it := tree.Page_Iterator(0, nil)
+		index, err := it.Next()
+		for ; index != nil; index, err = it.Next() {
+			for _, deleted := range index.Each_Deleted_Record() {
+				if deleted.Status != UNDELETE_STATUS_OK {
+					continue
+				}
+				table_id, ok := to_uint64(deleted.Fields["ID"])
+				name, _ := deleted.Fields["NAME"].(string)
+				if ok {
+					table_names[table_id] = name
+				}
+			}
+		}
+		if err != nil {
+			Log.Info("%v\n", err)
+		}
+	}
+
+	var dropped []*DroppedIndex
+	tree := dh.Get_Data_Dictionary_Index_Tree("SYS_INDEXES", "PRIMARY")
+	if tree == nil {
+		return dropped
+	}
+	it := tree.Page_Iterator(0, nil)
+	index, err := it.Next()
+	for ; index != nil; index, err = it.Next() {
+		for _, deleted := range index.Each_Deleted_Record() {
+			if deleted.Status != UNDELETE_STATUS_OK {
+				continue
+			}
+			space_id, _ := to_uint64(deleted.Fields["SPACE"])
+			index_id, ok := to_uint64(deleted.Fields["ID"])
+			if !ok || space_id != 0 {
+				continue
+			}
+			table_id, _ := to_uint64(deleted.Fields["TABLE_ID"])
+			root_page, _ := to_uint64(deleted.Fields["PAGE_NO"])
+			index_name, _ := deleted.Fields["NAME"].(string)
+			dropped = append(dropped, &DroppedIndex{Index_id: index_id, Table_id: table_id, Table_name: table_names[table_id],
+				Index_name: index_name, Root_page: root_page, Source: DROPPED_SOURCE_SYS_INDEXES})
+		}
+	}
+	if err != nil {
+		Log.Info("%v\n", err)
+	}
+	return dropped
+}
+
+// 用户指定的索引id加上SYS_INDEXES中找到的, 扫描系统表空间的所有页, 找到这些索引的叶子页
+func (system *System) Each_Dropped_Index(index_ids []uint64) []*DroppedIndex {
+	space := system.System_Space()
+	indexes := make(map[uint64]*DroppedIndex)
+	var dropped []*DroppedIndex
+	for _, index_id := range index_ids {
+		if _, ok := indexes[index_id]; !ok {
+			indexes[index_id] = &DroppedIndex{Index_id: index_id, Source: DROPPED_SOURCE_USER}
+			dropped = append(dropped, indexes[index_id])
+		}
+	}
+	for _, index := range system.data_dictionary.Each_Dropped_Index() {
+		if current, ok := indexes[index.Index_id]; ok {
+			// 用户指定的索引在SYS_INDEXES中也找到了, 补上表名
+			current.Table_id, current.Table_name, current.Index_name, current.Root_page =
+				index.Table_id, index.Table_name, index.Index_name, index.Root_page
+			continue
+		}
+		indexes[index.Index_id] = index
+		dropped = append(dropped, index)
+	}
+	if len(dropped) == 0 {
+		return dropped
+	}
+
+	xdes_pages := NewXdesPages(space)
+	for page_number := uint64(0); page_number < space.Pages; page_number++ {
+		page := space.Page(page_number)
+		if page.FileHeader.Page_type != FIL_PAGE_INDEX {
+			continue
+		}
+		index_page := NewIndex(page)
+		index, ok := indexes[index_page.PageHeader.Index_id]
+		if !ok || index_page.PageHeader.Level != 0 {
+			continue
+		}
+		index.Leaf_pages = append(index.Leaf_pages, page_number)
+		if xdes_pages.Is_Page_Free(page_number) {
+			index.Free_pages++
+		} else {
+			index.Used_pages++
+		}
+	}
+	sort.Slice(dropped, func(i, j int) bool { return dropped[i].Index_id < dropped[j].Index_id })
+	return dropped
+}
+
+// 删除的索引的叶子页, 用建表语句解析上面的记录
+func (system *System) Dropped_Index_Page_Iterator(index *DroppedIndex, record_describer interface{}) *LeafPageIterator {
+	return system.System_Space().Leaf_Page_Iterator(index.Leaf_pages, record_describer)
+}
+
+func (index *DroppedIndex) Dump() {
+	println("dropped index:")
+
+	data, _ := json.Marshal(index)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
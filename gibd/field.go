@@ -33,7 +33,7 @@ func NewFieldDescriptor(name string, field_type string, value interface{}, exter
 	}
 }
 
-//  字段定义以及元数据信息
+// 字段定义以及元数据信息
 type RecordFieldMeta struct {
 	Extern   *ExternReference
 	Position int
@@ -45,6 +45,11 @@ type RecordFieldMeta struct {
 	// char varchar字段 字符长度
 	Length     int
 	Properties string
+	// instant ADD/DROP COLUMN, 只有聚簇索引的非key字段有
+	Instant         bool
+	Version_added   uint64
+	Version_dropped uint64
+	Default         interface{} // 记录中没有这个字段时的值
 }
 
 func NewRecordFieldMeta(position int, name string, type_definition string, properties string) *RecordFieldMeta {
@@ -70,22 +75,55 @@ func (rf *RecordFieldMeta) Is_Nullable(record *Record) bool {
 }
 
 func (rf *RecordFieldMeta) Is_Variable() bool {
-	types := []string{"BlobType", "VariableBinaryType", "VariableCharacterType"}
-	for _, element := range types {
-		if rf.DataType == element {
-			return true
-		}
+	switch rf.DataType.(type) {
+	case *VariableCharacterType, *GeometryType, *BlobType:
+		return true
 	}
 	return false
 }
 
-func (rf *RecordFieldMeta) Is_Blob() bool {
-	if rf.DataType == "BlobType" {
+// compact格式中变长字段的长度是否可能用2个字节存储
+func (rf *RecordFieldMeta) Is_Big() bool {
+	switch rf.DataType.(type) {
+	case *VariableCharacterType:
+		return rf.DataType.(*VariableCharacterType).Is_Big()
+	case *GeometryType, *BlobType:
 		return true
 	}
 	return false
 }
 
+// 固定长度字段的字节数, 变长字段返回0
+func (rf *RecordFieldMeta) Fixed_Length() int64 {
+	switch rf.DataType.(type) {
+	case *IntegerType:
+		return int64(rf.DataType.(*IntegerType).width)
+	case *BitType:
+		return int64(rf.DataType.(*BitType).width)
+	case *TransactionIdType:
+		return 6
+	case *RollPointerType:
+		return 7
+	case *MbrType:
+		return DATA_MBR_LEN
+	}
+	return 0
+}
+
+func (rf *RecordFieldMeta) Is_Null(record *UserRecord) bool {
+	for _, name := range record.header.Nulls {
+		if rf.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (rf *RecordFieldMeta) Is_Blob() bool {
+	_, ok := rf.DataType.(*BlobType)
+	return ok
+}
+
 func Parse_Type_Definition(type_definition string) (string, string) {
 	// base_type := "varchar(100)" modifiers=100
 	if strings.Contains(type_definition, "(") && strings.Contains(type_definition, ")") {
@@ -105,6 +143,13 @@ func (rf *RecordFieldMeta) Value(offset uint64, record *UserRecord, index *Index
 	if record == nil {
 		return nil, 0
 	}
+	//compact格式null字段不占空间, redundant格式的长度在header中
+	if rf.Is_Null(record) {
+		if length := rf.length(record); length > 0 {
+			return nil, uint64(length)
+		}
+		return nil, 0
+	}
 
 	return rf.Value_By_Length(offset, rf.length(record), index)
 }
@@ -112,23 +157,42 @@ func (rf *RecordFieldMeta) Value(offset uint64, record *UserRecord, index *Index
 func (rf *RecordFieldMeta) Value_By_Length(offset uint64, field_length int64, index *IndexPage) (interface{}, uint64) {
 
 	switch rf.DataType.(type) {
-	case *IntegerType:
-		return rf.DataType.(*IntegerType).Value(rf.Read(offset, field_length, index), index), uint64(field_length)
+	case *IntegerType, *RollPointerType, *VariableCharacterType, *MbrType, *GeometryType, *BlobType:
 	case *TransactionIdType:
-		return rf.DataType.(*TransactionIdType).Read(offset, index.Page), 6
-	case *RollPointerType:
-		bytes := rf.Read(offset, field_length, index)
-
-		return rf.DataType.(*RollPointerType).Value(bytes), uint64(field_length)
-	case *VariableCharacterType:
-
-		return rf.DataType.(*VariableCharacterType).Value(string(rf.Read(offset, field_length, index))), uint64(field_length)
+		field_length = 6
 	default:
 		Log.Info("value_by_length() 还未实现的类型========%\n")
+		return nil, 0
 	}
 
-	return nil, 0
+	return rf.Value_From_Bytes(rf.Read(offset, field_length, index)), uint64(field_length)
+
+}
 
+// 按字段类型解析字节, 索引记录和undo记录中字段的存储格式是一样的
+func (rf *RecordFieldMeta) Value_From_Bytes(data []byte) interface{} {
+	switch rf.DataType.(type) {
+	case *IntegerType:
+		integer := rf.DataType.(*IntegerType)
+		//无符号BIGINT会超过int64的范围
+		if integer.unsigned && integer.width == 8 {
+			return uint64(BytesToUIntLittleEndian(data))
+		}
+		return integer.Value(data, nil)
+	case *TransactionIdType:
+		return uint64(BytesToUIntLittleEndian(data))
+	case *RollPointerType:
+		return rf.DataType.(*RollPointerType).Value(data)
+	case *VariableCharacterType:
+		return rf.DataType.(*VariableCharacterType).Value(string(data))
+	case *MbrType:
+		return rf.DataType.(*MbrType).Value(data)
+	case *GeometryType:
+		return rf.DataType.(*GeometryType).Value(data)
+	case *BlobType:
+		return rf.DataType.(*BlobType).Value(data)
+	}
+	return nil
 }
 
 func (rf *RecordFieldMeta) length(record *UserRecord) int64 {
@@ -144,16 +208,9 @@ func (rf *RecordFieldMeta) length(record *UserRecord) int64 {
 	if name_in_header_lengths_map {
 		len = int64(record.header.Lengths[rf.Name])
 	} else {
-		switch value := rf.DataType.(type) {
-		case *IntegerType:
-			len = int64(rf.DataType.(*IntegerType).width)
-		case *BitType:
-			len = int64(rf.DataType.(*BitType).width)
-		// case *VariableCharacterType:
-		// 	//此处的变长字段长度值，需要在record header 中的variable field lengths中获取
-		// 	len = int64(rf.data_type.(*VariableCharacterType).width)
-		default:
-			fmt.Println("unkown data type===>", value)
+		len = rf.Fixed_Length()
+		if len == 0 {
+			fmt.Println("unkown data type===>", rf.DataType)
 		}
 	}
 
@@ -164,8 +221,8 @@ func (rf *RecordFieldMeta) length(record *UserRecord) int64 {
 }
 
 func (rf *RecordFieldMeta) Is_Extern(record *UserRecord) bool {
-	for i := 0; i < len(record.header.Externs); i++ {
-		if rf.Name == string(record.header.Externs[i]) {
+	for _, name := range record.header.Externs {
+		if rf.Name == name {
 			return true
 		}
 	}
@@ -187,6 +244,28 @@ func (rf *RecordFieldMeta) Read_Extern(offset int64, index *IndexPage) *ExternRe
 	return NewExternReference(uint64(space_id), uint64(page_number), uint64(e_offset), uint64(length))
 }
 
+// 外部存储的字段, BLOB页链表: part_len(4) next_page(4) data, 5.7的BLOB页和8.0的SDI BLOB页是这个格式
+// 8.0的用户表用的是新的LOB格式(FIL_PAGE_TYPE_LOB_FIRST), 没有实现
+func (s *Space) Read_Blob(page_number uint64, length uint64) ([]byte, error) {
+	var data []byte
+	for i := uint64(0); page_number != FIL_NULL && uint64(len(data)) < length; i++ {
+		if i > s.Pages || page_number >= s.Pages {
+			return nil, fmt.Errorf("blob page list is broken at page %d", page_number)
+		}
+		page := s.Page(page_number)
+		if page.FileHeader.Page_type != FIL_PAGE_TYPE_BLOB && page.FileHeader.Page_type != FIL_PAGE_SDI_BLOB {
+			return nil, fmt.Errorf("page %d is not a blob page, page type is %d", page_number, page.FileHeader.Page_type)
+		}
+		part_len := uint64(BufferReadAt(page, 38, 4))
+		if part_len > DEFAULT_PAGE_SIZE-46 {
+			return nil, fmt.Errorf("bad blob part length %d on page %d", part_len, page_number)
+		}
+		data = append(data, ReadBytes(page, 46, int64(part_len))...)
+		page_number = uint64(BufferReadAt(page, 42, 4))
+	}
+	return data, nil
+}
+
 func (rf *RecordFieldMeta) Has_Method(data_type interface{}, method_name string) bool {
 
 	switch value := data_type.(type) {
@@ -41,6 +41,27 @@ func NewBaseNode() *BaseNode {
 	return &BaseNode{}
 }
 
+// 从页的pos位置读取链表的base node, 16字节
+func Read_Base_Node(p *Page, pos int64) *BaseNode {
+	base_node := NewBaseNode()
+	base_node.ListLen = uint64(BufferReadAt(p, pos, 4))
+	base_node.First_page = uint64(BufferReadAt(p, pos+4, 4))
+	base_node.First_offset = uint64(BufferReadAt(p, pos+8, 2))
+	base_node.Last_page = uint64(BufferReadAt(p, pos+10, 4))
+	base_node.Last_offset = uint64(BufferReadAt(p, pos+14, 2))
+	return base_node
+}
+
+// 从页的pos位置读取链表节点, 12字节
+func Read_Node(p *Page, pos int64) *Node {
+	node := NewNode()
+	node.Prev_page = uint64(BufferReadAt(p, pos, 4))
+	node.Prev_offset = uint64(BufferReadAt(p, pos+4, 2))
+	node.Next_page = uint64(BufferReadAt(p, pos+6, 4))
+	node.Next_offset = uint64(BufferReadAt(p, pos+10, 2))
+	return node
+}
+
 type FspHeader struct {
 	Space_id         uint64    `json:"space_id"`
 	Unused           uint64    `json:"unused"`
@@ -56,13 +77,61 @@ type FspHeader struct {
 	Free_inodes      uint64    `json:"free_inodes"` // base node for free_inodes list
 }
 
+// extent的状态 fsp0fsp.h
+const XDES_FREE = 1      // 在表空间的free链表上
+const XDES_FREE_FRAG = 2 // 在free_frag链表上, 里面的页单独分配
+const XDES_FULL_FRAG = 3 // 在full_frag链表上
+const XDES_FSEG = 4      // 属于一个段
+const XDES_FSEG_FRAG = 5 // 8.0, 属于一个段的碎片extent
+
+const FSP_EXTENT_SIZE = 64
+const XDES_BITS_PER_PAGE = 2
+const XDES_FREE_BIT = 0
+
 //xdes entry
 type Xdes struct {
 	F_seg_id  uint64 `json:"f_seg_id"`
 	Xdes_List *Node  `json:"xdes_list"`
 	State     uint64 `json:"state"`
 	Bitmap    string `json:"bitmap"`
+	bitmap    []byte
 }
+
+// extent中第i个页是否空闲, 没有初始化的extent(state是0)和free的extent中的页都是空闲的
+func (xdes *Xdes) Is_Page_Free(i uint64) bool {
+	if xdes.State == 0 || xdes.State == XDES_FREE || len(xdes.bitmap) == 0 {
+		return true
+	}
+	bit := i*XDES_BITS_PER_PAGE + XDES_FREE_BIT
+	return (xdes.bitmap[bit/8]>>(bit%8))&1 != 0
+}
+
+// 按页号找extent描述, 每16384个页有一个描述页, 读过的描述页缓存起来
+type XdesPages struct {
+	space *Space
+	pages map[uint64]*FspHdrXdes
+}
+
+func NewXdesPages(space *Space) *XdesPages {
+	return &XdesPages{space: space, pages: make(map[uint64]*FspHdrXdes)}
+}
+
+func (x *XdesPages) Xdes(page_number uint64) *Xdes {
+	xdes_page_number := page_number - page_number%DEFAULT_PAGE_SIZE
+	fsp, ok := x.pages[xdes_page_number]
+	if !ok {
+		value := NewFspHdrXdes(x.space.Page(xdes_page_number))
+		value.Fsp_Header()
+		fsp = &value
+		x.pages[xdes_page_number] = fsp
+	}
+	return &fsp.Xdes[(page_number%DEFAULT_PAGE_SIZE)/FSP_EXTENT_SIZE]
+}
+
+func (x *XdesPages) Is_Page_Free(page_number uint64) bool {
+	return x.Xdes(page_number).Is_Page_Free(page_number % FSP_EXTENT_SIZE)
+}
+
 type FspHdrXdes struct {
 	Page *Page
 	// Flags     Flags
@@ -141,6 +210,7 @@ func (f *FspHdrXdes) Fsp_Header() {
 		pos = pos + 16
 
 		xdes.Bitmap = BytesToBinaryString(bitmap)
+		xdes.bitmap = bitmap
 		xdes.F_seg_id = f_seg_id
 		xdes.State = state
 		xdes.Xdes_List = node
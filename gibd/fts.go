@@ -0,0 +1,277 @@
+package gibd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FULLTEXT索引的数据不在用户表的B+树中, 在辅助表中, 每个辅助表是一个ibd文件, 参考fts0fts.cc, fts0priv.h
+// FTS_<table_id>_<index_id>_INDEX_1..6: 每个FULLTEXT索引6个, 按词的第一个字符分到不同的表, 参考fts_index_selector
+// INDEX表的聚簇索引是(word, first_doc_id), 后面是last_doc_id, doc_count, ilist; 一个词可能有多行
+// FTS_<table_id>_DELETED, BEING_DELETED: 删掉的doc id, OPTIMIZE TABLE之前ilist中还有这些doc, 查询的时候过滤掉
+// FTS_<table_id>_DELETED_CACHE, BEING_DELETED_CACHE: 同上, 一般是空的
+// FTS_<table_id>_CONFIG: key value, synced_doc_id之后插入的doc还在内存的cache中, 没有写到INDEX表
+// table_id和index_id是16位的十六进制, 5.7的文件名是FTS_0000000000000439_00000000000004a0_INDEX_1.ibd, 8.0是小写的
+// 用户表中有一个FTS_DOC_ID字段(BIGINT UNSIGNED NOT NULL)和FTS_DOC_ID_INDEX唯一索引, 没有定义的时候InnoDB自己加
+const FTS_DOC_ID_COL_NAME = "FTS_DOC_ID"
+const FTS_DOC_ID_INDEX_NAME = "FTS_DOC_ID_INDEX"
+const FTS_NUM_AUX_INDEX = 6
+const FTS_MAX_WORD_LEN_IN_CHAR = 84
+const FTS_CONFIG_TABLE_KEY_COL_LEN = 50
+const FTS_CONFIG_TABLE_VALUE_COL_LEN = 200
+
+var fts_aux_file = regexp.MustCompile(`(?i)^FTS_([0-9a-f]{16})_(?:([0-9a-f]{16})_)?(INDEX_[1-6]|BEING_DELETED_CACHE|BEING_DELETED|DELETED_CACHE|DELETED|CONFIG)\.ibd$`)
+
+// 有FULLTEXT索引的表, 没有FTS_DOC_ID字段的时候加在所有字段后面, FTS_DOC_ID_INDEX在所有二级索引后面
+func (td *TableDescriber) Add_Fts_Doc_Id() {
+	if len(td.Fulltext) == 0 {
+		return
+	}
+	if td.Column(FTS_DOC_ID_COL_NAME) == nil {
+		td.Columns = append(td.Columns, &TableColumn{Name: FTS_DOC_ID_COL_NAME, Type: "BIGINT", Unsigned: true,
+			Charset: td.Charset, Hidden: true})
+	}
+	if td.Index(FTS_DOC_ID_INDEX_NAME) == nil && !strings.EqualFold(td.Clustered_index, FTS_DOC_ID_INDEX_NAME) {
+		td.Indexes = append(td.Indexes, &TableIndex{Name: FTS_DOC_ID_INDEX_NAME, Unique: true,
+			Columns: []string{FTS_DOC_ID_COL_NAME}, Prefixes: []int{0}})
+	}
+}
+
+// INDEX_1..6的表结构, word字段的类型和FULLTEXT索引的第一个字段一样(字符集, 是否可以为NULL)
+func NewFtsIndexTableDescriber(charset string, nullable bool) *TableDescriber {
+	return &TableDescriber{TAB_TYPE: "clustered", Name: "FTS_INDEX_TABLE", Charset: charset,
+		Clustered_index: "FTS_INDEX_TABLE_IND", Primary: []string{"word", "first_doc_id"},
+		Columns: []*TableColumn{
+			{Name: "word", Type: "VARCHAR(" + strconv.Itoa(FTS_MAX_WORD_LEN_IN_CHAR) + ")", Nullable: nullable, Charset: charset},
+			{Name: "first_doc_id", Type: "BIGINT", Unsigned: true},
+			{Name: "last_doc_id", Type: "BIGINT", Unsigned: true},
+			{Name: "doc_count", Type: "INT", Unsigned: true},
+			{Name: "ilist", Type: "BLOB", Charset: "binary"},
+		}}
+}
+
+// DELETED, BEING_DELETED, DELETED_CACHE, BEING_DELETED_CACHE, doc_id建表的时候没有NOT NULL
+func NewFtsDeletedTableDescriber(name string) *TableDescriber {
+	return &TableDescriber{TAB_TYPE: "clustered", Name: name, Charset: "binary", Clustered_index: "IND",
+		Primary: []string{"doc_id"},
+		Columns: []*TableColumn{{Name: "doc_id", Type: "BIGINT", Unsigned: true, Nullable: true}}}
+}
+
+// CONFIG, key建表的时候没有NOT NULL
+func NewFtsConfigTableDescriber() *TableDescriber {
+	return &TableDescriber{TAB_TYPE: "clustered", Name: "CONFIG", Charset: "latin1", Clustered_index: "IND",
+		Primary: []string{"key"},
+		Columns: []*TableColumn{
+			{Name: "key", Type: "VARCHAR(" + strconv.Itoa(FTS_CONFIG_TABLE_KEY_COL_LEN) + ")", Nullable: true, Charset: "latin1"},
+			{Name: "value", Type: "VARCHAR(" + strconv.Itoa(FTS_CONFIG_TABLE_VALUE_COL_LEN) + ")", Charset: "latin1"},
+		}}
+}
+
+type FtsAuxTable struct {
+	Table_id uint64 `json:"table_id"`
+	Index_id uint64 `json:"index_id,omitempty"` // 只有INDEX_1..6有
+	Name     string `json:"name"`               // INDEX_1, DELETED, CONFIG这些, 大写
+	File     string `json:"file"`
+}
+
+func (aux *FtsAuxTable) Is_Index() bool {
+	return strings.HasPrefix(aux.Name, "INDEX_")
+}
+
+// 目录下所有的辅助表, 按table_id, index_id, 表名排序
+func Fts_Aux_Tables(dir string) ([]*FtsAuxTable, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var tables []*FtsAuxTable
+	for _, file := range files {
+		m := fts_aux_file.FindStringSubmatch(file.Name())
+		if m == nil || file.IsDir() {
+			continue
+		}
+		aux := &FtsAuxTable{Name: strings.ToUpper(m[3]), File: filepath.Join(dir, file.Name())}
+		aux.Table_id, _ = strconv.ParseUint(m[1], 16, 64)
+		if m[2] != "" {
+			aux.Index_id, _ = strconv.ParseUint(m[2], 16, 64)
+		}
+		if aux.Is_Index() != (m[2] != "") {
+			continue
+		}
+		tables = append(tables, aux)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		a, b := tables[i], tables[j]
+		if a.Table_id != b.Table_id {
+			return a.Table_id < b.Table_id
+		}
+		if a.Index_id != b.Index_id {
+			return a.Index_id < b.Index_id
+		}
+		return a.Name < b.Name
+	})
+	return tables, nil
+}
+
+// 辅助表的聚簇索引
+func (aux *FtsAuxTable) Tree(td *TableDescriber) (*BTreeIndex, error) {
+	space := NewSpace([]string{aux.File})
+	if space == nil || space.Pages == 0 {
+		return nil, errors.New("cannot open " + aux.File)
+	}
+	root := space.Clustered_Index_Root_Page_Number()
+	if root == 0 {
+		return nil, errors.New("no index in " + aux.File)
+	}
+	return space.Get_Index_Tree(root, td), nil
+}
+
+// DELETED这些表中没有delete mark的doc id
+func (aux *FtsAuxTable) Doc_Ids() ([]uint64, error) {
+	tree, err := aux.Tree(NewFtsDeletedTableDescriber(aux.Name))
+	if err != nil {
+		return nil, err
+	}
+	var doc_ids []uint64
+	it := tree.Record_Iterator(nil)
+	record, err := it.Next()
+	for ; record != nil; record, err = it.Next() {
+		if record.Is_Deleted() {
+			continue
+		}
+		if doc_id, ok := record.Get_Fields_And_Value_Map()["doc_id"].(uint64); ok {
+			doc_ids = append(doc_ids, doc_id)
+		}
+	}
+	return doc_ids, err
+}
+
+// CONFIG表中的key value
+func (aux *FtsAuxTable) Config() (map[string]string, error) {
+	tree, err := aux.Tree(NewFtsConfigTableDescriber())
+	if err != nil {
+		return nil, err
+	}
+	config := make(map[string]string)
+	it := tree.Record_Iterator(nil)
+	record, err := it.Next()
+	for ; record != nil; record, err = it.Next() {
+		if record.Is_Deleted() {
+			continue
+		}
+		fields := record.Get_Fields_And_Value_Map()
+		key, _ := fields["key"].(string)
+		value, _ := fields["value"].(string)
+		config[key] = value
+	}
+	return config, err
+}
+
+// ilist中的一个doc, position是词在文档中的字节位置
+type FtsDoc struct {
+	Doc_id    uint64   `json:"doc_id"`
+	Positions []uint64 `json:"positions"`
+	Deleted   bool     `json:"deleted,omitempty"` // 在DELETED或者BEING_DELETED表中
+}
+
+// INDEX表中的一行
+type FtsToken struct {
+	Table_id     uint64    `json:"table_id"`
+	Index_id     uint64    `json:"index_id"`
+	Table        string    `json:"table"`
+	Word         string    `json:"word"`
+	First_doc_id uint64    `json:"first_doc_id"`
+	Last_doc_id  uint64    `json:"last_doc_id"`
+	Doc_count    uint64    `json:"doc_count"`
+	Docs         []*FtsDoc `json:"docs"`
+	Error        string    `json:"error,omitempty"` // ilist解析出错, 或者和first_doc_id, last_doc_id, doc_count对不上
+}
+
+// 变长整数, 每个字节7位, 高位在前, 最后一个字节的最高位是1, 参考fts_decode_vlc
+func Decode_Fts_Vlc(data []byte, pos *int) (uint64, error) {
+	var value uint64
+	for i := 0; *pos < len(data) && i < 10; i++ {
+		b := data[*pos]
+		*pos++
+		value |= uint64(b & 0x7f)
+		if b&0x80 != 0 {
+			return value, nil
+		}
+		value <<= 7
+	}
+	return value, fmt.Errorf("bad variable length integer at %d", *pos)
+}
+
+// ilist: 每个doc是doc id和前一个doc id的差, 然后是每个位置和前一个位置的差, 最后是一个0字节
+// 第一个doc id是和0的差, 参考fts_cache_node_add_positions
+func Decode_Fts_Ilist(ilist []byte) ([]*FtsDoc, error) {
+	var docs []*FtsDoc
+	var doc_id uint64
+	for pos := 0; pos < len(ilist); {
+		delta, err := Decode_Fts_Vlc(ilist, &pos)
+		if err != nil {
+			return docs, err
+		}
+		doc_id += delta
+		doc := &FtsDoc{Doc_id: doc_id}
+		var position uint64
+		for {
+			if pos >= len(ilist) {
+				return append(docs, doc), fmt.Errorf("ilist ends in the positions of doc %d", doc_id)
+			}
+			if ilist[pos] == 0 {
+				pos++
+				break
+			}
+			delta, err := Decode_Fts_Vlc(ilist, &pos)
+			if err != nil {
+				return append(docs, doc), err
+			}
+			position += delta
+			doc.Positions = append(doc.Positions, position)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// INDEX表中的一条记录, ilist外部存储的时候从BLOB页中读
+func (aux *FtsAuxTable) Token(tree *BTreeIndex, record *Record) *FtsToken {
+	fields := record.Get_Fields_And_Value_Map()
+	token := &FtsToken{Table_id: aux.Table_id, Index_id: aux.Index_id, Table: aux.Name}
+	token.Word, _ = fields["word"].(string)
+	token.First_doc_id, _ = fields["first_doc_id"].(uint64)
+	token.Last_doc_id, _ = fields["last_doc_id"].(uint64)
+	if v, ok := fields["doc_count"].(int64); ok {
+		token.Doc_count = uint64(v)
+	}
+	ilist, _ := fields["ilist"].(string)
+	data := []byte(ilist)
+	if ref := record.Extern("ilist"); ref != nil {
+		blob, err := tree.Space.Read_Blob(ref.page_number, ref.length)
+		if err != nil {
+			token.Error = err.Error()
+			return token
+		}
+		data = append(data, blob...)
+	}
+
+	docs, err := Decode_Fts_Ilist(data)
+	token.Docs = docs
+	switch {
+	case err != nil:
+		token.Error = err.Error()
+	case uint64(len(docs)) != token.Doc_count:
+		token.Error = fmt.Sprintf("ilist has %d docs, doc_count is %d", len(docs), token.Doc_count)
+	case len(docs) > 0 && (docs[0].Doc_id != token.First_doc_id || docs[len(docs)-1].Doc_id != token.Last_doc_id):
+		token.Error = fmt.Sprintf("ilist has doc %d to %d, expected %d to %d",
+			docs[0].Doc_id, docs[len(docs)-1].Doc_id, token.First_doc_id, token.Last_doc_id)
+	}
+	return token
+}
@@ -0,0 +1,139 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/pretty"
+)
+
+// 沿着回滚指针找undo记录, 一条条往前还原记录的历史版本(MVCC版本链)
+const MAX_HISTORY_VERSIONS = 1000
+const UNDO_INFO_DELETED_FLAG = RECORD_INFO_DELETED_FLAG << 4 // undo记录中info bits没有右移
+
+// 记录的一个版本
+type RecordVersion struct {
+	Trx_id       uint64                 `json:"trx_id"`       // 产生这个版本的事务
+	Trx_no       uint64                 `json:"trx_no"`       // 这个事务提交的顺序, undo log header中的TRX_UNDO_TRX_NO, 0表示不知道
+	Active       bool                   `json:"active"`       // 这个事务还没有提交
+	Roll_pointer *Pointer               `json:"roll_pointer"` // 指向修改之前的undo记录
+	Deleted      bool                   `json:"deleted"`
+	Change       string                 `json:"change"`   // trx_id这个事务做的修改: insert, update, delete
+	Modified     []string               `json:"modified"` // 相比前一个版本修改过的字段
+	Fields       map[string]interface{} `json:"fields"`
+}
+
+type RecordHistory struct {
+	Versions []*RecordVersion `json:"versions"` // 第一个是当前版本, 越往后越旧
+	Complete bool             `json:"complete"` // 是否追溯到了插入这条记录的版本
+	Reason   string           `json:"reason"`   // 没有追溯完的原因
+}
+
+// 记录的历史版本, 需要记录所在的表空间加到System中, 这样才能找到undo所在的表空间
+func (record *Record) History() *RecordHistory {
+	user_record, ok := record.record.(*UserRecord)
+	if !ok || user_record.Roll_pointer == nil {
+		return nil
+	}
+
+	describer := record.Page.record_describer
+	if describer == nil && record.Page.Space != nil {
+		describer = record.Page.Space.Record_describer
+	}
+
+	history := &RecordHistory{}
+	version := &RecordVersion{
+		Trx_id:       user_record.Transaction_id,
+		Roll_pointer: user_record.Roll_pointer,
+		Deleted:      user_record.header.Is_Deleted(),
+		Fields:       record.Get_Fields_And_Value_Map(),
+	}
+	history.Versions = append(history.Versions, version)
+
+	var system *System
+	if record.Page.Space != nil {
+		system = record.Page.Space.Innodb_system
+	}
+
+	for len(history.Versions) < MAX_HISTORY_VERSIONS {
+		if version.Roll_pointer.Is_insert {
+			version.Change = "insert"
+			history.Complete = true
+			return history
+		}
+		if system == nil {
+			history.Reason = "system tablespace not loaded"
+			return history
+		}
+		undo := system.Undo_Record(version.Roll_pointer, describer)
+		if undo == nil {
+			history.Reason = "undo page not found"
+			return history
+		}
+		if undo.Is_Insert() || !Undo_Record_Matches(undo, version.Fields) {
+			// undo页已经被purge然后重用了
+			history.Reason = "undo record has been purged"
+			return history
+		}
+		// insert undo在提交以后就释放了, 只有update undo能找到提交的顺序
+		if header, active := system.Undo_Log_Header(version.Roll_pointer); header != nil && header.Trx_id == version.Trx_id {
+			version.Active = active
+			if !active {
+				version.Trx_no = header.Trx_no
+			}
+		}
+
+		switch undo.Type_id {
+		case TRX_UNDO_UPD_EXIST_REC:
+			version.Change = "update"
+		case TRX_UNDO_DEL_MARK_REC:
+			version.Change = "delete"
+		case TRX_UNDO_UPD_DEL_REC:
+			// 在delete mark的记录上重新插入
+			version.Change = "insert"
+		}
+
+		prev := &RecordVersion{
+			Trx_id:       undo.Trx_id,
+			Roll_pointer: NewRollPointerType("ROLL_PTR", "", "").Parse_Roll_Pointer(undo.Roll_ptr),
+			Deleted:      undo.Info_bits&UNDO_INFO_DELETED_FLAG != 0,
+			Fields:       make(map[string]interface{}),
+		}
+		for name, value := range version.Fields {
+			prev.Fields[name] = value
+		}
+		for _, field := range undo.Update {
+			if field.Name == "" || field.Name == "DB_TRX_ID" || field.Name == "DB_ROLL_PTR" {
+				continue
+			}
+			prev.Fields[field.Name] = field.Value
+			version.Modified = append(version.Modified, field.Name)
+		}
+
+		history.Versions = append(history.Versions, prev)
+		version = prev
+	}
+	history.Reason = "too many versions"
+	return history
+}
+
+// undo记录中的主键和记录的主键一致, 才是这条记录的undo
+func Undo_Record_Matches(undo *UndoRecord, fields map[string]interface{}) bool {
+	for _, key := range undo.Key {
+		if key.Name == "" {
+			continue
+		}
+		if fmt.Sprint(key.Value) != fmt.Sprint(fields[key.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (history *RecordHistory) Dump() {
+	println("record history:")
+
+	data, _ := json.Marshal(history)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
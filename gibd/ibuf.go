@@ -0,0 +1,314 @@
+package gibd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/tidwall/pretty"
+)
+
+// change buffer(insert buffer) ibuf0ibuf.cc
+// 每个表空间每16384个页的第1页是IBUF_BITMAP页, 每个页4个bit: 空闲空间(2 bit), 有没有缓存的修改, 是不是ibuf自己的页
+// 缓存的修改存在系统表空间第4页为根的ibuf B+树中, 记录是redundant格式
+const FSP_IBUF_BITMAP_OFFSET = 1
+const FSP_IBUF_HEADER_PAGE_NO = 3
+const FSP_IBUF_TREE_ROOT_PAGE_NO = 4
+const IBUF_BITMAP = FIL_PAGE_DATA
+const IBUF_BITS_PER_PAGE = 4
+const IBUF_BITMAP_FREE = 0
+const IBUF_BITMAP_BUFFERED = 2
+const IBUF_BITMAP_IBUF = 3
+
+// ibuf记录的第4个字段: counter(2) op(1) flags(1), 后面每个字段6个字节的类型信息
+const IBUF_REC_INFO_SIZE = 4
+const DATA_NEW_ORDER_NULL_TYPE_BUF_SIZE = 6
+const IBUF_REC_COMPACT = 0x1
+const IBUF_OP_INSERT = 0
+const IBUF_OP_DELETE_MARK = 1
+const IBUF_OP_DELETE = 2
+
+var IBUF_OPS = map[uint64]string{
+	IBUF_OP_INSERT:      "insert",
+	IBUF_OP_DELETE_MARK: "delete_mark",
+	IBUF_OP_DELETE:      "delete",
+}
+
+// 空闲空间的等级, ibuf_index_page_calc_free_bits, 页上至少有这么多的空闲空间
+var IBUF_FREE_CLASSES = map[uint64]string{
+	0: "none",
+	1: "1/32",
+	2: "1/16",
+	3: "1/8",
+}
+
+type IbufBitmapEntry struct {
+	Page_number uint64 `json:"page_number"`
+	Free        uint64 `json:"free"`
+	Free_class  string `json:"free_class"`
+	Buffered    bool   `json:"buffered"` // 有缓存在ibuf树中还没有合并的修改
+	Ibuf        bool   `json:"ibuf"`     // 是ibuf树自己的页
+}
+
+type IbufBitmap struct {
+	Page        *Page             `json:"-"`
+	Page_number uint64            `json:"page_number"`
+	Entries     []IbufBitmapEntry `json:"entries"`
+}
+
+func NewIbufBitmap(page *Page) *IbufBitmap {
+	return &IbufBitmap{Page: page, Page_number: page.Page_number}
+}
+
+// 这个bitmap页管理的页从自己前一页开始
+func (bitmap *IbufBitmap) First_Page_Number() uint64 {
+	return bitmap.Page_number - FSP_IBUF_BITMAP_OFFSET
+}
+
+// ibuf_bitmap_page_get_bits
+func (bitmap *IbufBitmap) Entry(page_number uint64) IbufBitmapEntry {
+	entry := IbufBitmapEntry{Page_number: page_number}
+	bit_offset := (page_number % DEFAULT_PAGE_SIZE) * IBUF_BITS_PER_PAGE
+	map_byte := uint64(BufferReadAt(bitmap.Page, int64(IBUF_BITMAP+bit_offset/8), 1))
+	bit := bit_offset % 8
+	entry.Free = (map_byte>>(bit+IBUF_BITMAP_FREE)&1)<<1 | map_byte>>(bit+IBUF_BITMAP_FREE+1)&1
+	entry.Free_class = IBUF_FREE_CLASSES[entry.Free]
+	entry.Buffered = map_byte>>(bit+IBUF_BITMAP_BUFFERED)&1 != 0
+	entry.Ibuf = map_byte>>(bit+IBUF_BITMAP_IBUF)&1 != 0
+	return entry
+}
+
+// 解析表空间中这个bitmap页管理的所有页
+func (bitmap *IbufBitmap) Ibuf_Bitmap() {
+	bitmap.Entries = nil
+	last := bitmap.First_Page_Number() + DEFAULT_PAGE_SIZE
+	if bitmap.Page.Space != nil && bitmap.Page.Space.Pages < last {
+		last = bitmap.Page.Space.Pages
+	}
+	for page_number := bitmap.First_Page_Number(); page_number < last; page_number++ {
+		bitmap.Entries = append(bitmap.Entries, bitmap.Entry(page_number))
+	}
+}
+
+// 有缓存的修改的页
+func (bitmap *IbufBitmap) Each_Buffered() []IbufBitmapEntry {
+	var entries []IbufBitmapEntry
+	for _, entry := range bitmap.Entries {
+		if entry.Buffered {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (bitmap *IbufBitmap) Dump() {
+	println("ibuf bitmap:")
+
+	data, _ := json.Marshal(bitmap)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
+
+// 页所在的bitmap页
+func (s *Space) Ibuf_Bitmap(page_number uint64) *IbufBitmap {
+	bitmap := NewIbufBitmap(s.Page(page_number/DEFAULT_PAGE_SIZE*DEFAULT_PAGE_SIZE + FSP_IBUF_BITMAP_OFFSET))
+	bitmap.Ibuf_Bitmap()
+	return bitmap
+}
+
+// 二级索引记录的一个字段
+type IbufField struct {
+	Mtype uint64   `json:"mtype"`
+	Null  bool     `json:"null"`
+	Value HexBytes `json:"value"`
+}
+
+// 一条缓存的修改
+type IbufRecord struct {
+	Space_id    uint64      `json:"space_id"`
+	Page_number uint64      `json:"page_number"`
+	Counter     uint64      `json:"counter"` // 同一个页上修改的顺序
+	Op          uint64      `json:"op"`
+	Op_name     string      `json:"op_name"`
+	Compact     bool        `json:"compact"` // 二级索引是不是compact格式
+	Fields      []IbufField `json:"fields"`
+}
+
+type HexBytes []byte
+
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+// 系统表空间中的ibuf树
+type Ibuf struct {
+	Space   *Space        `json:"-"`
+	Records []*IbufRecord `json:"records"`
+	Reason  string        `json:"reason,omitempty"` // 没有解析完的原因
+}
+
+func (system *System) Ibuf() *Ibuf {
+	ibuf := &Ibuf{Space: system.System_Space()}
+	ibuf.Each_Record()
+	return ibuf
+}
+
+// redundant格式的记录: 字段结束位置在6个字节的header前面逆序存放, 1个字节的最高位或者2个字节的最高位表示NULL
+func Redundant_Record_Fields(page *Page, offset uint64) ([][]byte, []bool) {
+	bits := uint64(BufferReadAt(page, int64(offset)-5, 3))
+	n_fields := (bits >> 1) & 0x3FF
+	short := bits&1 != 0
+	var fields [][]byte
+	var nulls []bool
+	var start uint64
+	for i := uint64(0); i < n_fields; i++ {
+		var end uint64
+		var null bool
+		if short {
+			info := uint64(BufferReadAt(page, int64(offset-6-i-1), 1))
+			end, null = info&RECORD_REDUNDANT_OFF1_OFFSET_MASK, info&RECORD_REDUNDANT_OFF1_NULL_MASK != 0
+		} else {
+			info := uint64(BufferReadAt(page, int64(offset-6-2*i-2), 2))
+			end, null = info&RECORD_REDUNDANT_OFF2_OFFSET_MASK, info&RECORD_REDUNDANT_OFF2_NULL_MASK != 0
+		}
+		if null {
+			fields = append(fields, nil)
+		} else {
+			fields = append(fields, ReadBytes(page, int64(offset+start), int64(end-start)))
+		}
+		nulls = append(nulls, null)
+		start = end
+	}
+	return fields, nulls
+}
+
+// redundant格式的页上的用户记录, next是页内的绝对位置
+func Redundant_Page_Records(index *IndexPage) []uint64 {
+	var offsets []uint64
+	infimum := index.Pos_Infimum()
+	supremum := index.Pos_Supremum()
+	offset := uint64(BufferReadAt(index.Page, int64(infimum)-2, 2))
+	for offset != supremum && offset != 0 && offset < DEFAULT_PAGE_SIZE && uint64(len(offsets)) <= index.PageHeader.N_heap {
+		offsets = append(offsets, offset)
+		offset = uint64(BufferReadAt(index.Page, int64(offset)-2, 2))
+	}
+	return offsets
+}
+
+// 从根页一直往左下走到叶子层, 再沿着叶子页的链表读所有记录
+func (ibuf *Ibuf) Each_Record() []*IbufRecord {
+	ibuf.Records = nil
+	page := ibuf.Space.Page(FSP_IBUF_TREE_ROOT_PAGE_NO)
+	if page.FileHeader.Page_type != FIL_PAGE_INDEX {
+		ibuf.Reason = "ibuf root page is not an index page"
+		return nil
+	}
+	index := NewIndex(page)
+	for index.PageHeader.Level > 0 {
+		if index.PageHeader.Format != "redundant" {
+			ibuf.Reason = "ibuf page is not redundant format"
+			return ibuf.Records
+		}
+		records := Redundant_Page_Records(index)
+		if len(records) == 0 {
+			ibuf.Reason = "empty non-leaf page"
+			return ibuf.Records
+		}
+		//节点指针的最后一个字段是子页号
+		fields, _ := Redundant_Record_Fields(index.Page, records[0])
+		child := uint64(BytesToUIntLittleEndian(fields[len(fields)-1]))
+		if child >= ibuf.Space.Pages {
+			ibuf.Reason = "child page is beyond the end of the file"
+			return ibuf.Records
+		}
+		index = NewIndex(ibuf.Space.Page(child))
+	}
+
+	visited := make(map[uint64]bool)
+	for {
+		if index.PageHeader.Format != "redundant" {
+			ibuf.Reason = "ibuf page is not redundant format"
+			return ibuf.Records
+		}
+		visited[index.Page.Page_number] = true
+		for _, offset := range Redundant_Page_Records(index) {
+			fields, nulls := Redundant_Record_Fields(index.Page, offset)
+			if record := Parse_Ibuf_Record(fields, nulls); record != nil {
+				ibuf.Records = append(ibuf.Records, record)
+			}
+		}
+		next := index.Page.FileHeader.Next
+		if next == FIL_NULL || visited[next] || next >= ibuf.Space.Pages {
+			break
+		}
+		index = NewIndex(ibuf.Space.Page(next))
+	}
+	return ibuf.Records
+}
+
+// ibuf记录: space id(4) marker(1) page no(4) metadata 二级索引记录的字段...
+// 4.1以前的格式第2个字段就是页号, 不解析
+func Parse_Ibuf_Record(fields [][]byte, nulls []bool) *IbufRecord {
+	if len(fields) < 4 || len(fields[0]) != 4 || len(fields[1]) != 1 || len(fields[2]) != 4 {
+		return nil
+	}
+	record := &IbufRecord{
+		Space_id:    uint64(BytesToUIntLittleEndian(fields[0])),
+		Page_number: uint64(BytesToUIntLittleEndian(fields[2])),
+		Op:          IBUF_OP_INSERT,
+	}
+	types := fields[3]
+	if len(types)%DATA_NEW_ORDER_NULL_TYPE_BUF_SIZE == IBUF_REC_INFO_SIZE {
+		record.Counter = uint64(BytesToUIntLittleEndian(types[0:2]))
+		record.Op = uint64(types[2])
+		record.Compact = types[3]&IBUF_REC_COMPACT != 0
+		types = types[IBUF_REC_INFO_SIZE:]
+	}
+	record.Op_name = IBUF_OPS[record.Op]
+	for i := 4; i < len(fields); i++ {
+		field := IbufField{Null: nulls[i], Value: fields[i]}
+		if pos := (i - 4) * DATA_NEW_ORDER_NULL_TYPE_BUF_SIZE; pos < len(types) {
+			field.Mtype = uint64(types[pos])
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record
+}
+
+// 每个页缓存的修改数量
+type IbufPageSummary struct {
+	Space_id    uint64         `json:"space_id"`
+	Page_number uint64         `json:"page_number"`
+	Ops         map[string]int `json:"ops"`
+}
+
+func (ibuf *Ibuf) Summary() []*IbufPageSummary {
+	pages := make(map[string]*IbufPageSummary)
+	var summaries []*IbufPageSummary
+	for _, record := range ibuf.Records {
+		key := fmt.Sprintf("%d:%d", record.Space_id, record.Page_number)
+		summary, ok := pages[key]
+		if !ok {
+			summary = &IbufPageSummary{Space_id: record.Space_id, Page_number: record.Page_number, Ops: make(map[string]int)}
+			pages[key] = summary
+			summaries = append(summaries, summary)
+		}
+		summary.Ops[record.Op_name]++
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Space_id != summaries[j].Space_id {
+			return summaries[i].Space_id < summaries[j].Space_id
+		}
+		return summaries[i].Page_number < summaries[j].Page_number
+	})
+	return summaries
+}
+
+func (ibuf *Ibuf) Dump() {
+	println("ibuf:")
+
+	data, _ := json.Marshal(ibuf)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -64,8 +64,9 @@ type IndexPage struct {
 
 func NewIndex(page *Page) *IndexPage {
 
-	index := &IndexPage{Page: page}
+	index := &IndexPage{Page: page, size: DEFAULT_PAGE_SIZE}
 	index.Space = page.Space
+	index.record_describer = page.record_describer
 	index.Index_Header()
 	return index
 }
@@ -181,7 +182,7 @@ func (index *IndexPage) Index_Header() {
 	n_recs := uint64(BufferReadAt(index.Page, int64(index.Pos_Index_Header())+16, 2))
 	max_trx_id := uint64(BufferReadAt(index.Page, int64(index.Pos_Index_Header())+18, 8))
 	level := uint64(BufferReadAt(index.Page, int64(index.Pos_Index_Header())+26, 2))
-	index_id := uint64(BufferReadAt(index.Page, int64(index.Pos_Index_Header())+28, 4))
+	index_id := uint64(BufferReadAt(index.Page, int64(index.Pos_Index_Header())+28, 8))
 
 	page_header := PageHeader{N_dir_slots: n_dir_slots, Heap_top: heap_top, N_heap_format: n_heap_format,
 		Garbage_offset: garbage_offset, Garbage_size: garbage_size, Last_insert_offset: last_insert_offset,
@@ -264,17 +265,31 @@ func (index *IndexPage) each_record() []*Record {
 	var records []*Record
 
 	rc := index.Record_Cursor(min, "forward")
-	r := rc.record()
-	records = append(records, r)
+	//空页的最小记录是supremum
+	if _, ok := rc.Record.record.(*UserRecord); !ok {
+		return records
+	}
 
-	for ; r != nil; rc.Record, r = r, rc.record() {
+	for r := rc.record(); r != nil; r = rc.record() {
 		records = append(records, r)
+		rc.Record = r
 	}
 
 	return records
 
 }
 
+// 页上的数据可能是坏的, 解析出错或者链表有环的时候返回已经解析的记录和错误
+func (index *IndexPage) Each_Record_Checked() ([]*Record, error) {
+	var records []*Record
+	it := index.Record_Iterator()
+	record, err := it.Next()
+	for ; record != nil; record, err = it.Next() {
+		records = append(records, record)
+	}
+	return records, err
+}
+
 //Return the minimum record on this page.不是Infimum,是用户的最小值
 func (index *IndexPage) Min_Record() *Record {
 
@@ -291,15 +306,13 @@ func (index *IndexPage) Min_Record() *Record {
 	return min
 }
 
-//获取用户的最大值
+//获取用户的最大值, 是supremum前面的记录, 空页返回infimum
 func (index *IndexPage) Max_Record() *Record {
-	//max_cursor := index.record_cursor(index.supremum().system_record.offset, "backward")
-	// max := max_cursor.prev_record
-	// if max != index.infimum() {
-	// 	return max
-	// }
-	return NewRecord2()
-
+	prev := index.Prev_Offset(index.Pos_Supremum())
+	if prev == 0 {
+		return index.Infimum()
+	}
+	return index.record(prev)
 }
 
 func (index *IndexPage) Get_Record_Fields_From_Format() ([]*RecordFieldMeta, []*RecordFieldMeta, []*RecordFieldMeta) {
@@ -342,6 +355,9 @@ func (index *IndexPage) record(offset uint64) *Record {
 		return index.Supremum()
 	}
 
+	//compact格式的header中的null和变长字段长度需要字段信息才能解析
+	index.Record_Format = index.Get_Record_Format()
+
 	header, header_len := index.Record_Header(offset)
 
 	rec_len += header_len
@@ -360,9 +376,7 @@ func (index *IndexPage) record(offset uint64) *Record {
 		next,
 	)
 
-	rf := index.Get_Record_Format()
-
-	index.Record_Format = rf
+	rf := index.Record_Format
 	if index.Record_Format != nil {
 
 		this_record.record_type = rf["tab_type"].(string)
@@ -446,117 +460,45 @@ func (index *IndexPage) record(offset uint64) *Record {
 		// https://blog.jcole.us/2013/01/10/the-physical-structure-of-records-in-innodb/
 		if index.IsLeaf() == true {
 			//先获取key字段，然后是transaction id 然后是roll pointer 然后是non-key字段
-			var keyLen uint64
-			for i := 0; i < len(key_arr); i++ {
-				f := key_arr[i]
-				p := fmap[f.Position]
-
-				filed_value, len := f.Value(offset, this_record, index)
-
-				keyLen = keyLen + len
-
-				offset = offset + len
-				var f_name string
-				switch f.DataType.(type) {
-				case *TransactionIdType:
-					f_name = f.DataType.(*TransactionIdType).name
-				case *IntegerType:
-					f_name = f.DataType.(*IntegerType).name
-				}
-				fieldDescriptor := NewFieldDescriptor(f.Name, f_name, filed_value, f.extern(int64(offset), index, this_record))
-				switch p {
-				case "key":
-					keys = append(keys, fieldDescriptor)
-				case "row":
-					rows = append(rows, fieldDescriptor)
-				case "sys":
-					syss = append(syss, fieldDescriptor)
-				}
-
+			var sys_arr []*RecordFieldMeta
+			if rf["sys"] != nil {
+				sys_arr = rf["sys"].([]*RecordFieldMeta)
 			}
+			keys, offset = index.Read_Fields(key_arr, offset, this_record)
+			syss, offset = index.Read_Fields(sys_arr, offset, this_record)
+			rows, offset = index.Read_Instant_Fields(row_arr, offset, this_record)
 
-			//获取事务id,回滚段指针
-			// transaction_id := BufferReadAt(index.Page, int64(offset)+int64(keyLen), 6)
-
-			// fmt.Println("transaction_id ==", transaction_id)
-
-			// roll_pointer := BufferReadAt(index.Page, int64(offset)+int64(keyLen)+6, 7)
-			// fmt.Println("roll pointer ==", roll_pointer)
-
-			//获取non-key field
-			offset = offset + 13
-
-			for i := 0; i < len(row_arr); i++ {
-				f := row_arr[i]
-				p := fmap[f.Position]
-				//get value exception unkown data type===> &{ 0 false}
-
-				filed_value, len := f.Value(offset, this_record, index)
-				offset = offset + len
-
-				var f_name string
-				switch f.DataType.(type) {
-				case *TransactionIdType:
-					f_name = f.DataType.(*TransactionIdType).name
-				case *IntegerType:
-					f_name = f.DataType.(*IntegerType).name
-				}
-				fieldDescriptor := NewFieldDescriptor(f.Name, f_name, filed_value, f.extern(int64(offset), index, this_record))
-				switch p {
-				case "key":
-					keys = append(keys, fieldDescriptor)
-				case "row":
-					rows = append(rows, fieldDescriptor)
-				case "sys":
-					syss = append(syss, fieldDescriptor)
-				}
-
-			}
 			this_record.key = keys
 			this_record.row = rows
 			this_record.sys = syss
 			//叶子结点的系统字段
 			for i := 0; i < len(this_record.sys); i++ {
-				switch this_record.sys[i].FieldMeta.Name {
-				case "DB_TRX_ID":
-					// if len(this_record.sys[i].value.(uint64)) == 0 {
-					// 	this_record.transaction_id = 0
-					// } else {
-					// 	this_record.transaction_id = uint64(this_record.sys[i].value.([]uint8)[0])
-					// }
-					this_record.Transaction_id = this_record.sys[i].Value.(uint64)
-					Log.Info("record this record's transaction_id is =======> %+v\n", this_record.Transaction_id)
-				case "DB_ROLL_PTR":
-					// if len(this_record.sys[i].value.([]uint8)) == 0 {
-					// 	this_record.roll_pointer = 0
-					// } else {
-					// 	this_record.roll_pointer = uint64(this_record.sys[i].value.([]uint8)[0])
-					// }
-					this_record.Roll_pointer = this_record.sys[i].Value.(*Pointer)
-
+				switch value := this_record.sys[i].Value.(type) {
+				case uint64:
+					this_record.Transaction_id = value
+				case *Pointer:
+					this_record.Roll_pointer = value
 				}
-
 			}
+			rec_len += offset - record_offset
 		}
 
 		//叶子结点，记录值是key和child_page_number
 		if index.IsLeaf() == false {
 
-			var keyLen uint64
-			for i := 0; i < len(key_arr); i++ {
-				f := key_arr[i]
-
-				_, len := f.Value(offset, this_record, index)
-				offset = offset + len
-				keyLen = keyLen + len
-
+			keys, offset = index.Read_Fields(key_arr, offset, this_record)
+			this_record.key = keys
+			//二级索引的node pointer中有索引的所有字段(包括主键字段), 聚簇索引只有key字段
+			if rf["tab_type"] == "secondary" {
+				rows, offset = index.Read_Fields(row_arr, offset, this_record)
+				this_record.row = rows
 			}
 			//child_page_number是在最后的4个字节，前面是最小key的值,这里key的信息需要在描述符中获取
 			// fmt.Println("offset==?", record_offset)
-			this_record.Child_page_number = uint64(BufferReadAt(index.Page, int64(record_offset)+int64(keyLen), 4))
+			this_record.Child_page_number = uint64(BufferReadAt(index.Page, int64(offset), 4))
 
 			offset = offset + 4
-			rec_len += 4
+			rec_len += offset - record_offset
 		}
 
 		this_record.Length = rec_len
@@ -564,6 +506,37 @@ func (index *IndexPage) record(offset uint64) *Record {
 	return NewRecord(index.Page, this_record)
 }
 
+// 按顺序读取字段的值, 返回字段和下一个字段的位置
+func (index *IndexPage) Read_Fields(fields []*RecordFieldMeta, offset uint64, record *UserRecord) ([]*FieldDescriptor, uint64) {
+	var descriptors []*FieldDescriptor
+	for _, f := range fields {
+		value, length := f.Value(offset, record, index)
+		offset = offset + length
+
+		var f_name string
+		switch f.DataType.(type) {
+		case *TransactionIdType:
+			f_name = f.DataType.(*TransactionIdType).name
+		case *IntegerType:
+			f_name = f.DataType.(*IntegerType).name
+		case *VariableCharacterType:
+			f_name = f.DataType.(*VariableCharacterType).name
+		case *MbrType:
+			f_name = f.DataType.(*MbrType).name
+		case *GeometryType:
+			f_name = f.DataType.(*GeometryType).name
+		case *BlobType:
+			f_name = f.DataType.(*BlobType).name
+		}
+		extern := f.extern(int64(offset), index, record)
+		if extern != nil {
+			offset = offset + EXTERN_FIELD_SIZE
+		}
+		descriptors = append(descriptors, NewFieldDescriptor(f.Name, f_name, value, extern))
+	}
+	return descriptors, offset
+}
+
 func (index *IndexPage) Get_Record_Format() map[string]interface{} {
 
 	if index.Record_Format != nil {
@@ -634,14 +607,17 @@ func (index *IndexPage) Record_Header(offset uint64) (*RecordHeader, uint64) {
 		bits1 := uint64(BufferReadAt(index.Page, int64(offset)-4, 2))
 
 		header.Record_Type = RECORD_TYPES[bits1&0x07]
+		if bits1&0x07 == REC_STATUS_INSTANT {
+			header.Record_Type = "conventional"
+			header.Instant = true
+		}
 		header.Heap_Number = (bits1 & 0xfff8) >> 3
 
 		bits2 := uint64(BufferReadAt(index.Page, int64(offset)-5, 1))
 		header.N_owned = bits2 & 0x0f
 		header.Info_flags = (bits2 & 0xf0) >> 4
 		//用户记录去查additional
-		if header.Record_Type == "conventional" {
-			fmt.Println("offset", offset-5)
+		if header.Record_Type == "conventional" || header.Record_Type == "node_pointer" {
 			index.Record_Header_Compact_Additional(header, offset-5)
 		}
 
@@ -686,37 +662,91 @@ func (index *IndexPage) Record_Header_Compact_Additional(header *RecordHeader, o
 	switch header.Record_Type {
 	// node_pointer 是中间节点记录 conventional 是正常的记录
 	case "conventional", "node_pointer":
-		// 变长部分，如果没有列的元数据信息，没法取长度，所以如果自己知道表信息，可以手工设置字节长度，在else中判断
-		if index.Record_Format != nil {
-			header.Nulls = index.Record_Header_Compact_Null_Bitmap(offset)
-			header.Lengths, header.Externs = index.Record_Header_Compact_Variable_Lengths_And_Externs(offset, header.Nulls)
-		} else {
-			//人为决定获取长度处理
-			fmt.Println("offset for null", offset)
-			offset = offset - 2
-
-			header.Nulls = index.Record_Header_Compact_Null_Bitmap(offset)
-			header.Lengths, header.Externs = index.Record_Header_Compact_Variable_Lengths_And_Externs(offset, header.Nulls)
-
+		// 变长部分，如果没有列的元数据信息，没法取长度
+		if index.Record_Format == nil {
+			return
 		}
+		// instant ADD/DROP以后的记录, header前面还有字段数或者row version
+		offset -= index.Record_Header_Compact_Instant(header, offset)
+		fields := index.Record_Header_Compact_Fields(header)
+		header.Nulls = index.Record_Header_Compact_Null_Bitmap(fields, offset)
+		header.Lengths, header.Externs = index.Record_Header_Compact_Variable_Lengths_And_Externs(fields, offset, header.Nulls)
 	}
 
 }
 
-func (index *IndexPage) Record_Header_Compact_Null_Bitmap(offset uint64) string {
-	//fields := index.record_fields()
-	//size = fields.count(is_nullable())
-	//方便测试，将null bitmap和extern的信息放在了一起，默认测试分别占用1个字节，根据具体情况修改，因为没有字段元数据信息
+// header中描述的字段, 叶子结点是所有字段, 非叶子结点是key字段(child page number是固定长度)
+// 二级索引的非叶子结点是索引的所有字段
+func (index *IndexPage) Record_Header_Compact_Fields(header *RecordHeader) []*RecordFieldMeta {
+	all_fields, key_arr, _ := index.Get_Record_Fields_From_Format()
+	if header.Record_Type == "node_pointer" && index.Record_Format["tab_type"] != "secondary" {
+		return key_arr
+	}
+	return header.Present_Fields(all_fields)
+}
 
-	nulls := ReadBytes(index.Page, int64(offset), 2)
+// null bitmap在5个字节的header前面, 第一个可以为null的字段是最靠近header的那个字节的最低位
+func (index *IndexPage) Record_Header_Compact_Null_Bitmap(fields []*RecordFieldMeta, offset uint64) []string {
+	var nulls []string
+	var n_nullable uint64
+	for _, f := range fields {
+		if !f.Nullable {
+			continue
+		}
+		b := BufferReadAt(index.Page, int64(offset-1-n_nullable/8), 1)
+		if b&(1<<(n_nullable%8)) != 0 {
+			nulls = append(nulls, f.Name)
+		}
+		n_nullable++
+	}
+	return nulls
+}
 
-	nullString := BytesToBinaryString(nulls)
-	return nullString
+func (index *IndexPage) Record_Header_Compact_Null_Bitmap_Size(fields []*RecordFieldMeta) uint64 {
+	var n_nullable uint64
+	for _, f := range fields {
+		if f.Nullable {
+			n_nullable++
+		}
+	}
+	return (n_nullable + 7) / 8
 }
 
-func (index *IndexPage) Record_Header_Compact_Variable_Lengths_And_Externs(offset uint64, header_nulls string) (map[string]int, string) {
-	return nil, ""
+// 变长字段的长度在null bitmap前面, 逆序存放, 最大长度超过255的字段长度大于127时用2个字节, 第二高位表示外部存储
+func (index *IndexPage) Record_Header_Compact_Variable_Lengths_And_Externs(fields []*RecordFieldMeta, offset uint64, header_nulls []string) (map[string]int, []string) {
+	lengths := make(map[string]int)
+	var externs []string
+
+	pos := int64(offset - index.Record_Header_Compact_Null_Bitmap_Size(fields))
+	for _, f := range fields {
+		is_null := false
+		for _, name := range header_nulls {
+			if name == f.Name {
+				is_null = true
+			}
+		}
+		if is_null {
+			lengths[f.Name] = 0
+			continue
+		}
+		if !f.Is_Variable() {
+			lengths[f.Name] = int(f.Fixed_Length())
+			continue
+		}
 
+		pos--
+		length := BufferReadAt(index.Page, pos, 1)
+		if f.Is_Big() && length&0x80 != 0 {
+			pos--
+			length = (length << 8) | BufferReadAt(index.Page, pos, 1)
+			if length&0x4000 != 0 {
+				externs = append(externs, f.Name)
+			}
+			length = length & 0x3fff
+		}
+		lengths[f.Name] = length
+	}
+	return lengths, externs
 }
 
 func (index *IndexPage) Record_Header_Redundant_Additional(header *RecordHeader, offset uint64) {
@@ -751,8 +781,8 @@ func (index *IndexPage) Record_Header_Redundant_Additional(header *RecordHeader,
 
 	if index.Record_Format != nil {
 		header.Lengths = make(map[string]int)
-		header.Nulls = ""
-		header.Externs = ""
+		header.Nulls = nil
+		header.Externs = nil
 		all_fields, _, _ := index.Get_Record_Fields_From_Format()
 		for i := 0; i < len(all_fields); i++ {
 			f := all_fields[i]
@@ -762,19 +792,11 @@ func (index *IndexPage) Record_Header_Redundant_Additional(header *RecordHeader,
 				header.Lengths[f.Name] = lengths[f.Position]
 			}
 
-			if f.Position >= len(nulls) {
-				header.Nulls = header.Nulls + ""
-			} else {
-				if nulls[f.Position] {
-					header.Nulls = header.Nulls + f.Name
-				}
+			if f.Position < len(nulls) && nulls[f.Position] {
+				header.Nulls = append(header.Nulls, f.Name)
 			}
-			if f.Position >= len(externs) {
-				header.Externs = header.Externs + ""
-			} else {
-				if externs[f.Position] {
-					header.Externs = header.Externs + f.Name
-				}
+			if f.Position < len(externs) && externs[f.Position] {
+				header.Externs = append(header.Externs, f.Name)
 			}
 
 		}
@@ -0,0 +1,125 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/pretty"
+)
+
+// 索引的大小, 填充率和碎片, 用来判断哪些表需要OPTIMIZE TABLE
+// 只读页头, 不解析记录, 所以不需要建表语句
+type LevelStats struct {
+	Level              uint64  `json:"level"`
+	Pages              uint64  `json:"pages"`
+	Records            uint64  `json:"records"`
+	Record_bytes       uint64  `json:"record_bytes"`  // 记录占用的空间, 每页的Record_Space
+	Free_bytes         uint64  `json:"free_bytes"`    // 可以插入记录的空间, 包括garbage
+	Garbage_bytes      uint64  `json:"garbage_bytes"` // 删除的记录占用的空间
+	Records_per_page   float64 `json:"records_per_page"`
+	Bytes_per_record   uint64  `json:"bytes_per_record"`
+	Fill_factor        float64 `json:"fill_factor"`        // record_bytes / (record_bytes + free_bytes)
+	Out_of_order_pages uint64  `json:"out_of_order_pages"` // next不是物理上的下一个页
+	Fragmentation      float64 `json:"fragmentation"`      // out_of_order_pages / (pages - 1)
+}
+
+type IndexStats struct {
+	Name             string        `json:"name,omitempty"`
+	Index_id         uint64        `json:"index_id"`
+	Root_page_number uint64        `json:"root_page_number"`
+	Height           uint64        `json:"height"`
+	Pages            uint64        `json:"pages"`
+	Size             uint64        `json:"size"`
+	Records          uint64        `json:"records"` // 叶子页上的记录数, 包括delete mark的
+	Fill_factor      float64       `json:"fill_factor"`
+	Fragmentation    float64       `json:"fragmentation"`
+	Free_pages       uint64        `json:"free_pages,omitempty"` // index id相同但是已经释放的页, 不算在里面
+	Levels           []*LevelStats `json:"levels"`
+}
+
+func (level *LevelStats) add(index *IndexPage) {
+	level.Pages++
+	level.Records += index.PageHeader.N_recs
+	level.Record_bytes += index.Record_Space()
+	level.Free_bytes += index.Free_Space()
+	level.Garbage_bytes += index.PageHeader.Garbage_size
+	next := index.Page.FileHeader.Next
+	if next != FIL_NULL && next != index.Page.Page_number+1 {
+		level.Out_of_order_pages++
+	}
+}
+
+func (level *LevelStats) finish() {
+	if level.Pages > 0 {
+		level.Records_per_page = float64(level.Records) / float64(level.Pages)
+	}
+	if level.Records > 0 {
+		level.Bytes_per_record = level.Record_bytes / level.Records
+	}
+	if level.Record_bytes+level.Free_bytes > 0 {
+		level.Fill_factor = float64(level.Record_bytes) / float64(level.Record_bytes+level.Free_bytes)
+	}
+	if level.Pages > 1 {
+		level.Fragmentation = float64(level.Out_of_order_pages) / float64(level.Pages-1)
+	}
+}
+
+// 表空间中每个索引的统计, 扫描所有页一次, 按root页的index id分组
+func (s *Space) Index_Stats(innodb_system *System) []*IndexStats {
+	var stats []*IndexStats
+	by_id := make(map[uint64]*IndexStats)
+	for _, root := range RemoveRepeatedElement(s.Each_Index_Root_Page_Number(innodb_system)) {
+		index := NewIndex(s.Page(root))
+		if !index.Is_Index_Page() {
+			continue
+		}
+		if _, ok := by_id[index.PageHeader.Index_id]; ok {
+			continue
+		}
+		st := &IndexStats{Index_id: index.PageHeader.Index_id, Root_page_number: root, Height: index.PageHeader.Level + 1}
+		for level := uint64(0); level < st.Height; level++ {
+			st.Levels = append(st.Levels, &LevelStats{Level: level})
+		}
+		by_id[st.Index_id] = st
+		stats = append(stats, st)
+	}
+	if len(stats) == 0 {
+		return stats
+	}
+
+	xdes_pages := NewXdesPages(s)
+	for page_number := uint64(0); page_number < s.Pages; page_number++ {
+		index := NewIndex(s.Page(page_number))
+		if !index.Is_Index_Page() {
+			continue
+		}
+		st, ok := by_id[index.PageHeader.Index_id]
+		if !ok {
+			continue
+		}
+		if xdes_pages.Is_Page_Free(page_number) || index.PageHeader.Level >= st.Height {
+			st.Free_pages++
+			continue
+		}
+		st.Levels[index.PageHeader.Level].add(index)
+	}
+
+	for _, st := range stats {
+		for _, level := range st.Levels {
+			level.finish()
+			st.Pages += level.Pages
+		}
+		st.Size = st.Pages * DEFAULT_PAGE_SIZE
+		leaf := st.Levels[0]
+		st.Records, st.Fill_factor, st.Fragmentation = leaf.Records, leaf.Fill_factor, leaf.Fragmentation
+	}
+	return stats
+}
+
+func (st *IndexStats) Dump() {
+	println("index stats:")
+
+	data, _ := json.Marshal(st)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
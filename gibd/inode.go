@@ -92,15 +92,21 @@ func (inode *Inode) ParseInodeBlock() {
 		nodeEntry.Magic = uint64(BufferReadAt(inode.Page, pos+60, 4))
 
 		for j := int64(0); j < 32; j++ {
-			entry := uint64(BufferReadAt(inode.Page, pos+60+j*4, 4))
+			entry := uint64(BufferReadAt(inode.Page, pos+64+j*4, 4))
 			nodeEntry.FragArrayEntry[j] = *NewFragEntry(entry)
 
 		}
+		inode.Inodes[i] = *nodeEntry
 		pos = pos + 192
 
 	}
 }
 
+// inode entry在页中的位置
+func Pos_Inode_Entry(i int) uint64 {
+	return 50 + uint64(i)*192
+}
+
 func (inode *Inode) Dump() {
 	println("Inode dump:")
 
@@ -109,3 +115,18 @@ func (inode *Inode) Dump() {
 	fmt.Printf("%s\n", outStr)
 
 }
+
+// fseg entry: 指向inode页中的某个inode entry, 10字节
+type FsegEntry struct {
+	Space_id    uint64 `json:"space_id"`
+	Page_number uint64 `json:"page_number"`
+	Offset      uint64 `json:"offset"`
+}
+
+func Read_Fseg_Entry(p *Page, pos int64) *FsegEntry {
+	return &FsegEntry{
+		Space_id:    uint64(BufferReadAt(p, pos, 4)),
+		Page_number: uint64(BufferReadAt(p, pos+4, 4)),
+		Offset:      uint64(BufferReadAt(p, pos+8, 2)),
+	}
+}
@@ -0,0 +1,210 @@
+package gibd
+
+import (
+	"strconv"
+)
+
+// instant ADD/DROP COLUMN以后, 聚簇索引叶子页上的记录字段数不一样, 只处理compact格式
+// MySQL 8.0.12~8.0.28: info bits中有REC_INFO_INSTANT_FLAG的记录, header前面是1~2个字节的字段数(包括系统字段)
+//
+//	没有这个标记的记录只有instant ADD之前的字段
+//
+// MySQL 8.0.29以后: info bits中有REC_INFO_VERSION_FLAG的记录, header前面是1个字节的row version
+//
+//	字段在version_added <= version < version_dropped的时候在记录中, 删掉的字段在旧记录中还占空间
+//
+// MariaDB 10.3以后: 记录的status是REC_STATUS_INSTANT, header前面是1~2个字节的n_add_field
+//
+//	字段数是n_core_fields + 1 + n_add_field, n_core_fields在root页的PAGE_INSTANT中
+//	最左边叶子页上第一条记录是带min_rec标记的metadata记录, 不是用户的数据
+//
+// 记录中没有的字段用instant ADD时的默认值
+const REC_INFO_INSTANT_FLAG = 8 // Info_flags是info bits右移4位以后的值
+const REC_INFO_VERSION_FLAG = 4
+const REC_STATUS_INSTANT = 4
+const REC_N_FIELDS_TWO_BYTES_FLAG = 0x80
+
+// MariaDB instant ALTER以后聚簇索引root页的页类型, 和MySQL 8.0的FIL_PAGE_SDI_BLOB的值一样
+const FIL_PAGE_TYPE_INSTANT = 18
+const PAGE_INSTANT = 12
+
+// 建表语句中前n个字段(不包括DB_ROW_ID)是instant ADD之前就有的, 后面的都是instant ADD加的
+func (td *TableDescriber) Instant_Columns(n int) {
+	i := 0
+	for _, column := range td.Columns {
+		if column.Hidden {
+			continue
+		}
+		column.Instant = i >= n
+		i++
+	}
+}
+
+func (td *TableDescriber) Has_Instant_Columns() bool {
+	for _, column := range td.Columns {
+		if column.Instant || column.Version_added > 0 || column.Version_dropped > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MariaDB的root页中的n_core_fields, 不是instant root页的时候返回0
+func (tree *BTreeIndex) Instant_Core_Fields() uint64 {
+	root := tree.Root
+	if root.Page.FileHeader.Page_type != FIL_PAGE_TYPE_INSTANT {
+		return 0
+	}
+	return uint64(BufferReadAt(root.Page, int64(root.Pos_Index_Header())+PAGE_INSTANT, 2)) >> 3
+}
+
+// 索引页, MariaDB instant ALTER以后root页的类型是FIL_PAGE_TYPE_INSTANT, root页没有兄弟页
+// 空间索引的页是FIL_PAGE_RTREE, 参考rtree.go
+func (index *IndexPage) Is_Index_Page() bool {
+	switch index.Page.FileHeader.Page_type {
+	case FIL_PAGE_INDEX, FIL_PAGE_RTREE:
+		return true
+	case FIL_PAGE_TYPE_INSTANT:
+		return index.Page.FileHeader.Prev == FIL_NULL && index.Page.FileHeader.Next == FIL_NULL
+	}
+	return false
+}
+
+// MariaDB的metadata记录, 叶子页上带min_rec标记的记录
+func (rh *RecordHeader) Is_Metadata() bool {
+	return rh.Record_Type == "conventional" && rh.Is_Min_Rec()
+}
+
+func (record *Record) Is_Metadata() bool {
+	user_record, ok := record.record.(*UserRecord)
+	return ok && user_record.header != nil && user_record.header.Is_Metadata()
+}
+
+// 不解析记录, 只看header中的标记, 解析metadata记录可能会出错
+func (index *IndexPage) Is_Metadata_Record(offset uint64) bool {
+	if index.PageHeader.Format != "compact" || !index.IsLeaf() {
+		return false
+	}
+	info := uint64(BufferReadAt(index.Page, int64(offset)-5, 1)) >> 4
+	return info&RECORD_INFO_MIN_REC_FLAG != 0
+}
+
+// 读header前面的字段数或者row version, offset是5个字节header的开始位置, 返回占用的字节数
+// 只有聚簇索引叶子页上的记录有这些标记
+func (index *IndexPage) Record_Header_Compact_Instant(header *RecordHeader, offset uint64) uint64 {
+	if header.Record_Type != "conventional" || index.Record_Format["tab_type"] != "clustered" {
+		return 0
+	}
+	// 超过127的时候是2个字节, 第一个字节有REC_N_FIELDS_TWO_BYTES_FLAG, MySQL和MariaDB的高低位不一样
+	first := uint64(BufferReadAt(index.Page, int64(offset)-1, 1))
+	second := func() uint64 { return uint64(BufferReadAt(index.Page, int64(offset)-2, 1)) }
+	// MariaDB rec_get_n_add_field: 第一个字节是低7位
+	read_n_add_field := func() (uint64, uint64) {
+		if first&REC_N_FIELDS_TWO_BYTES_FLAG == 0 {
+			return first, 1
+		}
+		return (first & 0x7f) | second()<<7, 2
+	}
+	// MySQL rec_get_n_fields_instant: 第一个字节是高7位
+	read_n_fields_instant := func() (uint64, uint64) {
+		if first&REC_N_FIELDS_TWO_BYTES_FLAG == 0 {
+			return first, 1
+		}
+		return (first&0x7f)<<8 | second(), 2
+	}
+	switch {
+	case header.Instant:
+		fields, _, _ := index.Get_Record_Fields_From_Format()
+		var n_core uint64
+		for _, f := range fields {
+			if f.Is_Core() {
+				n_core++
+			}
+		}
+		n_add, size := read_n_add_field()
+		header.N_fields = n_core + 1 + n_add
+		header.Instant_length = size
+	case header.Info_flags&REC_INFO_VERSION_FLAG != 0:
+		header.Version = uint64(BufferReadAt(index.Page, int64(offset)-1, 1))
+		header.Instant_length = 1
+	case header.Info_flags&REC_INFO_INSTANT_FLAG != 0:
+		header.N_fields, header.Instant_length = read_n_fields_instant()
+	}
+	return header.Instant_length
+}
+
+// instant ADD之前就有的字段
+func (rf *RecordFieldMeta) Is_Core() bool {
+	return !rf.Instant && rf.Version_added == 0
+}
+
+// 记录中实际存储的字段, fields中的Position是字段在记录中的位置
+func (header *RecordHeader) Present_Fields(fields []*RecordFieldMeta) []*RecordFieldMeta {
+	var present []*RecordFieldMeta
+	for _, f := range fields {
+		var ok bool
+		switch {
+		case header.Info_flags&REC_INFO_VERSION_FLAG != 0 && header.Record_Type == "conventional":
+			ok = f.Version_added <= header.Version && (f.Version_dropped == 0 || f.Version_dropped > header.Version)
+		case header.N_fields > 0 && (header.Instant_length > 0 || header.Offset_size > 0):
+			ok = uint64(f.Position) < header.N_fields
+		default:
+			ok = f.Is_Core()
+		}
+		if ok {
+			present = append(present, f)
+		}
+	}
+	return present
+}
+
+// 读取记录中的字段, 记录中没有的字段用默认值, 已经删掉的字段不返回
+func (index *IndexPage) Read_Instant_Fields(fields []*RecordFieldMeta, offset uint64, record *UserRecord) ([]*FieldDescriptor, uint64) {
+	present := record.header.Present_Fields(fields)
+	values, offset := index.Read_Fields(present, offset, record)
+	var descriptors []*FieldDescriptor
+	i := 0
+	for _, f := range fields {
+		if i < len(present) && present[i] == f {
+			if f.Version_dropped == 0 {
+				descriptors = append(descriptors, values[i])
+			}
+			i++
+			continue
+		}
+		if f.Version_dropped == 0 {
+			descriptors = append(descriptors, NewFieldDescriptor(f.Name, "", f.Default, nil))
+		}
+	}
+	return descriptors, offset
+}
+
+// 字段的instant信息和默认值, SDI中的默认值是记录中的存储格式, 建表语句中的默认值是字符串
+// 没有默认值的NOT NULL字段和MySQL一样用0或者空字符串
+func (rf *RecordFieldMeta) Set_Instant(column *TableColumn) {
+	rf.Instant, rf.Version_added, rf.Version_dropped = column.Instant, column.Version_added, column.Version_dropped
+	if rf.Is_Core() {
+		return
+	}
+	switch {
+	case column.Instant_default != nil:
+		rf.Default = rf.Value_From_Bytes(column.Instant_default)
+	case column.Default != nil:
+		rf.Default = rf.Value_From_String(*column.Default, column.Unsigned)
+	case !column.Nullable:
+		rf.Default = rf.Value_From_String("", column.Unsigned)
+	}
+}
+
+func (rf *RecordFieldMeta) Value_From_String(value string, unsigned bool) interface{} {
+	switch rf.DataType.(type) {
+	case *IntegerType:
+		if unsigned {
+			n, _ := strconv.ParseUint(value, 10, 64)
+			return int64(n)
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return n
+	}
+	return value
+}
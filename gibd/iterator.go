@@ -0,0 +1,127 @@
+package gibd
+
+import "fmt"
+
+// 流式读取, 一次只在内存中放一个页, 大表不用先把所有页和记录放到slice里
+// Next返回nil, nil表示结束, 页坏了的时候返回error
+// 页内记录链表的错误返回以后可以继续调用Next, 从下一个页接着读; 页链表坏了就结束
+
+// 同一层的页, 沿着next链表往后走
+type PageIterator struct {
+	tree  *BTreeIndex
+	dh    *DataDictionary
+	level uint64
+	first *IndexPage
+	next  uint64
+	pages uint64
+}
+
+func (tree *BTreeIndex) Page_Iterator(level int, dh *DataDictionary) *PageIterator {
+	first := tree.Min_Page_At_Level(level)
+	return &PageIterator{tree: tree, dh: dh, level: uint64(level), first: first, next: first.Page.Page_number}
+}
+
+func (it *PageIterator) Next() (*IndexPage, error) {
+	if it.next == FIL_NULL {
+		return nil, nil
+	}
+	var index *IndexPage
+	if it.first != nil {
+		index, it.first = it.first, nil
+	} else {
+		index = it.tree.Page(it.next)
+	}
+	it.pages++
+	if it.pages > it.tree.Space.Pages {
+		it.next = FIL_NULL
+		return nil, fmt.Errorf("page list at level %d has more pages than the space, there is a loop", it.level)
+	}
+	if !index.Is_Index_Page() || index.PageHeader.Level != it.level {
+		it.next = FIL_NULL
+		return nil, fmt.Errorf("page %d is not an index page at level %d", index.Page.Page_number, it.level)
+	}
+	index.dh = it.dh
+	it.next = index.Page.FileHeader.Next
+	return index, nil
+}
+
+// 记录链表中的记录, 包括delete mark的记录; 没有pages的时候只读一个页
+type RecordIterator struct {
+	pages  *PageIterator
+	index  *IndexPage
+	offset uint64
+	n      uint64
+}
+
+func (tree *BTreeIndex) Record_Iterator(dh *DataDictionary) *RecordIterator {
+	return &RecordIterator{pages: tree.Page_Iterator(0, dh)}
+}
+
+func (index *IndexPage) Record_Iterator() *RecordIterator {
+	return &RecordIterator{index: index, offset: index.Pos_Infimum()}
+}
+
+func (it *RecordIterator) Next() (*Record, error) {
+	for {
+		if it.index == nil {
+			if it.pages == nil {
+				return nil, nil
+			}
+			index, err := it.pages.Next()
+			if index == nil || err != nil {
+				return nil, err
+			}
+			it.index, it.offset, it.n = index, index.Pos_Infimum(), 0
+		}
+		index := it.index
+		offset := index.Next_Offset(it.offset)
+		if offset == index.Pos_Supremum() {
+			it.index = nil
+			continue
+		}
+		it.n++
+		if offset < index.Pos_User_Records() || offset >= index.Pos_Directory() || it.n > index.PageHeader.N_heap {
+			it.index = nil
+			return nil, fmt.Errorf("record list on page %d is broken at offset %d", index.Page.Page_number, it.offset)
+		}
+		// MariaDB instant ALTER以后的metadata记录
+		if index.Is_Metadata_Record(offset) {
+			it.offset = offset
+			continue
+		}
+		record, err := index.Try_Record(offset)
+		if err != nil {
+			it.index = nil
+			return nil, err
+		}
+		it.offset = offset
+		return record, nil
+	}
+}
+
+// 一组给定的叶子页, root页或者中间的页丢了树走不通的时候用(carve, dropped)
+type LeafPageIterator struct {
+	space            *Space
+	record_describer interface{}
+	page_numbers     []uint64
+}
+
+func (s *Space) Leaf_Page_Iterator(page_numbers []uint64, record_describer interface{}) *LeafPageIterator {
+	return &LeafPageIterator{space: s, record_describer: record_describer, page_numbers: page_numbers}
+}
+
+// 不是叶子页的时候返回error, 可以继续调用Next读下一个页
+func (it *LeafPageIterator) Next() (*IndexPage, error) {
+	if len(it.page_numbers) == 0 {
+		return nil, nil
+	}
+	page_number := it.page_numbers[0]
+	it.page_numbers = it.page_numbers[1:]
+	page := it.space.Page(page_number)
+	page.record_describer = it.record_describer
+	index := NewIndex(page)
+	if !index.Is_Index_Page() || !index.IsLeaf() {
+		return nil, fmt.Errorf("page %d is not a leaf page", page_number)
+	}
+	return index, nil
+}
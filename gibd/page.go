@@ -16,8 +16,11 @@ const (
 
 	FIL_PAGE_TYPE_TRX_SYS = 7
 	FIL_PAGE_TYPE_FSP_HDR = 8
+	FIL_PAGE_TYPE_BLOB    = 10
 	FIL_PAGE_INDEX        = 17855
 	FIL_PAGE_RTREE        = 17854
+
+	FIL_PAGE_TYPE_RSEG_ARRAY = 21
 )
 
 var PAGE_TYPE = map[int]string{
@@ -39,19 +42,20 @@ var PAGE_TYPE = map[int]string{
 	15:    "FIL_PAGE_ENCRYPTED",                /*!< Encrypted page */
 	16:    "FIL_PAGE_COMPRESSED_AND_ENCRYPTED", /*!< Compressed and Encrypted page */
 	17:    "FIL_PAGE_ENCRYPTED_RTREE",          /*!< Encrypted R-tree page */
+	21:    "FIL_PAGE_TYPE_RSEG_ARRAY",          /*!< Rollback Segment Array page */
 	17855: "FIL_PAGE_INDEX",                    /*!< B-tree node */
 	17854: "FIL_PAGE_RTREE",                    /*!< B-tree node */
 }
 
 type Address struct {
-	Page uint64 `json:"pageno"`
-	// Offset uint64 `json:"offset"`
+	Page   uint64 `json:"pageno"`
+	Offset uint64 `json:"offset"`
 }
 
-func NewAddress(Page uint64) *Address {
+func NewAddress(Page uint64, Offset uint64) *Address {
 	return &Address{
-		Page: Page,
-		// Offset: Offset,
+		Page:   Page,
+		Offset: Offset,
 	}
 
 }
@@ -130,8 +134,27 @@ func (p *Page) Page_Dump() {
 	println()
 
 	if p.FileHeader.Page_type == FIL_PAGE_TYPE_SYS {
-		dict_header := NewSysDataDictionaryHeader(p)
-		dict_header.Dump()
+		//回滚段头页的类型也是FIL_PAGE_TYPE_SYS
+		if p.Space != nil && p.Space.Is_Rseg_Header_Page(p.Page_number) {
+			rseg := NewRseg(p)
+			rseg.Rseg_Header()
+			rseg.Dump()
+		} else {
+			dict_header := NewSysDataDictionaryHeader(p)
+			dict_header.Dump()
+		}
+	}
+	if p.FileHeader.Page_type == FIL_PAGE_TYPE_TRX_SYS {
+		//系统表空间第5页
+		trx_sys := NewTrxSys(p)
+		trx_sys.Trx_Sys_Header()
+		trx_sys.Dump()
+	}
+	if p.FileHeader.Page_type == FIL_PAGE_TYPE_RSEG_ARRAY {
+		//8.0 undo表空间第3页
+		rseg_array := NewRsegArray(p)
+		rseg_array.Rseg_Array_Header()
+		rseg_array.Dump()
 	}
 	if p.FileHeader.Page_type == FIL_PAGE_TYPE_FSP_HDR {
 		//表空间从block 0 是fsp extent descriptor信息，
@@ -142,8 +165,10 @@ func (p *Page) Page_Dump() {
 
 	}
 	if p.FileHeader.Page_type == FIL_PAGE_IBUF_BITMAP {
-		// TODO
 		//表空间从block 1 是IBUF_BITMAP信息，
+		bitmap := NewIbufBitmap(p)
+		bitmap.Ibuf_Bitmap()
+		bitmap.Dump()
 	}
 
 	if p.FileHeader.Page_type == FIL_PAGE_INODE {
@@ -153,21 +178,32 @@ func (p *Page) Page_Dump() {
 		iNodePage.Dump()
 
 	}
-	if p.FileHeader.Page_type == FIL_PAGE_INDEX {
+	if p.FileHeader.Page_type == FIL_PAGE_INDEX || p.FileHeader.Page_type == FIL_PAGE_RTREE {
 		//表空间从block 3开始是用户数据页
 		indexPage := NewIndex(p)
 		indexPage.Index_Header()
 		indexPage.Fseg_Header()
 
 		indexPage.Dump()
-		indexPage.each_record()
+		if indexPage.Is_Rtree_Page() {
+			indexPage.Rtree_Dump()
+		} else {
+			indexPage.each_record()
+		}
 	}
 	if p.FileHeader.Page_type == FIL_PAGE_TYPE_ALLOCATED {
 		// do nothing,new page
 	}
 
 	if p.FileHeader.Page_type == FIL_PAGE_UNDO_LOG {
-		// undo block parse
+		// undo block parse, 有表的描述符的时候按字段解析旧值
+		undo_page := NewUndoPage(p)
+		var describer interface{}
+		if p.Space != nil {
+			describer = p.Space.Record_describer
+		}
+		undo_page.Each_Undo_Record(describer)
+		undo_page.Dump()
 	}
 
 }
@@ -0,0 +1,197 @@
+package gibd
+
+import "fmt"
+
+// 按key范围读取叶子页上的记录, 只在需要的时候读下一个页
+// lo和hi可以是nil(没有边界), 也可以只有主键的前面几个字段
+// delete mark的记录不返回, limit是返回的记录数, 0表示不限制
+type IndexRange struct {
+	Tree      *BTreeIndex
+	Lo        []interface{}
+	Hi        []interface{}
+	Inclusive bool
+	Direction string // forward或者backward
+	Limit     uint64
+	index     *IndexPage
+	offset    uint64
+	count     uint64
+	done      bool
+	err       error
+}
+
+func (tree *BTreeIndex) Range(lo []interface{}, hi []interface{}, inclusive bool, direction string, limit uint64) *IndexRange {
+	r := &IndexRange{Tree: tree, Lo: lo, Hi: hi, Inclusive: inclusive, Direction: direction, Limit: limit}
+	for _, key := range [][]interface{}{lo, hi} {
+		if err := tree.check_key_order(key); err != nil {
+			r.err, r.done = err, true
+			return r
+		}
+	}
+	switch direction {
+	case "forward":
+		// 从小于lo的最后一条记录后面开始
+		if lo == nil {
+			r.start(tree.Min_Page_At_Level(0), nil, nil)
+		} else {
+			r.start(tree.search_leaf(lo, true))
+		}
+	case "backward":
+		// 从小于等于hi的最后一条记录开始, 位置放在它后面
+		if hi == nil {
+			index := tree.Max_Page_At_Level(0)
+			r.start(index, index.Max_Record(), nil)
+		} else {
+			r.start(tree.search_leaf(hi, false))
+		}
+		if r.index != nil && r.offset != r.index.Pos_Infimum() {
+			r.offset = r.index.Next_Offset(r.offset)
+		}
+	default:
+		r.index = nil
+	}
+	r.done = r.index == nil || r.err != nil
+	return r
+}
+
+// 位置放在record上, record是nil的时候放在infimum上, 边界和记录比较不了的时候Next返回err
+func (r *IndexRange) start(index *IndexPage, record *Record, err error) {
+	r.index, r.err = index, err
+	if index == nil {
+		return
+	}
+	r.offset = index.Pos_Infimum()
+	if record == nil {
+		return
+	}
+	if user_record, ok := record.record.(*UserRecord); ok {
+		r.offset = user_record.offset
+	}
+}
+
+// 记录和边界比较, 只比较边界中的字段
+func (r *IndexRange) after_lo(record *Record) (bool, error) {
+	if r.Lo == nil {
+		return true, nil
+	}
+	c, err := r.index.compare_record(record, r.Lo)
+	return c > 0 || (r.Inclusive && c == 0), err
+}
+
+func (r *IndexRange) before_hi(record *Record) (bool, error) {
+	if r.Hi == nil {
+		return true, nil
+	}
+	c, err := r.index.compare_record(record, r.Hi)
+	return c < 0 || (r.Inclusive && c == 0), err
+}
+
+// 换到兄弟页, 没有兄弟页或者兄弟页不是同一层的叶子页的时候结束
+func (r *IndexRange) move_to(page_number uint64) bool {
+	if page_number == FIL_NULL || page_number == 0 {
+		return false
+	}
+	index := r.Tree.Page(page_number)
+	if index.Page.FileHeader.Page_type != FIL_PAGE_INDEX || !index.IsLeaf() {
+		return false
+	}
+	r.index = index
+	return true
+}
+
+// 下一条记录, 结束的时候返回nil, nil
+func (r *IndexRange) Next() (*Record, error) {
+	if r.err != nil {
+		err := r.err
+		r.err = nil
+		return nil, err
+	}
+	for !r.done {
+		if r.Limit > 0 && r.count >= r.Limit {
+			r.done = true
+			break
+		}
+		var record *Record
+		var err error
+		if r.Direction == "backward" {
+			record, err = r.prev()
+		} else {
+			record, err = r.next()
+		}
+		if record == nil || err != nil {
+			r.done = true
+			return nil, err
+		}
+		after_lo, err := r.after_lo(record)
+		if err != nil {
+			r.done = true
+			return nil, err
+		}
+		before_hi, err := r.before_hi(record)
+		if err != nil {
+			r.done = true
+			return nil, err
+		}
+		// 开始的位置前后可能有不在范围内的记录, 跳过去
+		if r.Direction == "backward" {
+			if !after_lo {
+				r.done = true
+				break
+			}
+			if !before_hi {
+				continue
+			}
+		} else {
+			if !before_hi {
+				r.done = true
+				break
+			}
+			if !after_lo {
+				continue
+			}
+		}
+		if record.Is_Deleted() || record.Is_Metadata() {
+			continue
+		}
+		r.count++
+		return record, nil
+	}
+	return nil, nil
+}
+
+func (r *IndexRange) next() (*Record, error) {
+	for {
+		if r.offset == r.index.Pos_Supremum() {
+			if !r.move_to(r.index.Page.FileHeader.Next) {
+				return nil, nil
+			}
+			r.offset = r.index.Pos_Infimum()
+		}
+		offset := r.index.Next_Offset(r.offset)
+		if offset != r.index.Pos_Supremum() && (offset < r.index.Pos_User_Records() || offset >= r.index.Pos_Directory()) {
+			return nil, fmt.Errorf("record list on page %d is broken at offset %d", r.index.Page.Page_number, r.offset)
+		}
+		r.offset = offset
+		if r.offset != r.index.Pos_Supremum() {
+			return r.index.Try_Record(r.offset)
+		}
+	}
+}
+
+func (r *IndexRange) prev() (*Record, error) {
+	for {
+		if r.offset == r.index.Pos_Infimum() {
+			if !r.move_to(r.index.Page.FileHeader.Prev) {
+				return nil, nil
+			}
+			r.offset = r.index.Pos_Supremum()
+		}
+		offset := r.index.Prev_Offset(r.offset)
+		if offset == 0 {
+			return nil, fmt.Errorf("cannot find the record before offset %d on page %d", r.offset, r.index.Page.Page_number)
+		}
+		r.offset = offset
+		if r.offset != r.index.Pos_Infimum() {
+			return r.index.Try_Record(r.offset)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package gibd
+
+import "sort"
+
+// 一致性读的视图 read0types.h, 判断某个事务的修改对这个快照是否可见
+type ReadView struct {
+	Low_limit_id   uint64   `json:"low_limit_id"`   // 大于等于这个id的事务都不可见
+	Up_limit_id    uint64   `json:"up_limit_id"`    // 小于这个id的事务都可见
+	Creator_trx_id uint64   `json:"creator_trx_id"` // 自己的修改总是可见的
+	Ids            []uint64 `json:"ids"`            // 创建视图时活跃的事务, 不可见
+	Low_limit_no   uint64   `json:"low_limit_no"`   // 提交顺序(trx_no)小于这个值的事务都可见, 0表示只按trx id判断
+}
+
+func NewReadView(low_limit_id uint64, up_limit_id uint64, creator_trx_id uint64, ids []uint64) *ReadView {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return &ReadView{Low_limit_id: low_limit_id, Up_limit_id: up_limit_id, Creator_trx_id: creator_trx_id, Ids: ids}
+}
+
+// trx_id这个事务的修改, 和trx_no小于等于trx_id(在这之前提交)的事务的修改可见
+// 事务的trx_no在提交的时候分配, 和trx id用同一个计数器, 所以比trx_id小的事务在trx_id之后提交的时候也不可见
+// 不知道trx_no的版本(insert的版本, undo log找不到)只能按trx id判断
+func NewReadViewAsOf(trx_id uint64) *ReadView {
+	view := NewReadView(trx_id+1, trx_id+1, trx_id, nil)
+	view.Low_limit_no = trx_id + 1
+	return view
+}
+
+func (view *ReadView) Changes_Visible(trx_id uint64) bool {
+	if trx_id == view.Creator_trx_id || trx_id < view.Up_limit_id {
+		return true
+	}
+	if trx_id >= view.Low_limit_id {
+		return false
+	}
+	i := sort.Search(len(view.Ids), func(i int) bool { return view.Ids[i] >= trx_id })
+	return i == len(view.Ids) || view.Ids[i] != trx_id
+}
+
+// 版本是不是对视图可见, 有提交顺序的时候用提交顺序
+func (view *ReadView) Version_Visible(version *RecordVersion) bool {
+	if version.Trx_id == view.Creator_trx_id {
+		return true
+	}
+	if version.Active {
+		return false
+	}
+	if view.Low_limit_no == 0 || version.Trx_no == 0 {
+		return view.Changes_Visible(version.Trx_id)
+	}
+	return version.Trx_no < view.Low_limit_no
+}
+
+// 记录对这个视图可见的版本, 插入在快照之后或者快照时已经删除的返回nil
+// undo已经被purge追溯不到的时候也返回nil
+func (record *Record) Version_As_Of(view *ReadView) *RecordVersion {
+	history := record.History()
+	if history == nil {
+		return nil
+	}
+	for _, version := range history.Versions {
+		if !view.Version_Visible(version) {
+			continue
+		}
+		if version.Deleted {
+			return nil
+		}
+		return version
+	}
+	if !history.Complete {
+		Log.Info("record history is not complete, %s\n", history.Reason)
+	}
+	return nil
+}
+
+// 按视图还原的所有行, 包括之后被删除(delete mark还没有purge)的行
+func (tree *BTreeIndex) Each_Record_As_Of(view *ReadView, dh *DataDictionary) []*RecordVersion {
+	var versions []*RecordVersion
+	it := tree.Record_Iterator(dh)
+	for {
+		record, err := it.Next()
+		if err != nil {
+			Log.Info("%v\n", err)
+			continue
+		}
+		if record == nil {
+			break
+		}
+		if version := record.Version_As_Of(view); version != nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
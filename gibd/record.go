@@ -38,9 +38,13 @@ type RecordHeader struct {
 	Info_flags  uint64         `json:"info_flags"`
 	Offset_size uint64         `json:"offset_size"`
 	N_fields    uint64         `json:"n_fields"`
-	Nulls       string         `json:"nulls"`
+	Nulls       []string       `json:"nulls"`
 	Lengths     map[string]int `json:"lengths"`
-	Externs     string         `json:"externs"`
+	Externs     []string       `json:"externs"`
+	// instant ADD/DROP COLUMN以后的记录, 参考instant.go
+	Instant        bool   `json:"instant,omitempty"`        // MariaDB的REC_STATUS_INSTANT
+	Version        uint64 `json:"version,omitempty"`        // MySQL 8.0.29以后的row version
+	Instant_length uint64 `json:"instant_length,omitempty"` // header前面字段数或者row version占用的字节数
 }
 
 func NewRecordHeader(offset uint64) *RecordHeader {
@@ -73,7 +77,7 @@ func NewRecord2() *Record {
 	return &Record{}
 }
 
-//获取列对应的值，放到map中
+// 获取列对应的值，放到map中
 func (record *Record) Get_Fields_And_Value_Map() map[string]interface{} {
 	fields_map := make(map[string]interface{})
 	keys := record.record.(*UserRecord).key
@@ -92,6 +96,47 @@ func (record *Record) Get_Fields_And_Value_Map() map[string]interface{} {
 
 }
 
+// 外部存储的字段指向BLOB页的引用, 不是外部存储的时候返回nil
+func (record *Record) Extern(name string) *ExternReference {
+	user_record, ok := record.record.(*UserRecord)
+	if !ok {
+		return nil
+	}
+	for _, fields := range [][]*FieldDescriptor{user_record.key, user_record.row} {
+		for _, value := range fields {
+			if value.FieldMeta.Name == name {
+				return value.FieldMeta.Extern
+			}
+		}
+	}
+	return nil
+}
+
+// key字段的值, 按索引中的顺序
+// 二级索引的记录按索引字段加上主键排序, 后面是主键字段的值
+func (record *Record) Key() []interface{} {
+	var keys []interface{}
+	user_record, ok := record.record.(*UserRecord)
+	if !ok {
+		return nil
+	}
+	for _, value := range user_record.key {
+		keys = append(keys, value.Value)
+	}
+	if user_record.record_type == "secondary" || user_record.record_type == "spatial" {
+		for _, value := range user_record.row {
+			keys = append(keys, value.Value)
+		}
+	}
+	return keys
+}
+
+// delete mark了还没有purge的记录
+func (record *Record) Is_Deleted() bool {
+	user_record, ok := record.record.(*UserRecord)
+	return ok && user_record.header != nil && user_record.header.Is_Deleted()
+}
+
 type SystemRecord struct {
 	offset uint64
 	header *RecordHeader
@@ -4,156 +4,127 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 )
 
 // 记录描述符这应该重构下，描述符这有点混乱
-//只实现了系统表systable sysindex 的description
+//只实现了系统表systable sysindex systablespaces sysdatafiles 的description
 func (index *IndexPage) Make_Record_Description() map[string]interface{} {
-	var position [1024]int
-	for i := 0; i <= RECORD_MAX_N_FIELDS; i++ {
-		position[i] = i
-	}
 	//用之前的描述符，更改下格式
 	description := index.Get_Record_Describer()
-	fields := make(map[string]string)
+	return Make_Record_Description_By_Describer(description, index.IsLeaf())
+}
 
+func Make_Record_Description_By_Describer(description interface{}, is_leaf bool) map[string]interface{} {
 	var field_map_description map[string]interface{}
 
+	//转化格式，统一下，要不后续不好处理
 	switch description.(type) {
 	case *SysTablesPrimary:
+		field_map_description = Restruct_Describer(*description.(*SysTablesPrimary))
+	case *SysIndexesPrimary:
+		field_map_description = Restruct_Describer(*description.(*SysIndexesPrimary))
+	case *SysTablespacesPrimary:
+		field_map_description = Restruct_Describer(*description.(*SysTablespacesPrimary))
+	case *SysDatafilesPrimary:
+		field_map_description = Restruct_Describer(*description.(*SysDatafilesPrimary))
+	case *TableDescriber:
+		field_map_description = description.(*TableDescriber).Restruct()
+	default:
+		fmt.Printf("\n")
+		return field_map_description
+	}
 
-		description := description.(*SysTablesPrimary)
-		fields["type"] = description.TAB_TYPE
-
-		//转化格式，统一下，要不后续不好处理
-		field_map_description = Restruct_Describer(*description)
-
-		var counter int
-		counter = 0
-
-		var key_arr []*RecordFieldMeta
-		for _, v := range field_map_description["key"].([]interface{}) {
-			//key_arr = []*Recordfield{}
-
-			value := v.(map[string]interface{})
-			prop := value["type"].([]interface{})
-			var properties string
-			for i := 1; i < len(prop); i++ {
-				properties += " " + prop[i].(string)
-			}
-			rf := NewRecordFieldMeta(position[counter], value["name"].(string), prop[0].(string), properties)
+	return Make_Field_Meta_Description(field_map_description, is_leaf)
+}
 
-			fmap[counter] = "key"
-			key_arr = append(key_arr, rf)
-			counter = counter + 1
+// 聚簇索引叶子结点上的所有字段, 按position排序, 也就是undo记录中的field no; 同时返回key字段的个数
+func Record_Fields_By_Describer(description interface{}) ([]*RecordFieldMeta, int) {
+	var fields []*RecordFieldMeta
+	field_map_description := Make_Record_Description_By_Describer(description, true)
+	if field_map_description == nil {
+		return nil, 0
+	}
+	for _, name := range []string{"key", "sys", "row"} {
+		if arr, ok := field_map_description[name].([]*RecordFieldMeta); ok {
+			fields = append(fields, arr...)
 		}
+	}
+	sort.Sort(FiledSort(fields))
+	key_arr, _ := field_map_description["key"].([]*RecordFieldMeta)
+	return fields, len(key_arr)
+}
 
-		field_map_description["key"] = key_arr
+// 把Restruct_Describer转换后的字段描述变成RecordFieldMeta，按key,sys,row的顺序分配position
+func Make_Field_Meta_Description(field_map_description map[string]interface{}, is_leaf bool) map[string]interface{} {
+	if field_map_description == nil {
+		return nil
+	}
+	var position [1024]int
+	for i := 0; i <= RECORD_MAX_N_FIELDS; i++ {
+		position[i] = i
+	}
 
-		//叶子结点加上系统字段
-		var sys_arr []*RecordFieldMeta
-		if index.IsLeaf() && field_map_description["tab_type"] == "clustered" {
+	var counter int
+	counter = 0
 
-			DB_TRX_ID := NewRecordFieldMeta(position[counter], "DB_TRX_ID", "TRX_ID", "NOT_NULL")
-			fmap[counter] = "sys"
-			counter = counter + 1
-			sys_arr = append(sys_arr, DB_TRX_ID)
-			DB_ROLL_PTR := NewRecordFieldMeta(position[counter], "DB_ROLL_PTR", "ROLL_PTR", "NOT_NULL")
-			fmap[counter] = "sys"
-			counter = counter + 1
-			sys_arr = append(sys_arr, DB_ROLL_PTR)
+	var key_arr []*RecordFieldMeta
+	for _, v := range field_map_description["key"].([]interface{}) {
 
-			field_map_description["sys"] = sys_arr
+		value := v.(map[string]interface{})
+		prop := value["type"].([]interface{})
+		var properties string
+		for i := 1; i < len(prop); i++ {
+			properties += " " + prop[i].(string)
 		}
+		rf := NewRecordFieldMeta(position[counter], value["name"].(string), prop[0].(string), properties)
 
-		var row_arr []*RecordFieldMeta
-		if (field_map_description["tab_type"] == "clustered") || (field_map_description["tab_type"] == "secondary") {
-			for _, v := range field_map_description["row"].([]interface{}) {
-				value := v.(map[string]interface{})
-				name := value["name"].(string)
-				prop := value["type"].([]interface{})
-				var properties string
-				for i := 1; i < len(prop); i++ {
-					properties += " " + prop[i].(string)
-				}
-				row := NewRecordFieldMeta(position[counter], name, prop[0].(string), properties)
-
-				fmap[counter] = "row"
-				row_arr = append(row_arr, row)
-				counter = counter + 1
-
-			}
+		fmap[counter] = "key"
+		key_arr = append(key_arr, rf)
+		counter = counter + 1
+	}
 
-			field_map_description["row"] = row_arr
-		}
+	field_map_description["key"] = key_arr
 
-		return field_map_description
-	case *SysIndexesPrimary:
-		description := description.(*SysIndexesPrimary)
+	//叶子结点加上系统字段,回滚段和事务id的值
+	var sys_arr []*RecordFieldMeta
+	if is_leaf && field_map_description["tab_type"] == "clustered" {
 
-		//转化格式，统一下，要不后续不好处理
-		field_map_description = Restruct_Describer(*description)
-		var counter int
-		counter = 0
+		DB_TRX_ID := NewRecordFieldMeta(position[counter], "DB_TRX_ID", "TRX_ID", "NOT_NULL")
+		fmap[counter] = "sys"
+		counter = counter + 1
+		sys_arr = append(sys_arr, DB_TRX_ID)
+		DB_ROLL_PTR := NewRecordFieldMeta(position[counter], "DB_ROLL_PTR", "ROLL_PTR", "NOT_NULL")
+		fmap[counter] = "sys"
+		counter = counter + 1
+		sys_arr = append(sys_arr, DB_ROLL_PTR)
 
-		var key_arr []*RecordFieldMeta
-		for _, v := range field_map_description["key"].([]interface{}) {
+		field_map_description["sys"] = sys_arr
+	}
 
+	var row_arr []*RecordFieldMeta
+	if (field_map_description["tab_type"] == "clustered") || (field_map_description["tab_type"] == "secondary") || (field_map_description["tab_type"] == "spatial") {
+		for _, v := range field_map_description["row"].([]interface{}) {
 			value := v.(map[string]interface{})
+			name := value["name"].(string)
 			prop := value["type"].([]interface{})
 			var properties string
 			for i := 1; i < len(prop); i++ {
 				properties += " " + prop[i].(string)
 			}
-			rf := NewRecordFieldMeta(position[counter], value["name"].(string), prop[0].(string), properties)
-
-			fmap[counter] = "key"
-			key_arr = append(key_arr, rf)
-			counter = counter + 1
-		}
-
-		field_map_description["key"] = key_arr
-
-		var sys_arr []*RecordFieldMeta
-		// 叶子结点加上回滚段和事务id的值
-		if index.IsLeaf() && field_map_description["tab_type"] == "clustered" {
+			row := NewRecordFieldMeta(position[counter], name, prop[0].(string), properties)
+			if column, ok := value["column"].(*TableColumn); ok && field_map_description["tab_type"] == "clustered" {
+				row.Set_Instant(column)
+			}
 
-			DB_TRX_ID := NewRecordFieldMeta(position[counter], "DB_TRX_ID", "TRX_ID", "NOT_NULL")
-			fmap[counter] = "sys"
+			fmap[counter] = "row"
+			row_arr = append(row_arr, row)
 			counter = counter + 1
-			sys_arr = append(sys_arr, DB_TRX_ID)
-			DB_ROLL_PTR := NewRecordFieldMeta(position[counter], "DB_ROLL_PTR", "ROLL_PTR", "NOT_NULL")
-			fmap[counter] = "sys"
-			counter = counter + 1
-			sys_arr = append(sys_arr, DB_ROLL_PTR)
-
-			field_map_description["sys"] = sys_arr
-		}
 
-		var row_arr []*RecordFieldMeta
-		if (field_map_description["tab_type"] == "clustered") || (field_map_description["tab_type"] == "secondary") {
-			for _, v := range field_map_description["row"].([]interface{}) {
-				value := v.(map[string]interface{})
-				name := value["name"].(string)
-				prop := value["type"].([]interface{})
-				var properties string
-				for i := 1; i < len(prop); i++ {
-					properties += " " + prop[i].(string)
-				}
-				row := NewRecordFieldMeta(position[counter], name, prop[0].(string), properties)
-				fmap[counter] = "row"
-				row_arr = append(row_arr, row)
-				counter = counter + 1
-
-			}
-
-			field_map_description["row"] = row_arr
 		}
-		return field_map_description
 
-	default:
-		fmt.Printf("\n")
+		field_map_description["row"] = row_arr
 	}
 
 	return field_map_description
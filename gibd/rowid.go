@@ -0,0 +1,79 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/pretty"
+)
+
+// 数据字典头中的Max_row_id每分配256个row id才写一次, 参考dict_hdr_flush_row_id
+// 重启的时候从对齐以后再加256的地方开始分配, 参考dict_boot
+const DICT_HDR_ROW_ID_WRITE_MARGIN = 256
+
+func Next_Row_Id(max_row_id uint64) uint64 {
+	return (max_row_id+DICT_HDR_ROW_ID_WRITE_MARGIN-1)/DICT_HDR_ROW_ID_WRITE_MARGIN*DICT_HDR_ROW_ID_WRITE_MARGIN + DICT_HDR_ROW_ID_WRITE_MARGIN
+}
+
+type RowIdStats struct {
+	Table            string  `json:"table"`
+	Clustered_index  string  `json:"clustered_index"`
+	Table_max_row_id uint64  `json:"table_max_row_id"`      // 表中最大的DB_ROW_ID, 包括delete mark的记录
+	Max_row_id       *uint64 `json:"max_row_id,omitempty"`  // 数据字典头中的值, 没有系统表空间的时候没有
+	Next_row_id      uint64  `json:"next_row_id,omitempty"` // 重启以后分配的第一个row id
+	Reused           bool    `json:"reused"`                // next_row_id不大于table_max_row_id, 新插入的行会覆盖已有的行
+}
+
+func (system *System) Data_Dictionary_Header() *SysDataDictionaryHeader {
+	header := NewSysDataDictionaryHeader(system.System_Space().Data_Dictionary_Header_Page())
+	header.Data_Dictionary_Header()
+	return header
+}
+
+// 最右边叶子页上的最后一条记录, 表是空的时候返回0
+func (tree *BTreeIndex) Max_Row_Id() (uint64, error) {
+	index := tree.Max_Page_At_Level(0)
+	if !index.IsLeaf() {
+		return 0, fmt.Errorf("cannot find the last leaf page from root page %d", tree.Root.Page.Page_number)
+	}
+	record := index.Max_Record()
+	if _, ok := record.record.(*UserRecord); !ok {
+		return 0, nil
+	}
+	key := record.Key()
+	if len(key) == 0 {
+		return 0, fmt.Errorf("record on page %d has no key", index.Page.Page_number)
+	}
+	row_id, ok := key[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("DB_ROW_ID on page %d is not an integer", index.Page.Page_number)
+	}
+	return uint64(row_id), nil
+}
+
+// td的聚簇索引要是GEN_CLUST_INDEX, innodb_system是nil的时候只输出表中的最大值
+func (tree *BTreeIndex) Row_Id_Stats(td *TableDescriber, innodb_system *System) (*RowIdStats, error) {
+	if !td.Has_Row_Id() {
+		return nil, fmt.Errorf("table %s uses %s as clustered index, there is no DB_ROW_ID", td.Name, td.Clustered_index)
+	}
+	max, err := tree.Max_Row_Id()
+	if err != nil {
+		return nil, err
+	}
+	stats := &RowIdStats{Table: td.Name, Clustered_index: td.Clustered_index, Table_max_row_id: max}
+	if innodb_system != nil && innodb_system.System_Space() != nil {
+		header := innodb_system.Data_Dictionary_Header()
+		stats.Max_row_id = &header.Max_row_id
+		stats.Next_row_id = Next_Row_Id(header.Max_row_id)
+		stats.Reused = stats.Next_row_id <= max
+	}
+	return stats, nil
+}
+
+func (stats *RowIdStats) Dump() {
+	println("row id:")
+
+	data, _ := json.Marshal(stats)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -0,0 +1,72 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/pretty"
+)
+
+// 回滚段头页 trx0rseg.h, 页类型是FIL_PAGE_TYPE_SYS
+const TRX_RSEG_N_SLOTS = DEFAULT_PAGE_SIZE / 16
+const TRX_RSEG_SLOT_SIZE = 4
+
+type RsegUndoSlot struct {
+	Slot        uint64 `json:"slot"`
+	Page_number uint64 `json:"page_number"`
+}
+
+type Rseg struct {
+	Page         *Page          `json:"-"`
+	Slot         uint64         `json:"slot"` // 在TRX_SYS中的slot,也就是rseg id
+	Space_id     uint64         `json:"space_id"`
+	Page_number  uint64         `json:"page_number"`
+	Max_size     uint64         `json:"max_size"`
+	History_size uint64         `json:"history_size"`
+	History      *BaseNode      `json:"history"` // 已提交事务的update undo log链表,长度就是history list length
+	Fseg         *FsegEntry     `json:"fseg"`
+	Undo_slots   []RsegUndoSlot `json:"undo_slots"`
+}
+
+func NewRseg(page *Page) *Rseg {
+	return &Rseg{Page: page, Space_id: page.FileHeader.Space_id, Page_number: page.Page_number}
+}
+
+func (rseg *Rseg) Pos_Rseg_Header() uint64 {
+	return Pos_Page_Body()
+}
+
+func (rseg *Rseg) Pos_Undo_Slots() uint64 {
+	return rseg.Pos_Rseg_Header() + 4 + 4 + 16 + FsegEntry_SIZE
+}
+
+func (rseg *Rseg) History_List_Length() uint64 {
+	return rseg.History.ListLen
+}
+
+func (rseg *Rseg) Rseg_Header() {
+	pos := int64(rseg.Pos_Rseg_Header())
+	rseg.Max_size = uint64(BufferReadAt(rseg.Page, pos, 4))
+	rseg.History_size = uint64(BufferReadAt(rseg.Page, pos+4, 4))
+	rseg.History = Read_Base_Node(rseg.Page, pos+8)
+	rseg.Fseg = Read_Fseg_Entry(rseg.Page, pos+24)
+
+	rseg.Undo_slots = nil
+	pos = int64(rseg.Pos_Undo_Slots())
+	for i := uint64(0); i < TRX_RSEG_N_SLOTS; i++ {
+		page_number := uint64(BufferReadAt(rseg.Page, pos, TRX_RSEG_SLOT_SIZE))
+		pos = pos + TRX_RSEG_SLOT_SIZE
+		if page_number == FIL_NULL {
+			continue
+		}
+		rseg.Undo_slots = append(rseg.Undo_slots, RsegUndoSlot{Slot: i, Page_number: page_number})
+	}
+}
+
+func (rseg *Rseg) Dump() {
+	println("rseg header:")
+
+	data, _ := json.Marshal(rseg)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
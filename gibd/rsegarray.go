@@ -0,0 +1,99 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/pretty"
+)
+
+// 8.0的undo表空间第3页是RSEG_ARRAY页, 记录这个表空间中所有回滚段头页的页号 trx0rseg.h
+// 5.6/5.7的undo表空间没有这个页, 回滚段头页由系统表空间TRX_SYS页中的slot指向
+const FSP_RSEG_ARRAY_PAGE_NO = 3
+const RSEG_ARRAY_VERSION = 0x52534547 + 1 // "RSEG" + 1
+const RSEG_ARRAY_RESERVED_BYTES = 200
+const RSEG_ARRAY_SLOT_SIZE = 4
+
+// 8.0 undo表空间的space id从0xFFFFFFEF往下分配, 回滚指针中存的是编号(1-127)不是space id
+const UNDO_SPACE_MAX_ID = 0xFFFFFFEF
+const FSP_MAX_UNDO_TABLESPACES = TRX_SYS_N_RSEGS - 1
+const UNDO_SPACE_ID_RANGE = 512
+const UNDO_SPACE_MIN_ID = UNDO_SPACE_MAX_ID - FSP_MAX_UNDO_TABLESPACES*UNDO_SPACE_ID_RANGE
+
+type RsegArray struct {
+	Page        *Page      `json:"-"`
+	Space_id    uint64     `json:"space_id"`
+	Page_number uint64     `json:"page_number"`
+	Version     uint64     `json:"version"`
+	Size        uint64     `json:"size"`
+	Fseg        *FsegEntry `json:"fseg"`
+	Rsegs       []RsegSlot `json:"rsegs"`
+}
+
+func NewRsegArray(page *Page) *RsegArray {
+	return &RsegArray{Page: page, Space_id: page.FileHeader.Space_id, Page_number: page.Page_number}
+}
+
+func (rseg_array *RsegArray) Pos_Rseg_Array_Header() uint64 {
+	return Pos_Page_Body()
+}
+
+func (rseg_array *RsegArray) Pos_Rseg_Pages() uint64 {
+	return rseg_array.Pos_Rseg_Array_Header() + 8 + FsegEntry_SIZE + RSEG_ARRAY_RESERVED_BYTES
+}
+
+func (rseg_array *RsegArray) Is_Valid() bool {
+	return rseg_array.Version == RSEG_ARRAY_VERSION
+}
+
+func (rseg_array *RsegArray) Rseg_Array_Header() {
+	pos := int64(rseg_array.Pos_Rseg_Array_Header())
+	rseg_array.Version = uint64(BufferReadAt(rseg_array.Page, pos, 4))
+	rseg_array.Size = uint64(BufferReadAt(rseg_array.Page, pos+4, 4))
+	rseg_array.Fseg = Read_Fseg_Entry(rseg_array.Page, pos+8)
+
+	rseg_array.Rsegs = nil
+	if !rseg_array.Is_Valid() {
+		return
+	}
+	pos = int64(rseg_array.Pos_Rseg_Pages())
+	for i := uint64(0); i < rseg_array.Size && pos+RSEG_ARRAY_SLOT_SIZE <= DEFAULT_PAGE_SIZE-8; i++ {
+		page_number := uint64(BufferReadAt(rseg_array.Page, pos, RSEG_ARRAY_SLOT_SIZE))
+		pos = pos + RSEG_ARRAY_SLOT_SIZE
+		if page_number == FIL_NULL {
+			continue
+		}
+		rseg_array.Rsegs = append(rseg_array.Rsegs, RsegSlot{Slot: i, Space_id: rseg_array.Space_id, Page_number: page_number})
+	}
+}
+
+func (rseg_array *RsegArray) Each_Rseg() []*Rseg {
+	var rsegs []*Rseg
+	for _, slot := range rseg_array.Rsegs {
+		rseg := NewRseg(rseg_array.Page.Space.Page(slot.Page_number))
+		rseg.Rseg_Header()
+		rseg.Slot = slot.Slot
+		rsegs = append(rsegs, rseg)
+	}
+	return rsegs
+}
+
+func (rseg_array *RsegArray) Dump() {
+	println("rseg array:")
+
+	data, _ := json.Marshal(rseg_array)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
+
+// 8.0 undo表空间的编号, 也就是回滚指针中rseg id的位置存的值; 不是8.0的undo表空间返回0
+func Undo_Space_Number(space_id uint64) uint64 {
+	if space_id < UNDO_SPACE_MIN_ID || space_id > UNDO_SPACE_MAX_ID {
+		return 0
+	}
+	num := (UNDO_SPACE_MAX_ID + 1 - space_id) % FSP_MAX_UNDO_TABLESPACES
+	if num == 0 {
+		num = FSP_MAX_UNDO_TABLESPACES
+	}
+	return num
+}
@@ -0,0 +1,231 @@
+package gibd
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// 空间索引(SPATIAL KEY)是R-tree, 页类型是FIL_PAGE_RTREE, 页的格式和B+树一样, 参考rtr0rtree.cc
+// 记录的第一个字段是MBR(最小外接矩形): xmin xmax ymin ymax 4个double, 32个字节
+// 叶子页: MBR + 主键字段; 非叶子页: MBR + child page number, 没有主键字段
+// 页上的记录不按MBR排序, 父节点中的MBR包含子页上所有记录的MBR
+// FIL_PAGE_FILE_FLUSH_LSN的位置是split sequence number, 页分裂的时候加1
+const DATA_MBR_LEN = 32
+
+// 空间查询的模式, 和InnoDB的PAGE_CUR_CONTAIN, PAGE_CUR_WITHIN, PAGE_CUR_INTERSECT一样
+const MBR_CONTAIN = "contain"     // 记录的MBR包含查询的MBR
+const MBR_WITHIN = "within"       // 记录的MBR在查询的MBR里面
+const MBR_INTERSECT = "intersect" // 记录的MBR和查询的MBR相交
+
+type MBR struct {
+	Xmin float64 `json:"xmin"`
+	Xmax float64 `json:"xmax"`
+	Ymin float64 `json:"ymin"`
+	Ymax float64 `json:"ymax"`
+}
+
+func NewMBR(data []byte) *MBR {
+	if len(data) < DATA_MBR_LEN {
+		return nil
+	}
+	d := func(i int) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:])) }
+	return &MBR{Xmin: d(0), Xmax: d(1), Ymin: d(2), Ymax: d(3)}
+}
+
+// xmin,xmax,ymin,ymax, 只有两个值的时候是一个点x,y
+func Parse_MBR(value string) (*MBR, error) {
+	var v []float64
+	for _, s := range strings.Split(value, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad MBR %q: %v", value, err)
+		}
+		v = append(v, f)
+	}
+	switch len(v) {
+	case 2:
+		return &MBR{Xmin: v[0], Xmax: v[0], Ymin: v[1], Ymax: v[1]}, nil
+	case 4:
+		if v[0] > v[1] || v[2] > v[3] {
+			return nil, fmt.Errorf("bad MBR %q: min is greater than max", value)
+		}
+		return &MBR{Xmin: v[0], Xmax: v[1], Ymin: v[2], Ymax: v[3]}, nil
+	}
+	return nil, fmt.Errorf("bad MBR %q: need x,y or xmin,xmax,ymin,ymax", value)
+}
+
+func (m *MBR) Contains(o *MBR) bool {
+	return m.Xmin <= o.Xmin && m.Xmax >= o.Xmax && m.Ymin <= o.Ymin && m.Ymax >= o.Ymax
+}
+
+func (m *MBR) Within(o *MBR) bool {
+	return o.Contains(m)
+}
+
+func (m *MBR) Intersects(o *MBR) bool {
+	return m.Xmin <= o.Xmax && m.Xmax >= o.Xmin && m.Ymin <= o.Ymax && m.Ymax >= o.Ymin
+}
+
+func (m *MBR) Match(query *MBR, mode string) bool {
+	switch mode {
+	case MBR_CONTAIN:
+		return m.Contains(query)
+	case MBR_WITHIN:
+		return m.Within(query)
+	case MBR_INTERSECT:
+		return m.Intersects(query)
+	}
+	return false
+}
+
+// 空间索引的描述符, 叶子页上是MBR, 然后是主键字段
+func (td *TableDescriber) Spatial_Describer(index *TableIndex) (*TableDescriber, error) {
+	if len(index.Columns) != 1 {
+		return nil, fmt.Errorf("spatial index %s has %d columns, expected 1", index.Name, len(index.Columns))
+	}
+	if td.Column(index.Columns[0]) == nil {
+		return nil, errors.New("index column " + index.Columns[0] + " not found")
+	}
+	mbr := &TableColumn{Name: td.Column(index.Columns[0]).Name, Type: "MBR", Charset: "binary"}
+	spatial := &TableDescriber{TAB_TYPE: "spatial", Name: td.Name + "." + index.Name, Charset: td.Charset,
+		Table_id: td.Table_id, Primary: []string{mbr.Name}, Columns: []*TableColumn{mbr}}
+	for _, name := range td.Primary {
+		if !spatial.Is_Key_Column(name) {
+			spatial.Columns = append(spatial.Columns, td.Column(name))
+		}
+	}
+	return spatial, nil
+}
+
+func (index *IndexPage) Is_Rtree_Page() bool {
+	return index.Page.FileHeader.Page_type == FIL_PAGE_RTREE
+}
+
+func (index *IndexPage) Split_Seq_Num() uint64 {
+	return index.Page.FileHeader.Flush_lsn
+}
+
+// 空间索引记录的MBR, 不是空间索引的时候返回nil
+func (record *Record) MBR() *MBR {
+	key := record.Key()
+	if len(key) == 0 {
+		return nil
+	}
+	mbr, _ := key[0].(*MBR)
+	return mbr
+}
+
+// R-tree页上的一条记录, 没有空间索引描述符的时候也能解析MBR和child page number
+type RTreeEntry struct {
+	Page_number       uint64                 `json:"page_number"`
+	Offset            uint64                 `json:"offset"`
+	Mbr               *MBR                   `json:"mbr"`
+	Deleted           bool                   `json:"deleted,omitempty"`
+	Child_page_number uint64                 `json:"child_page_number,omitempty"` // 非叶子页
+	Fields            map[string]interface{} `json:"fields,omitempty"`            // 叶子页上的主键字段, 要有空间索引描述符
+}
+
+// 按记录链表的顺序
+func (index *IndexPage) Rtree_Entries() ([]*RTreeEntry, error) {
+	chain, err := index.Record_Chain()
+	if len(chain) < 2 {
+		return nil, err
+	}
+	td, _ := index.Get_Record_Describer().(*TableDescriber)
+	var entries []*RTreeEntry
+	for _, offset := range chain[1:] {
+		if offset == index.Pos_Supremum() {
+			break
+		}
+		entry := &RTreeEntry{Page_number: index.Page.Page_number, Offset: offset,
+			Mbr: NewMBR(ReadBytes(index.Page, int64(offset), DATA_MBR_LEN))}
+		info := int64(offset) - 5
+		if index.PageHeader.Format == "redundant" {
+			info = int64(offset) - 6
+		}
+		entry.Deleted = uint64(BufferReadAt(index.Page, info, 1))>>4&RECORD_INFO_DELETED_FLAG != 0
+		if !index.IsLeaf() {
+			entry.Child_page_number = uint64(BufferReadAt(index.Page, int64(offset)+DATA_MBR_LEN, 4))
+		} else if td != nil && td.TAB_TYPE == "spatial" {
+			entry.Fields = index.record(offset).Get_Fields_And_Value_Map()
+			delete(entry.Fields, td.Primary[0])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, err
+}
+
+func (index *IndexPage) Rtree_Dump() {
+	println("rtree:")
+
+	entries, err := index.Rtree_Entries()
+	data, _ := json.Marshal(map[string]interface{}{"split_seq_num": index.Split_Seq_Num(), "entries": entries})
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+	if err != nil {
+		println(err.Error())
+	}
+}
+
+// 从root页往下找, 和rtr_pcur_getnext_from_path一样深度优先, 结果按页和页内记录的顺序
+// contain模式只进入MBR包含查询MBR的子页, 其他模式进入相交的子页; 不返回delete mark的记录
+func (tree *BTreeIndex) Search_MBR(query *MBR, mode string) ([]*RTreeEntry, error) {
+	switch mode {
+	case MBR_CONTAIN, MBR_WITHIN, MBR_INTERSECT:
+	default:
+		return nil, fmt.Errorf("unknown MBR search mode %s", mode)
+	}
+	if !tree.Root.Is_Rtree_Page() {
+		return nil, fmt.Errorf("root page %d is not an R-tree page", tree.Root.Page.Page_number)
+	}
+
+	type node struct {
+		page_number uint64
+		level       uint64
+	}
+	var result []*RTreeEntry
+	visited := make(map[uint64]bool)
+	stack := []node{{tree.Root.Page.Page_number, tree.Root.PageHeader.Level}}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[n.page_number] || n.page_number >= tree.Space.Pages {
+			return result, fmt.Errorf("node pointer points to page %d which is visited or out of the space", n.page_number)
+		}
+		visited[n.page_number] = true
+		index := tree.Page(n.page_number)
+		if !index.Is_Rtree_Page() || index.PageHeader.Level != n.level {
+			return result, fmt.Errorf("page %d is not an R-tree page at level %d", n.page_number, n.level)
+		}
+		entries, err := index.Rtree_Entries()
+		if err != nil {
+			return result, err
+		}
+		if index.IsLeaf() {
+			for _, e := range entries {
+				if e.Mbr != nil && !e.Deleted && e.Mbr.Match(query, mode) {
+					result = append(result, e)
+				}
+			}
+			continue
+		}
+		// 倒着入栈, 先读前面的子页
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if e.Mbr == nil {
+				continue
+			}
+			if (mode == MBR_CONTAIN && e.Mbr.Contains(query)) || (mode != MBR_CONTAIN && e.Mbr.Intersects(query)) {
+				stack = append(stack, node{e.Child_page_number, n.level - 1})
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,439 @@
+package gibd
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MySQL 8.0的ibd文件中有SDI(serialized dictionary information), 是表空间中的一棵B+树, 参考ibd2sdi
+// 记录: type(4) id(8) DB_TRX_ID(6) DB_ROLL_PTR(7) uncompressed_len(4) compressed_len(4) data(zlib压缩的json)
+// data比较大的时候放在FIL_PAGE_SDI_BLOB页中, 记录中是20个字节的外部引用
+const FIL_PAGE_SDI = 17853
+const FIL_PAGE_SDI_BLOB = 18
+
+// page 0上SDI版本和root页的位置: FSP header + 256个XDES entry + 加密信息
+const SDI_OFFSET = 150 + 40*256 + 115
+const SDI_TYPE_TABLE = 1
+const SDI_TYPE_TABLESPACE = 2
+
+type SDIRecord struct {
+	Type   uint64          `json:"type"`
+	Id     uint64          `json:"id"`
+	Object json.RawMessage `json:"object"`
+}
+
+// 找不到SDI的时候返回0, 5.7的ibd文件没有SDI
+func (s *Space) SDI_Root_Page_Number() uint64 {
+	page := s.Page(0)
+	version := uint64(BufferReadAt(page, SDI_OFFSET, 4))
+	root := uint64(BufferReadAt(page, SDI_OFFSET+4, 4))
+	if version == 1 && root > 0 && root < s.Pages && s.Page(root).FileHeader.Page_type == FIL_PAGE_SDI {
+		return root
+	}
+	return 0
+}
+
+// 一条SDI记录, 所有字段都是NOT NULL, 只有data是变长的
+func (s *Space) sdi_record(index *IndexPage, offset uint64) (*SDIRecord, error) {
+	page := index.Page
+	length := uint64(BufferReadAt(page, int64(offset)-6, 1))
+	extern := false
+	if length&0x80 != 0 {
+		length = (length<<8 | uint64(BufferReadAt(page, int64(offset)-7, 1))) & 0x7fff
+		extern = length&0x4000 != 0
+		length &= 0x3fff
+	}
+	record := &SDIRecord{Type: uint64(BufferReadAt(page, int64(offset), 4)), Id: uint64(BufferReadAt(page, int64(offset)+4, 8))}
+	uncompressed_len := uint64(BufferReadAt(page, int64(offset)+25, 4))
+	compressed_len := uint64(BufferReadAt(page, int64(offset)+29, 4))
+	pos := int64(offset) + 33
+
+	var compressed []byte
+	if extern {
+		if length < EXTERN_FIELD_SIZE {
+			return nil, fmt.Errorf("bad SDI extern reference at offset %d on page %d", offset, page.Page_number)
+		}
+		compressed = ReadBytes(page, pos, int64(length-EXTERN_FIELD_SIZE))
+		ref := pos + int64(length-EXTERN_FIELD_SIZE)
+		blob, err := s.Read_Blob(uint64(BufferReadAt(page, ref+4, 4)), uint64(BufferReadAt(page, ref+16, 4)))
+		if err != nil {
+			return nil, err
+		}
+		compressed = append(compressed, blob...)
+	} else {
+		compressed = ReadBytes(page, pos, int64(length))
+	}
+	if uint64(len(compressed)) < compressed_len {
+		return nil, fmt.Errorf("SDI %d:%d has %d bytes, expected %d", record.Type, record.Id, len(compressed), compressed_len)
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(compressed[:compressed_len]))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != uncompressed_len {
+		return nil, fmt.Errorf("SDI %d:%d is %d bytes after uncompress, expected %d", record.Type, record.Id, len(data), uncompressed_len)
+	}
+	record.Object = data
+	return record, nil
+}
+
+// SDI树上的所有记录, 从root页一直往左走到叶子页, 再沿着next链表读
+func (s *Space) Each_SDI() ([]*SDIRecord, error) {
+	root := s.SDI_Root_Page_Number()
+	if root == 0 {
+		return nil, errors.New("no SDI in this space, it is not a MySQL 8.0 tablespace")
+	}
+	index := NewIndex(s.Page(root))
+	for !index.IsLeaf() {
+		first := index.Next_Offset(index.Pos_Infimum())
+		// node pointer: type(4) id(8) child(4)
+		child := NewIndex(s.Page(uint64(BufferReadAt(index.Page, int64(first)+12, 4))))
+		if child.Page.FileHeader.Page_type != FIL_PAGE_SDI || child.PageHeader.Level+1 != index.PageHeader.Level {
+			return nil, fmt.Errorf("page %d is not an SDI page at level %d", child.Page.Page_number, index.PageHeader.Level-1)
+		}
+		index = child
+	}
+
+	var records []*SDIRecord
+	for pages := uint64(0); ; pages++ {
+		if pages > s.Pages {
+			return records, errors.New("SDI leaf page list has a loop")
+		}
+		offset := index.Pos_Infimum()
+		for n := uint64(0); n <= index.PageHeader.N_heap; n++ {
+			offset = index.Next_Offset(offset)
+			if offset == index.Pos_Supremum() {
+				break
+			}
+			if offset < index.Pos_User_Records() || offset >= index.Pos_Directory() {
+				return records, fmt.Errorf("SDI record list on page %d is broken", index.Page.Page_number)
+			}
+			if index.Is_Metadata_Record(offset) || uint64(BufferReadAt(index.Page, int64(offset)-5, 1))&0x20 != 0 {
+				continue
+			}
+			record, err := s.sdi_record(index, offset)
+			if err != nil {
+				return records, err
+			}
+			records = append(records, record)
+		}
+		next := index.Page.FileHeader.Next
+		if next == FIL_NULL {
+			break
+		}
+		index = NewIndex(s.Page(next))
+		if index.Page.FileHeader.Page_type != FIL_PAGE_SDI {
+			return records, fmt.Errorf("page %d is not an SDI page", next)
+		}
+	}
+	return records, nil
+}
+
+// SDI中的表定义, 只用到了解析记录需要的部分
+type sdiColumn struct {
+	Name                    string `json:"name"`
+	Is_nullable             bool   `json:"is_nullable"`
+	Is_unsigned             bool   `json:"is_unsigned"`
+	Hidden                  int    `json:"hidden"`     // 1是可见的字段, 2是InnoDB加的字段(DB_TRX_ID这些, 还有instant DROP的字段), 3是函数索引的字段, 4是INVISIBLE的字段
+	Is_virtual              bool   `json:"is_virtual"` // 虚拟生成列, 聚簇索引中不存
+	Ordinal_position        int    `json:"ordinal_position"`
+	Char_length             int    `json:"char_length"`
+	Column_type_utf8        string `json:"column_type_utf8"`
+	Default_value_utf8      string `json:"default_value_utf8"`
+	Default_value_utf8_null bool   `json:"default_value_utf8_null"`
+	Collation_id            int    `json:"collation_id"`
+	Se_private_data         string `json:"se_private_data"`
+}
+
+type sdiIndexElement struct {
+	Length     int  `json:"length"`
+	Hidden     bool `json:"hidden"`
+	Column_opx int  `json:"column_opx"`
+}
+
+type sdiIndex struct {
+	Name            string            `json:"name"`
+	Hidden          bool              `json:"hidden"`
+	Type            int               `json:"type"`            // 1 PRIMARY, 2 UNIQUE, 3 MULTIPLE, 4 FULLTEXT, 5 SPATIAL
+	Se_private_data string            `json:"se_private_data"` // id=索引id;root=root页;space_id=...
+	Elements        []sdiIndexElement `json:"elements"`
+}
+
+type sdiTable struct {
+	Dd_object_type string `json:"dd_object_type"`
+	Dd_object      struct {
+		Name            string      `json:"name"`
+		Collation_id    int         `json:"collation_id"`
+		Se_private_data string      `json:"se_private_data"`
+		Columns         []sdiColumn `json:"columns"`
+		Indexes         []sdiIndex  `json:"indexes"`
+	} `json:"dd_object"`
+}
+
+// 常用的collation id对应的字符集
+func Collation_Charset(id int) string {
+	switch {
+	case id == 63:
+		return "binary"
+	case id == 8 || id == 47 || (id >= 48 && id <= 49):
+		return "latin1"
+	case id == 33 || id == 83 || (id >= 192 && id <= 223) || id == 76:
+		return "utf8"
+	case id == 28 || id == 87:
+		return "gbk"
+	case id == 11 || id == 65:
+		return "ascii"
+	}
+	return "utf8mb4"
+}
+
+// 常用的collation id对应的名字, 不认识的id返回"字符集_id", 当成按权重比较的collation
+func Collation_Name(id int) string {
+	switch id {
+	case 8:
+		return "latin1_swedish_ci"
+	case 11:
+		return "ascii_general_ci"
+	case 28:
+		return "gbk_chinese_ci"
+	case 33:
+		return "utf8_general_ci"
+	case 45:
+		return "utf8mb4_general_ci"
+	case 46:
+		return "utf8mb4_bin"
+	case 47:
+		return "latin1_bin"
+	case 48:
+		return "latin1_general_ci"
+	case 49:
+		return "latin1_general_cs"
+	case 63:
+		return "binary"
+	case 65:
+		return "ascii_bin"
+	case 76:
+		return "utf8_tolower_ci"
+	case 83:
+		return "utf8_bin"
+	case 87:
+		return "gbk_bin"
+	case 192:
+		return "utf8_unicode_ci"
+	case 224:
+		return "utf8mb4_unicode_ci"
+	case 255:
+		return "utf8mb4_0900_ai_ci"
+	case 278:
+		return "utf8mb4_0900_as_cs"
+	case 305:
+		return "utf8mb4_0900_as_ci"
+	case 309:
+		return "utf8mb4_0900_bin"
+	}
+	return Collation_Charset(id) + "_" + strconv.Itoa(id)
+}
+
+// se_private_data是 key=value; 的格式
+func Parse_Se_Private_Data(data string) map[string]string {
+	values := make(map[string]string)
+	for _, item := range strings.Split(data, ";") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) == 2 {
+			values[kv[0]] = kv[1]
+		}
+	}
+	return values
+}
+
+// 从SDI的json生成表描述符, 包括instant ADD/DROP的字段和默认值
+func NewTableDescriberFromSDI(object []byte) (*TableDescriber, error) {
+	var table sdiTable
+	if err := json.Unmarshal(object, &table); err != nil {
+		return nil, err
+	}
+	if table.Dd_object_type != "Table" {
+		return nil, fmt.Errorf("SDI object is a %s, not a table", table.Dd_object_type)
+	}
+	obj := table.Dd_object
+	td := &TableDescriber{TAB_TYPE: "clustered", Name: obj.Name, Charset: Collation_Charset(obj.Collation_id)}
+
+	instant_col := -1
+	if n, err := strconv.Atoi(Parse_Se_Private_Data(obj.Se_private_data)["instant_col"]); err == nil {
+		instant_col = n
+	}
+	physical_pos := make(map[*TableColumn]int)
+	names := make([]string, len(obj.Columns))
+	visible := 0
+	for i, c := range obj.Columns {
+		names[i] = c.Name
+		private := Parse_Se_Private_Data(c.Se_private_data)
+		_, dropped := private["version_dropped"]
+		// DB_TRX_ID, DB_ROLL_PTR, DB_ROW_ID由describer自己加, instant DROP的字段还要留着
+		// INVISIBLE的字段记录中也有, 虚拟生成列(包括函数索引的隐藏字段)记录中没有
+		user := c.Hidden == 1 || c.Hidden == 4
+		if c.Is_virtual || (!user && !dropped) {
+			continue
+		}
+		def := "`" + c.Name + "` " + c.Column_type_utf8
+		if !c.Is_nullable {
+			def += " NOT NULL"
+		}
+		column := Parse_Column_Definition(def)
+		column.Unsigned = column.Unsigned || c.Is_unsigned
+		column.Charset = Collation_Charset(c.Collation_id)
+		if column.Is_String() {
+			column.Collation = Collation_Name(c.Collation_id)
+		}
+		if !c.Default_value_utf8_null {
+			value := c.Default_value_utf8
+			column.Default = &value
+		}
+		column.Version_added, _ = strconv.ParseUint(private["version_added"], 10, 64)
+		column.Version_dropped, _ = strconv.ParseUint(private["version_dropped"], 10, 64)
+		if value, ok := private["default"]; ok {
+			column.Instant_default, _ = hex.DecodeString(value)
+		} else if private["default_null"] == "1" {
+			column.Default = nil
+		}
+		if user {
+			column.Instant = instant_col >= 0 && visible >= instant_col && column.Version_added == 0
+			visible++
+		}
+		if pos, err := strconv.Atoi(private["physical_pos"]); err == nil {
+			physical_pos[column] = pos
+		}
+		td.Columns = append(td.Columns, column)
+	}
+	// 8.0.29以后instant ADD的字段可以加在中间, 记录中按physical_pos排列
+	if len(physical_pos) == len(td.Columns) {
+		sort.SliceStable(td.Columns, func(i, j int) bool { return physical_pos[td.Columns[i]] < physical_pos[td.Columns[j]] })
+	}
+
+	for _, index := range obj.Indexes {
+		if index.Hidden {
+			continue
+		}
+		var columns []string
+		var prefixes []int
+		for _, e := range index.Elements {
+			if e.Hidden || e.Column_opx < 0 || e.Column_opx >= len(obj.Columns) {
+				continue
+			}
+			c := obj.Columns[e.Column_opx]
+			columns = append(columns, c.Name)
+			prefix := 0
+			if _, width := Parse_Type_Definition(c.Column_type_utf8); e.Length > 0 && e.Length < c.Char_length {
+				if n, err := strconv.Atoi(width); err == nil && n > 0 {
+					prefix = e.Length / (c.Char_length / n)
+				}
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		switch index.Type {
+		case 1:
+			td.Primary = columns
+		case 2, 3, 5:
+			td.Indexes = append(td.Indexes, &TableIndex{Name: index.Name, Unique: index.Type == 2, Columns: columns, Prefixes: prefixes,
+				Spatial: index.Type == 5})
+		case 4:
+			td.Fulltext = append(td.Fulltext, &TableIndex{Name: index.Name, Columns: columns, Prefixes: prefixes})
+		}
+	}
+
+	if len(td.Primary) == 0 {
+		td.Choose_Clustered_Index()
+	} else {
+		td.Clustered_index = "PRIMARY"
+	}
+	// FTS_DOC_ID是SE隐藏的字段, 和DB_ROW_ID一样由describer加
+	td.Add_Fts_Doc_Id()
+	for _, name := range td.Primary {
+		if td.Column(name) == nil {
+			return nil, errors.New("primary key column " + name + " not found")
+		}
+		td.Column(name).Nullable = false
+	}
+	return td, nil
+}
+
+// 表空间SDI中的表定义, 一个ibd文件中只有一个表(分区表每个分区一个文件)
+func (s *Space) Table_Describer_From_SDI() (*TableDescriber, error) {
+	records, err := s.Each_SDI()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Type == SDI_TYPE_TABLE {
+			return NewTableDescriberFromSDI(record.Object)
+		}
+	}
+	return nil, errors.New("no table in SDI")
+}
+
+// SDI中每个索引的root页, 索引名是SDI中的名字
+func (s *Space) SDI_Index_Root_Page_Numbers() (map[string]uint64, error) {
+	records, err := s.Each_SDI()
+	if err != nil {
+		return nil, err
+	}
+	roots := make(map[string]uint64)
+	for _, record := range records {
+		if record.Type != SDI_TYPE_TABLE {
+			continue
+		}
+		var table sdiTable
+		if err := json.Unmarshal(record.Object, &table); err != nil {
+			return nil, err
+		}
+		for _, index := range table.Dd_object.Indexes {
+			if root, err := strconv.ParseUint(Parse_Se_Private_Data(index.Se_private_data)["root"], 10, 64); err == nil {
+				roots[index.Name] = root
+			}
+		}
+	}
+	return roots, nil
+}
+
+// SDI中表空间记录的名字和space id, 没有表空间记录的时候用表名和索引上的space_id, 都没有的时候space id是0
+func (s *Space) SDI_Tablespace() (string, uint64, error) {
+	records, err := s.Each_SDI()
+	if err != nil {
+		return "", 0, err
+	}
+	name := ""
+	space_id := uint64(0)
+	for _, record := range records {
+		var object sdiTable
+		if err := json.Unmarshal(record.Object, &object); err != nil {
+			return "", 0, err
+		}
+		switch record.Type {
+		case SDI_TYPE_TABLESPACE:
+			id, _ := strconv.ParseUint(Parse_Se_Private_Data(object.Dd_object.Se_private_data)["id"], 10, 64)
+			return object.Dd_object.Name, id, nil
+		case SDI_TYPE_TABLE:
+			if name == "" {
+				name = object.Dd_object.Name
+			}
+			for _, index := range object.Dd_object.Indexes {
+				if id, err := strconv.ParseUint(Parse_Se_Private_Data(index.Se_private_data)["space_id"], 10, 64); err == nil && space_id == 0 {
+					space_id = id
+				}
+			}
+		}
+	}
+	return name, space_id, nil
+}
@@ -0,0 +1,130 @@
+package gibd
+
+import "fmt"
+
+// 按key查找, 参考page_cur_search_with_match和btr_cur_search_to_nth_level(PAGE_CUR_LE)
+// 每一层先在page directory的槽上二分, 再在槽拥有的记录里顺序找
+
+// 记录和key比较, key可以只有前面几个字段, 只比较这几个字段
+// 非叶子页上每层最左边的node pointer(min_rec)比任何key都小
+func (index *IndexPage) compare_record(record *Record, key []interface{}) (int, error) {
+	user_record, ok := record.record.(*UserRecord)
+	if !ok {
+		return -1, nil
+	}
+	// 叶子页上的min_rec是MariaDB的metadata记录, 也比任何key都小
+	if user_record.header.Is_Min_Rec() {
+		return -1, nil
+	}
+	record_key := record.Key()
+	if len(record_key) > len(key) {
+		record_key = record_key[:len(key)]
+	}
+	return Compare_Keys(record_key, key)
+}
+
+// 页上key小于等于key的最后一条记录, 没有的时候返回nil
+func (index *IndexPage) Search(key []interface{}) (*Record, error) {
+	return index.search(key, false)
+}
+
+// strict的时候找key小于key的最后一条记录(PAGE_CUR_L), 否则是小于等于(PAGE_CUR_LE)
+// key和记录比较不了的时候返回error
+func (index *IndexPage) search(key []interface{}, strict bool) (*Record, error) {
+	var err error
+	before := func(record *Record) bool {
+		c, compare_err := index.compare_record(record, key)
+		if compare_err != nil && err == nil {
+			err = compare_err
+		}
+		return c < 0 || (!strict && c == 0)
+	}
+	slots := index.Directory_Slot_Offsets()
+	if len(slots) < 2 {
+		return nil, nil
+	}
+	// 第一个槽是infimum, 最后一个槽是supremum
+	low, high := 0, len(slots)-1
+	for high-low > 1 {
+		mid := (low + high) / 2
+		if before(index.record(slots[mid])) {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	var found *Record
+	offset := slots[low]
+	if low > 0 {
+		found = index.record(offset)
+	}
+	// 一个槽最多拥有8条记录, 多走几步防止链表有环
+	for i := 0; i < 2*PAGE_DIR_SLOT_MAX_N_OWNED; i++ {
+		offset = index.Next_Offset(offset)
+		if offset == 0 || offset == slots[high] {
+			break
+		}
+		record := index.record(offset)
+		if !before(record) {
+			break
+		}
+		found = record
+	}
+	return found, err
+}
+
+// 非二进制collation的字符串字段在InnoDB中按权重排序, 按字节比较在树上会走错路, 找不到大小写或者重音不同的记录
+func (tree *BTreeIndex) check_key_order(key []interface{}) error {
+	td, ok := tree.Record_describer.(*TableDescriber)
+	if !ok {
+		return nil
+	}
+	if column := td.Unordered_Key_Column(len(key)); column != nil {
+		return fmt.Errorf("key column %s uses collation %s, only binary collations can be searched", column.Name, column.Collation)
+	}
+	return nil
+}
+
+// 从root页往下找到叶子页, 返回叶子页和页上的search结果
+func (tree *BTreeIndex) search_leaf(key []interface{}, strict bool) (*IndexPage, *Record, error) {
+	if err := tree.check_key_order(key); err != nil {
+		return nil, nil, err
+	}
+	index := tree.Root
+	for {
+		record, err := index.search(key, strict)
+		if err != nil {
+			return nil, nil, err
+		}
+		if index.IsLeaf() {
+			return index, record, nil
+		}
+		if record == nil {
+			record = index.Min_Record()
+		}
+		user_record, ok := record.record.(*UserRecord)
+		if !ok {
+			return nil, nil, nil
+		}
+		child := tree.Page(user_record.Child_page_number)
+		// level要一层一层减少, 不然树是坏的
+		if child.Page.FileHeader.Page_type != FIL_PAGE_INDEX || child.PageHeader.Level+1 != index.PageHeader.Level {
+			return nil, nil, nil
+		}
+		index = child
+	}
+}
+
+// 从root页往下找, 找到key完全相等的叶子记录, 找不到的时候返回nil
+// key的值要和解析出来的类型一致, 整数是int64(无符号BIGINT是uint64), 字符串是string
+func (tree *BTreeIndex) Find(key ...interface{}) (*Record, error) {
+	_, record, err := tree.search_leaf(key, false)
+	if record == nil || err != nil || record.Is_Metadata() {
+		return nil, err
+	}
+	if c, err := Compare_Keys(record.Key(), key); c != 0 || err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,18 @@
+package gibd
+
+// 二级索引的叶子记录是索引字段加上索引中没有的主键字段, 回表的时候用主键在聚簇索引中查找, 参考row_sel_get_clust_rec
+
+// 二级索引记录中的主键值, 按主键定义的顺序
+func Secondary_Primary_Key(record *Record, td *TableDescriber) []interface{} {
+	fields := record.Get_Fields_And_Value_Map()
+	var key []interface{}
+	for _, name := range td.Primary {
+		key = append(key, fields[name])
+	}
+	return key
+}
+
+// 二级索引记录对应的聚簇索引记录, 找不到的时候返回nil
+func (clustered *BTreeIndex) Clustered_Record(record *Record, td *TableDescriber) (*Record, error) {
+	return clustered.Find(Secondary_Primary_Key(record, td)...)
+}
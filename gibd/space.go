@@ -3,10 +3,12 @@ package gibd
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/tidwall/pretty"
 )
@@ -32,14 +34,21 @@ func NewDataFile(filename string, offset uint64) *DataFile {
 
 //普通表空间
 type Space struct {
-	Datafiles []*DataFile
-	Size      uint64
-	Pages     uint64
-	Name      string
-	Space_id  uint64
-	// Innodb_system    *System
+	Datafiles        []*DataFile
+	Size             uint64
+	Pages            uint64
+	Name             string
+	Space_id         uint64
+	Innodb_system    *System `json:"-"` // 加到System中的时候设置, 用来找undo等其他表空间中的信息
 	Record_describer interface{}
 	IsSystemSpace    bool
+	pages            map[uint64]*PageLocation // 页扫描恢复出来的表空间, 页在磁盘镜像里的任意位置, 没有对应的文件
+}
+
+// 页在别的文件中的位置
+type PageLocation struct {
+	Filename    string
+	File_offset uint64
 }
 
 func NewSpace(filenames []string) *Space {
@@ -75,6 +84,23 @@ func NewSpace(filenames []string) *Space {
 	return s
 }
 
+// 页分散在别的文件中的表空间, 用到的时候才读, 没有的页读出来是全0的
+func NewMappedSpace(name string, space_id uint64, pages map[uint64]*PageLocation) *Space {
+	s := &Space{
+		Name:     name,
+		Space_id: space_id,
+		pages:    pages,
+	}
+	for page_number := range pages {
+		if page_number+1 > s.Pages {
+			s.Pages = page_number + 1
+		}
+	}
+	s.Size = s.Pages * DEFAULT_PAGE_SIZE
+	s.IsSystemSpace = Is_System_Space(s)
+	return s
+}
+
 func (s *Space) Get_Space_Id() uint64 {
 
 	return s.Space_id
@@ -111,25 +137,110 @@ func (s *Space) Each_Index_Root_Page_Number(innodb_system *System) []uint64 {
 		return root_page_numer
 	} else {
 		//获取普通index表空间的root page number
+		//root页是非叶子段的第一个碎片页, root页的fseg header指回这个inode entry, 顺序就是建索引的顺序
+		inode := NewInode(s.Page(2))
+		inode.ParseInodeBlock()
+		for i, entry := range inode.Inodes {
+			if entry.Fseg_id == 0 || entry.FragArrayEntry[0].V == FIL_NULL || entry.FragArrayEntry[0].V >= s.Pages {
+				continue
+			}
+			page := s.Page(entry.FragArrayEntry[0].V)
+			index := NewIndex(page)
+			if !index.Is_Index_Page() {
+				continue
+			}
+			index.Fseg_Header()
+			if index.FsegHeader.InodePageNumber == 2 && index.FsegHeader.InodeOffset == Pos_Inode_Entry(i) {
+				root_page_numer = append(root_page_numer, page.Page_number)
+			}
+		}
+		return root_page_numer
 	}
-	return nil
 }
-func (s *Space) data_file_for_offset(offset uint64) *DataFile {
-	var i uint64
-	i = 1
 
-	for _, file := range s.Datafiles {
-		if (i * file.size) < offset {
-			i = i + 1
+// 聚簇索引的root页, 普通表空间中是第一个建的索引
+func (s *Space) Clustered_Index_Root_Page_Number() uint64 {
+	roots := s.Each_Index_Root_Page_Number(nil)
+	if len(roots) == 0 {
+		return 0
+	}
+	return roots[0]
+}
+
+// 数据字典中索引名到root页的对应关系: 8.0是SDI中索引se_private_data的root,
+// 5.7是SYS_INDEXES.PAGE_NO(表空间要通过System打开, 只支持独立表空间); 都没有的时候返回nil
+func (s *Space) Index_Root_Page_Numbers() map[string]uint64 {
+	if s.SDI_Root_Page_Number() != 0 {
+		roots, err := s.SDI_Index_Root_Page_Numbers()
+		if err != nil {
+			Log.Info("read index roots from SDI err %v\n", err)
+			return nil
+		}
+		return roots
+	}
+	if s.Innodb_system == nil || s.IsSystemSpace {
+		return nil
+	}
+	roots := make(map[string]uint64)
+	for _, index := range s.Innodb_system.data_dictionary.Each_Index_By_Space_Id(s.Space_id) {
+		roots[index["NAME"].(string)] = uint64(index["PAGE_NO"].(int64))
+	}
+	return roots
+}
+
+// 二级索引的root页, 先从数据字典里找
+// 没有数据字典的时候按位置猜: 建表语句中第N个索引是inode中第N+1个root, 只有建表以后没有ADD/DROP INDEX,
+// 并且建表语句中索引的顺序就是建索引的顺序(SHOW CREATE TABLE会把UNIQUE放在前面, 没有FTS_DOC_ID_INDEX)的时候才对
+func (s *Space) Secondary_Index_Root_Page_Number(td *TableDescriber, name string) (uint64, error) {
+	if td.Index(name) == nil {
+		return 0, fmt.Errorf("index %s not found in table %s", name, td.Name)
+	}
+	if dictionary := s.Index_Root_Page_Numbers(); len(dictionary) > 0 {
+		for index_name, root := range dictionary {
+			if strings.EqualFold(index_name, name) {
+				return root, nil
+			}
+		}
+		return 0, fmt.Errorf("index %s not found in the data dictionary of space %d", name, s.Space_id)
+	}
+	roots := s.Each_Index_Root_Page_Number(nil)
+	for i, index := range td.Indexes {
+		if !strings.EqualFold(index.Name, name) {
 			continue
 		}
-		return file
+		if i+1 >= len(roots) {
+			return 0, fmt.Errorf("root page of index %s not found, space has %d indexes", name, len(roots))
+		}
+		return roots[i+1], nil
+	}
+	return 0, fmt.Errorf("index %s not found in table %s", name, td.Name)
+}
 
+// 二级索引的树, 记录按建表语句生成的二级索引描述符解析
+func (s *Space) Secondary_Index_Tree(td *TableDescriber, name string) (*BTreeIndex, error) {
+	root, err := s.Secondary_Index_Root_Page_Number(td, name)
+	if err != nil {
+		return nil, err
+	}
+	describer, err := td.Secondary_Describer(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get_Index_Tree(root, describer), nil
+}
+func (s *Space) data_file_for_offset(offset uint64) *DataFile {
+	for _, file := range s.Datafiles {
+		if offset < file.offset+file.size {
+			return file
+		}
 	}
 	return nil
 }
 
 func (s *Space) Read_At_Offset(offset uint64, size uint64) []byte {
+	if s.pages != nil {
+		return s.read_mapped_pages(offset, size)
+	}
 
 	data_file := s.data_file_for_offset(offset)
 
@@ -147,6 +258,55 @@ func (s *Space) Read_At_Offset(offset uint64, size uint64) []byte {
 	return _bytes
 }
 
+func (s *Space) read_mapped_pages(offset uint64, size uint64) []byte {
+	buffer := make([]byte, size)
+	for pos := uint64(0); pos < size; {
+		page_number := (offset + pos) / DEFAULT_PAGE_SIZE
+		in_page := (offset + pos) % DEFAULT_PAGE_SIZE
+		n := DEFAULT_PAGE_SIZE - in_page
+		if n > size-pos {
+			n = size - pos
+		}
+		if location, ok := s.pages[page_number]; ok {
+			if file, err := os.Open(location.Filename); err == nil {
+				file.ReadAt(buffer[pos:pos+n], int64(location.File_offset+in_page))
+				file.Close()
+			}
+		}
+		pos += n
+	}
+	return buffer
+}
+
+// 写到表空间文件中, 超过最后一个文件的时候扩展最后一个文件
+func (s *Space) Write_At_Offset(offset uint64, data []byte) error {
+	if s.pages != nil {
+		return errors.New("space " + s.Name + " is mapped from other files")
+	}
+	data_file := s.data_file_for_offset(offset)
+	if data_file == nil {
+		data_file = s.Datafiles[len(s.Datafiles)-1]
+	}
+	file, err := os.OpenFile(data_file.filename, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.WriteAt(data, int64(offset-data_file.offset)); err != nil {
+		return err
+	}
+	if end := offset - data_file.offset + uint64(len(data)); end > data_file.size {
+		s.Size += end - data_file.size
+		data_file.size = end
+		s.Pages = s.Size / DEFAULT_PAGE_SIZE
+	}
+	return nil
+}
+
+func (s *Space) Write_Page(page_number uint64, data []byte) error {
+	return s.Write_At_Offset(page_number*DEFAULT_PAGE_SIZE, data)
+}
+
 func (s *Space) Page_Data(page_number uint64) []byte {
 	//shuol return new page
 	return s.Read_At_Offset(page_number*DEFAULT_PAGE_SIZE, DEFAULT_PAGE_SIZE)
@@ -167,6 +327,51 @@ func (s *Space) Data_Dictionary_Header_Page() *Page {
 	return nil
 }
 
+func (s *Space) Trx_Sys_Page() *Page {
+	if Is_System_Space(s) {
+		return s.Page(TRX_SYS_PAGE_NO)
+	}
+	return nil
+}
+
+//是否是回滚段头页
+func (s *Space) Is_Rseg_Header_Page(page_number uint64) bool {
+	var slots []RsegSlot
+	if rseg_array := s.Rseg_Array(); rseg_array != nil {
+		slots = rseg_array.Rsegs
+	} else if page := s.Trx_Sys_Page(); page != nil {
+		trx_sys := NewTrxSys(page)
+		trx_sys.Trx_Sys_Header()
+		slots = trx_sys.Rsegs
+	} else if s.Innodb_system != nil && s.Innodb_system.System_Space() != nil {
+		//5.6/5.7的undo表空间, 回滚段由系统表空间的TRX_SYS页指向
+		slots = s.Innodb_system.Trx_Sys().Rsegs
+	}
+	for _, slot := range slots {
+		if slot.Space_id == s.Space_id && slot.Page_number == page_number {
+			return true
+		}
+	}
+	return false
+}
+
+// 8.0 undo表空间的RSEG_ARRAY页, 其他表空间返回nil
+func (s *Space) Rseg_Array() *RsegArray {
+	if s.IsSystemSpace || s.Pages <= FSP_RSEG_ARRAY_PAGE_NO {
+		return nil
+	}
+	page := s.Page(FSP_RSEG_ARRAY_PAGE_NO)
+	if page.FileHeader.Page_type != FIL_PAGE_TYPE_RSEG_ARRAY {
+		return nil
+	}
+	rseg_array := NewRsegArray(page)
+	rseg_array.Rseg_Array_Header()
+	if !rseg_array.Is_Valid() {
+		return nil
+	}
+	return rseg_array
+}
+
 func (Space Space) String() string {
 	res := "space: " + Space.Name + ",pages=" + strconv.FormatUint(Space.Pages, 10)
 	return res
@@ -1,10 +1,18 @@
 package gibd
 
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
 //系统表空间
 type System struct {
 	config          map[string]string
 	spaces          map[uint64]*Space
 	orphans         []Space
+	duplicates      []*Space //和已经注册的表空间space id相同的文件
 	data_dictionary *DataDictionary
 }
 
@@ -14,22 +22,99 @@ func NewSystem(filenames []string) *System {
 	system.config["datadir"] = filenames[0]
 
 	space := NewSpace(filenames)
+	space.Innodb_system = system
 	system.spaces = make(map[uint64]*Space)
 	system.spaces[space.Space_id] = space
 	//	system.Add_Space_File(filenames)
 	system.data_dictionary = NewDataDictionary(system)
 	return system
 }
+
+// 打开datadir下的ibdata*作为系统表空间, 然后扫描所有的ibd文件,按照page 0 的space id注册
+func NewSystemFromDatadir(datadir string) *System {
+	ibdata_files, _ := filepath.Glob(filepath.Join(datadir, "ibdata*"))
+	if len(ibdata_files) == 0 {
+		println("no ibdata file found in", datadir)
+		return nil
+	}
+	sort.Strings(ibdata_files)
+
+	system := NewSystem(ibdata_files)
+	system.config["datadir"] = datadir
+
+	for _, filename := range Each_Ibd_File(datadir) {
+		system.Add_Space_File([]string{filename})
+	}
+	for _, filename := range Each_Undo_File(datadir) {
+		system.Add_Space_File([]string{filename})
+	}
+	return system
+}
+
+// undo表空间文件: 5.6/5.7是undo001, 8.0是undo_001, 8.0用CREATE UNDO TABLESPACE建的是*.ibu
+func Each_Undo_File(datadir string) []string {
+	var filenames []string
+	for _, pattern := range []string{"undo[0-9][0-9][0-9]", "undo_[0-9][0-9][0-9]", "*.ibu"} {
+		matches, _ := filepath.Glob(filepath.Join(datadir, pattern))
+		filenames = append(filenames, matches...)
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+//datadir下的所有ibd文件, 包括分区表的#p#文件和通用表空间
+func Each_Ibd_File(datadir string) []string {
+	var filenames []string
+	filepath.Walk(datadir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(strings.ToLower(info.Name()), ".ibd") {
+			filenames = append(filenames, path)
+		}
+		return nil
+	})
+	sort.Strings(filenames)
+	return filenames
+}
+
 func (system *System) Add_Space(space *Space) {
+	if exist, ok := system.spaces[space.Space_id]; ok && exist.Name != space.Name {
+		system.duplicates = append(system.duplicates, space)
+		return
+	}
+	space.Innodb_system = system
 	system.spaces[space.Space_id] = space
 }
 
-// func (system *System) Add_Space_File(space_id uint64,space_filenames []string) {
-// space := NewSpace(space_filenames)
-// space.Innodb_system = system
-// system.Add_Space(space)
+func (system *System) Add_Space_File(space_filenames []string) *Space {
+	for _, filename := range space_filenames {
+		fi, err := os.Stat(filename)
+		if err != nil || fi.Size() < DEFAULT_PAGE_SIZE {
+			Log.Info("skip space file %s, size too small or not readable\n", filename)
+			return nil
+		}
+	}
+	space := NewSpace(space_filenames)
+	system.Add_Space(space)
+	return space
+}
 
-// }
+func (system *System) Space(space_id uint64) *Space {
+	return system.spaces[space_id]
+}
+
+//按space id排序的所有表空间
+func (system *System) Each_Space() []*Space {
+	var spaces []*Space
+	for _, space := range system.spaces {
+		spaces = append(spaces, space)
+	}
+	sort.Slice(spaces, func(i, j int) bool {
+		return spaces[i].Space_id < spaces[j].Space_id
+	})
+	return spaces
+}
 
 func (system *System) System_Space() *Space {
 	for _, value := range system.spaces {
@@ -41,6 +126,104 @@ func (system *System) System_Space() *Space {
 	return nil
 }
 
+func (system *System) Trx_Sys() *TrxSys {
+	trx_sys := NewTrxSys(system.System_Space().Trx_Sys_Page())
+	trx_sys.Trx_Sys_Header()
+	return trx_sys
+}
+
+// 已经打开的undo表空间, 按space id排序
+func (system *System) Each_Undo_Space() []*Space {
+	var spaces []*Space
+	rseg_space_ids := make(map[uint64]bool)
+	for _, slot := range system.Trx_Sys().Rsegs {
+		rseg_space_ids[slot.Space_id] = true
+	}
+	for _, space := range system.Each_Space() {
+		if space.IsSystemSpace {
+			continue
+		}
+		if rseg_space_ids[space.Space_id] || space.Rseg_Array() != nil {
+			spaces = append(spaces, space)
+		}
+	}
+	return spaces
+}
+
+// 回滚指针中的rseg id找到undo所在的表空间
+// 8.0是undo表空间的编号(0是系统表空间), 5.6/5.7是TRX_SYS中slot的序号
+func (system *System) Undo_Space(rseg_id uint64) *Space {
+	if rseg_id != 0 {
+		for _, space := range system.spaces {
+			if Undo_Space_Number(space.Space_id) == rseg_id && space.Rseg_Array() != nil {
+				return space
+			}
+		}
+	}
+	for _, slot := range system.Trx_Sys().Rsegs {
+		if slot.Slot == rseg_id {
+			return system.spaces[slot.Space_id]
+		}
+	}
+	return nil
+}
+
+// 回滚指针指向的undo记录, 找不到undo页的时候返回nil
+func (system *System) Undo_Record(pointer *Pointer, describer interface{}) *UndoRecord {
+	space := system.Undo_Space(pointer.Rseg_id)
+	if space == nil || pointer.Undo_log.Page >= space.Pages {
+		return nil
+	}
+	page := space.Page(pointer.Undo_log.Page)
+	if page.FileHeader.Page_type != FIL_PAGE_UNDO_LOG || pointer.Undo_log.Offset+3 >= DEFAULT_PAGE_SIZE {
+		return nil
+	}
+	//undo页被重用以后偏移处可能是任意数据
+	record := NewUndoRecord(page, pointer.Undo_log.Offset)
+	if err := record.Parse(describer); err != nil {
+		return nil
+	}
+	return record
+}
+
+// 回滚指针指向的undo记录所在的undo log的header, active表示这个undo log的事务还没有提交
+// 段的第一个页上可以有多个undo log(段被重用), 有多个页的段只有一个undo log, header在第一个页上
+func (system *System) Undo_Log_Header(pointer *Pointer) (header *UndoLogHeader, active bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			header, active = nil, false
+		}
+	}()
+	space := system.Undo_Space(pointer.Rseg_id)
+	if space == nil {
+		return nil, false
+	}
+	page_number := pointer.Undo_log.Page
+	for i := uint64(0); i < space.Pages && page_number < space.Pages; i++ {
+		page := space.Page(page_number)
+		if page.FileHeader.Page_type != FIL_PAGE_UNDO_LOG {
+			return nil, false
+		}
+		undo_page := NewUndoPage(page)
+		if undo_page.Segment_header == nil {
+			page_number = undo_page.Page_header.Node.Prev_page
+			continue
+		}
+		for _, log := range undo_page.Logs {
+			if page_number == pointer.Undo_log.Page && log.Offset > pointer.Undo_log.Offset {
+				break
+			}
+			header = log
+		}
+		if header != nil && header.Offset == undo_page.Segment_header.Last_log {
+			state := undo_page.Segment_header.State
+			active = state == "active" || state == "prepared"
+		}
+		return header, active
+	}
+	return nil, false
+}
+
 func (system *System) Each_Table_Name() []string {
 	var table_names []string
 	tables := system.data_dictionary.Get_Each_Table_Name()
@@ -0,0 +1,560 @@
+package gibd
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// 没有数据字典信息的时候(普通的ibd文件), 通过建表语句构造记录描述符
+// CREATE TABLE `dba_user5` (
+//   `id` int(11) NOT NULL AUTO_INCREMENT,
+//   `username` varchar(100) DEFAULT NULL COMMENT '用户名',
+//   PRIMARY KEY (`id`)
+// ) ENGINE=InnoDB DEFAULT CHARSET=utf8 ROW_FORMAT=COMPACT
+
+type TableColumn struct {
+	Name      string  `json:"name"`
+	Type      string  `json:"type"` // 大写的类型定义, 比如 VARCHAR(100)
+	Unsigned  bool    `json:"unsigned"`
+	Nullable  bool    `json:"nullable"`
+	Default   *string `json:"default"`             // nil表示没有默认值, DEFAULT NULL也是nil
+	Charset   string  `json:"charset"`             // 没有指定的时候用表的默认字符集
+	Collation string  `json:"collation,omitempty"` // 字符串字段的collation, 空的时候按字节比较
+	Hidden    bool    `json:"hidden,omitempty"`    // InnoDB加的DB_ROW_ID, 建表语句里没有
+	// instant ADD/DROP COLUMN, 参考instant.go
+	Instant         bool   `json:"instant,omitempty"`         // 8.0.12~8.0.28和MariaDB instant ADD加的字段, 之前插入的记录里没有
+	Version_added   uint64 `json:"version_added,omitempty"`   // 8.0.29以后加字段时的row version
+	Version_dropped uint64 `json:"version_dropped,omitempty"` // instant DROP时的row version, 旧记录里还有这个字段
+	Instant_default []byte `json:"instant_default,omitempty"` // SDI中的默认值, 是记录中的存储格式, nil的时候用Default
+}
+
+type TableIndex struct {
+	Name     string   `json:"name"`
+	Unique   bool     `json:"unique"`
+	Columns  []string `json:"columns"`
+	Prefixes []int    `json:"prefixes"`          // 前缀索引的长度(字符数), 0表示整个字段
+	Spatial  bool     `json:"spatial,omitempty"` // 空间索引是R-tree, 参考rtree.go
+}
+
+// 没有主键的时候, InnoDB用第一个字段都是NOT NULL的唯一索引做聚簇索引
+// 也没有这样的唯一索引的时候, 聚簇索引是GEN_CLUST_INDEX, key是6个字节的DB_ROW_ID
+// DB_ROW_ID从数据字典头的Max_row_id分配, 所有表共用
+const GEN_CLUST_INDEX = "GEN_CLUST_INDEX"
+const DB_ROW_ID = "DB_ROW_ID"
+
+type TableDescriber struct {
+	TAB_TYPE        string         `json:"tab_type"`
+	Name            string         `json:"name"`
+	Charset         string         `json:"charset"`
+	Table_id        uint64         `json:"table_id"` // 0表示不限制, 解析undo记录的时候用来匹配表
+	Columns         []*TableColumn `json:"columns"`
+	Primary         []string       `json:"primary"`
+	Clustered_index string         `json:"clustered_index"`    // PRIMARY, 唯一索引名或者GEN_CLUST_INDEX
+	Indexes         []*TableIndex  `json:"indexes"`            // 二级索引, 不包括做了聚簇索引的唯一索引
+	Fulltext        []*TableIndex  `json:"fulltext,omitempty"` // FULLTEXT索引, 数据在辅助表中, 参考fts.go
+}
+
+func NewTableDescriberFromFile(filename string) (*TableDescriber, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewTableDescriber(string(data))
+}
+
+func NewTableDescriber(create_table string) (*TableDescriber, error) {
+	start := strings.Index(create_table, "(")
+	end := strings.LastIndex(create_table, ")")
+	if start < 0 || end < start {
+		return nil, errors.New("bad create table statement")
+	}
+
+	td := &TableDescriber{TAB_TYPE: "clustered"}
+	head := strings.Fields(create_table[:start])
+	if len(head) > 0 {
+		td.Name = Unquote_Identifier(head[len(head)-1])
+	}
+
+	for _, def := range Split_Definitions(create_table[start+1 : end]) {
+		words := strings.Fields(def)
+		if len(words) == 0 {
+			continue
+		}
+		switch strings.ToUpper(words[0]) {
+		case "PRIMARY":
+			td.Primary = Parse_Index_Columns(def)
+		case "UNIQUE", "KEY", "INDEX", "SPATIAL", "FULLTEXT":
+			index := &TableIndex{Unique: strings.ToUpper(words[0]) == "UNIQUE", Columns: Parse_Index_Columns(def),
+				Prefixes: Parse_Index_Prefixes(def), Spatial: strings.ToUpper(words[0]) == "SPATIAL"}
+			for _, w := range words[1:] {
+				upper := strings.ToUpper(w)
+				if upper == "KEY" || upper == "INDEX" {
+					continue
+				}
+				if !strings.HasPrefix(w, "(") {
+					index.Name = Unquote_Identifier(strings.SplitN(w, "(", 2)[0])
+				}
+				break
+			}
+			if strings.ToUpper(words[0]) == "FULLTEXT" {
+				td.Fulltext = append(td.Fulltext, index)
+			} else {
+				td.Indexes = append(td.Indexes, index)
+			}
+		case "CONSTRAINT", "FOREIGN", "CHECK":
+			// 不影响记录格式
+		default:
+			td.Columns = append(td.Columns, Parse_Column_Definition(def))
+		}
+	}
+
+	td.Charset = Parse_Table_Charset(create_table[end+1:])
+	table_collation := Parse_Table_Collation(create_table[end+1:])
+	for _, column := range td.Columns {
+		if column.Charset == "" && column.Collation != "" {
+			column.Charset = strings.SplitN(column.Collation, "_", 2)[0]
+		}
+		if column.Charset == "" {
+			column.Charset = td.Charset
+		}
+		if column.Collation == "" && column.Is_String() {
+			column.Collation = Default_Collation(column.Charset)
+			if column.Charset == td.Charset && table_collation != "" {
+				column.Collation = table_collation
+			}
+		}
+	}
+
+	if len(td.Primary) == 0 {
+		td.Choose_Clustered_Index()
+	} else {
+		td.Clustered_index = "PRIMARY"
+	}
+	td.Add_Fts_Doc_Id()
+	for _, name := range td.Primary {
+		if td.Column(name) == nil {
+			return nil, errors.New("primary key column " + name + " not found")
+		}
+		td.Column(name).Nullable = false
+	}
+	return td, nil
+}
+
+func (td *TableDescriber) Column(name string) *TableColumn {
+	for _, column := range td.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return column
+		}
+	}
+	return nil
+}
+
+func (td *TableDescriber) Is_Key_Column(name string) bool {
+	for _, key := range td.Primary {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// 参考dict_index_build_internal_clust, 唯一索引的字段都是NOT NULL并且不是前缀索引的时候才能做聚簇索引
+func (td *TableDescriber) Choose_Clustered_Index() {
+	for i, index := range td.Indexes {
+		if !index.Unique || len(index.Columns) == 0 {
+			continue
+		}
+		ok := true
+		for j, name := range index.Columns {
+			column := td.Column(name)
+			if column == nil || column.Nullable || (j < len(index.Prefixes) && index.Prefixes[j] > 0) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			td.Primary = index.Columns
+			td.Clustered_index = index.Name
+			td.Indexes = append(td.Indexes[:i:i], td.Indexes[i+1:]...)
+			return
+		}
+	}
+	row_id := &TableColumn{Name: DB_ROW_ID, Type: "INT6", Unsigned: true, Charset: td.Charset, Hidden: true}
+	td.Columns = append([]*TableColumn{row_id}, td.Columns...)
+	td.Primary = []string{DB_ROW_ID}
+	td.Clustered_index = GEN_CLUST_INDEX
+}
+
+// 聚簇索引的key是DB_ROW_ID
+func (td *TableDescriber) Has_Row_Id() bool {
+	return td.Clustered_index == GEN_CLUST_INDEX
+}
+
+// 聚簇索引的key字段, 按主键定义的顺序
+func (td *TableDescriber) Key_Columns() []*TableColumn {
+	var columns []*TableColumn
+	for _, name := range td.Primary {
+		columns = append(columns, td.Column(name))
+	}
+	return columns
+}
+
+// 命令行中逗号分隔的主键值, 按key字段的类型转换, 整数字段和解析出来的值一样是int64
+// 可以只给前面几个字段, 范围查询的时候用
+func (td *TableDescriber) Parse_Key(key string) ([]interface{}, error) {
+	columns := td.Key_Columns()
+	values := strings.Split(key, ",")
+	if len(values) > len(columns) {
+		return nil, errors.New("key " + key + " has more than " + strconv.Itoa(len(columns)) + " values")
+	}
+	var res []interface{}
+	for i, value := range values {
+		column := columns[i]
+		value = strings.TrimSpace(value)
+		//和记录中解析出来的类型一致, 只有这些类型可以比较大小
+		base_type, modifiers := Parse_Type_Definition(column.Type)
+		data_type, _ := NewDataType(base_type, modifiers, "")
+		switch data_type.(type) {
+		case *VariableCharacterType, *BlobType:
+			res = append(res, value)
+		case *IntegerType:
+			if column.Unsigned {
+				n, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				if base_type == "BIGINT" {
+					res = append(res, n)
+				} else {
+					res = append(res, int64(n))
+				}
+			} else {
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, n)
+			}
+		default:
+			return nil, errors.New("key column " + column.Name + " is " + column.Type + ", only integer and string keys can be compared")
+		}
+	}
+	return res, nil
+}
+
+func (td *TableDescriber) Index(name string) *TableIndex {
+	for _, index := range td.Indexes {
+		if strings.EqualFold(index.Name, name) {
+			return index
+		}
+	}
+	return nil
+}
+
+// 二级索引的描述符, 叶子结点上是索引字段, 然后是索引中没有的主键字段
+func (td *TableDescriber) Secondary_Describer(name string) (*TableDescriber, error) {
+	index := td.Index(name)
+	if index == nil {
+		return nil, errors.New("index " + name + " not found in table " + td.Name)
+	}
+	if index.Spatial {
+		return td.Spatial_Describer(index)
+	}
+	secondary := &TableDescriber{TAB_TYPE: "secondary", Name: td.Name + "." + index.Name, Charset: td.Charset,
+		Table_id: td.Table_id, Primary: index.Columns}
+	for _, name := range index.Columns {
+		column := td.Column(name)
+		if column == nil {
+			return nil, errors.New("index column " + name + " not found")
+		}
+		secondary.Columns = append(secondary.Columns, column)
+	}
+	for _, name := range td.Primary {
+		if !secondary.Is_Key_Column(name) {
+			secondary.Columns = append(secondary.Columns, td.Column(name))
+		}
+	}
+	return secondary, nil
+}
+
+// 非key字段, 按建表语句中的顺序
+func (td *TableDescriber) Row_Columns() []*TableColumn {
+	var columns []*TableColumn
+	for _, column := range td.Columns {
+		if !td.Is_Key_Column(column.Name) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// Record.Key()中每个值对应的字段名, 二级索引后面是主键字段
+func (td *TableDescriber) Key_Names() []string {
+	var names []string
+	for _, column := range td.Key_Columns() {
+		names = append(names, column.Name)
+	}
+	if td.TAB_TYPE == "secondary" || td.TAB_TYPE == "spatial" {
+		for _, column := range td.Row_Columns() {
+			names = append(names, column.Name)
+		}
+	}
+	return names
+}
+
+// 转换成Restruct_Describer的格式 map[tab_type:"" key:[{name,type:[type,properties]}] row:[...]]
+func (td *TableDescriber) Restruct() map[string]interface{} {
+	var keys []interface{}
+	var rows []interface{}
+	for _, column := range td.Key_Columns() {
+		keys = append(keys, column.Restruct())
+	}
+	for _, column := range td.Row_Columns() {
+		rows = append(rows, column.Restruct())
+	}
+	return map[string]interface{}{"tab_type": td.TAB_TYPE, "key": keys, "row": rows}
+}
+
+func (column *TableColumn) Properties() string {
+	var properties []string
+	if column.Unsigned {
+		properties = append(properties, "UNSIGNED")
+	}
+	if !column.Nullable {
+		properties = append(properties, "NOT_NULL")
+	}
+	if column.Charset != "" {
+		properties = append(properties, "CHARSET="+column.Charset)
+	}
+	return strings.Join(properties, " ")
+}
+
+func (column *TableColumn) Restruct() map[string]interface{} {
+	return map[string]interface{}{
+		"name":   column.Name,
+		"type":   []interface{}{column.Type, column.Properties()},
+		"column": column,
+	}
+}
+
+func Parse_Column_Definition(def string) *TableColumn {
+	words := Split_Words(def)
+	column := &TableColumn{Name: Unquote_Identifier(words[0]), Nullable: true}
+	if len(words) > 1 {
+		column.Type = strings.ToUpper(words[1])
+	}
+	for i := 2; i < len(words); i++ {
+		switch strings.ToUpper(words[i]) {
+		case "UNSIGNED":
+			column.Unsigned = true
+		case "NOT":
+			if i+1 < len(words) && strings.ToUpper(words[i+1]) == "NULL" {
+				column.Nullable = false
+				i++
+			}
+		case "CHARSET", "CHARACTER":
+			if strings.ToUpper(words[i]) == "CHARACTER" {
+				i++
+			}
+			if i+1 < len(words) {
+				column.Charset = strings.ToLower(words[i+1])
+				i++
+			}
+		case "COLLATE":
+			if i+1 < len(words) {
+				column.Collation = strings.ToLower(words[i+1])
+				i++
+			}
+		case "DEFAULT":
+			if i+1 < len(words) {
+				if strings.ToUpper(words[i+1]) != "NULL" {
+					value := strings.Trim(words[i+1], "'\"")
+					column.Default = &value
+				}
+				i++
+			}
+		}
+	}
+	return column
+}
+
+// 表选项中的 DEFAULT CHARSET=utf8 或者 CHARACTER SET utf8, 没有的时候是latin1(5.7的默认值)
+func Parse_Table_Charset(options string) string {
+	words := strings.Fields(strings.Replace(options, "=", " ", -1))
+	for i := 0; i+1 < len(words); i++ {
+		switch strings.ToUpper(words[i]) {
+		case "CHARSET":
+			return strings.ToLower(words[i+1])
+		case "CHARACTER":
+			if strings.ToUpper(words[i+1]) == "SET" && i+2 < len(words) {
+				return strings.ToLower(words[i+2])
+			}
+		}
+	}
+	return "latin1"
+}
+
+// 表选项中的 COLLATE=utf8mb4_0900_ai_ci, 没有的时候是空的, 用字符集的默认collation
+func Parse_Table_Collation(options string) string {
+	words := strings.Fields(strings.Replace(options, "=", " ", -1))
+	for i := 0; i+1 < len(words); i++ {
+		if strings.ToUpper(words[i]) == "COLLATE" {
+			return strings.ToLower(words[i+1])
+		}
+	}
+	return ""
+}
+
+// 字符集的默认collation, 是5.7的默认值, 8.0的utf8mb4是utf8mb4_0900_ai_ci, SHOW CREATE TABLE中会写出来
+func Default_Collation(charset string) string {
+	switch charset {
+	case "binary":
+		return "binary"
+	case "latin1":
+		return "latin1_swedish_ci"
+	case "gbk":
+		return "gbk_chinese_ci"
+	}
+	return charset + "_general_ci"
+}
+
+// CHAR, VARCHAR和TEXT, 比较的时候要看collation
+func (column *TableColumn) Is_String() bool {
+	base := strings.SplitN(column.Type, "(", 2)[0]
+	switch base {
+	case "CHAR", "VARCHAR", "TINYTEXT", "TEXT", "MEDIUMTEXT", "LONGTEXT":
+		return column.Charset != "binary"
+	}
+	return false
+}
+
+// 按字节比较的顺序和InnoDB中的顺序一样: 不是字符串, 或者collation是binary或者_bin
+// _ci, _cs和0900的collation按权重排序, 大小写或者重音不同的值可能相等, 顺序也和字节不一样
+func (column *TableColumn) Is_Binary_Ordered() bool {
+	if !column.Is_String() || column.Collation == "" {
+		return true
+	}
+	return column.Collation == "binary" || strings.HasSuffix(column.Collation, "_bin")
+}
+
+// key的前n个字段中第一个不能按字节比较的字段, 都可以的时候返回nil
+func (td *TableDescriber) Unordered_Key_Column(n int) *TableColumn {
+	for i, name := range td.Key_Names() {
+		if i >= n {
+			break
+		}
+		if column := td.Column(name); column != nil && !column.Is_Binary_Ordered() {
+			return column
+		}
+	}
+	return nil
+}
+
+// 取出括号中的列名,去掉前缀长度, 比如 KEY `idx_name` (`name`(10),`class`)
+func Parse_Index_Columns(def string) []string {
+	var columns []string
+	start := strings.Index(def, "(")
+	end := strings.LastIndex(def, ")")
+	if start < 0 || end < start {
+		return nil
+	}
+	for _, name := range Split_Definitions(def[start+1 : end]) {
+		name = strings.TrimSpace(name)
+		if i := strings.Index(name, "("); i > 0 {
+			name = name[:i]
+		}
+		name = strings.Fields(name + " ")[0]
+		columns = append(columns, Unquote_Identifier(name))
+	}
+	return columns
+}
+
+// 每个索引字段的前缀长度, 比如 (`name`(10),`class`) 是 [10 0]
+func Parse_Index_Prefixes(def string) []int {
+	var prefixes []int
+	start := strings.Index(def, "(")
+	end := strings.LastIndex(def, ")")
+	if start < 0 || end < start {
+		return nil
+	}
+	for _, name := range Split_Definitions(def[start+1 : end]) {
+		prefix := 0
+		if i := strings.Index(name, "("); i > 0 {
+			if j := strings.Index(name[i:], ")"); j > 0 {
+				prefix, _ = strconv.Atoi(strings.TrimSpace(name[i+1 : i+j]))
+			}
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// 按最外层的逗号分割, 忽略括号和引号中的逗号
+func Split_Definitions(body string) []string {
+	var defs []string
+	depth := 0
+	var quote rune
+	last := 0
+	for i, c := range body {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, strings.TrimSpace(body[last:i]))
+			last = i + 1
+		}
+	}
+	defs = append(defs, strings.TrimSpace(body[last:]))
+	return defs
+}
+
+// 按空白分割, 引号和括号中的内容作为一个整体
+func Split_Words(def string) []string {
+	var words []string
+	var word []rune
+	depth := 0
+	var quote rune
+	for _, c := range def {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case (c == ' ' || c == '\t' || c == '\n' || c == '\r') && depth == 0:
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+			continue
+		}
+		word = append(word, c)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+func Unquote_Identifier(name string) string {
+	name = strings.Trim(name, "`\"")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = strings.Trim(name[i+1:], "`\"")
+	}
+	return name
+}
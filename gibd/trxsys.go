@@ -0,0 +1,153 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// 系统表空间第5页, 事务系统页 trx0sys.h
+const TRX_SYS_PAGE_NO = 5
+const TRX_SYS_N_RSEGS = 128
+const TRX_SYS_RSEG_SLOT_SIZE = 8
+const TRX_SYS_MYSQL_LOG_MAGIC_N = 873422344
+const TRX_SYS_DOUBLEWRITE_MAGIC_N = 536853855
+const TRX_SYS_DOUBLEWRITE_SPACE_ID_STORED_N = 1783657386
+const TRX_SYS_DOUBLEWRITE_BLOCK_SIZE = 64 // 每个block是一个extent, 64个页
+
+type RsegSlot struct {
+	Slot        uint64 `json:"slot"`
+	Space_id    uint64 `json:"space_id"`
+	Page_number uint64 `json:"page_number"`
+}
+
+// binlog位点, 在页尾部1000字节的位置
+type BinlogInfo struct {
+	Magic  uint64 `json:"magic"`
+	Offset uint64 `json:"offset"`
+	Name   string `json:"name"`
+}
+
+// doublewrite buffer信息, 在页尾部200字节的位置
+type DoublewriteInfo struct {
+	Fseg            *FsegEntry `json:"fseg"`
+	Magic           uint64     `json:"magic"`
+	Block1          uint64     `json:"block1"`
+	Block2          uint64     `json:"block2"`
+	Repeat_magic    uint64     `json:"repeat_magic"`
+	Repeat_block1   uint64     `json:"repeat_block1"`
+	Repeat_block2   uint64     `json:"repeat_block2"`
+	Space_id_stored bool       `json:"space_id_stored"`
+}
+
+func (dw *DoublewriteInfo) Is_Valid() bool {
+	return dw.Magic == TRX_SYS_DOUBLEWRITE_MAGIC_N
+}
+
+type TrxSys struct {
+	Page        *Page            `json:"-"`
+	Max_trx_id  uint64           `json:"max_trx_id"`
+	Fseg        *FsegEntry       `json:"fseg"`
+	Rsegs       []RsegSlot       `json:"rsegs"`
+	Binlog      *BinlogInfo      `json:"binlog"`
+	Doublewrite *DoublewriteInfo `json:"doublewrite"`
+}
+
+func NewTrxSys(page *Page) *TrxSys {
+	return &TrxSys{Page: page}
+}
+
+func (trx_sys *TrxSys) Pos_Trx_Sys_Header() uint64 {
+	return Pos_Page_Body()
+}
+
+func (trx_sys *TrxSys) Pos_Rsegs() uint64 {
+	return trx_sys.Pos_Trx_Sys_Header() + 8 + FsegEntry_SIZE
+}
+
+func (trx_sys *TrxSys) Pos_Binlog_Info() uint64 {
+	return DEFAULT_PAGE_SIZE - 1000
+}
+
+func (trx_sys *TrxSys) Pos_Doublewrite_Info() uint64 {
+	return DEFAULT_PAGE_SIZE - 200
+}
+
+func (trx_sys *TrxSys) Trx_Sys_Header() {
+	pos := int64(trx_sys.Pos_Trx_Sys_Header())
+	trx_sys.Max_trx_id = uint64(BufferReadAt(trx_sys.Page, pos, 8))
+	trx_sys.Fseg = Read_Fseg_Entry(trx_sys.Page, pos+8)
+
+	trx_sys.Rsegs = nil
+	pos = int64(trx_sys.Pos_Rsegs())
+	for i := uint64(0); i < TRX_SYS_N_RSEGS; i++ {
+		space_id := uint64(BufferReadAt(trx_sys.Page, pos, 4))
+		page_number := uint64(BufferReadAt(trx_sys.Page, pos+4, 4))
+		pos = pos + TRX_SYS_RSEG_SLOT_SIZE
+		//没有使用的slot页号是FIL_NULL
+		if page_number == FIL_NULL {
+			continue
+		}
+		trx_sys.Rsegs = append(trx_sys.Rsegs, RsegSlot{Slot: i, Space_id: space_id, Page_number: page_number})
+	}
+
+	pos = int64(trx_sys.Pos_Binlog_Info())
+	binlog := &BinlogInfo{}
+	binlog.Magic = uint64(BufferReadAt(trx_sys.Page, pos, 4))
+	if binlog.Magic == TRX_SYS_MYSQL_LOG_MAGIC_N {
+		binlog.Offset = uint64(BufferReadAt(trx_sys.Page, pos+4, 4))<<32 | uint64(BufferReadAt(trx_sys.Page, pos+8, 4))
+		name := string(ReadBytes(trx_sys.Page, pos+12, 512))
+		binlog.Name = strings.TrimRight(name[:strings.IndexByte(name+"\x00", 0)], " ")
+	}
+	trx_sys.Binlog = binlog
+
+	pos = int64(trx_sys.Pos_Doublewrite_Info())
+	dw := &DoublewriteInfo{}
+	dw.Fseg = Read_Fseg_Entry(trx_sys.Page, pos)
+	dw.Magic = uint64(BufferReadAt(trx_sys.Page, pos+10, 4))
+	dw.Block1 = uint64(BufferReadAt(trx_sys.Page, pos+14, 4))
+	dw.Block2 = uint64(BufferReadAt(trx_sys.Page, pos+18, 4))
+	dw.Repeat_magic = uint64(BufferReadAt(trx_sys.Page, pos+22, 4))
+	dw.Repeat_block1 = uint64(BufferReadAt(trx_sys.Page, pos+26, 4))
+	dw.Repeat_block2 = uint64(BufferReadAt(trx_sys.Page, pos+30, 4))
+	dw.Space_id_stored = uint64(BufferReadAt(trx_sys.Page, pos+34, 4)) == TRX_SYS_DOUBLEWRITE_SPACE_ID_STORED_N
+	trx_sys.Doublewrite = dw
+}
+
+// 根据slot读取回滚段头页, slot指向其他表空间的时候需要通过system找到对应的space
+func (trx_sys *TrxSys) Rseg(slot RsegSlot) *Rseg {
+	space := trx_sys.Page.Space
+	if space != nil && space.Space_id != slot.Space_id && space.Innodb_system != nil {
+		space = space.Innodb_system.Space(slot.Space_id)
+	}
+	if space == nil || space.Space_id != slot.Space_id {
+		Log.Info("rseg slot %d in space %d is not opened\n", slot.Slot, slot.Space_id)
+		return nil
+	}
+	rseg := NewRseg(space.Page(slot.Page_number))
+	rseg.Rseg_Header()
+	return rseg
+}
+
+func (trx_sys *TrxSys) Each_Rseg() []*Rseg {
+	var rsegs []*Rseg
+	for _, slot := range trx_sys.Rsegs {
+		rseg := trx_sys.Rseg(slot)
+		if rseg == nil {
+			continue
+		}
+		rseg.Slot = slot.Slot
+		rsegs = append(rsegs, rseg)
+	}
+	return rsegs
+}
+
+func (trx_sys *TrxSys) Dump() {
+	println("trx_sys:")
+
+	data, _ := json.Marshal(trx_sys)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -0,0 +1,189 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/tidwall/pretty"
+)
+
+// 误删数据的恢复
+// 1. delete mark了还没有purge的记录, 还在页的记录链表里, 是完整的
+// 2. purge以后的记录放到PAGE_FREE(Garbage_offset)链表, 数据还在页里, 直到空间被新插入的记录重用
+// 空间被重用的时候记录会从PAGE_FREE链表中摘掉, 但是链表中后面的记录可能和新记录有重叠
+const UNDELETE_SOURCE_FREE_LIST = "free_list"
+const UNDELETE_SOURCE_DELETE_MARKED = "delete_marked"
+
+const UNDELETE_STATUS_OK = "ok"
+const UNDELETE_STATUS_PARTIAL = "partial"
+
+type DeletedRecord struct {
+	Page_number    uint64                 `json:"page_number"`
+	Offset         uint64                 `json:"offset"`
+	Source         string                 `json:"source"`
+	Status         string                 `json:"status"`
+	Reason         string                 `json:"reason,omitempty"`
+	Heap_number    uint64                 `json:"heap_number"`
+	Transaction_id uint64                 `json:"trx_id"`
+	Roll_pointer   *Pointer               `json:"roll_pointer,omitempty"`
+	Fields         map[string]interface{} `json:"fields,omitempty"`
+}
+
+// 记录占用的空间[start, end), start是extra部分的开始, end是数据的结尾
+type recordExtent struct {
+	start uint64
+	end   uint64
+}
+
+// 解析记录的时候数据可能已经被覆盖, 读越界会panic, 这里转成error
+func (index *IndexPage) Try_Record(offset uint64) (record *Record, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			record = nil
+			err = fmt.Errorf("cannot decode record at offset %d: %v", offset, r)
+		}
+	}()
+	return index.record(offset), nil
+}
+
+// 记录头前面的extra部分的长度, compact格式是5字节header+null bitmap+变长字段长度, redundant格式是6字节header+字段结束位置数组
+func (index *IndexPage) Record_Extra_Size(user_record *UserRecord) uint64 {
+	header := user_record.header
+	if index.PageHeader.Format == "redundant" {
+		return 6 + header.N_fields*header.Offset_size
+	}
+	fields := index.Record_Header_Compact_Fields(header)
+	size := 5 + header.Instant_length + index.Record_Header_Compact_Null_Bitmap_Size(fields)
+	for _, f := range fields {
+		if !f.Is_Variable() {
+			continue
+		}
+		is_null := false
+		for _, name := range header.Nulls {
+			if name == f.Name {
+				is_null = true
+			}
+		}
+		if is_null {
+			continue
+		}
+		is_extern := false
+		for _, name := range header.Externs {
+			if name == f.Name {
+				is_extern = true
+			}
+		}
+		if f.Is_Big() && (header.Lengths[f.Name] > 127 || is_extern) {
+			size += 2
+		} else {
+			size += 1
+		}
+	}
+	return size
+}
+
+func (index *IndexPage) record_extent(user_record *UserRecord) recordExtent {
+	return recordExtent{
+		start: user_record.offset - index.Record_Extra_Size(user_record),
+		end:   user_record.offset + user_record.Length - user_record.header.Length,
+	}
+}
+
+// PAGE_FREE链表中的记录
+func (index *IndexPage) Each_Free_Record_Offset() []uint64 {
+	var offsets []uint64
+	visited := make(map[uint64]bool)
+	offset := index.PageHeader.Garbage_offset
+	for offset != 0 && !visited[offset] && uint64(len(offsets)) < index.PageHeader.N_heap {
+		if offset < index.Pos_User_Records() || offset >= index.PageHeader.Heap_top {
+			break
+		}
+		visited[offset] = true
+		offsets = append(offsets, offset)
+
+		offset = index.Next_Offset(offset)
+	}
+	return offsets
+}
+
+func NewDeletedRecord(index *IndexPage, record *Record, source string) *DeletedRecord {
+	user_record := record.record.(*UserRecord)
+	deleted := &DeletedRecord{
+		Page_number:    index.Page.Page_number,
+		Offset:         user_record.offset,
+		Source:         source,
+		Status:         UNDELETE_STATUS_OK,
+		Heap_number:    user_record.header.Heap_Number,
+		Transaction_id: user_record.Transaction_id,
+		Roll_pointer:   user_record.Roll_pointer,
+		Fields:         record.Get_Fields_And_Value_Map(),
+	}
+	return deleted
+}
+
+// 叶子页上能找到的删除的记录, 先是delete mark的, 然后是PAGE_FREE链表中的
+// 和页上现有记录重叠或者超出heap top的记录标记为partial, 里面的值不能完全相信
+func (index *IndexPage) Each_Deleted_Record() []*DeletedRecord {
+	var deleted []*DeletedRecord
+	if !index.IsLeaf() {
+		return deleted
+	}
+
+	var live []recordExtent
+	for _, record := range index.each_record() {
+		user_record := record.record.(*UserRecord)
+		live = append(live, index.record_extent(user_record))
+		if record.Is_Deleted() {
+			deleted = append(deleted, NewDeletedRecord(index, record, UNDELETE_SOURCE_DELETE_MARKED))
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].start < live[j].start })
+
+	for _, offset := range index.Each_Free_Record_Offset() {
+		record, err := index.Try_Record(offset)
+		if err != nil {
+			deleted = append(deleted, &DeletedRecord{Page_number: index.Page.Page_number, Offset: offset,
+				Source: UNDELETE_SOURCE_FREE_LIST, Status: UNDELETE_STATUS_PARTIAL, Reason: err.Error()})
+			continue
+		}
+		user_record, ok := record.record.(*UserRecord)
+		if !ok {
+			deleted = append(deleted, &DeletedRecord{Page_number: index.Page.Page_number, Offset: offset,
+				Source: UNDELETE_SOURCE_FREE_LIST, Status: UNDELETE_STATUS_PARTIAL, Reason: "record header is overwritten"})
+			continue
+		}
+		this_record := NewDeletedRecord(index, record, UNDELETE_SOURCE_FREE_LIST)
+		deleted = append(deleted, this_record)
+
+		// redundant格式的记录头里没有记录类型
+		if index.PageHeader.Format == "compact" && user_record.header.Record_Type != "conventional" {
+			this_record.Status = UNDELETE_STATUS_PARTIAL
+			this_record.Reason = "record type is " + user_record.header.Record_Type
+			continue
+		}
+		extent := index.record_extent(user_record)
+		if extent.start < index.Pos_User_Records() || extent.end > index.PageHeader.Heap_top {
+			this_record.Status = UNDELETE_STATUS_PARTIAL
+			this_record.Reason = fmt.Sprintf("record [%d, %d) is outside of the heap", extent.start, extent.end)
+			continue
+		}
+		for _, other := range live {
+			if extent.start < other.end && other.start < extent.end {
+				this_record.Status = UNDELETE_STATUS_PARTIAL
+				this_record.Reason = fmt.Sprintf("record [%d, %d) overlaps live record [%d, %d)",
+					extent.start, extent.end, other.start, other.end)
+				break
+			}
+		}
+	}
+	return deleted
+}
+
+func (deleted *DeletedRecord) Dump() {
+	println("deleted record:")
+
+	data, _ := json.Marshal(deleted)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -0,0 +1,213 @@
+package gibd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/pretty"
+)
+
+// undo页 trx0undo.h
+// FIL header, undo page header, (段的第一个页才有)undo segment header, undo log header, undo记录
+const TRX_UNDO_PAGE_HDR = 38
+const TRX_UNDO_PAGE_HDR_SIZE = 2 + 2 + 2 + 12
+const TRX_UNDO_SEG_HDR = TRX_UNDO_PAGE_HDR + TRX_UNDO_PAGE_HDR_SIZE
+const TRX_UNDO_SEG_HDR_SIZE = 2 + 2 + FsegEntry_SIZE + 16
+const TRX_UNDO_LOG_OLD_HDR_SIZE = 46
+const TRX_UNDO_XA_XID_SIZE = 128
+
+// 8.0里header第20个字节是TRX_UNDO_FLAGS, 5.7以前是TRX_UNDO_XID_EXISTS, 只用到最低位
+const TRX_UNDO_FLAG_XID = 0x01
+
+var UNDO_PAGE_TYPES = map[uint64]string{
+	1: "insert", // TRX_UNDO_INSERT
+	2: "update", // TRX_UNDO_UPDATE, 包括delete mark
+}
+
+var UNDO_SEGMENT_STATES = map[uint64]string{
+	1: "active",   // 事务还没有结束
+	2: "cached",   // 可以被下一个事务重用
+	3: "to_free",  // insert undo, 事务提交后释放
+	4: "to_purge", // update undo, 等待purge
+	5: "prepared", // XA prepared
+}
+
+type UndoPageHeader struct {
+	Type  string `json:"type"`
+	Start uint64 `json:"start"` // 这个页上最后一个undo log的第一条记录
+	Free  uint64 `json:"free"`  // 第一个空闲字节的位置
+	Node  *Node  `json:"node"`  // undo段页链表的节点
+}
+
+type UndoSegmentHeader struct {
+	State     string     `json:"state"`
+	Last_log  uint64     `json:"last_log"` // 最后一个undo log header的位置
+	Fseg      *FsegEntry `json:"fseg"`
+	Page_list *BaseNode  `json:"page_list"`
+}
+
+type Xid struct {
+	Format_id uint64 `json:"format_id"`
+	Gtrid     string `json:"gtrid"`
+	Bqual     string `json:"bqual"`
+}
+
+type UndoLogHeader struct {
+	Offset       uint64 `json:"offset"`
+	Trx_id       uint64 `json:"trx_id"`
+	Trx_no       uint64 `json:"trx_no"` // 提交的顺序, 没有提交是0
+	Del_marks    bool   `json:"del_marks"`
+	Log_start    uint64 `json:"log_start"`
+	Xid_exists   bool   `json:"xid_exists"`
+	Dict_trans   bool   `json:"dict_trans"`
+	Table_id     uint64 `json:"table_id"`
+	Next_log     uint64 `json:"next_log"`
+	Prev_log     uint64 `json:"prev_log"`
+	History_node *Node  `json:"history_node"`
+	Xid          *Xid   `json:"xid"`
+}
+
+type UndoPage struct {
+	Page           *Page              `json:"-"`
+	Page_number    uint64             `json:"page_number"`
+	Page_header    *UndoPageHeader    `json:"page_header"`
+	Segment_header *UndoSegmentHeader `json:"segment_header"`
+	Logs           []*UndoLogHeader   `json:"logs"`
+	Records        []*UndoRecord      `json:"records"`
+}
+
+func NewUndoPage(page *Page) *UndoPage {
+	undo_page := &UndoPage{Page: page, Page_number: page.Page_number}
+	undo_page.Undo_Page_Header()
+	if undo_page.Is_Segment_Header_Page() {
+		undo_page.Undo_Segment_Header()
+		undo_page.Each_Undo_Log_Header()
+	}
+	return undo_page
+}
+
+func (undo_page *UndoPage) Undo_Page_Header() {
+	pos := int64(TRX_UNDO_PAGE_HDR)
+	header := &UndoPageHeader{}
+	header.Type = UNDO_PAGE_TYPES[uint64(BufferReadAt(undo_page.Page, pos, 2))]
+	header.Start = uint64(BufferReadAt(undo_page.Page, pos+2, 2))
+	header.Free = uint64(BufferReadAt(undo_page.Page, pos+4, 2))
+	header.Node = Read_Node(undo_page.Page, pos+6)
+	undo_page.Page_header = header
+}
+
+// 段的第一个页上有segment header,后面的页记录直接从page header后开始
+func (undo_page *UndoPage) Is_Segment_Header_Page() bool {
+	if undo_page.Page_header.Start <= TRX_UNDO_SEG_HDR {
+		return false
+	}
+	state := uint64(BufferReadAt(undo_page.Page, TRX_UNDO_SEG_HDR, 2))
+	_, ok := UNDO_SEGMENT_STATES[state]
+	return ok
+}
+
+func (undo_page *UndoPage) Undo_Segment_Header() {
+	pos := int64(TRX_UNDO_SEG_HDR)
+	header := &UndoSegmentHeader{}
+	header.State = UNDO_SEGMENT_STATES[uint64(BufferReadAt(undo_page.Page, pos, 2))]
+	header.Last_log = uint64(BufferReadAt(undo_page.Page, pos+2, 2))
+	header.Fseg = Read_Fseg_Entry(undo_page.Page, pos+4)
+	header.Page_list = Read_Base_Node(undo_page.Page, pos+4+FsegEntry_SIZE)
+	undo_page.Segment_header = header
+}
+
+// 第一个undo log header紧跟在segment header后面, 通过next_log找到后面的header
+func (undo_page *UndoPage) Each_Undo_Log_Header() {
+	undo_page.Logs = nil
+	offset := uint64(TRX_UNDO_SEG_HDR + TRX_UNDO_SEG_HDR_SIZE)
+	for offset != 0 && offset < undo_page.Page_header.Free {
+		log := undo_page.Undo_Log_Header(offset)
+		undo_page.Logs = append(undo_page.Logs, log)
+		if log.Next_log <= offset {
+			break
+		}
+		offset = log.Next_log
+	}
+}
+
+func (undo_page *UndoPage) Undo_Log_Header(offset uint64) *UndoLogHeader {
+	p := undo_page.Page
+	pos := int64(offset)
+	log := &UndoLogHeader{Offset: offset}
+	log.Trx_id = uint64(BufferReadAt(p, pos, 8))
+	log.Trx_no = uint64(BufferReadAt(p, pos+8, 8))
+	log.Del_marks = BufferReadAt(p, pos+16, 2) != 0
+	log.Log_start = uint64(BufferReadAt(p, pos+18, 2))
+	log.Xid_exists = BufferReadAt(p, pos+20, 1)&TRX_UNDO_FLAG_XID != 0
+	log.Dict_trans = BufferReadAt(p, pos+21, 1) != 0
+	log.Table_id = uint64(BufferReadAt(p, pos+22, 8))
+	log.Next_log = uint64(BufferReadAt(p, pos+30, 2))
+	log.Prev_log = uint64(BufferReadAt(p, pos+32, 2))
+	log.History_node = Read_Node(p, pos+34)
+
+	if log.Xid_exists {
+		pos = pos + TRX_UNDO_LOG_OLD_HDR_SIZE
+		xid := &Xid{}
+		xid.Format_id = uint64(BufferReadAt(p, pos, 4))
+		trid_len := int64(BufferReadAt(p, pos+4, 4))
+		bqual_len := int64(BufferReadAt(p, pos+8, 4))
+		if trid_len+bqual_len <= TRX_UNDO_XA_XID_SIZE {
+			data := ReadBytes(p, pos+12, trid_len+bqual_len)
+			xid.Gtrid = hex.EncodeToString(data[:trid_len])
+			xid.Bqual = hex.EncodeToString(data[trid_len:])
+		}
+		log.Xid = xid
+	}
+	return log
+}
+
+// 每个undo log在这个页上的记录范围[start, end)
+func (undo_page *UndoPage) Record_Ranges() [][2]uint64 {
+	var ranges [][2]uint64
+	if undo_page.Segment_header == nil {
+		return append(ranges, [2]uint64{undo_page.Page_header.Start, undo_page.Page_header.Free})
+	}
+	for i, log := range undo_page.Logs {
+		end := undo_page.Page_header.Free
+		if i+1 < len(undo_page.Logs) {
+			end = undo_page.Logs[i+1].Offset
+		}
+		ranges = append(ranges, [2]uint64{log.Log_start, end})
+	}
+	return ranges
+}
+
+// 解析页上所有的undo记录, describer为nil的时候不知道字段类型, 只输出原始的字节
+func (undo_page *UndoPage) Each_Undo_Record(describer interface{}) []*UndoRecord {
+	var records []*UndoRecord
+	for _, r := range undo_page.Record_Ranges() {
+		offset, end := r[0], r[1]
+		for offset != 0 && offset < end {
+			record := undo_page.Undo_Record(offset, describer)
+			records = append(records, record)
+			if record.Error != "" || record.Next <= offset || record.Next >= end {
+				break
+			}
+			offset = record.Next
+		}
+	}
+	undo_page.Records = records
+	return records
+}
+
+func (undo_page *UndoPage) Undo_Record(offset uint64, describer interface{}) *UndoRecord {
+	record := NewUndoRecord(undo_page.Page, offset)
+	if err := record.Parse(describer); err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+func (undo_page *UndoPage) Dump() {
+	println("undo page:")
+
+	data, _ := json.Marshal(undo_page)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
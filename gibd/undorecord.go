@@ -0,0 +1,261 @@
+package gibd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/pretty"
+)
+
+// undo记录 trx0rec.h
+// next(2) type_cmpl(1) undo_no table_id [info_bits trx_id roll_ptr] key字段 [update vector] [索引列] ... start(2)
+const TRX_UNDO_INSERT_REC = 11
+const TRX_UNDO_UPD_EXIST_REC = 12
+const TRX_UNDO_UPD_DEL_REC = 13
+const TRX_UNDO_DEL_MARK_REC = 14
+const TRX_UNDO_CMPL_INFO_MULT = 16
+const TRX_UNDO_MODIFY_BLOB = 64 // 8.0, table_id后面多一个flags字节
+const TRX_UNDO_UPD_EXTERN = 128
+const UPD_NODE_NO_ORD_CHANGE = 1
+
+// 字段长度的特殊值
+const UNIV_SQL_NULL = 0xFFFFFFFF
+const UNIV_EXTERN_STORAGE_FIELD = UNIV_SQL_NULL - DEFAULT_PAGE_SIZE
+
+var UNDO_RECORD_TYPES = map[uint64]string{
+	TRX_UNDO_INSERT_REC:    "insert",
+	TRX_UNDO_UPD_EXIST_REC: "update_existing",
+	TRX_UNDO_UPD_DEL_REC:   "update_deleted",
+	TRX_UNDO_DEL_MARK_REC:  "delete_mark",
+}
+
+type UndoRecordField struct {
+	Field_no uint64           `json:"field_no"`
+	Name     string           `json:"name"`
+	Length   uint64           `json:"length"`
+	Is_null  bool             `json:"is_null"`
+	Extern   *ExternReference `json:"-"`
+	Value    interface{}      `json:"value"`
+}
+
+type UndoRecord struct {
+	Page           *Page              `json:"-"`
+	Page_number    uint64             `json:"page_number"`
+	Offset         uint64             `json:"offset"`
+	Next           uint64             `json:"next"`
+	Type           string             `json:"type"`
+	Type_id        uint64             `json:"type_id"`
+	Cmpl_info      uint64             `json:"cmpl_info"`
+	Updated_extern bool               `json:"updated_extern"`
+	Undo_no        uint64             `json:"undo_no"`
+	Table_id       uint64             `json:"table_id"`
+	Info_bits      uint64             `json:"info_bits"`
+	Trx_id         uint64             `json:"trx_id"`   // 修改前的记录上的事务id
+	Roll_ptr       uint64             `json:"roll_ptr"` // 修改前的记录上的回滚指针, 指向更早的版本
+	Key            []*UndoRecordField `json:"key"`
+	Update         []*UndoRecordField `json:"update"`          // 被修改字段的旧值
+	Ordering       []*UndoRecordField `json:"ordering"`        // 索引列的旧值, purge的时候用
+	Error          string             `json:"error,omitempty"` // 解析到一半读到页外面
+	fields         []*RecordFieldMeta
+}
+
+func NewUndoRecord(page *Page, offset uint64) *UndoRecord {
+	return &UndoRecord{Page: page, Page_number: page.Page_number, Offset: offset}
+}
+
+func (record *UndoRecord) Is_Insert() bool {
+	return record.Type_id == TRX_UNDO_INSERT_REC
+}
+
+// describer为nil, 或者和记录的table id对不上的时候, 假设主键只有一个字段
+// 记录被截断或者内容不对, 读到页外面的时候返回error, 前面已经解析的字段保留
+func (record *UndoRecord) Parse(describer interface{}) error {
+	b := *record.Page.Buffer
+	pos := record.Offset
+	if pos+3 > uint64(len(b)) {
+		return fmt.Errorf("undo record at %d is beyond the page", pos)
+	}
+
+	record.Next = uint64(BytesToUIntLittleEndian(b[pos : pos+2]))
+	pos += 2
+	type_cmpl := uint64(b[pos])
+	pos += 1
+	if type_cmpl&TRX_UNDO_UPD_EXTERN != 0 {
+		record.Updated_extern = true
+		type_cmpl -= TRX_UNDO_UPD_EXTERN
+	}
+	modify_blob := type_cmpl&TRX_UNDO_MODIFY_BLOB != 0
+	type_cmpl &^= TRX_UNDO_MODIFY_BLOB
+	record.Type_id = type_cmpl & (TRX_UNDO_CMPL_INFO_MULT - 1)
+	record.Type = UNDO_RECORD_TYPES[record.Type_id]
+	record.Cmpl_info = type_cmpl / TRX_UNDO_CMPL_INFO_MULT
+
+	var size uint64
+	var err error
+	if record.Undo_no, size, err = Mach_U64_Read_Much_Compressed(b, pos); err != nil {
+		return err
+	}
+	pos += size
+	if record.Table_id, size, err = Mach_U64_Read_Much_Compressed(b, pos); err != nil {
+		return err
+	}
+	pos += size
+	if modify_blob {
+		pos += 1
+	}
+
+	n_unique := 1
+	if describer != nil {
+		if td, ok := describer.(*TableDescriber); !ok || td.Table_id == 0 || td.Table_id == record.Table_id {
+			if fields, n := Record_Fields_By_Describer(describer); fields != nil {
+				record.fields = fields
+				n_unique = n
+			}
+		}
+	}
+
+	if !record.Is_Insert() {
+		if pos >= uint64(len(b)) {
+			return fmt.Errorf("undo record at %d: info bits are beyond the page", record.Offset)
+		}
+		record.Info_bits = uint64(b[pos])
+		pos += 1
+		if record.Trx_id, size, err = Mach_U64_Read_Compressed(b, pos); err != nil {
+			return err
+		}
+		pos += size
+		if record.Roll_ptr, size, err = Mach_U64_Read_Compressed(b, pos); err != nil {
+			return err
+		}
+		pos += size
+	}
+
+	for i := 0; i < n_unique; i++ {
+		var field *UndoRecordField
+		if field, pos, err = record.Read_Field(b, pos, uint64(i)); err != nil {
+			return err
+		}
+		record.Key = append(record.Key, field)
+	}
+	if record.Is_Insert() {
+		return nil
+	}
+
+	//delete mark的记录没有update vector
+	if record.Type_id != TRX_UNDO_DEL_MARK_REC {
+		n_fields, size, err := Mach_Read_Compressed(b, pos)
+		if err != nil {
+			return err
+		}
+		pos += size
+		for i := uint64(0); i < n_fields; i++ {
+			field_no, size, err := Mach_Read_Compressed(b, pos)
+			if err != nil {
+				return err
+			}
+			pos += size
+			var field *UndoRecordField
+			if field, pos, err = record.Read_Field(b, pos, field_no); err != nil {
+				return err
+			}
+			record.Update = append(record.Update, field)
+		}
+	}
+
+	//索引列的旧值, 前面2个字节是这部分的长度
+	if record.Type_id == TRX_UNDO_DEL_MARK_REC || record.Cmpl_info&UPD_NODE_NO_ORD_CHANGE == 0 {
+		if pos+2 > record.Next || pos+2 > uint64(len(b)) {
+			return nil
+		}
+		end := pos + uint64(BytesToUIntLittleEndian(b[pos:pos+2]))
+		pos += 2
+		for pos < end && end <= record.Next {
+			field_no, size, err := Mach_Read_Compressed(b, pos)
+			if err != nil {
+				return err
+			}
+			pos += size
+			var field *UndoRecordField
+			if field, pos, err = record.Read_Field(b, pos, field_no); err != nil {
+				return err
+			}
+			record.Ordering = append(record.Ordering, field)
+		}
+	}
+	return nil
+}
+
+// 读取一个字段: 长度(压缩格式) + 数据, 返回字段和下一个字段的位置
+func (record *UndoRecord) Read_Field(b []byte, pos uint64, field_no uint64) (*UndoRecordField, uint64, error) {
+	field := &UndoRecordField{Field_no: field_no}
+	length, size, err := Mach_Read_Compressed(b, pos)
+	if err != nil {
+		return field, pos, err
+	}
+	pos += size
+
+	if length == UNIV_SQL_NULL {
+		field.Is_null = true
+	} else if length == UNIV_EXTERN_STORAGE_FIELD {
+		//5.6以后外部存储的字段: 原始长度 + 本地前缀长度 + 数据
+		if _, size, err = Mach_Read_Compressed(b, pos); err != nil {
+			return field, pos, err
+		}
+		pos += size
+		if length, size, err = Mach_Read_Compressed(b, pos); err != nil {
+			return field, pos, err
+		}
+		pos += size
+		field.Extern = &ExternReference{}
+	} else if length > UNIV_EXTERN_STORAGE_FIELD {
+		length -= UNIV_EXTERN_STORAGE_FIELD
+		field.Extern = &ExternReference{}
+	}
+	field.Length = length
+
+	var meta *RecordFieldMeta
+	if field_no < uint64(len(record.fields)) {
+		meta = record.fields[field_no]
+		field.Name = meta.Name
+	}
+	//NULL字段后面没有数据
+	if field.Is_null {
+		field.Length = 0
+		return field, pos, nil
+	}
+
+	if pos+length > uint64(len(b)) {
+		return field, pos, fmt.Errorf("field %d at %d has %d bytes, beyond the page", field_no, pos, length)
+	}
+	data := b[pos : pos+length]
+	pos += length
+
+	switch {
+	case field.Extern != nil:
+		//外部存储的字段, 最后20个字节是指向blob页的引用
+		if length >= EXTERN_FIELD_SIZE {
+			ref := data[length-EXTERN_FIELD_SIZE:]
+			field.Extern = NewExternReference(uint64(BytesToUIntLittleEndian(ref[0:4])), uint64(BytesToUIntLittleEndian(ref[4:8])),
+				uint64(BytesToUIntLittleEndian(ref[8:12])), uint64(BytesToUIntLittleEndian(ref[12:20]))&0x3fffffff)
+			data = data[:length-EXTERN_FIELD_SIZE]
+		}
+		field.Value = hex.EncodeToString(data)
+	case meta != nil:
+		field.Value = meta.Value_From_Bytes(data)
+		if field.Value == nil {
+			field.Value = hex.EncodeToString(data)
+		}
+	default:
+		field.Value = hex.EncodeToString(data)
+	}
+	return field, pos, nil
+}
+
+func (record *UndoRecord) Dump() {
+	println("undo record:")
+
+	data, _ := json.Marshal(record)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -0,0 +1,99 @@
+package gibd
+
+import "testing"
+
+func TestMachU64ReadMuchCompressed(t *testing.T) {
+	b := []byte{0xFF, 0x01, 0x05, 0x7f}
+	value, size, err := Mach_U64_Read_Much_Compressed(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 0x100000005 || size != 3 {
+		t.Fatalf("got 0x%x size %d, want 0x100000005 size 3", value, size)
+	}
+	value, size, err = Mach_U64_Read_Much_Compressed(b, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 0x7f || size != 1 {
+		t.Fatalf("got 0x%x size %d, want 0x7f size 1", value, size)
+	}
+}
+
+// update vector里有NULL字段, NULL后面的字段也要能读出来
+func TestUndoRecordUpdateWithNull(t *testing.T) {
+	td, err := NewTableDescriber("CREATE TABLE `t` (\n  `id` int NOT NULL,\n  `a` int DEFAULT NULL,\n  `b` varchar(10) DEFAULT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB DEFAULT CHARSET=latin1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := []byte{
+		0x00, 0x00, // next, 后面填
+		TRX_UNDO_UPD_EXIST_REC | UPD_NODE_NO_ORD_CHANGE*TRX_UNDO_CMPL_INFO_MULT | TRX_UNDO_MODIFY_BLOB,
+		0xFF, 0x01, 0x05, // undo_no 0x100000005
+		0x20,                         // table_id
+		0x00,                         // 8.0的flags字节
+		0x00,                         // info_bits
+		0x00, 0x00, 0x00, 0x00, 0x10, // trx_id
+		0x20, 0x00, 0x00, 0x01, 0x10, // roll_ptr
+		0x04, 0x80, 0x00, 0x00, 0x07, // id = 7
+		0x02,                               // update vector的字段数
+		0x03, 0xF0, 0xFF, 0xFF, 0xFF, 0xFF, // a = NULL
+		0x04, 0x02, 'h', 'i', // b = "hi"
+	}
+	b[1] = byte(len(b))
+	record := NewUndoRecord(&Page{Buffer: &b}, 0)
+	if err := record.Parse(td); err != nil {
+		t.Fatal(err)
+	}
+
+	if record.Type_id != TRX_UNDO_UPD_EXIST_REC || record.Cmpl_info != UPD_NODE_NO_ORD_CHANGE {
+		t.Fatalf("type %d cmpl_info %d", record.Type_id, record.Cmpl_info)
+	}
+	if record.Undo_no != 0x100000005 || record.Table_id != 0x20 || record.Trx_id != 0x10 {
+		t.Fatalf("undo_no 0x%x table_id 0x%x trx_id 0x%x", record.Undo_no, record.Table_id, record.Trx_id)
+	}
+	if len(record.Key) != 1 || record.Key[0].Value != int64(7) {
+		t.Fatalf("key %+v", record.Key)
+	}
+	if len(record.Update) != 2 {
+		t.Fatalf("update has %d fields, want 2", len(record.Update))
+	}
+	a, v := record.Update[0], record.Update[1]
+	if !a.Is_null || a.Name != "a" || a.Value != nil {
+		t.Fatalf("field a %+v", a)
+	}
+	if v.Is_null || v.Name != "b" || v.Value != "hi" {
+		t.Fatalf("field b %+v", v)
+	}
+}
+
+// 记录被截断的时候返回error, 不能panic
+func TestUndoRecordTruncated(t *testing.T) {
+	if _, _, err := Mach_U64_Read_Much_Compressed([]byte{0xFF, 0x01}, 0); err == nil {
+		t.Fatal("want error for truncated much compressed number")
+	}
+	if _, _, err := Mach_Read_Compressed([]byte{0xE0, 0x00}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Mach_Read_Compressed([]byte{0xE0, 0x00}, 0); err == nil {
+		t.Fatal("want error for truncated compressed number")
+	}
+
+	b := []byte{
+		0x00, 0x40,
+		TRX_UNDO_UPD_EXIST_REC,
+		0x01,                         // undo_no
+		0x20,                         // table_id
+		0x00,                         // info_bits
+		0x00, 0x00, 0x00, 0x00, 0x10, // trx_id
+		0x20, 0x00, 0x00, 0x01, 0x10, // roll_ptr
+		0x08, 0x80, 0x00, // 主键长度8, 只剩2个字节
+	}
+	record := NewUndoRecord(&Page{Buffer: &b}, 0)
+	if err := record.Parse(nil); err == nil {
+		t.Fatal("want error for field beyond the page")
+	}
+	if record.Trx_id != 0x10 || len(record.Key) != 0 {
+		t.Fatalf("trx_id 0x%x key %+v", record.Trx_id, record.Key)
+	}
+}
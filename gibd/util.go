@@ -454,3 +454,69 @@ func BytesToUint32(b []byte) uint32 {
 	_ = b[3] // bounds check hint to compiler; see golang.org/issue/14808
 	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
 }
+
+// mach0data.h 中的压缩格式, 返回值和占用的字节数, 超出b的时候返回error
+// 0xxxxxxx 1字节, 10xxxxxx 2字节, 110xxxxx 3字节, 1110xxxx 4字节, 11110000 5字节
+func Mach_Read_Compressed(b []byte, pos uint64) (uint64, uint64, error) {
+	if pos >= uint64(len(b)) {
+		return 0, 0, fmt.Errorf("compressed number at %d is beyond %d bytes", pos, len(b))
+	}
+	flag := b[pos]
+	size := uint64(5)
+	switch {
+	case flag < 0x80:
+		size = 1
+	case flag < 0xC0:
+		size = 2
+	case flag < 0xE0:
+		size = 3
+	case flag < 0xF0:
+		size = 4
+	}
+	if pos+size > uint64(len(b)) {
+		return 0, 0, fmt.Errorf("compressed number at %d needs %d bytes, only %d left", pos, size, uint64(len(b))-pos)
+	}
+	switch size {
+	case 1:
+		return uint64(flag), 1, nil
+	case 2:
+		return uint64(BytesToUIntLittleEndian(b[pos:pos+2])) & 0x3FFF, 2, nil
+	case 3:
+		return uint64(BytesToUIntLittleEndian(b[pos:pos+3])) & 0x1FFFFF, 3, nil
+	case 4:
+		return uint64(BytesToUIntLittleEndian(b[pos:pos+4])) & 0xFFFFFFF, 4, nil
+	}
+	return uint64(BytesToUIntLittleEndian(b[pos+1 : pos+5])), 5, nil
+}
+
+// 高32位压缩, 低32位固定4字节
+func Mach_U64_Read_Compressed(b []byte, pos uint64) (uint64, uint64, error) {
+	high, size, err := Mach_Read_Compressed(b, pos)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pos+size+4 > uint64(len(b)) {
+		return 0, 0, fmt.Errorf("u64 compressed number at %d is beyond %d bytes", pos, len(b))
+	}
+	low := uint64(BytesToUIntLittleEndian(b[pos+size : pos+size+4]))
+	return high<<32 | low, size + 4, nil
+}
+
+// 第一个字节是0xFF的时候后面是高32位和低32位, 都是压缩格式, 否则和Mach_Read_Compressed一样
+func Mach_U64_Read_Much_Compressed(b []byte, pos uint64) (uint64, uint64, error) {
+	if pos >= uint64(len(b)) {
+		return 0, 0, fmt.Errorf("much compressed number at %d is beyond %d bytes", pos, len(b))
+	}
+	if b[pos] != 0xFF {
+		return Mach_Read_Compressed(b, pos)
+	}
+	high, high_size, err := Mach_Read_Compressed(b, pos+1)
+	if err != nil {
+		return 0, 0, err
+	}
+	low, low_size, err := Mach_Read_Compressed(b, pos+1+high_size)
+	if err != nil {
+		return 0, 0, err
+	}
+	return high<<32 | low, 1 + high_size + low_size, nil
+}
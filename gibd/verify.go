@@ -0,0 +1,387 @@
+package gibd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// 离线的CHECK TABLE, 参考btr_validate_index和page_validate
+const VERIFY_PAGE = "page"                 // 不是INDEX页或者读不出来
+const VERIFY_LEVEL = "level"               // 页的level和在树中的位置不一致
+const VERIFY_INDEX_ID = "index_id"         // 页的index id和root页不一致
+const VERIFY_SIBLING = "sibling"           // 同一层的prev/next链表不对
+const VERIFY_KEY_ORDER = "key_order"       // 页内或者相邻页之间的key不是递增的
+const VERIFY_NODE_POINTER = "node_pointer" // 父节点中的key和子页的最小key不一致
+const VERIFY_DIRECTORY = "directory"       // page directory的槽和n_owned不对
+const VERIFY_N_RECS = "n_recs"             // 页头的n_recs和记录链表的长度不一致
+const VERIFY_RECORD = "record"             // 记录解析不了
+const VERIFY_KEY_TYPE = "key_type"         // key中有不能排序的类型, 不检查key的顺序
+
+type IndexFinding struct {
+	Check       string `json:"check"`
+	Page_number uint64 `json:"page_number"`
+	Level       uint64 `json:"level"`
+	Offset      uint64 `json:"offset,omitempty"`
+	Message     string `json:"message"`
+}
+
+type IndexVerification struct {
+	Index_id          uint64          `json:"index_id"`
+	Root_page_number  uint64          `json:"root_page_number"`
+	Height            uint64          `json:"height"`
+	Pages             uint64          `json:"pages"`
+	Records           uint64          `json:"records"`
+	Findings          []*IndexFinding `json:"findings,omitempty"`
+	key_type_reported bool
+}
+
+// 比较两个值, 整数按数值比较, 字符串按字节比较(没有考虑collation), null最小
+// 其他类型(MBR, 还没有解析的类型)和类型不一样的值不能排序, 返回error
+func Compare_Values(a interface{}, b interface{}) (int, error) {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0, nil
+		case a == nil:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	}
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(int64); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case uint64:
+		if y, ok := b.(uint64); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+	return 0, fmt.Errorf("can not compare %T %v with %T %v", a, a, b, b)
+}
+
+// 按字段逐个比较, 前面的字段都相等的时候短的key小
+func Compare_Keys(a []interface{}, b []interface{}) (int, error) {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		c, err := Compare_Values(a[i], b[i])
+		if err != nil || c != 0 {
+			return c, err
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1, nil
+	case len(a) > len(b):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// 记录链表中的下一个记录, compact格式next是相对位置, redundant格式是绝对位置
+func (index *IndexPage) Next_Offset(offset uint64) uint64 {
+	next := uint64(BufferReadAt(index.Page, int64(offset)-2, 2))
+	if index.PageHeader.Format == "compact" {
+		if next == 0 {
+			return 0
+		}
+		return (offset + next) & (DEFAULT_PAGE_SIZE - 1)
+	}
+	return next
+}
+
+// 记录头中的n_owned
+func (index *IndexPage) N_Owned(offset uint64) uint64 {
+	if index.PageHeader.Format == "compact" {
+		return uint64(BufferReadAt(index.Page, int64(offset)-5, 1)) & 0x0f
+	}
+	return uint64(BufferReadAt(index.Page, int64(offset)-6, 1)) & 0x0f
+}
+
+// 从infimum到supremum的记录位置, 包括这两个系统记录
+func (index *IndexPage) Record_Chain() ([]uint64, error) {
+	offsets := []uint64{index.Pos_Infimum()}
+	offset := index.Pos_Infimum()
+	for offset != index.Pos_Supremum() {
+		offset = index.Next_Offset(offset)
+		if offset < index.Pos_Infimum() || offset >= DEFAULT_PAGE_SIZE {
+			return offsets, fmt.Errorf("record %d points to %d", offsets[len(offsets)-1], offset)
+		}
+		if uint64(len(offsets)) > index.PageHeader.N_heap {
+			return offsets, fmt.Errorf("record list is longer than n_heap %d", index.PageHeader.N_heap)
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets, nil
+}
+
+// page directory的槽, 从infimum开始
+func (index *IndexPage) Directory_Slot_Offsets() []uint64 {
+	var slots []uint64
+	pos := int64(index.Pos_Directory())
+	for i := uint64(0); i < index.Directory_Slots(); i++ {
+		pos -= PAGE_DIR_SLOT_SIZE
+		slots = append(slots, uint64(BufferReadAt(index.Page, pos, PAGE_DIR_SLOT_SIZE)))
+	}
+	return slots
+}
+
+func (v *IndexVerification) add(index *IndexPage, check string, offset uint64, format string, args ...interface{}) {
+	v.Findings = append(v.Findings, &IndexFinding{Check: check, Page_number: index.Page.Page_number,
+		Level: index.PageHeader.Level, Offset: offset, Message: fmt.Sprintf(format, args...)})
+}
+
+// 比较不了的时候只记一次, ok是false
+func (v *IndexVerification) compare_keys(index *IndexPage, a []interface{}, b []interface{}) (int, bool) {
+	c, err := Compare_Keys(a, b)
+	if err != nil {
+		if !v.key_type_reported {
+			v.key_type_reported = true
+			v.add(index, VERIFY_KEY_TYPE, 0, "%v", err)
+		}
+		return 0, false
+	}
+	return c, true
+}
+
+// 槽指向的记录要在链表里并且按顺序, 每个槽拥有的记录数等于槽记录的n_owned
+// infimum的槽只拥有自己, supremum的槽拥有1到8个, 其他槽拥有4到8个
+func (v *IndexVerification) verify_directory(index *IndexPage, chain []uint64) {
+	position := make(map[uint64]int)
+	for i, offset := range chain {
+		position[offset] = i
+	}
+	slots := index.Directory_Slot_Offsets()
+	if len(slots) < 2 {
+		v.add(index, VERIFY_DIRECTORY, 0, "page has %d directory slots", len(slots))
+		return
+	}
+	if slots[0] != index.Pos_Infimum() || slots[len(slots)-1] != index.Pos_Supremum() {
+		v.add(index, VERIFY_DIRECTORY, 0, "first slot is %d and last slot is %d", slots[0], slots[len(slots)-1])
+	}
+	owned := make(map[uint64]bool)
+	prev := -1
+	for i, slot := range slots {
+		pos, ok := position[slot]
+		if !ok {
+			v.add(index, VERIFY_DIRECTORY, slot, "slot %d points to %d which is not in the record list", i, slot)
+			continue
+		}
+		if pos <= prev {
+			v.add(index, VERIFY_DIRECTORY, slot, "slot %d is out of order", i)
+			continue
+		}
+		owned[slot] = true
+		n_owned := index.N_Owned(slot)
+		if uint64(pos-prev) != n_owned {
+			v.add(index, VERIFY_DIRECTORY, slot, "slot %d owns %d records but n_owned is %d", i, pos-prev, n_owned)
+		}
+		switch {
+		case i == 0:
+			if n_owned != 1 {
+				v.add(index, VERIFY_DIRECTORY, slot, "infimum n_owned is %d", n_owned)
+			}
+		case i == len(slots)-1:
+			if n_owned < 1 || n_owned > PAGE_DIR_SLOT_MAX_N_OWNED {
+				v.add(index, VERIFY_DIRECTORY, slot, "supremum n_owned %d is not between 1 and %d", n_owned, PAGE_DIR_SLOT_MAX_N_OWNED)
+			}
+		default:
+			if n_owned < PAGE_DIR_SLOT_MIN_N_OWNED || n_owned > PAGE_DIR_SLOT_MAX_N_OWNED {
+				v.add(index, VERIFY_DIRECTORY, slot, "slot %d n_owned %d is not between %d and %d",
+					i, n_owned, PAGE_DIR_SLOT_MIN_N_OWNED, PAGE_DIR_SLOT_MAX_N_OWNED)
+			}
+		}
+		prev = pos
+	}
+	for _, offset := range chain {
+		if !owned[offset] && index.N_Owned(offset) != 0 {
+			v.add(index, VERIFY_DIRECTORY, offset, "record is not a slot but n_owned is %d", index.N_Owned(offset))
+		}
+	}
+}
+
+// 检查一个页, 返回页上的用户记录
+func (v *IndexVerification) verify_page(index *IndexPage, level uint64) []*Record {
+	if !index.Is_Index_Page() {
+		v.add(index, VERIFY_PAGE, 0, "page type is %d", index.Page.FileHeader.Page_type)
+		return nil
+	}
+	if index.PageHeader.Index_id != v.Index_id {
+		v.add(index, VERIFY_INDEX_ID, 0, "index id is %d, root page has %d", index.PageHeader.Index_id, v.Index_id)
+	}
+	if index.PageHeader.Level != level {
+		v.add(index, VERIFY_LEVEL, 0, "level is %d, expected %d", index.PageHeader.Level, level)
+	}
+
+	chain, err := index.Record_Chain()
+	if err != nil {
+		v.add(index, VERIFY_RECORD, 0, "%v", err)
+	} else {
+		if uint64(len(chain)-2) != index.PageHeader.N_recs {
+			v.add(index, VERIFY_N_RECS, 0, "n_recs is %d but the record list has %d records", index.PageHeader.N_recs, len(chain)-2)
+		}
+		v.verify_directory(index, chain)
+	}
+
+	records, err := index.Each_Record_Checked()
+	if err != nil {
+		v.add(index, VERIFY_RECORD, 0, "%v", err)
+	}
+	// R-tree页上的记录不按MBR排序
+	for i := 1; i < len(records) && !index.Is_Rtree_Page(); i++ {
+		if c, ok := v.compare_keys(index, records[i-1].Key(), records[i].Key()); ok && c >= 0 {
+			v.add(index, VERIFY_KEY_ORDER, records[i].record.(*UserRecord).offset,
+				"key %v is not greater than the previous key %v", records[i].Key(), records[i-1].Key())
+		}
+	}
+	v.Pages++
+	if level == 0 {
+		v.Records += uint64(len(records))
+	}
+	return records
+}
+
+// 下一层要检查的页和父节点中指向它的node pointer, 只留key, 页在检查的时候再读
+type verify_child struct {
+	page_number uint64
+	parent      uint64
+	key         []interface{}
+	mbr         *MBR
+	min_rec     bool
+}
+
+// 一层一层往下走, 每一层的页按父节点中node pointer的顺序
+// 非叶子页的最小key等于node pointer的key; 叶子页上最左边的记录purge以后父节点不会改(btr_cur_pessimistic_delete),
+// 所以叶子页只要求node pointer的key <= 页上的最小key, 并且页上的key都小于下一个node pointer的key
+func (tree *BTreeIndex) Verify() *IndexVerification {
+	root := tree.Root
+	v := &IndexVerification{Index_id: root.PageHeader.Index_id, Root_page_number: root.Page.Page_number,
+		Height: root.PageHeader.Level + 1}
+	if root.Page.FileHeader.Prev != FIL_NULL || root.Page.FileHeader.Next != FIL_NULL {
+		v.add(root, VERIFY_SIBLING, 0, "root page has siblings prev %d next %d", root.Page.FileHeader.Prev, root.Page.FileHeader.Next)
+	}
+
+	visited := map[uint64]bool{root.Page.Page_number: true}
+	pages := []*verify_child{{page_number: root.Page.Page_number}}
+	for level := int64(root.PageHeader.Level); level >= 0 && len(pages) > 0; level-- {
+		var children []*verify_child
+		var last_key []interface{}
+		for i, child := range pages {
+			index := root
+			if level != int64(root.PageHeader.Level) {
+				index = tree.Page(child.page_number)
+			}
+			records := v.verify_page(index, uint64(level))
+
+			// 同一层的兄弟页
+			expected_prev, expected_next := uint64(FIL_NULL), uint64(FIL_NULL)
+			if i > 0 {
+				expected_prev = pages[i-1].page_number
+			}
+			if i < len(pages)-1 {
+				expected_next = pages[i+1].page_number
+			}
+			if index.Page.FileHeader.Prev != expected_prev {
+				v.add(index, VERIFY_SIBLING, 0, "prev is %d, expected %d", index.Page.FileHeader.Prev, expected_prev)
+			}
+			if index.Page.FileHeader.Next != expected_next {
+				v.add(index, VERIFY_SIBLING, 0, "next is %d, expected %d", index.Page.FileHeader.Next, expected_next)
+			}
+			if len(records) == 0 {
+				if index != root {
+					v.add(index, VERIFY_N_RECS, 0, "page has no records")
+				}
+				continue
+			}
+			first, last := records[0], records[len(records)-1]
+
+			// 相邻页之间的key
+			if last_key != nil && !index.Is_Rtree_Page() {
+				if c, ok := v.compare_keys(index, last_key, first.Key()); ok && c >= 0 {
+					v.add(index, VERIFY_KEY_ORDER, first.record.(*UserRecord).offset,
+						"first key %v is not greater than the last key %v of the previous page", first.Key(), last_key)
+				}
+			}
+			last_key = last.Key()
+
+			// 每层最左边的node pointer有min_rec标记, 不比较下界
+			// R-tree的node pointer的MBR要包含子页上所有记录的MBR
+			switch {
+			case index == root:
+			case index.Is_Rtree_Page():
+				for _, record := range records {
+					if child.mbr != nil && record.MBR() != nil && !child.mbr.Contains(record.MBR()) {
+						v.add(index, VERIFY_NODE_POINTER, record.record.(*UserRecord).offset,
+							"node pointer MBR %v on page %d does not contain MBR %v", *child.mbr, child.parent, *record.MBR())
+					}
+				}
+			case level > 0:
+				if c, ok := v.compare_keys(index, first.Key(), child.key); !child.min_rec && ok && c != 0 {
+					v.add(index, VERIFY_NODE_POINTER, first.record.(*UserRecord).offset,
+						"node pointer key %v on page %d is not the minimum key %v of the page", child.key, child.parent, first.Key())
+				}
+			default:
+				if c, ok := v.compare_keys(index, child.key, first.Key()); !child.min_rec && ok && c > 0 {
+					v.add(index, VERIFY_NODE_POINTER, first.record.(*UserRecord).offset,
+						"node pointer key %v on page %d is greater than the minimum key %v of the page", child.key, child.parent, first.Key())
+				}
+				if i < len(pages)-1 {
+					if c, ok := v.compare_keys(index, last.Key(), pages[i+1].key); ok && c >= 0 {
+						v.add(index, VERIFY_NODE_POINTER, last.record.(*UserRecord).offset,
+							"key %v is not less than the next node pointer key %v on page %d", last.Key(), pages[i+1].key, pages[i+1].parent)
+					}
+				}
+			}
+
+			if level == 0 {
+				continue
+			}
+			for _, record := range records {
+				child_page_number := record.record.(*UserRecord).Child_page_number
+				if visited[child_page_number] || child_page_number >= tree.Space.Pages {
+					v.add(index, VERIFY_NODE_POINTER, record.record.(*UserRecord).offset,
+						"node pointer points to page %d which is visited or out of the space", child_page_number)
+					continue
+				}
+				visited[child_page_number] = true
+				next := &verify_child{page_number: child_page_number, parent: index.Page.Page_number,
+					min_rec: record.record.(*UserRecord).header.Is_Min_Rec()}
+				if index.Is_Rtree_Page() {
+					next.mbr = record.MBR()
+				} else {
+					next.key = record.Key()
+				}
+				children = append(children, next)
+			}
+		}
+		pages = children
+	}
+	return v
+}
+
+func (v *IndexVerification) Dump() {
+	println("index verification:")
+
+	data, _ := json.Marshal(v)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"gibd/gibd"
+	"gibd/redo"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 var PAGE_DIRECTION = map[int]string{
@@ -69,13 +76,210 @@ func Print_System_Spaces(innodb_system *gibd.System) {
 
 }
 
+// 打开用户表的ibd文件, 有系统表空间的时候加到System中, 这样才能读到undo
+func Open_Table_Index(system_files []string, undo_files []string, table_space_file string, table_describer *gibd.TableDescriber) *gibd.BTreeIndex {
+	var space *gibd.Space
+	if len(system_files) > 0 && system_files[0] != "" {
+		innodb_system := Open_System(system_files, undo_files)
+		space = innodb_system.Add_Space_File([]string{table_space_file})
+	} else {
+		space = gibd.NewSpace([]string{table_space_file})
+	}
+	if space == nil {
+		return nil
+	}
+	tree := space.Get_Index_Tree(space.Clustered_Index_Root_Page_Number(), table_describer)
+	// MariaDB instant ADD以后root页中有n_core_fields, 减掉DB_TRX_ID和DB_ROLL_PTR就是instant ADD之前的字段数
+	if n_core := tree.Instant_Core_Fields(); n_core > 0 && table_describer != nil && !table_describer.Has_Instant_Columns() {
+		n := int(n_core) - 2
+		if table_describer.Has_Row_Id() {
+			n--
+		}
+		table_describer.Instant_Columns(n)
+	}
+	return tree
+}
+
+func Print_Rsegs(rsegs []*gibd.Rseg) {
+	for _, rseg := range rsegs {
+		fmt.Printf("rseg %d, space %d, page %d, history list length %d, undo slots %d\n",
+			rseg.Slot, rseg.Space_id, rseg.Page_number, rseg.History_List_Length(), len(rseg.Undo_slots))
+		for _, slot := range rseg.Undo_slots {
+			fmt.Printf("\tundo slot %d => page %d\n", slot.Slot, slot.Page_number)
+		}
+	}
+}
+
+// 一次读一个叶子页, 每条记录输出一行json, 读不了的页跳过
+func Print_Leaf_Page_Records(it *gibd.LeafPageIterator) {
+	for {
+		index, err := it.Next()
+		if err != nil {
+			println(err.Error())
+			continue
+		}
+		if index == nil {
+			return
+		}
+		for _, record := range index.Try_Each_Record() {
+			data, _ := json.Marshal(record.Get_Fields_And_Value_Map())
+			fmt.Printf("%s\n", data)
+		}
+	}
+}
+
+// 把数据文件复制到out_dir中, 不能覆盖原来的文件
+func Copy_Data_File(filename string, out_dir string) (string, error) {
+	src, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	dst, err := filepath.Abs(filepath.Join(out_dir, filepath.Base(filename)))
+	if err != nil {
+		return "", err
+	}
+	if src == dst {
+		return "", errors.New("output file " + dst + " is the input file")
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// 复制每组文件, 打开副本作为表空间
+func Copy_Spaces(file_groups [][]string, out_dir string) (map[uint64]*gibd.Space, error) {
+	if err := os.MkdirAll(out_dir, 0755); err != nil {
+		return nil, err
+	}
+	var copy_groups [][]string
+	for _, files := range file_groups {
+		var copies []string
+		for _, filename := range files {
+			if filename == "" {
+				continue
+			}
+			copied, err := Copy_Data_File(filename, out_dir)
+			if err != nil {
+				return nil, err
+			}
+			copies = append(copies, copied)
+		}
+		copy_groups = append(copy_groups, copies)
+	}
+	return Open_Spaces(copy_groups)
+}
+
+// 每组文件打开成一个表空间, 按space id索引
+func Open_Spaces(file_groups [][]string) (map[uint64]*gibd.Space, error) {
+	spaces := make(map[uint64]*gibd.Space)
+	for _, files := range file_groups {
+		var filenames []string
+		for _, filename := range files {
+			if filename != "" {
+				filenames = append(filenames, filename)
+			}
+		}
+		if len(filenames) == 0 {
+			continue
+		}
+		space := gibd.NewSpace(filenames)
+		if _, ok := spaces[space.Space_id]; ok {
+			return nil, fmt.Errorf("space id %d is in more than one file", space.Space_id)
+		}
+		spaces[space.Space_id] = space
+	}
+	return spaces, nil
+}
+
+// 系统表空间加上独立的undo表空间
+func Open_System(system_files []string, undo_files []string) *gibd.System {
+	innodb_system := gibd.NewSystem(system_files)
+	for _, undo_file := range undo_files {
+		if undo_file != "" {
+			innodb_system.Add_Space_File([]string{undo_file})
+		}
+	}
+	return innodb_system
+}
+
 func main() {
 
 	var file string
 	var page_no int
 	var mode string
+	var datadir string
+	var table_file string
+	var table_id uint64
+	var table_space_file string
+	var key string
+	var as_of_trx_id uint64
+	var undo_file string
+	var redo_path string
+	var start_lsn uint64
+	var end_lsn uint64
+	var out_dir string
+	var carve_step uint64
+	var index_id uint64
+	var index_name string
+	var lo string
+	var hi string
+	var limit uint64
+	var backward bool
+	var exclusive bool
+	var join bool
+	var sample_pages uint64
+	var seed int64
+	var column string
+	var buckets int
+	var use_sdi bool
+	var instant_cols int
+	var mbr string
+	var mbr_mode string
+	var word string
+	var doc_id uint64
 
 	flag.StringVar(&file, "s", "", "表空间文件名")
+	flag.StringVar(&datadir, "d", "", "数据目录")
+	flag.StringVar(&table_file, "t", "", "建表语句文件,没有数据字典的时候用来解析记录")
+	flag.Uint64Var(&table_id, "table-id", 0, "表id,解析undo记录的时候只解析这个表的记录; fts模式下只读这个表的辅助表")
+	flag.StringVar(&table_space_file, "f", "", "用户表的ibd文件")
+	flag.StringVar(&undo_file, "u", "", "独立的undo表空间文件,多个用逗号分隔")
+	flag.StringVar(&key, "k", "", "主键的值,多个字段用逗号分隔")
+	flag.Uint64Var(&as_of_trx_id, "trx-id", 0, "as-of模式下的事务id,只能看到这个事务以及之前的修改")
+	flag.StringVar(&redo_path, "r", "", "redo日志,可以是数据目录,#innodb_redo目录或者日志文件")
+	flag.Uint64Var(&start_lsn, "start-lsn", 0, "从这个lsn开始解析redo,默认从最早的checkpoint开始")
+	flag.Uint64Var(&end_lsn, "end-lsn", 0, "解析redo到这个lsn为止,默认到日志结尾")
+	flag.StringVar(&out_dir, "o", "", "输出目录,apply-redo把数据文件复制到这里再应用redo")
+	flag.Uint64Var(&carve_step, "step", gibd.CARVE_MIN_STEP, "carve模式下扫描的步长,512的倍数")
+	flag.Uint64Var(&index_id, "index-id", 0, "索引id,carve和dropped模式下只解析这个索引的记录; fts模式下只读这个FULLTEXT索引的辅助表")
+	flag.StringVar(&lo, "lo", "", "range模式下主键的下界,可以只给前面几个字段")
+	flag.StringVar(&hi, "hi", "", "range模式下主键的上界,可以只给前面几个字段")
+	flag.Uint64Var(&limit, "limit", 0, "range模式下最多输出的行数,0表示不限制")
+	flag.BoolVar(&backward, "backward", false, "range模式下从大到小输出")
+	flag.BoolVar(&exclusive, "exclusive", false, "range模式下不包括上下界")
+	flag.StringVar(&index_name, "i", "", "二级索引名,check-secondary模式下只检查这个索引,默认检查所有二级索引; cardinality和check-index模式下默认是聚簇索引")
+	flag.BoolVar(&join, "join", false, "dump-index模式下通过主键回表,同时输出聚簇索引中的行")
+	flag.Uint64Var(&sample_pages, "sample", 0, "cardinality模式下采样的叶子页数,和innodb_stats_persistent_sample_pages一样,0表示读所有叶子页")
+	flag.Int64Var(&seed, "seed", 0, "cardinality模式下随机采样的种子,0表示用当前时间")
+	flag.StringVar(&column, "c", "", "cardinality模式下输出这个字段的等高直方图")
+	flag.IntVar(&buckets, "buckets", 100, "直方图的桶数")
+	flag.BoolVar(&use_sdi, "sdi", false, "从-f指定的ibd文件的SDI中读表定义,不用-t")
+	flag.StringVar(&mbr, "mbr", "", "rtree-search模式下查询的MBR: xmin,xmax,ymin,ymax, 或者一个点x,y")
+	flag.StringVar(&mbr_mode, "mbr-mode", gibd.MBR_INTERSECT, "rtree-search模式下的查询方式: contain(记录包含查询的MBR), within(记录在查询的MBR里面), intersect")
+	flag.StringVar(&word, "word", "", "fts模式下只输出这个词")
+	flag.Uint64Var(&doc_id, "doc-id", 0, "fts模式下只输出包含这个FTS_DOC_ID的词")
+	flag.IntVar(&instant_cols, "instant-cols", -1, "MySQL 8.0.29之前instant ADD COLUMN之前的字段数,后面的字段是instant ADD加的")
 	//共享表空间第7块是数据字典头块
 	flag.IntVar(&page_no, "p", 7, "块号")
 	flag.StringVar(&mode, "m", "page-dump", "运行模式")
@@ -84,6 +288,34 @@ func main() {
 	flag.Parse()
 
 	file_arr := strings.Split(file, ",")
+	undo_file_arr := strings.Split(undo_file, ",")
+
+	var table_describer *gibd.TableDescriber
+	if table_file != "" {
+		var err error
+		table_describer, err = gibd.NewTableDescriberFromFile(table_file)
+		if err != nil {
+			fmt.Println("parse table file err ", err)
+			return
+		}
+		table_describer.Table_id = table_id
+	}
+	if use_sdi && table_space_file != "" {
+		var err error
+		space := gibd.NewSpace([]string{table_space_file})
+		if space == nil {
+			return
+		}
+		table_describer, err = space.Table_Describer_From_SDI()
+		if err != nil {
+			fmt.Println("read SDI err ", err)
+			return
+		}
+		table_describer.Table_id = table_id
+	}
+	if table_describer != nil && instant_cols >= 0 {
+		table_describer.Instant_Columns(instant_cols)
+	}
 
 	switch mode {
 	case "system-spaces":
@@ -99,11 +331,805 @@ func main() {
 		// if page.FileHeader.Page_type == 3 {
 		// 	//index := space.index(page_no)
 		// }
+	case "datadir-spaces":
+		innodb_system := gibd.NewSystemFromDatadir(datadir)
+		if innodb_system == nil {
+			return
+		}
+		innodb_system.Check_Datadir().Dump()
+	case "trx-sys":
+		innodb_system := Open_System(file_arr, undo_file_arr)
+		trx_sys := innodb_system.Trx_Sys()
+		trx_sys.Dump()
+		Print_Rsegs(trx_sys.Each_Rseg())
+		//8.0的undo表空间, 回滚段记录在RSEG_ARRAY页中
+		for _, space := range innodb_system.Each_Undo_Space() {
+			rseg_array := space.Rseg_Array()
+			if rseg_array == nil {
+				continue
+			}
+			fmt.Printf("undo space %d (number %d), %d rsegs\n", space.Space_id, gibd.Undo_Space_Number(space.Space_id), len(rseg_array.Rsegs))
+			Print_Rsegs(rseg_array.Each_Rseg())
+		}
 	case "page-dump":
 		space := gibd.NewSpace(file_arr)
+		if table_describer != nil {
+			space.Record_describer = table_describer
+		}
 		page := space.Page(uint64(page_no))
 		page.Page_Dump()
 
+	case "record-history":
+		// -s ibdata1 -f t.ibd -t t.sql -k 1
+		if table_describer == nil || table_space_file == "" {
+			println("record-history needs -f and -t")
+			return
+		}
+		tree := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if tree == nil {
+			return
+		}
+		it := tree.Record_Iterator(nil)
+		for {
+			record, err := it.Next()
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			if record == nil {
+				break
+			}
+			var record_key []string
+			for _, value := range record.Key() {
+				record_key = append(record_key, fmt.Sprint(value))
+			}
+			if key != "" && strings.Join(record_key, ",") != key {
+				continue
+			}
+			fmt.Printf("key: %v\n", record.Key())
+			if history := record.History(); history != nil {
+				history.Dump()
+			}
+		}
+
+	case "as-of":
+		// -s ibdata1 -f t.ibd -t t.sql -trx-id 1500, 输出trx-id提交时表中的数据
+		if table_describer == nil || table_space_file == "" || file == "" {
+			println("as-of needs -s, -f and -t")
+			return
+		}
+		tree := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if tree == nil {
+			return
+		}
+		view := gibd.NewReadViewAsOf(as_of_trx_id)
+		it := tree.Record_Iterator(nil)
+		for {
+			record, err := it.Next()
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			if record == nil {
+				break
+			}
+			if version := record.Version_As_Of(view); version != nil {
+				data, _ := json.Marshal(version.Fields)
+				fmt.Printf("%s\n", data)
+			}
+		}
+
+	case "redo":
+		// -r datadir, 输出日志文件头, checkpoint和checkpoint之后的日志记录
+		log, err := redo.NewLogFromPath(redo_path)
+		if err != nil {
+			fmt.Println("open redo log err ", err)
+			return
+		}
+		log.Dump()
+		if start_lsn == 0 {
+			checkpoint := log.Oldest_Checkpoint()
+			if checkpoint == nil {
+				println("no valid checkpoint, use -start-lsn")
+				return
+			}
+			start_lsn = checkpoint.Lsn
+		}
+		latest := log.Latest_Checkpoint()
+		pages := make(map[string]int)
+		for _, record := range log.Each_Record(start_lsn, end_lsn) {
+			if latest != nil && record.Lsn >= latest.Lsn && start_lsn < latest.Lsn {
+				fmt.Printf("---- checkpoint %d ----\n", latest.Lsn)
+				latest = nil
+			}
+			data, _ := json.Marshal(record)
+			fmt.Printf("%s\n", data)
+			if record.Has_Page() {
+				pages[fmt.Sprintf("%d:%d", record.Space_id, record.Page_number)]++
+			}
+		}
+		fmt.Printf("changed pages (space:page records): %v\n", pages)
+
+	case "apply-redo":
+		// -s ibdata1 -f t1.ibd,t2.ibd -u undo_001 -r datadir -o out -end-lsn 19560000
+		if redo_path == "" || out_dir == "" {
+			println("apply-redo needs -r and -o")
+			return
+		}
+		log, err := redo.NewLogFromPath(redo_path)
+		if err != nil {
+			fmt.Println("open redo log err ", err)
+			return
+		}
+		if start_lsn == 0 {
+			checkpoint := log.Latest_Checkpoint()
+			if checkpoint == nil {
+				println("no valid checkpoint, use -start-lsn")
+				return
+			}
+			start_lsn = checkpoint.Lsn
+		}
+		file_groups := [][]string{file_arr}
+		for _, filename := range append(strings.Split(table_space_file, ","), undo_file_arr...) {
+			file_groups = append(file_groups, []string{filename})
+		}
+		//先在内存中应用, 全部能应用的时候才复制文件写页, 否则不输出
+		spaces, err := Open_Spaces(file_groups)
+		if err != nil {
+			fmt.Println("open data files err ", err)
+			return
+		}
+		result, err := log.Apply(spaces, start_lsn, end_lsn)
+		if err != nil {
+			result.Dump()
+			fmt.Println("apply redo err ", err, ", nothing is written to", out_dir)
+			return
+		}
+		copies, err := Copy_Spaces(file_groups, out_dir)
+		if err != nil {
+			fmt.Println("copy data files err ", err)
+			return
+		}
+		if err := result.Flush(copies); err != nil {
+			fmt.Println("write pages err ", err)
+		}
+		result.Dump()
+
+	case "doublewrite":
+		// -s ibdata1 或者 -d datadir(8.0.20以后的dblwr文件), 加上 -f t.ibd -o out 用doublewrite修复t.ibd的副本
+		var dw_pages []*gibd.DoublewritePage
+		var system_files, dblwr_files []string
+		for _, filename := range file_arr {
+			if strings.HasSuffix(filename, ".dblwr") {
+				dblwr_files = append(dblwr_files, filename)
+			} else if filename != "" {
+				system_files = append(system_files, filename)
+			}
+		}
+		if datadir != "" {
+			dblwr_files = append(dblwr_files, gibd.Each_Doublewrite_File(datadir)...)
+		}
+		if len(system_files) > 0 {
+			dw_pages = append(dw_pages, gibd.NewSystem(system_files).Trx_Sys().Each_Doublewrite_Page()...)
+		}
+		for _, filename := range dblwr_files {
+			pages, err := gibd.Each_Doublewrite_File_Page(filename)
+			if err != nil {
+				fmt.Println("read doublewrite file err ", err)
+				return
+			}
+			dw_pages = append(dw_pages, pages...)
+		}
+		for _, dw_page := range dw_pages {
+			data, _ := json.Marshal(dw_page)
+			fmt.Printf("%s\n", data)
+		}
+		if table_space_file == "" || out_dir == "" {
+			return
+		}
+		spaces, err := Copy_Spaces([][]string{{table_space_file}}, out_dir)
+		if err != nil {
+			fmt.Println("copy data files err ", err)
+			return
+		}
+		for _, space := range spaces {
+			for _, repair := range space.Repair_From_Doublewrite(dw_pages) {
+				data, _ := json.Marshal(repair)
+				fmt.Printf("repair: %s\n", data)
+			}
+		}
+
+	case "ibuf":
+		// -s ibdata1, 输出change buffer中还没有合并的修改; 加上 -f t.ibd 输出t.ibd的bitmap中标记了有缓存修改的页
+		innodb_system := gibd.NewSystem(file_arr)
+		ibuf := innodb_system.Ibuf()
+		for _, record := range ibuf.Records {
+			data, _ := json.Marshal(record)
+			fmt.Printf("%s\n", data)
+		}
+		if ibuf.Reason != "" {
+			fmt.Printf("ibuf tree is not fully parsed: %s\n", ibuf.Reason)
+		}
+		for _, summary := range ibuf.Summary() {
+			fmt.Printf("space %d page %d: %v\n", summary.Space_id, summary.Page_number, summary.Ops)
+		}
+		if table_space_file != "" {
+			space := gibd.NewSpace([]string{table_space_file})
+			for page_number := uint64(0); page_number < space.Pages; page_number += gibd.DEFAULT_PAGE_SIZE {
+				for _, entry := range space.Ibuf_Bitmap(page_number).Each_Buffered() {
+					fmt.Printf("space %d page %d has buffered changes\n", space.Space_id, entry.Page_number)
+				}
+			}
+		}
+
+	case "dump-rows":
+		// -f t.ibd -t t.sql, 输出聚簇索引中没有delete mark的行
+		if table_describer == nil || table_space_file == "" {
+			println("dump-rows needs -f and -t")
+			return
+		}
+		tree := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if tree == nil {
+			return
+		}
+		it := tree.Record_Iterator(nil)
+		for {
+			record, err := it.Next()
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			if record == nil {
+				break
+			}
+			if record.Is_Deleted() {
+				continue
+			}
+			data, _ := json.Marshal(record.Get_Fields_And_Value_Map())
+			fmt.Printf("%s\n", data)
+		}
+
+	case "sdi":
+		// -f t.ibd, 输出MySQL 8.0 ibd文件中的SDI, 和ibd2sdi一样
+		if table_space_file == "" {
+			println("sdi needs -f")
+			return
+		}
+		space := gibd.NewSpace([]string{table_space_file})
+		if space == nil {
+			return
+		}
+		records, err := space.Each_SDI()
+		for _, record := range records {
+			data, _ := json.Marshal(record)
+			fmt.Printf("%s\n", data)
+		}
+		if err != nil {
+			println(err.Error())
+		}
+
+	case "row-id":
+		// -f t.ibd -t t.sql [-s ibdata1], 没有主键的表, 对比表中最大的DB_ROW_ID和数据字典头中的Max_row_id
+		if table_describer == nil || table_space_file == "" {
+			println("row-id needs -f and -t")
+			return
+		}
+		var innodb_system *gibd.System
+		var space *gibd.Space
+		if file != "" {
+			innodb_system = Open_System(file_arr, undo_file_arr)
+			space = innodb_system.Add_Space_File([]string{table_space_file})
+		} else {
+			space = gibd.NewSpace([]string{table_space_file})
+		}
+		if space == nil {
+			return
+		}
+		tree := space.Get_Index_Tree(space.Clustered_Index_Root_Page_Number(), table_describer)
+		stats, err := tree.Row_Id_Stats(table_describer, innodb_system)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		data, _ := json.Marshal(stats)
+		fmt.Printf("%s\n", data)
+
+	case "lookup":
+		// -f t.ibd -t t.sql -k 1, 从root页按主键找到一行, 不扫描所有叶子页
+		if table_describer == nil || table_space_file == "" || key == "" {
+			println("lookup needs -f, -t and -k")
+			return
+		}
+		key_values, err := table_describer.Parse_Key(key)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		if len(key_values) != len(table_describer.Primary) {
+			fmt.Printf("lookup needs all %d primary key values\n", len(table_describer.Primary))
+			return
+		}
+		tree := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if tree == nil {
+			return
+		}
+		record, err := tree.Find(key_values...)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		if record == nil {
+			println("key " + key + " not found")
+			return
+		}
+		if record.Is_Deleted() {
+			fmt.Printf("record on page %d is delete-marked\n", record.Page.Page_number)
+		}
+		data, _ := json.Marshal(record.Get_Fields_And_Value_Map())
+		fmt.Printf("%s\n", data)
+
+	case "range":
+		// -f t.ibd -t t.sql -lo 100 -hi 200 -limit 10 -backward, 按主键范围输出没有delete mark的行
+		if table_describer == nil || table_space_file == "" {
+			println("range needs -f and -t")
+			return
+		}
+		var bounds [2][]interface{}
+		for i, bound := range []string{lo, hi} {
+			if bound == "" {
+				continue
+			}
+			values, err := table_describer.Parse_Key(bound)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			bounds[i] = values
+		}
+		tree := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if tree == nil {
+			return
+		}
+		direction := "forward"
+		if backward {
+			direction = "backward"
+		}
+		r := tree.Range(bounds[0], bounds[1], !exclusive, direction, limit)
+		record, err := r.Next()
+		for ; record != nil; record, err = r.Next() {
+			data, _ := json.Marshal(record.Get_Fields_And_Value_Map())
+			fmt.Printf("%s\n", data)
+		}
+		if err != nil {
+			println(err.Error())
+		}
+
+	case "undelete":
+		// -f t.ibd -t t.sql, 输出delete mark的记录和PAGE_FREE链表中的记录
+		if table_describer == nil || table_space_file == "" {
+			println("undelete needs -f and -t")
+			return
+		}
+		tree := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if tree == nil {
+			return
+		}
+		it := tree.Page_Iterator(0, nil)
+		index, err := it.Next()
+		for ; index != nil; index, err = it.Next() {
+			for _, deleted := range index.Each_Deleted_Record() {
+				data, _ := json.Marshal(deleted)
+				fmt.Printf("%s\n", data)
+			}
+		}
+		if err != nil {
+			println(err.Error())
+		}
+
+	case "carve":
+		// -f 磁盘镜像或者损坏的文件,多个用逗号分隔, -t 解析记录, -o 把找到的页写成ibd文件
+		if table_space_file == "" {
+			println("carve needs -f")
+			return
+		}
+		var pages []*gibd.CarvedPage
+		for _, filename := range strings.Split(table_space_file, ",") {
+			carved, err := gibd.Carve_File(filename, carve_step)
+			if err != nil {
+				fmt.Println("carve file err ", err)
+			}
+			pages = append(pages, carved...)
+		}
+		for _, carved_space := range gibd.Group_Carved_Pages(pages) {
+			carved_space.Dump()
+			if out_dir != "" {
+				filename := filepath.Join(out_dir, fmt.Sprintf("space_%d.ibd", carved_space.Space_id))
+				if err := carved_space.Write_File(filename); err != nil {
+					fmt.Println("write carved space err ", err)
+				} else {
+					fmt.Printf("space %d written to %s\n", carved_space.Space_id, filename)
+				}
+			}
+			if table_describer == nil {
+				continue
+			}
+			for i, carved_index := range carved_space.Indexes() {
+				if (index_id == 0 && i > 0) || (index_id != 0 && carved_index.Index_id != index_id) {
+					continue
+				}
+				Print_Leaf_Page_Records(carved_space.Leaf_Page_Iterator(carved_index, table_describer))
+			}
+		}
+
+	case "dropped":
+		// -s ibdata1, -index-id 删除的索引id(不指定的时候从SYS_INDEXES中删除的记录里找), -t 解析记录
+		innodb_system := Open_System(file_arr, undo_file_arr)
+		var index_ids []uint64
+		if index_id != 0 {
+			index_ids = append(index_ids, index_id)
+		}
+		dropped := innodb_system.Each_Dropped_Index(index_ids)
+		for _, index := range dropped {
+			data, _ := json.Marshal(index)
+			fmt.Printf("%s\n", data)
+		}
+		if table_describer == nil {
+			break
+		}
+		for _, index := range dropped {
+			if (index_id != 0 && index.Index_id != index_id) || (index_id == 0 && len(dropped) > 1) {
+				continue
+			}
+			Print_Leaf_Page_Records(innodb_system.Dropped_Index_Page_Iterator(index, table_describer))
+		}
+		if index_id == 0 && len(dropped) > 1 {
+			println("more than one dropped index found, use -index-id to choose the one to decode with -t")
+		}
+
+	case "check-index":
+		// -f t.ibd -t t.sql [-i idx], 检查聚簇索引(或者-i指定的索引)的结构, 每个问题输出一行json, 最后一行是汇总
+		if table_describer == nil || table_space_file == "" {
+			println("check-index needs -f and -t")
+			return
+		}
+		tree := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if tree == nil {
+			return
+		}
+		if index_name != "" && !strings.EqualFold(index_name, table_describer.Clustered_index) {
+			var err error
+			if tree, err = tree.Space.Secondary_Index_Tree(table_describer, index_name); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+		verification := tree.Verify()
+		for _, finding := range verification.Findings {
+			data, _ := json.Marshal(finding)
+			fmt.Printf("%s\n", data)
+		}
+		findings := verification.Findings
+		verification.Findings = nil
+		data, _ := json.Marshal(struct {
+			*gibd.IndexVerification
+			N_findings int `json:"n_findings"`
+		}{verification, len(findings)})
+		fmt.Printf("%s\n", data)
+
+	case "dump-index":
+		// -f t.ibd -t t.sql -i idx [-join], 输出二级索引中没有delete mark的记录
+		if table_describer == nil || table_space_file == "" || index_name == "" {
+			println("dump-index needs -f, -t and -i")
+			return
+		}
+		clustered := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if clustered == nil {
+			return
+		}
+		secondary, err := clustered.Space.Secondary_Index_Tree(table_describer, index_name)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		it := secondary.Record_Iterator(nil)
+		for {
+			record, err := it.Next()
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			if record == nil {
+				break
+			}
+			if record.Is_Deleted() {
+				continue
+			}
+			entry := record.Get_Fields_And_Value_Map()
+			if !join {
+				data, _ := json.Marshal(entry)
+				fmt.Printf("%s\n", data)
+				continue
+			}
+			// 聚簇索引中找不到或者已经delete mark的时候row是null
+			var row map[string]interface{}
+			clustered_record, err := clustered.Clustered_Record(record, table_describer)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			if clustered_record != nil && !clustered_record.Is_Deleted() {
+				row = clustered_record.Get_Fields_And_Value_Map()
+			}
+			data, _ := json.Marshal(map[string]interface{}{"entry": entry, "row": row})
+			fmt.Printf("%s\n", data)
+		}
+
+	case "rtree-search":
+		// -f t.ibd -t t.sql -i sp_idx -mbr 0,10,0,10 [-mbr-mode within] [-join], 从空间索引的root页往下找MBR满足条件的记录
+		if table_describer == nil || table_space_file == "" || index_name == "" || mbr == "" {
+			println("rtree-search needs -f, -t, -i and -mbr")
+			return
+		}
+		query, err := gibd.Parse_MBR(mbr)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		clustered := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if clustered == nil {
+			return
+		}
+		spatial, err := clustered.Space.Secondary_Index_Tree(table_describer, index_name)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		entries, err := spatial.Search_MBR(query, mbr_mode)
+		for _, entry := range entries {
+			if !join {
+				data, _ := json.Marshal(entry)
+				fmt.Printf("%s\n", data)
+				continue
+			}
+			var key []interface{}
+			for _, name := range table_describer.Primary {
+				key = append(key, entry.Fields[name])
+			}
+			var row map[string]interface{}
+			clustered_record, err := clustered.Find(key...)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			if clustered_record != nil && !clustered_record.Is_Deleted() {
+				row = clustered_record.Get_Fields_And_Value_Map()
+			}
+			data, _ := json.Marshal(map[string]interface{}{"entry": entry, "row": row})
+			fmt.Printf("%s\n", data)
+		}
+		if err != nil {
+			println(err.Error())
+		}
+
+	case "fts":
+		// -d 数据库目录 [-table-id 1081] [-index-id 1184] [-word w] [-doc-id 5] [-t t.sql], 读FULLTEXT索引的辅助表
+		// 先输出CONFIG和DELETED这些表, 然后INDEX_1..6中每个词一行json, ilist解析成doc id和位置
+		if datadir == "" {
+			println("fts needs -d")
+			return
+		}
+		tables, err := gibd.Fts_Aux_Tables(datadir)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		// word字段和FULLTEXT索引的第一个字段一样, 没有建表语句的时候按utf8mb4, 可以为NULL
+		charset, nullable := "utf8mb4", true
+		if table_describer != nil && len(table_describer.Fulltext) > 0 {
+			if c := table_describer.Column(table_describer.Fulltext[0].Columns[0]); c != nil {
+				charset, nullable = c.Charset, c.Nullable
+			}
+		}
+		// 每个表的DELETED和BEING_DELETED中的doc, 查询的时候会过滤掉
+		deleted := make(map[uint64]map[uint64]bool)
+		for _, aux := range tables {
+			if (table_id != 0 && aux.Table_id != table_id) || aux.Is_Index() {
+				continue
+			}
+			output := map[string]interface{}{"table_id": aux.Table_id, "table": aux.Name}
+			if aux.Name == "CONFIG" {
+				config, err := aux.Config()
+				if err != nil {
+					output["error"] = err.Error()
+				}
+				output["config"] = config
+			} else {
+				doc_ids, err := aux.Doc_Ids()
+				if err != nil {
+					output["error"] = err.Error()
+				}
+				if deleted[aux.Table_id] == nil {
+					deleted[aux.Table_id] = make(map[uint64]bool)
+				}
+				for _, id := range doc_ids {
+					deleted[aux.Table_id][id] = true
+				}
+				output["doc_ids"] = doc_ids
+			}
+			data, _ := json.Marshal(output)
+			fmt.Printf("%s\n", data)
+		}
+		td := gibd.NewFtsIndexTableDescriber(charset, nullable)
+		for _, aux := range tables {
+			if (table_id != 0 && aux.Table_id != table_id) || (index_id != 0 && aux.Index_id != index_id) || !aux.Is_Index() {
+				continue
+			}
+			tree, err := aux.Tree(td)
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			it := tree.Record_Iterator(nil)
+			for {
+				record, err := it.Next()
+				if err != nil {
+					println(aux.File + ": " + err.Error())
+					continue
+				}
+				if record == nil {
+					break
+				}
+				if record.Is_Deleted() {
+					continue
+				}
+				token := aux.Token(tree, record)
+				if word != "" && !strings.EqualFold(token.Word, word) {
+					continue
+				}
+				var docs []*gibd.FtsDoc
+				for _, doc := range token.Docs {
+					doc.Deleted = deleted[aux.Table_id][doc.Doc_id]
+					if doc_id == 0 || doc.Doc_id == doc_id {
+						docs = append(docs, doc)
+					}
+				}
+				if doc_id != 0 {
+					if len(docs) == 0 {
+						continue
+					}
+					token.Docs = docs
+				}
+				data, _ := json.Marshal(token)
+				fmt.Printf("%s\n", data)
+			}
+		}
+
+	case "index-stats":
+		// -f t.ibd [-t t.sql] 或者 -s ibdata1, 每个索引输出一行json: 高度, 每层的页数, 填充率和碎片
+		var innodb_system *gibd.System
+		var space *gibd.Space
+		switch {
+		case table_space_file != "" && file != "":
+			innodb_system = Open_System(file_arr, undo_file_arr)
+			space = innodb_system.Add_Space_File([]string{table_space_file})
+		case table_space_file != "":
+			space = gibd.NewSpace([]string{table_space_file})
+		case file != "":
+			innodb_system = Open_System(file_arr, undo_file_arr)
+			space = innodb_system.System_Space()
+		default:
+			println("index-stats needs -f or -s")
+			return
+		}
+		if space == nil {
+			return
+		}
+		// 索引名从数据字典(SDI或者SYS_INDEXES)里找, 没有的时候按建表语句中索引的顺序猜
+		names := make(map[uint64]string)
+		for name, root := range space.Index_Root_Page_Numbers() {
+			names[root] = name
+		}
+		if len(names) == 0 && table_describer != nil && !space.IsSystemSpace {
+			for i, root := range space.Each_Index_Root_Page_Number(innodb_system) {
+				if i == 0 {
+					names[root] = table_describer.Clustered_index
+				} else if i <= len(table_describer.Indexes) {
+					names[root] = table_describer.Indexes[i-1].Name
+				}
+			}
+		}
+		for _, stats := range space.Index_Stats(innodb_system) {
+			stats.Name = names[stats.Root_page_number]
+			data, _ := json.Marshal(stats)
+			fmt.Printf("%s\n", data)
+		}
+
+	case "cardinality":
+		// -f t.ibd -t t.sql [-i idx] [-sample 20] [-c col], 输出和mysql.innodb_index_stats一样的n_diff_pfx, 有-c的时候再输出一行直方图
+		if table_describer == nil || table_space_file == "" {
+			println("cardinality needs -f and -t")
+			return
+		}
+		tree := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if tree == nil {
+			return
+		}
+		td, name := table_describer, table_describer.Clustered_index
+		if index_name != "" && !strings.EqualFold(index_name, table_describer.Clustered_index) {
+			var err error
+			if td, err = table_describer.Secondary_Describer(index_name); err != nil {
+				println(err.Error())
+				return
+			}
+			if tree, err = tree.Space.Secondary_Index_Tree(table_describer, index_name); err != nil {
+				println(err.Error())
+				return
+			}
+			name = td.Name[len(table_describer.Name)+1:]
+		}
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		result, err := tree.Cardinality(td, name, sample_pages, seed)
+		if err != nil {
+			println(err.Error())
+		}
+		data, _ := json.Marshal(result)
+		fmt.Printf("%s\n", data)
+		if column == "" {
+			return
+		}
+		histogram, err := tree.Column_Histogram(td, column, buckets, sample_pages, seed)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		data, _ = json.Marshal(histogram)
+		fmt.Printf("%s\n", data)
+
+	case "check-secondary":
+		// -f t.ibd -t t.sql [-i idx], 二级索引和聚簇索引的记录一一对应, 每个问题输出一行json, 每个索引一行汇总
+		if table_describer == nil || table_space_file == "" {
+			println("check-secondary needs -f and -t")
+			return
+		}
+		clustered := Open_Table_Index(file_arr, undo_file_arr, table_space_file, table_describer)
+		if clustered == nil {
+			return
+		}
+		var index_names []string
+		if index_name != "" {
+			index_names = append(index_names, index_name)
+		}
+		for _, index := range table_describer.Indexes {
+			if index_name == "" && !index.Spatial {
+				index_names = append(index_names, index.Name)
+			}
+		}
+		for _, name := range index_names {
+			secondary, err := clustered.Space.Secondary_Index_Tree(table_describer, name)
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			result, err := gibd.Check_Secondary_Index(clustered, secondary, table_describer, name)
+			if err != nil {
+				println(err.Error())
+				continue
+			}
+			for _, finding := range result.Findings {
+				data, _ := json.Marshal(finding)
+				fmt.Printf("%s\n", data)
+			}
+			findings := result.Findings
+			result.Findings = nil
+			data, _ := json.Marshal(struct {
+				*gibd.SecondaryConsistency
+				N_findings int `json:"n_findings"`
+			}{result, len(findings)})
+			fmt.Printf("%s\n", data)
+		}
+
 	default:
 		println("no match mode")
 	}
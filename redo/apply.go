@@ -0,0 +1,625 @@
+package redo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gibd/gibd"
+	"sort"
+
+	"github.com/tidwall/pretty"
+)
+
+// 把日志记录应用到数据页上 log0recv.cc recv_parse_or_apply_log_rec_body
+// 支持物理日志, undo页和非压缩索引页上的逻辑日志(page.go), 压缩页的日志不支持,
+// 碰到不支持的日志或者解析不了的日志时整个应用失败, 不写任何页
+const FIL_PAGE_OFFSET = 4
+const FIL_PAGE_LSN = 16
+const FIL_PAGE_TYPE = 24
+const FIL_PAGE_SPACE_ID = 34
+const FIL_PAGE_DATA = 38
+const FIL_PAGE_END_LSN_OLD_CHKSUM = 8
+
+// trx0undo.h
+const TRX_UNDO_PAGE_HDR = FIL_PAGE_DATA
+const TRX_UNDO_PAGE_TYPE = 0
+const TRX_UNDO_PAGE_START = 2
+const TRX_UNDO_PAGE_FREE = 4
+const TRX_UNDO_PAGE_HDR_SIZE = 6 + 12
+const TRX_UNDO_SEG_HDR = TRX_UNDO_PAGE_HDR + TRX_UNDO_PAGE_HDR_SIZE
+const TRX_UNDO_STATE = 0
+const TRX_UNDO_LAST_LOG = 2
+const TRX_UNDO_SEG_HDR_SIZE = 4 + 10 + 16
+const TRX_UNDO_INSERT = 1
+const TRX_UNDO_ACTIVE = 1
+const TRX_UNDO_CACHED = 2
+
+// undo log header, 8.0里TRX_UNDO_XID_EXISTS是TRX_UNDO_FLAGS
+const TRX_UNDO_TRX_ID = 0
+const TRX_UNDO_DEL_MARKS = 16
+const TRX_UNDO_LOG_START = 18
+const TRX_UNDO_XID_EXISTS = 20
+const TRX_UNDO_DICT_TRANS = 21
+const TRX_UNDO_NEXT_LOG = 30
+const TRX_UNDO_PREV_LOG = 32
+const TRX_UNDO_LOG_OLD_HDR_SIZE = 34 + 12
+const TRX_UNDO_LOG_XA_HDR_SIZE = TRX_UNDO_LOG_OLD_HDR_SIZE + 4 + 4 + 4 + 128
+
+// ibuf0ibuf.h, 16k的页每页4个bit
+const IBUF_BITMAP = FIL_PAGE_DATA
+const IBUF_BITS_PER_PAGE = 4
+
+// 记录头中info bits所在的字节相对记录原点的位置
+const REC_NEW_INFO_BITS = 5
+const REC_OLD_INFO_BITS = 6
+const REC_OLD_SHORT = 3
+const REC_N_OLD_EXTRA_BYTES = 6
+const REC_N_NEW_EXTRA_BYTES = 5
+const REC_INFO_MIN_REC_FLAG = 0x10
+const REC_INFO_DELETED_FLAG = 0x20
+const REC_INFO_BITS_MASK = 0xF0
+
+// btr0cur.h, 有这个标记的时候不修改DB_TRX_ID和DB_ROLL_PTR
+const BTR_KEEP_SYS_FLAG = 4
+
+var ErrNotApplicable = errors.New("log record can not be applied to this page")
+
+// COMP记录中带的索引字段信息, mlog_parse_index
+type IndexFieldInfo struct {
+	Fixed_len uint64 `json:"fixed_len"` // 0表示变长
+	Not_null  bool   `json:"not_null"`
+	Big       bool   `json:"big"` // 变长字段最大长度超过255, 长度可能用2个字节存
+}
+
+// 每个页的应用情况
+type PageApply struct {
+	Space_id    uint64   `json:"space_id"`
+	Page_number uint64   `json:"page_number"`
+	Applied     int      `json:"applied"`               // 应用的记录数
+	Skipped     int      `json:"skipped"`               // 页上的lsn比日志新, 不需要应用的记录数
+	Lsn         uint64   `json:"lsn"`                   // 应用以后页上的lsn
+	Unsupported []string `json:"unsupported,omitempty"` // 不支持的记录类型, 这个页没有恢复到目标lsn
+	Data        []byte   `json:"-"`
+}
+
+type ApplyResult struct {
+	Start_lsn      uint64       `json:"start_lsn"`
+	Target_lsn     uint64       `json:"target_lsn"`
+	End_lsn        uint64       `json:"end_lsn"` // 最后一个应用了的mtr的结束lsn
+	Mtrs           int          `json:"mtrs"`
+	Records        int          `json:"records"`
+	Incomplete     int          `json:"incomplete"`       // 日志结尾或者目标lsn处没有结束的mtr, 没有应用
+	Unparsed       int          `json:"unparsed"`         // 解析不了的日志段, 所在的mtr没有应用
+	Errors         []string     `json:"errors,omitempty"` // 解析不了或者应用不了的日志, 有的时候不写页
+	Missing_spaces []uint64     `json:"missing_spaces,omitempty"`
+	Pages          []*PageApply `json:"pages"`
+	pages          map[string]*PageApply
+	spaces         map[uint64]*gibd.Space
+}
+
+// 从start_lsn开始把日志应用到spaces中的页上, 直到target_lsn(0表示日志结尾), 只应用完整的mtr
+// 只在内存中修改页, 不写文件; 有日志解析不了或者应用不了的时候返回错误, 这时候结果不是crash一致的, 不能Flush
+func (log *Log) Apply(spaces map[uint64]*gibd.Space, start_lsn uint64, target_lsn uint64) (*ApplyResult, error) {
+	result := &ApplyResult{Start_lsn: start_lsn, Target_lsn: target_lsn, End_lsn: start_lsn,
+		pages: make(map[string]*PageApply), spaces: spaces}
+	missing := make(map[uint64]bool)
+
+	var mtr []*LogRecord
+	for _, record := range log.Each_Record(start_lsn, target_lsn) {
+		if record.Error != "" {
+			//整个mtr都不能用了
+			result.Unparsed++
+			result.Errors = append(result.Errors, fmt.Sprintf("lsn %d %s: %s", record.Lsn, record.Type_name, record.Error))
+			mtr = nil
+			continue
+		}
+		mtr = append(mtr, record)
+		if !record.Single && record.Type != MLOG_MULTI_REC_END {
+			continue
+		}
+		if target_lsn != 0 && record.End_lsn > target_lsn {
+			break
+		}
+		for _, r := range mtr {
+			if !r.Has_Page() || Is_File_Operation(r.Type) {
+				continue
+			}
+			if spaces[r.Space_id] == nil {
+				missing[r.Space_id] = true
+				continue
+			}
+			result.Apply_Record(r, mtr[0].Lsn, record.End_lsn)
+			result.Records++
+		}
+		result.Mtrs++
+		result.End_lsn = record.End_lsn
+		mtr = nil
+	}
+	if len(mtr) > 0 {
+		result.Incomplete++
+	}
+
+	for space_id := range missing {
+		result.Missing_spaces = append(result.Missing_spaces, space_id)
+	}
+	sort.Slice(result.Missing_spaces, func(i, j int) bool { return result.Missing_spaces[i] < result.Missing_spaces[j] })
+
+	for _, page := range result.pages {
+		result.Pages = append(result.Pages, page)
+	}
+	sort.Slice(result.Pages, func(i, j int) bool {
+		if result.Pages[i].Space_id != result.Pages[j].Space_id {
+			return result.Pages[i].Space_id < result.Pages[j].Space_id
+		}
+		return result.Pages[i].Page_number < result.Pages[j].Page_number
+	})
+	for _, page := range result.Pages {
+		for _, reason := range page.Unsupported {
+			result.Errors = append(result.Errors, fmt.Sprintf("space %d page %d %s", page.Space_id, page.Page_number, reason))
+		}
+	}
+	if len(result.Errors) > 0 {
+		return result, fmt.Errorf("%d log records can not be applied, first: %s", len(result.Errors), result.Errors[0])
+	}
+	return result, nil
+}
+
+// 不修改页的记录
+func Is_File_Operation(mlog_type uint64) bool {
+	switch mlog_type {
+	case MLOG_FILE_CREATE, MLOG_FILE_RENAME, MLOG_FILE_DELETE, MLOG_FILE_CREATE2, MLOG_FILE_RENAME2,
+		MLOG_FILE_NAME, MLOG_FILE_EXTEND, MLOG_TRUNCATE, MLOG_INDEX_LOAD, MLOG_LSN:
+		return true
+	}
+	return false
+}
+
+// 建页的记录, 页可以不在文件中
+func Is_Page_Init(mlog_type uint64) bool {
+	return mlog_type == MLOG_INIT_FILE_PAGE || mlog_type == MLOG_INIT_FILE_PAGE2
+}
+
+func (result *ApplyResult) Page(space_id uint64, page_number uint64, mlog_type uint64) *PageApply {
+	key := fmt.Sprintf("%d:%d", space_id, page_number)
+	if page, ok := result.pages[key]; ok {
+		return page
+	}
+	page := &PageApply{Space_id: space_id, Page_number: page_number}
+	space := result.spaces[space_id]
+	if page_number < space.Pages {
+		page.Data = space.Page_Data(page_number)
+		page.Lsn = Read_Uint(page.Data, FIL_PAGE_LSN, 8)
+	} else if Is_Page_Init(mlog_type) {
+		page.Data = make([]byte, gibd.DEFAULT_PAGE_SIZE)
+	}
+	result.pages[key] = page
+	return page
+}
+
+// mtr_start_lsn之前页上已经有的修改不再应用, 应用以后页上的lsn是mtr结束的lsn
+func (result *ApplyResult) Apply_Record(record *LogRecord, mtr_start_lsn uint64, mtr_end_lsn uint64) {
+	page := result.Page(record.Space_id, record.Page_number, record.Type)
+	if len(page.Unsupported) > 0 {
+		return
+	}
+	if page.Data == nil {
+		page.Unsupported = append(page.Unsupported, "page not in file")
+		return
+	}
+	if page.Lsn > mtr_start_lsn {
+		page.Skipped++
+		return
+	}
+	if err := Apply_Body(page.Data, record); err != nil {
+		page.Unsupported = append(page.Unsupported, record.Type_name+": "+err.Error())
+		return
+	}
+	page.Applied++
+	page.Lsn = mtr_end_lsn
+}
+
+// 把改过的页写到spaces中, 更新页上的lsn和checksum, spaces要是数据文件的副本
+func (result *ApplyResult) Flush(spaces map[uint64]*gibd.Space) error {
+	if len(result.Errors) > 0 {
+		return errors.New("redo is not fully applied, refuse to write pages")
+	}
+	for _, page := range result.Pages {
+		if page.Applied == 0 {
+			continue
+		}
+		Write_Uint(page.Data, FIL_PAGE_LSN, 8, page.Lsn)
+		Write_Uint(page.Data, gibd.DEFAULT_PAGE_SIZE-FIL_PAGE_END_LSN_OLD_CHKSUM+4, 4, page.Lsn&0xFFFFFFFF)
+		checksum := gibd.Page_Checksum_Crc32(page.Data)
+		Write_Uint(page.Data, 0, 4, checksum)
+		Write_Uint(page.Data, gibd.DEFAULT_PAGE_SIZE-FIL_PAGE_END_LSN_OLD_CHKSUM, 4, checksum)
+		space := spaces[page.Space_id]
+		if space == nil {
+			return fmt.Errorf("space %d is not in the output", page.Space_id)
+		}
+		if err := space.Write_Page(page.Page_number, page.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按类型修改页的内容, 不支持的类型返回ErrUnsupported
+func Apply_Body(page []byte, record *LogRecord) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok && (e == ErrTruncated || e == ErrNotApplicable) {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	reader := NewMlogReader(record.Body, 0)
+	index := reader.Read_Log_Index(record.Type)
+	switch Unversioned_Type(record.Type) {
+	case MLOG_1BYTE, MLOG_2BYTES, MLOG_4BYTES:
+		offset := reader.Read_Uint(2)
+		value := reader.Read_Compressed()
+		Page_Write_Uint(page, offset, record.Type, value)
+	case MLOG_8BYTES:
+		offset := reader.Read_Uint(2)
+		Page_Write_Uint(page, offset, 8, reader.Read_U64_Compressed())
+	case MLOG_WRITE_STRING:
+		offset := reader.Read_Uint(2)
+		data := reader.Read_Bytes(reader.Read_Uint(2))
+		Page_Write_Bytes(page, offset, data)
+
+	case MLOG_INIT_FILE_PAGE, MLOG_INIT_FILE_PAGE2:
+		// fsp_init_file_page_low
+		for i := range page {
+			page[i] = 0
+		}
+		Write_Uint(page, FIL_PAGE_OFFSET, 4, record.Page_number)
+		Write_Uint(page, FIL_PAGE_SPACE_ID, 4, record.Space_id)
+	case MLOG_IBUF_BITMAP_INIT:
+		// ibuf_bitmap_page_init
+		Write_Uint(page, FIL_PAGE_TYPE, 2, gibd.FIL_PAGE_IBUF_BITMAP)
+		size := gibd.DEFAULT_PAGE_SIZE * IBUF_BITS_PER_PAGE / 8
+		Page_Write_Bytes(page, IBUF_BITMAP, make([]byte, size))
+
+	case MLOG_UNDO_INIT:
+		// trx_undo_page_init
+		undo_type := reader.Read_Compressed()
+		Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_TYPE, 2, undo_type)
+		Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_START, 2, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_HDR_SIZE)
+		Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_FREE, 2, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_HDR_SIZE)
+		Write_Uint(page, FIL_PAGE_TYPE, 2, gibd.FIL_PAGE_UNDO_LOG)
+	case MLOG_UNDO_INSERT:
+		// trx_undo_parse_add_undo_rec: next(2) 记录 start(2)
+		data := reader.Read_Bytes(reader.Read_Uint(2))
+		first_free := Read_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_FREE, 2)
+		end := first_free + 4 + uint64(len(data))
+		if end > gibd.DEFAULT_PAGE_SIZE-FIL_PAGE_END_LSN_OLD_CHKSUM {
+			return ErrNotApplicable
+		}
+		Write_Uint(page, first_free, 2, end)
+		copy(page[first_free+2:], data)
+		Write_Uint(page, end-2, 2, first_free)
+		Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_FREE, 2, end)
+	case MLOG_UNDO_ERASE_END:
+		// trx_undo_erase_page_end
+		first_free := Read_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_FREE, 2)
+		for i := first_free; i < gibd.DEFAULT_PAGE_SIZE-FIL_PAGE_END_LSN_OLD_CHKSUM; i++ {
+			page[i] = 0xFF
+		}
+	case MLOG_UNDO_HDR_CREATE:
+		Undo_Header_Create(page, reader.Read_U64_Compressed())
+	case MLOG_UNDO_HDR_REUSE:
+		Undo_Header_Reuse(page, reader.Read_U64_Compressed())
+	case MLOG_UNDO_HDR_DISCARD:
+		Undo_Discard_Latest(page)
+
+	case MLOG_PAGE_CREATE, MLOG_COMP_PAGE_CREATE:
+		// page_parse_create
+		Page_Create(page, record.Type == MLOG_COMP_PAGE_CREATE, gibd.FIL_PAGE_INDEX)
+	case MLOG_PAGE_CREATE_RTREE, MLOG_COMP_PAGE_CREATE_RTREE:
+		Page_Create(page, record.Type == MLOG_COMP_PAGE_CREATE_RTREE, gibd.FIL_PAGE_RTREE)
+	case MLOG_PAGE_CREATE_SDI:
+		Page_Create(page, true, FIL_PAGE_SDI)
+	case MLOG_REC_INSERT, MLOG_COMP_REC_INSERT:
+		newIndexPage(page, index).parse_insert_rec(reader, false)
+	case MLOG_LIST_END_COPY_CREATED, MLOG_COMP_LIST_END_COPY_CREATED:
+		// page_parse_copy_rec_list_to_created_page: 每条记录都和插入的日志一样, 只是没有游标记录的位置
+		index_page := newIndexPage(page, index)
+		records := NewMlogReader(reader.Read_Bytes(reader.Read_Uint(4)), 0)
+		for records.Pos < uint64(len(records.Data)) {
+			index_page.parse_insert_rec(records, true)
+		}
+		index_page.set_header(PAGE_LAST_INSERT, 0)
+		index_page.set_direction(PAGE_NO_DIRECTION, 0)
+	case MLOG_REC_DELETE, MLOG_COMP_REC_DELETE:
+		// page_cur_parse_delete_rec
+		newIndexPage(page, index).delete_rec(reader.Read_Uint(2))
+	case MLOG_LIST_END_DELETE, MLOG_COMP_LIST_END_DELETE:
+		// page_parse_delete_rec_list
+		newIndexPage(page, index).delete_list_end(reader.Read_Uint(2))
+	case MLOG_LIST_START_DELETE, MLOG_COMP_LIST_START_DELETE:
+		newIndexPage(page, index).delete_list_start(reader.Read_Uint(2))
+	case MLOG_PAGE_REORGANIZE, MLOG_COMP_PAGE_REORGANIZE:
+		// btr_parse_page_reorganize
+		newIndexPage(page, index).reorganize()
+
+	case MLOG_REC_MIN_MARK, MLOG_COMP_REC_MIN_MARK:
+		offset := reader.Read_Uint(2)
+		Rec_Set_Info_Flag(page, offset, record.Type == MLOG_COMP_REC_MIN_MARK, REC_INFO_MIN_REC_FLAG, true)
+	case MLOG_REC_SEC_DELETE_MARK, MLOG_COMP_REC_SEC_DELETE_MARK:
+		value := reader.Read_Uint(1)
+		offset := reader.Read_Uint(2)
+		Rec_Set_Info_Flag(page, offset, index.Comp, REC_INFO_DELETED_FLAG, value != 0)
+	case MLOG_REC_CLUST_DELETE_MARK, MLOG_COMP_REC_CLUST_DELETE_MARK:
+		// btr_cur_parse_del_mark_set_clust_rec
+		flags := reader.Read_Uint(1)
+		value := reader.Read_Uint(1)
+		pos, roll_ptr, trx_id := reader.Read_Sys_Vals()
+		offset := reader.Read_Uint(2)
+		Rec_Set_Info_Flag(page, offset, index.Comp, REC_INFO_DELETED_FLAG, value != 0)
+		if flags&BTR_KEEP_SYS_FLAG == 0 {
+			Rec_Write_Sys_Fields(page, offset, index, pos, roll_ptr, trx_id)
+		}
+	case MLOG_REC_UPDATE_IN_PLACE, MLOG_COMP_REC_UPDATE_IN_PLACE:
+		// btr_cur_parse_update_in_place, 只有字段长度不变的修改才会记这种日志
+		flags := reader.Read_Uint(1)
+		pos, roll_ptr, trx_id := reader.Read_Sys_Vals()
+		offset := reader.Read_Uint(2)
+		info_bits := reader.Read_Uint(1)
+		n_fields := reader.Read_Compressed()
+		offsets, _ := Rec_Field_Offsets(page, offset, index)
+		Rec_Set_Info_Bits(page, offset, index.Comp, info_bits)
+		if flags&BTR_KEEP_SYS_FLAG == 0 {
+			Rec_Write_Sys_Fields(page, offset, index, pos, roll_ptr, trx_id)
+		}
+		for i := uint64(0); i < n_fields; i++ {
+			field_no := reader.Read_Compressed()
+			length := reader.Read_Compressed()
+			if field_no >= uint64(len(offsets)) {
+				return ErrNotApplicable
+			}
+			field := offsets[field_no]
+			if length == UNIV_SQL_NULL {
+				if !field.Null {
+					return ErrNotApplicable
+				}
+				continue
+			}
+			if field.Null || field.Length != length {
+				return ErrNotApplicable
+			}
+			Page_Write_Bytes(page, offset+field.Start, reader.Read_Bytes(length))
+		}
+	default:
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// row_upd_parse_sys_vals: DB_TRX_ID在记录中的位置, 回滚指针和事务id
+func (reader *MlogReader) Read_Sys_Vals() (uint64, []byte, uint64) {
+	pos := reader.Read_Compressed()
+	roll_ptr := reader.Read_Bytes(7)
+	trx_id := reader.Read_U64_Compressed()
+	return pos, roll_ptr, trx_id
+}
+
+// 字段在记录中的位置, 相对记录原点
+type RecFieldOffset struct {
+	Start  uint64
+	Length uint64
+	Null   bool
+}
+
+// rec_get_offsets: 字段相对记录原点的位置和记录头的长度(rec_offs_extra_size)
+// 老格式的记录头中有每个字段的结束位置, 新格式要靠索引信息从null bitmap和变长字段长度中算出来,
+// 节点指针记录只有前面几个字段加上子页号, infimum和supremum只有一个8字节的字段
+// instant ADD/DROP过的索引上记录的字段数和顺序可能和索引不一样, 不支持
+func Rec_Field_Offsets(page []byte, rec uint64, index *LogIndex) ([]RecFieldOffset, uint64) {
+	if rec >= uint64(len(page)) || index.Versioned || index.Instant_cols > 0 {
+		panic(ErrNotApplicable)
+	}
+	var offsets []RecFieldOffset
+	if !index.Comp {
+		// rec_get_n_fields_old, rec_1/2_get_field_end_info
+		n_fields := Page_Read_Uint(page, rec-REC_OLD_N_FIELDS, 2) >> 1 & 0x3FF
+		short := Page_Read_Uint(page, rec-REC_OLD_SHORT, 1)&0x1 != 0
+		extra := REC_N_OLD_EXTRA_BYTES + 2*n_fields
+		if short {
+			extra = REC_N_OLD_EXTRA_BYTES + n_fields
+		}
+		var start uint64
+		for i := uint64(0); i < n_fields; i++ {
+			var end uint64
+			var null bool
+			if short {
+				info := Page_Read_Uint(page, rec-REC_N_OLD_EXTRA_BYTES-i-1, 1)
+				end, null = info&0x7F, info&0x80 != 0
+			} else {
+				info := Page_Read_Uint(page, rec-REC_N_OLD_EXTRA_BYTES-2*i-2, 2)
+				end, null = info&0x3FFF, info&0x8000 != 0
+			}
+			if end < start {
+				panic(ErrNotApplicable)
+			}
+			offsets = append(offsets, RecFieldOffset{Start: start, Length: end - start, Null: null})
+			start = end
+		}
+		return offsets, extra
+	}
+
+	fields := index.Fields
+	switch Page_Read_Uint(page, rec-REC_NEW_STATUS, 1) & REC_NEW_STATUS_MASK {
+	case REC_STATUS_ORDINARY:
+	case REC_STATUS_NODE_PTR:
+		fields = fields[:index.N_Node_Ptr_Fields()]
+	case REC_STATUS_INFIMUM, REC_STATUS_SUPREMUM:
+		return []RecFieldOffset{{Length: 8}}, REC_N_NEW_EXTRA_BYTES
+	default:
+		panic(ErrNotApplicable)
+	}
+	var n_nullable uint64
+	for _, field := range index.Fields {
+		if !field.Not_null {
+			n_nullable++
+		}
+	}
+	nulls := rec - REC_N_NEW_EXTRA_BYTES - 1
+	lens := nulls - (n_nullable+7)/8
+	var null_bit, start uint64
+	for _, field := range fields {
+		offset := RecFieldOffset{Start: start}
+		if !field.Not_null {
+			offset.Null = Page_Read_Uint(page, nulls-null_bit/8, 1)&(1<<(null_bit%8)) != 0
+			null_bit++
+		}
+		switch {
+		case offset.Null:
+		case field.Fixed_len > 0:
+			offset.Length = field.Fixed_len
+		default:
+			length := Page_Read_Uint(page, lens, 1)
+			lens--
+			if field.Big && length&0x80 != 0 {
+				length = (length<<8 | Page_Read_Uint(page, lens, 1)) & 0x3FFF
+				lens--
+			}
+			offset.Length = length
+		}
+		offsets = append(offsets, offset)
+		start = start + offset.Length
+	}
+	if len(fields) < len(index.Fields) {
+		offsets = append(offsets, RecFieldOffset{Start: start, Length: REC_NODE_PTR_SIZE})
+	}
+	return offsets, rec - lens - 1
+}
+
+func Rec_Info_Pos(rec uint64, comp bool) uint64 {
+	if comp {
+		return rec - REC_NEW_INFO_BITS
+	}
+	return rec - REC_OLD_INFO_BITS
+}
+
+func Rec_Set_Info_Flag(page []byte, rec uint64, comp bool, flag byte, on bool) {
+	if rec < REC_N_OLD_EXTRA_BYTES || rec >= uint64(len(page)) {
+		panic(ErrNotApplicable)
+	}
+	pos := Rec_Info_Pos(rec, comp)
+	if on {
+		page[pos] |= flag
+	} else {
+		page[pos] &^= flag
+	}
+}
+
+func Rec_Set_Info_Bits(page []byte, rec uint64, comp bool, info_bits uint64) {
+	if rec < REC_N_OLD_EXTRA_BYTES || rec >= uint64(len(page)) {
+		panic(ErrNotApplicable)
+	}
+	pos := Rec_Info_Pos(rec, comp)
+	page[pos] = page[pos]&^REC_INFO_BITS_MASK | byte(info_bits)&REC_INFO_BITS_MASK
+}
+
+// row_upd_rec_sys_fields_in_recovery: pos是DB_TRX_ID, 后面紧跟着DB_ROLL_PTR
+func Rec_Write_Sys_Fields(page []byte, rec uint64, index *LogIndex, pos uint64, roll_ptr []byte, trx_id uint64) {
+	offsets, _ := Rec_Field_Offsets(page, rec, index)
+	if pos+1 >= uint64(len(offsets)) || offsets[pos].Length != 6 || offsets[pos+1].Length != 7 {
+		panic(ErrNotApplicable)
+	}
+	Page_Write_Uint(page, rec+offsets[pos].Start, 6, trx_id)
+	Page_Write_Bytes(page, rec+offsets[pos+1].Start, roll_ptr)
+}
+
+func Page_Read_Uint(page []byte, offset uint64, n uint64) uint64 {
+	if offset >= uint64(len(page)) || offset+n > uint64(len(page)) {
+		panic(ErrNotApplicable)
+	}
+	return Read_Uint(page, offset, n)
+}
+
+func Page_Write_Uint(page []byte, offset uint64, n uint64, value uint64) {
+	if offset+n > uint64(len(page)) {
+		panic(ErrNotApplicable)
+	}
+	Write_Uint(page, offset, n, value)
+}
+
+func Page_Write_Bytes(page []byte, offset uint64, data []byte) {
+	if offset+uint64(len(data)) > uint64(len(page)) {
+		panic(ErrNotApplicable)
+	}
+	copy(page[offset:], data)
+}
+
+// trx_undo_header_create: 在TRX_UNDO_PAGE_FREE处加一个undo log header, 接在段中最后一个header后面
+func Undo_Header_Create(page []byte, trx_id uint64) {
+	free := Page_Read_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_FREE, 2)
+	if free+TRX_UNDO_LOG_XA_HDR_SIZE >= uint64(len(page))-100 {
+		panic(ErrNotApplicable)
+	}
+	new_free := free + TRX_UNDO_LOG_OLD_HDR_SIZE
+	Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_START, 2, new_free)
+	Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_FREE, 2, new_free)
+	Write_Uint(page, TRX_UNDO_SEG_HDR+TRX_UNDO_STATE, 2, TRX_UNDO_ACTIVE)
+	prev_log := Read_Uint(page, TRX_UNDO_SEG_HDR+TRX_UNDO_LAST_LOG, 2)
+	if prev_log != 0 {
+		Page_Write_Uint(page, prev_log+TRX_UNDO_NEXT_LOG, 2, free)
+	}
+	Write_Uint(page, TRX_UNDO_SEG_HDR+TRX_UNDO_LAST_LOG, 2, free)
+	Write_Uint(page, free+TRX_UNDO_DEL_MARKS, 2, 1)
+	Write_Uint(page, free+TRX_UNDO_TRX_ID, 8, trx_id)
+	Write_Uint(page, free+TRX_UNDO_LOG_START, 2, new_free)
+	page[free+TRX_UNDO_XID_EXISTS] = 0
+	page[free+TRX_UNDO_DICT_TRANS] = 0
+	Write_Uint(page, free+TRX_UNDO_NEXT_LOG, 2, 0)
+	Write_Uint(page, free+TRX_UNDO_PREV_LOG, 2, prev_log)
+}
+
+// trx_undo_insert_header_reuse: insert undo段的页可以整页重用, header放在段头后面
+func Undo_Header_Reuse(page []byte, trx_id uint64) {
+	free := uint64(TRX_UNDO_SEG_HDR + TRX_UNDO_SEG_HDR_SIZE)
+	if Page_Read_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_TYPE, 2) != TRX_UNDO_INSERT {
+		panic(ErrNotApplicable)
+	}
+	new_free := free + TRX_UNDO_LOG_OLD_HDR_SIZE
+	Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_START, 2, new_free)
+	Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_FREE, 2, new_free)
+	Write_Uint(page, TRX_UNDO_SEG_HDR+TRX_UNDO_STATE, 2, TRX_UNDO_ACTIVE)
+	Write_Uint(page, free+TRX_UNDO_TRX_ID, 8, trx_id)
+	Write_Uint(page, free+TRX_UNDO_LOG_START, 2, new_free)
+	page[free+TRX_UNDO_XID_EXISTS] = 0
+	page[free+TRX_UNDO_DICT_TRANS] = 0
+}
+
+// trx_undo_discard_latest_update_undo: 去掉最后一个header, 段的状态改成cached
+func Undo_Discard_Latest(page []byte) {
+	free := Page_Read_Uint(page, TRX_UNDO_SEG_HDR+TRX_UNDO_LAST_LOG, 2)
+	prev_log := Page_Read_Uint(page, free+TRX_UNDO_PREV_LOG, 2)
+	if prev_log != 0 {
+		Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_START, 2, Page_Read_Uint(page, prev_log+TRX_UNDO_LOG_START, 2))
+		Page_Write_Uint(page, prev_log+TRX_UNDO_NEXT_LOG, 2, 0)
+	}
+	Write_Uint(page, TRX_UNDO_PAGE_HDR+TRX_UNDO_PAGE_FREE, 2, free)
+	Write_Uint(page, TRX_UNDO_SEG_HDR+TRX_UNDO_STATE, 2, TRX_UNDO_CACHED)
+	Write_Uint(page, TRX_UNDO_SEG_HDR+TRX_UNDO_LAST_LOG, 2, prev_log)
+}
+
+// 大端写入n个字节
+func Write_Uint(b []byte, pos uint64, n uint64, value uint64) {
+	for i := n; i > 0; i-- {
+		b[pos+i-1] = byte(value)
+		value = value >> 8
+	}
+}
+
+func (result *ApplyResult) Dump() {
+	println("apply result:")
+
+	data, _ := json.Marshal(result)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -0,0 +1,292 @@
+package redo
+
+import (
+	"bytes"
+	"testing"
+)
+
+// COMP记录带的索引信息: 一个INT NOT NULL字段, 记录头5个字节, 数据4个字节
+var test_index = []byte{0x00, 0x01, 0x00, 0x01, 0x80, 0x04}
+
+func apply(t *testing.T, page []byte, mlog_type uint64, body ...[]byte) {
+	t.Helper()
+	if err := Apply_Body(page, &LogRecord{Type: mlog_type, Body: bytes.Join(body, nil)}); err != nil {
+		t.Fatalf("%s: %v", MLOG_TYPES[mlog_type], err)
+	}
+}
+
+func patch(page []byte, offset int, b ...byte) {
+	copy(page[offset:], b)
+}
+
+func check_page(t *testing.T, got []byte, want []byte) {
+	t.Helper()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("byte %d is 0x%02x, want 0x%02x, around %x want %x", i, got[i], want[i], got[i&^15:i&^15+16], want[i&^15:i&^15+16])
+		}
+	}
+}
+
+// 建页之前的页: 记录区是垃圾, PAGE_LEVEL=0, PAGE_INDEX_ID=0x99, 文件尾是0xCC
+func test_page() []byte {
+	page := make([]byte, 16384)
+	for i := range page {
+		page[i] = 0xEE
+	}
+	patch(page, 4, 0x00, 0x00, 0x00, 0x03)
+	patch(page, 64, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0x99)
+	for i := 16376; i < 16384; i++ {
+		page[i] = 0xCC
+	}
+	return page
+}
+
+// MLOG_COMP_PAGE_CREATE以后的页
+func want_created(orig []byte) []byte {
+	want := append([]byte(nil), orig...)
+	patch(want, 24, 0x45, 0xBF)
+	patch(want, 38,
+		0x00, 0x02, // PAGE_N_DIR_SLOTS
+		0x00, 0x78, // PAGE_HEAP_TOP
+		0x80, 0x02, // PAGE_N_HEAP
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // PAGE_FREE, PAGE_GARBAGE, PAGE_LAST_INSERT
+		0x00, 0x05, 0x00, 0x00, 0x00, 0x00, // PAGE_DIRECTION, PAGE_N_DIRECTION, PAGE_N_RECS
+		0, 0, 0, 0, 0, 0, 0, 0, // PAGE_MAX_TRX_ID
+	)
+	patch(want, 94,
+		0x01, 0x00, 0x02, 0x00, 0x0d, 'i', 'n', 'f', 'i', 'm', 'u', 'm', 0,
+		0x01, 0x00, 0x0b, 0x00, 0x00, 's', 'u', 'p', 'r', 'e', 'm', 'u', 'm')
+	for i := 120; i < 16376; i++ {
+		want[i] = 0
+	}
+	patch(want, 16372, 0x00, 0x70, 0x00, 0x63)
+	return want
+}
+
+// 从120开始按堆的顺序每条记录9个字节, 链表顺序和堆的顺序一样, n_owned都是0
+func want_records(want []byte, keys ...byte) {
+	prev := 99
+	for i, key := range keys {
+		rec := 125 + 9*i
+		heap_no := (i + 2) << 3
+		patch(want, rec-5, 0x00, byte(heap_no>>8), byte(heap_no))
+		patch(want, prev-2, byte((rec-prev)>>8), byte(rec-prev))
+		patch(want, rec, 0x80, 0x00, 0x00, key)
+		prev = rec
+	}
+	next := (112 - prev) & 0xFFFF
+	patch(want, prev-2, byte(next>>8), byte(next))
+}
+
+func u16(v int) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+// 完整的记录: end_seg_len最低位是1, info bits, origin offset 5, mismatch index 0
+func insert_full(cursor int, key byte) []byte {
+	return bytes.Join([][]byte{u16(cursor), {0x13, 0x00, 0x05, 0x00}, {0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, key}}, nil)
+}
+
+// 和游标记录只有数据不一样: end_seg_len是4<<1
+func insert_short(cursor int, key byte) []byte {
+	return bytes.Join([][]byte{u16(cursor), {0x08, 0x80, 0x00, 0x00, key}}, nil)
+}
+
+func created_page(t *testing.T) ([]byte, []byte) {
+	page := test_page()
+	want := want_created(page)
+	apply(t, page, MLOG_COMP_PAGE_CREATE)
+	return page, want
+}
+
+// 依次插入1..8, 第8条插入以后supremum拥有9条记录, 槽分成两个
+func eight_records(t *testing.T) ([]byte, []byte) {
+	page, want := created_page(t)
+	apply(t, page, MLOG_COMP_REC_INSERT, test_index, insert_full(99, 1))
+	for i := 1; i < 8; i++ {
+		apply(t, page, MLOG_COMP_REC_INSERT, test_index, insert_short(125+9*(i-1), byte(i+1)))
+	}
+	want_records(want, 1, 2, 3, 4, 5, 6, 7, 8)
+	patch(want, 147, 0x04)
+	patch(want, 107, 0x05)
+	patch(want, 16370, 0x00, 0x70, 0x00, 0x98, 0x00, 0x63)
+	patch(want, 38, 0x00, 0x03, 0x00, 0xC0, 0x80, 0x0A)
+	patch(want, 48, 0x00, 0xBC, 0x00, 0x02, 0x00, 0x07, 0x00, 0x08)
+	check_page(t, page, want)
+	return page, want
+}
+
+func TestApplyPageCreate(t *testing.T) {
+	page, want := created_page(t)
+	check_page(t, page, want)
+
+	page = test_page()
+	want = append([]byte(nil), page...)
+	apply(t, page, MLOG_PAGE_CREATE)
+	patch(want, 24, 0x45, 0xBF)
+	patch(want, 38, 0x00, 0x02, 0x00, 0x7D, 0x00, 0x02, 0, 0, 0, 0, 0, 0, 0x00, 0x05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	patch(want, 94,
+		0x08, 0x01, 0x00, 0x00, 0x03, 0x00, 0x74, 'i', 'n', 'f', 'i', 'm', 'u', 'm', 0,
+		0x09, 0x01, 0x00, 0x08, 0x03, 0x00, 0x00, 's', 'u', 'p', 'r', 'e', 'm', 'u', 'm', 0)
+	for i := 125; i < 16376; i++ {
+		want[i] = 0
+	}
+	patch(want, 16372, 0x00, 0x74, 0x00, 0x65)
+	check_page(t, page, want)
+
+	// 老格式的记录: 1个字段, 1字节的结束位置, 堆号在插入的时候填
+	apply(t, page, MLOG_REC_INSERT, u16(101), []byte{0x17, 0x00, 0x07, 0x00}, []byte{0x04, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x80, 0x00, 0x00, 0x01})
+	patch(want, 125, 0x04, 0x00, 0x00, 0x10, 0x03, 0x00, 0x74, 0x80, 0x00, 0x00, 0x01)
+	patch(want, 99, 0x00, 0x84)
+	patch(want, 110, 0x02)
+	patch(want, 40, 0x00, 0x88, 0x00, 0x03)
+	patch(want, 48, 0x00, 0x84, 0x00, 0x05, 0x00, 0x00, 0x00, 0x01)
+	check_page(t, page, want)
+}
+
+func TestApplyRecInsert(t *testing.T) {
+	page, want := created_page(t)
+	apply(t, page, MLOG_COMP_REC_INSERT, test_index, insert_full(99, 1))
+	apply(t, page, MLOG_COMP_REC_INSERT, test_index, insert_short(125, 2))
+	want_records(want, 1, 2)
+	patch(want, 107, 0x03)
+	patch(want, 40, 0x00, 0x8A, 0x80, 0x04)
+	patch(want, 48, 0x00, 0x86, 0x00, 0x02, 0x00, 0x01, 0x00, 0x02)
+	check_page(t, page, want)
+	if !bytes.Equal(page[97:99], []byte{0x00, 0x1A}) || !bytes.Equal(page[123:125], []byte{0x00, 0x09}) || !bytes.Equal(page[132:134], []byte{0xFF, 0xEA}) {
+		t.Fatalf("next pointers %x %x %x", page[97:99], page[123:125], page[132:134])
+	}
+
+	// 8.0.28以后的类型只是索引信息的格式不一样
+	versioned, _ := created_page(t)
+	apply(t, versioned, MLOG_REC_INSERT_8028, []byte{0x01, INDEX_LOG_COMPACT, 0x00, 0x01, 0x00, 0x01, 0x80, 0x04}, insert_full(99, 1))
+	apply(t, versioned, MLOG_REC_INSERT_8028, []byte{0x01, INDEX_LOG_COMPACT, 0x00, 0x01, 0x00, 0x01, 0x80, 0x04}, insert_short(125, 2))
+	check_page(t, versioned, want)
+
+	// instant ADD过的索引上记录的格式不确定
+	instant := []byte{0x01, INDEX_LOG_COMPACT | INDEX_LOG_INSTANT, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x80, 0x04}
+	err := Apply_Body(versioned, &LogRecord{Type: MLOG_REC_INSERT_8028, Body: append(instant, insert_short(134, 3)...)})
+	if err != ErrNotApplicable {
+		t.Fatalf("got %v, want ErrNotApplicable", err)
+	}
+}
+
+func TestApplyRecInsertSplitSlot(t *testing.T) {
+	eight_records(t)
+}
+
+func TestApplyRecDelete(t *testing.T) {
+	page, want := eight_records(t)
+	// 删除3: 槽1拥有的记录少于4条, 从supremum的槽借一条
+	apply(t, page, MLOG_COMP_REC_DELETE, test_index, u16(143))
+	patch(want, 132, 0x00, 0x12)
+	patch(want, 141, 0x00, 0x00)
+	patch(want, 147, 0x00)
+	patch(want, 156, 0x04)
+	patch(want, 107, 0x04)
+	patch(want, 16372, 0x00, 0xA1)
+	patch(want, 44, 0x00, 0x8F, 0x00, 0x09, 0x00, 0x00)
+	patch(want, 54, 0x00, 0x07)
+	check_page(t, page, want)
+
+	// 再插入3, 用PAGE_FREE中的空间和堆号
+	apply(t, page, MLOG_COMP_REC_INSERT, test_index, insert_short(134, 3))
+	patch(want, 132, 0x00, 0x09)
+	patch(want, 141, 0x00, 0x09)
+	patch(want, 156, 0x05)
+	patch(want, 44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x8F, 0x00, 0x05, 0x00, 0x00, 0x00, 0x08)
+	check_page(t, page, want)
+}
+
+func TestApplyListEndDelete(t *testing.T) {
+	page, want := eight_records(t)
+	apply(t, page, MLOG_COMP_LIST_END_DELETE, test_index, u16(161))
+	patch(want, 150, 0xFF, 0xD8)
+	patch(want, 186, 0x00, 0x00)
+	patch(want, 107, 0x01)
+	patch(want, 44, 0x00, 0xA1, 0x00, 0x24, 0x00, 0x00)
+	patch(want, 54, 0x00, 0x04)
+	check_page(t, page, want)
+}
+
+func TestApplyListStartDelete(t *testing.T) {
+	page, want := eight_records(t)
+	// 删除1和2, 第二次删除以后槽1和supremum的槽合并
+	apply(t, page, MLOG_COMP_LIST_START_DELETE, test_index, u16(143))
+	patch(want, 97, 0x00, 0x2C)
+	patch(want, 123, 0x00, 0x00)
+	patch(want, 132, 0xFF, 0xF7)
+	patch(want, 147, 0x00)
+	patch(want, 156, 0x00)
+	patch(want, 107, 0x07)
+	patch(want, 16370, 0x00, 0x00, 0x00, 0x70)
+	patch(want, 38, 0x00, 0x02)
+	patch(want, 44, 0x00, 0x86, 0x00, 0x12, 0x00, 0x00)
+	patch(want, 54, 0x00, 0x06)
+	check_page(t, page, want)
+}
+
+func TestApplyPageReorganize(t *testing.T) {
+	page, _ := eight_records(t)
+	apply(t, page, MLOG_COMP_REC_DELETE, test_index, u16(143))
+	patch(page, 56, 0, 0, 0, 0, 0, 0, 0x12, 0x34)
+	apply(t, page, MLOG_COMP_PAGE_REORGANIZE, test_index)
+
+	// 二级索引的叶子页, 保留PAGE_MAX_TRX_ID
+	want := want_created(test_page())
+	want_records(want, 1, 2, 4, 5, 6, 7, 8)
+	patch(want, 107, 0x08)
+	patch(want, 40, 0x00, 0xB7, 0x80, 0x09)
+	patch(want, 48, 0x00, 0xB3, 0x00, 0x02, 0x00, 0x06, 0x00, 0x07)
+	patch(want, 56, 0, 0, 0, 0, 0, 0, 0x12, 0x34)
+	check_page(t, page, want)
+}
+
+func TestApplyListEndCopyCreated(t *testing.T) {
+	page, want := created_page(t)
+	records := []byte{0x13, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x01, 0x08, 0x80, 0x00, 0x00, 0x02}
+	apply(t, page, MLOG_COMP_LIST_END_COPY_CREATED, test_index, []byte{0x00, 0x00, 0x00, byte(len(records))}, records)
+	want_records(want, 1, 2)
+	patch(want, 107, 0x03)
+	patch(want, 40, 0x00, 0x8A, 0x80, 0x04)
+	patch(want, 54, 0x00, 0x02)
+	check_page(t, page, want)
+}
+
+func TestApplyUndoHeader(t *testing.T) {
+	page := make([]byte, 16384)
+	apply(t, page, MLOG_UNDO_INIT, []byte{0x01})
+	// trx_undo_seg_create: 第一个页上header在段头后面
+	apply(t, page, MLOG_2BYTES, u16(40), []byte{86})
+	apply(t, page, MLOG_2BYTES, u16(42), []byte{86})
+	want := append([]byte(nil), page...)
+
+	apply(t, page, MLOG_UNDO_HDR_CREATE, []byte{0x00, 0x00, 0x00, 0x12, 0x34})
+	patch(want, 40, 0x00, 0x84, 0x00, 0x84)
+	patch(want, 56, 0x00, 0x01, 0x00, 0x56)
+	patch(want, 86, 0, 0, 0, 0, 0, 0, 0x12, 0x34)
+	patch(want, 102, 0x00, 0x01, 0x00, 0x84, 0x00, 0x00)
+	patch(want, 116, 0x00, 0x00, 0x00, 0x00)
+	check_page(t, page, want)
+
+	apply(t, page, MLOG_UNDO_HDR_CREATE, []byte{0x00, 0x00, 0x00, 0x12, 0x35})
+	patch(want, 40, 0x00, 0xB2, 0x00, 0xB2)
+	patch(want, 58, 0x00, 0x84)
+	patch(want, 116, 0x00, 0x84)
+	patch(want, 132, 0, 0, 0, 0, 0, 0, 0x12, 0x35)
+	patch(want, 148, 0x00, 0x01, 0x00, 0xB2, 0x00, 0x00)
+	patch(want, 162, 0x00, 0x00, 0x00, 0x56)
+	check_page(t, page, want)
+
+	apply(t, page, MLOG_UNDO_HDR_DISCARD)
+	patch(want, 40, 0x00, 0x84, 0x00, 0x84)
+	patch(want, 56, 0x00, 0x02, 0x00, 0x56)
+	patch(want, 116, 0x00, 0x00)
+	check_page(t, page, want)
+
+	apply(t, page, MLOG_UNDO_HDR_REUSE, []byte{0x00, 0x00, 0x00, 0x56, 0x78})
+	patch(want, 56, 0x00, 0x01)
+	patch(want, 86, 0, 0, 0, 0, 0, 0, 0x56, 0x78)
+	check_page(t, page, want)
+}
@@ -0,0 +1,150 @@
+package redo
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/tidwall/pretty"
+)
+
+// log block log0log.h
+// hdr_no(4, 最高位是flush bit) data_len(2) first_rec_group(2) checkpoint_no(4, 8.0是epoch_no) 数据(496) checksum(4)
+const OS_FILE_LOG_BLOCK_SIZE = 512
+const LOG_BLOCK_HDR_SIZE = 12
+const LOG_BLOCK_TRL_SIZE = 4
+const LOG_BLOCK_FLUSH_BIT_MASK = 0x80000000
+const LOG_BLOCK_DATA_SIZE = OS_FILE_LOG_BLOCK_SIZE - LOG_BLOCK_HDR_SIZE - LOG_BLOCK_TRL_SIZE
+
+// innodb_log_checksums=OFF的时候写入的值
+const LOG_NO_CHECKSUM_MAGIC = 0xDEADBEEF
+
+var crc32c_table = crc32.MakeTable(crc32.Castagnoli)
+
+type LogBlock struct {
+	Lsn             uint64 `json:"lsn"` // block开始的lsn, 512对齐
+	File            string `json:"file"`
+	Offset          uint64 `json:"offset"`
+	Hdr_no          uint64 `json:"hdr_no"`
+	Flush           bool   `json:"flush"`
+	Data_len        uint64 `json:"data_len"`        // 包括block header的长度, 512表示写满了
+	First_rec_group uint64 `json:"first_rec_group"` // 第一个从这个block开始的mtr的偏移, 0表示没有
+	Checkpoint_no   uint64 `json:"checkpoint_no"`
+	Checksum        string `json:"checksum"`
+	Checksum_valid  bool   `json:"checksum_valid"`
+	Data            []byte `json:"-"`
+}
+
+// lsn对应的block号, 从1开始循环
+func Block_No(lsn uint64) uint64 {
+	return ((lsn / OS_FILE_LOG_BLOCK_SIZE) & 0x3FFFFFFF) + 1
+}
+
+func NewLogBlock(data []byte, lsn uint64) *LogBlock {
+	block := &LogBlock{Lsn: lsn, Data: data}
+	hdr_no := Read_Uint(data, 0, 4)
+	block.Flush = hdr_no&LOG_BLOCK_FLUSH_BIT_MASK != 0
+	block.Hdr_no = hdr_no &^ LOG_BLOCK_FLUSH_BIT_MASK
+	block.Data_len = Read_Uint(data, 4, 2)
+	block.First_rec_group = Read_Uint(data, 6, 2)
+	block.Checkpoint_no = Read_Uint(data, 8, 4)
+	block.Checksum, block.Checksum_valid = Block_Checksum(data)
+	return block
+}
+
+// 块号和lsn对得上, checksum正确, 长度合理才是有效的block, 否则说明写到这里就结束了
+func (block *LogBlock) Is_Valid() bool {
+	return block.Checksum_valid && block.Hdr_no == Block_No(block.Lsn) &&
+		block.Data_len >= LOG_BLOCK_HDR_SIZE && block.Data_len <= OS_FILE_LOG_BLOCK_SIZE
+}
+
+func (block *LogBlock) Is_Full() bool {
+	return block.Data_len == OS_FILE_LOG_BLOCK_SIZE
+}
+
+// block中有效的日志数据
+func (block *LogBlock) Payload() []byte {
+	end := block.Data_len
+	if end > OS_FILE_LOG_BLOCK_SIZE-LOG_BLOCK_TRL_SIZE {
+		end = OS_FILE_LOG_BLOCK_SIZE - LOG_BLOCK_TRL_SIZE
+	}
+	if end < LOG_BLOCK_HDR_SIZE {
+		return nil
+	}
+	return block.Data[LOG_BLOCK_HDR_SIZE:end]
+}
+
+// 最后4个字节是checksum, 可能是crc32c, 老的innodb算法或者没有checksum
+func Block_Checksum(block []byte) (string, bool) {
+	stored := Read_Uint(block, OS_FILE_LOG_BLOCK_SIZE-LOG_BLOCK_TRL_SIZE, 4)
+	if uint64(crc32.Checksum(block[:OS_FILE_LOG_BLOCK_SIZE-LOG_BLOCK_TRL_SIZE], crc32c_table)) == stored {
+		return "crc32", true
+	}
+	if Block_Checksum_Innodb(block) == stored {
+		return "innodb", true
+	}
+	if stored == LOG_NO_CHECKSUM_MAGIC {
+		return "none", true
+	}
+	return "invalid", false
+}
+
+// log_block_calc_checksum_innodb
+func Block_Checksum_Innodb(block []byte) uint64 {
+	var sum uint64 = 1
+	var sh uint64 = 0
+	for i := 0; i < OS_FILE_LOG_BLOCK_SIZE-LOG_BLOCK_TRL_SIZE; i++ {
+		b := uint64(block[i])
+		sum &= 0x7FFFFFFF
+		sum += b
+		sum += b << sh
+		sh++
+		if sh > 24 {
+			sh = 0
+		}
+	}
+	return sum & 0xFFFFFFFF
+}
+
+// 读取lsn所在的block
+func (log *Log) Block(lsn uint64) (*LogBlock, error) {
+	lsn = lsn - lsn%OS_FILE_LOG_BLOCK_SIZE
+	file, offset, err := log.Lsn_To_Offset(lsn)
+	if err != nil {
+		return nil, err
+	}
+	data, err := file.Read_At(offset, OS_FILE_LOG_BLOCK_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	block := NewLogBlock(data, lsn)
+	block.File = file.Name
+	block.Offset = offset
+	return block, nil
+}
+
+// 从start_lsn所在的block开始, 一直读到end_lsn(0表示不限制)或者遇到无效的/没写满的block
+func (log *Log) Each_Block(start_lsn uint64, end_lsn uint64) []*LogBlock {
+	var blocks []*LogBlock
+	lsn := start_lsn - start_lsn%OS_FILE_LOG_BLOCK_SIZE
+	for end_lsn == 0 || lsn < end_lsn {
+		block, err := log.Block(lsn)
+		if err != nil || !block.Is_Valid() {
+			break
+		}
+		blocks = append(blocks, block)
+		if !block.Is_Full() {
+			break
+		}
+		lsn += OS_FILE_LOG_BLOCK_SIZE
+	}
+	return blocks
+}
+
+func (block *LogBlock) Dump() {
+	println("log block:")
+
+	data, _ := json.Marshal(block)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -0,0 +1,298 @@
+package redo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// redo日志文件 log0log.h
+// 每个文件前4个block(2048字节)是文件头: block 0 是header, block 1 和 block 3 是两个checkpoint
+// 5.7和8.0.30之前是ib_logfile0..N组成的环形文件组, 8.0.30以后是#innodb_redo目录下的#ib_redoN文件, 每个文件的头中记录了文件的起始lsn
+const LOG_FILE_HDR_SIZE = 4 * OS_FILE_LOG_BLOCK_SIZE
+const LOG_CHECKPOINT_1 = OS_FILE_LOG_BLOCK_SIZE
+const LOG_CHECKPOINT_2 = 3 * OS_FILE_LOG_BLOCK_SIZE
+
+// log file header中的格式版本
+const LOG_HEADER_FORMAT_5_6 = 0 // 5.7.9之前, 开头是group id
+const LOG_HEADER_FORMAT_5_7_9 = 1
+const LOG_HEADER_FORMAT_8_0_30 = 6
+
+var LOG_HEADER_FORMATS = map[uint64]string{
+	0: "5.6",
+	1: "5.7.9",
+	2: "8.0.1",
+	3: "8.0.3",
+	4: "8.0.19",
+	5: "8.0.28",
+	6: "8.0.30",
+}
+
+type LogFileHeader struct {
+	Format         uint64 `json:"format"`
+	Format_name    string `json:"format_name"`
+	Start_lsn      uint64 `json:"start_lsn"` // 文件中第一个数据block(偏移2048)的lsn
+	Creator        string `json:"creator"`
+	Checksum       string `json:"checksum"`
+	Checksum_valid bool   `json:"checksum_valid"`
+}
+
+type Checkpoint struct {
+	File           string `json:"file"`
+	Block_offset   uint64 `json:"block_offset"`
+	No             uint64 `json:"no"`
+	Lsn            uint64 `json:"lsn"`
+	Offset         uint64 `json:"offset"` // 8.0以前, checkpoint lsn在文件组中的偏移(包括文件头)
+	Checksum       string `json:"checksum"`
+	Checksum_valid bool   `json:"checksum_valid"`
+}
+
+type LogFile struct {
+	Name        string         `json:"name"`
+	Size        uint64         `json:"size"`
+	Header      *LogFileHeader `json:"header"`
+	Checkpoints []*Checkpoint  `json:"checkpoints"`
+}
+
+type Log struct {
+	Layout string     `json:"layout"` // ib_logfile 或者 innodb_redo
+	Files  []*LogFile `json:"files"`
+}
+
+// dir可以是datadir, #innodb_redo目录或者单个文件
+func NewLogFromPath(path string) (*Log, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return NewLogFromFiles([]string{path})
+	}
+	if filepath.Base(path) != "#innodb_redo" {
+		redo_dir := filepath.Join(path, "#innodb_redo")
+		if fi, err := os.Stat(redo_dir); err == nil && fi.IsDir() {
+			path = redo_dir
+		}
+	}
+	if filepath.Base(path) == "#innodb_redo" {
+		//#ib_redoN_tmp是预先创建还没有使用的文件
+		matches, _ := filepath.Glob(filepath.Join(path, "#ib_redo*"))
+		var filenames []string
+		for _, filename := range matches {
+			if !strings.HasSuffix(filename, "_tmp") {
+				filenames = append(filenames, filename)
+			}
+		}
+		sort.Slice(filenames, func(i, j int) bool { return Redo_File_Number(filenames[i]) < Redo_File_Number(filenames[j]) })
+		return NewLogFromFiles(filenames)
+	}
+	filenames, _ := filepath.Glob(filepath.Join(path, "ib_logfile[0-9]*"))
+	sort.Slice(filenames, func(i, j int) bool { return Redo_File_Number(filenames[i]) < Redo_File_Number(filenames[j]) })
+	return NewLogFromFiles(filenames)
+}
+
+// ib_logfile10排在ib_logfile2后面, 按文件名最后的数字排序
+func Redo_File_Number(filename string) int {
+	name := filepath.Base(filename)
+	i := len(name)
+	for i > 0 && name[i-1] >= '0' && name[i-1] <= '9' {
+		i--
+	}
+	n, _ := strconv.Atoi(name[i:])
+	return n
+}
+
+func NewLogFromFiles(filenames []string) (*Log, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("no redo log file found")
+	}
+	log := &Log{Layout: "ib_logfile"}
+	for _, filename := range filenames {
+		file, err := NewLogFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		log.Files = append(log.Files, file)
+	}
+	if log.Files[0].Header.Format >= LOG_HEADER_FORMAT_8_0_30 {
+		log.Layout = "innodb_redo"
+	}
+	return log, nil
+}
+
+func NewLogFile(filename string) (*LogFile, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < LOG_FILE_HDR_SIZE+OS_FILE_LOG_BLOCK_SIZE {
+		return nil, errors.New("redo log file " + filename + " is too small")
+	}
+	file := &LogFile{Name: filename, Size: uint64(fi.Size())}
+	data, err := file.Read_At(0, LOG_FILE_HDR_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	file.Parse_Header(data)
+	return file, nil
+}
+
+func (file *LogFile) Read_At(offset uint64, size uint64) ([]byte, error) {
+	f, err := os.Open(file.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, size)
+	n, err := f.ReadAt(data, int64(offset))
+	if uint64(n) < size {
+		return nil, fmt.Errorf("short read at %d of %s: %v", offset, file.Name, err)
+	}
+	return data, nil
+}
+
+// 文件的数据部分能存多少字节(不包括文件头)
+func (file *LogFile) Capacity() uint64 {
+	return file.Size - LOG_FILE_HDR_SIZE
+}
+
+func (file *LogFile) Parse_Header(data []byte) {
+	header := &LogFileHeader{}
+	block := data[:OS_FILE_LOG_BLOCK_SIZE]
+	header.Format = uint64(Read_Uint(block, 0, 4))
+	if header.Format == LOG_HEADER_FORMAT_5_6 {
+		//5.6: group id(4) start lsn(8) file no(4)
+		header.Start_lsn = Read_Uint(block, 4, 8)
+	} else {
+		header.Start_lsn = Read_Uint(block, 8, 8)
+		header.Creator = strings.TrimRight(string(block[16:48]), "\x00 ")
+	}
+	header.Format_name = LOG_HEADER_FORMATS[header.Format]
+	header.Checksum, header.Checksum_valid = Block_Checksum(block)
+	file.Header = header
+
+	file.Checkpoints = nil
+	for _, offset := range []uint64{LOG_CHECKPOINT_1, LOG_CHECKPOINT_2} {
+		checkpoint := file.Parse_Checkpoint(data[offset:offset+OS_FILE_LOG_BLOCK_SIZE], header.Format)
+		if checkpoint == nil {
+			continue
+		}
+		checkpoint.Block_offset = offset
+		file.Checkpoints = append(file.Checkpoints, checkpoint)
+	}
+}
+
+// 5.6: no(8) lsn(8) offset_low(4) ... offset_high(4)@304, LOG_CHECKPOINT_ARRAY_END(288)后面是checksum_1 checksum_2 fsp_free_limit fsp_magic_n
+// 5.7: no(8) lsn(8) offset(8) buf_size(8)
+// 8.0: lsn(8)@8
+func (file *LogFile) Parse_Checkpoint(block []byte, format uint64) *Checkpoint {
+	checkpoint := &Checkpoint{File: file.Name}
+	checkpoint.Lsn = Read_Uint(block, 8, 8)
+	if checkpoint.Lsn == 0 {
+		return nil
+	}
+	switch {
+	case format == LOG_HEADER_FORMAT_5_6:
+		checkpoint.No = Read_Uint(block, 0, 8)
+		checkpoint.Offset = Read_Uint(block, 304, 4)<<32 | Read_Uint(block, 16, 4)
+		//5.6的checkpoint用fold校验, 不检查
+		checkpoint.Checksum = "unchecked"
+		checkpoint.Checksum_valid = true
+		return checkpoint
+	case format == LOG_HEADER_FORMAT_5_7_9:
+		checkpoint.No = Read_Uint(block, 0, 8)
+		checkpoint.Offset = Read_Uint(block, 16, 8)
+	}
+	checkpoint.Checksum, checkpoint.Checksum_valid = Block_Checksum(block)
+	return checkpoint
+}
+
+// 所有文件中checksum正确的lsn最大的checkpoint
+func (log *Log) Latest_Checkpoint() *Checkpoint {
+	var latest *Checkpoint
+	for _, file := range log.Files {
+		for _, checkpoint := range file.Checkpoints {
+			if !checkpoint.Checksum_valid {
+				continue
+			}
+			if latest == nil || checkpoint.Lsn > latest.Lsn {
+				latest = checkpoint
+			}
+		}
+	}
+	return latest
+}
+
+// checksum正确的lsn最小的checkpoint, 从这里解析到日志结尾可以看到两次checkpoint之间的修改
+// 8.0.30以后checkpoint所在的文件可能已经被回收了, 跳过找不到的
+func (log *Log) Oldest_Checkpoint() *Checkpoint {
+	var oldest *Checkpoint
+	for _, file := range log.Files {
+		for _, checkpoint := range file.Checkpoints {
+			if !checkpoint.Checksum_valid {
+				continue
+			}
+			if _, _, err := log.Lsn_To_Offset(checkpoint.Lsn); err != nil {
+				continue
+			}
+			if oldest == nil || checkpoint.Lsn < oldest.Lsn {
+				oldest = checkpoint
+			}
+		}
+	}
+	return oldest
+}
+
+// lsn所在的文件和文件中的偏移
+func (log *Log) Lsn_To_Offset(lsn uint64) (*LogFile, uint64, error) {
+	if log.Layout == "innodb_redo" {
+		for _, file := range log.Files {
+			start := file.Header.Start_lsn
+			if lsn >= start && lsn < start+file.Capacity() {
+				return file, LOG_FILE_HDR_SIZE + lsn - start, nil
+			}
+		}
+		return nil, 0, fmt.Errorf("lsn %d is not in any redo file", lsn)
+	}
+
+	//文件组: 所有文件的数据部分首尾相连组成一个环
+	file_size := log.Files[0].Size
+	capacity := uint64(len(log.Files)) * (file_size - LOG_FILE_HDR_SIZE)
+	base_lsn, base_offset := log.Files[0].Header.Start_lsn, uint64(0)
+	if checkpoint := log.Latest_Checkpoint(); checkpoint != nil && log.Files[0].Header.Format <= LOG_HEADER_FORMAT_5_7_9 {
+		base_lsn = checkpoint.Lsn
+		base_offset = checkpoint.Offset - LOG_FILE_HDR_SIZE*(1+checkpoint.Offset/file_size)
+	}
+	var size_offset uint64
+	if lsn >= base_lsn {
+		size_offset = (base_offset + (lsn-base_lsn)%capacity) % capacity
+	} else {
+		size_offset = (base_offset + capacity - (base_lsn-lsn)%capacity) % capacity
+	}
+	index := size_offset / (file_size - LOG_FILE_HDR_SIZE)
+	return log.Files[index], LOG_FILE_HDR_SIZE + size_offset%(file_size-LOG_FILE_HDR_SIZE), nil
+}
+
+func (log *Log) Dump() {
+	println("redo log:")
+
+	data, _ := json.Marshal(log)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
+
+// 大端读取n个字节
+func Read_Uint(b []byte, pos uint64, n uint64) uint64 {
+	var value uint64
+	for i := uint64(0); i < n; i++ {
+		value = value<<8 | uint64(b[pos+i])
+	}
+	return value
+}
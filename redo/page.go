@@ -0,0 +1,610 @@
+package redo
+
+import "gibd/gibd"
+
+// 索引页上的逻辑日志: 建页, 插入删除记录, 重组 page0page.cc page0cur.cc
+// 只支持非压缩页, 和恢复的时候一样修改页, 删除最后一条记录的时候不重建页
+const PAGE_HEADER = FIL_PAGE_DATA
+const PAGE_N_DIR_SLOTS = 0
+const PAGE_HEAP_TOP = 2
+const PAGE_N_HEAP = 4 // 最高位是COMPACT格式的标记
+const PAGE_FREE = 6
+const PAGE_GARBAGE = 8
+const PAGE_LAST_INSERT = 10
+const PAGE_DIRECTION = 12
+const PAGE_N_DIRECTION = 14
+const PAGE_N_RECS = 16
+const PAGE_MAX_TRX_ID = 18
+const PAGE_HEADER_PRIV_END = 26 // page_create只清零这之前的部分, PAGE_LEVEL, PAGE_INDEX_ID和段头不变
+const PAGE_LEVEL = 26
+const PAGE_DATA = PAGE_HEADER + 36 + 2*10
+
+const PAGE_OLD_INFIMUM = PAGE_DATA + 1 + REC_N_OLD_EXTRA_BYTES
+const PAGE_OLD_SUPREMUM = PAGE_DATA + 2 + 2*REC_N_OLD_EXTRA_BYTES + 8
+const PAGE_OLD_SUPREMUM_END = PAGE_OLD_SUPREMUM + 9
+const PAGE_NEW_INFIMUM = PAGE_DATA + REC_N_NEW_EXTRA_BYTES
+const PAGE_NEW_SUPREMUM = PAGE_DATA + 2*REC_N_NEW_EXTRA_BYTES + 8
+const PAGE_NEW_SUPREMUM_END = PAGE_NEW_SUPREMUM + 8
+
+const PAGE_N_HEAP_COMPACT = 0x8000
+const PAGE_HEAP_NO_USER_LOW = 2
+const PAGE_LEFT = 1
+const PAGE_RIGHT = 2
+const PAGE_NO_DIRECTION = 5
+
+// 页目录从FIL_PAGE_DATA_END往前长, 第0个槽指向infimum, 最后一个指向supremum
+const PAGE_DIR = FIL_PAGE_END_LSN_OLD_CHKSUM
+
+const FIL_PAGE_SDI = 17853
+
+// 记录头中的字段相对记录原点的位置 rem0rec.h
+const REC_NEXT = 2
+const REC_NEW_STATUS = 3
+const REC_NEW_HEAP_NO = 4
+const REC_NEW_N_OWNED = 5
+const REC_OLD_N_FIELDS = 4
+const REC_OLD_HEAP_NO = 5
+const REC_OLD_N_OWNED = 6
+const REC_N_OWNED_MASK = 0x0F
+const REC_HEAP_NO_SHIFT = 3
+
+const REC_STATUS_ORDINARY = 0
+const REC_STATUS_NODE_PTR = 1
+const REC_STATUS_INFIMUM = 2
+const REC_STATUS_SUPREMUM = 3
+const REC_NEW_STATUS_MASK = 0x07
+
+// 节点指针记录最后的子页号
+const REC_NODE_PTR_SIZE = 4
+
+// page0page.cc infimum_supremum_compact, infimum_supremum_redundant
+var INFIMUM_SUPREMUM_COMPACT = []byte{
+	0x01, 0x00, 0x02, 0x00, 0x0d, 'i', 'n', 'f', 'i', 'm', 'u', 'm', 0,
+	0x01, 0x00, 0x0b, 0x00, 0x00, 's', 'u', 'p', 'r', 'e', 'm', 'u', 'm',
+}
+var INFIMUM_SUPREMUM_REDUNDANT = []byte{
+	0x08, 0x01, 0x00, 0x00, 0x03, 0x00, 0x74, 'i', 'n', 'f', 'i', 'm', 'u', 'm', 0,
+	0x09, 0x01, 0x00, 0x08, 0x03, 0x00, 0x00, 's', 'u', 'p', 'r', 'e', 'm', 'u', 'm', 0,
+}
+
+// page_create_low: 页类型, 页头的前26个字节, infimum和supremum, 后面到页目录都清零, 两个槽
+func Page_Create(page []byte, comp bool, page_type uint64) {
+	size := uint64(len(page))
+	if size < gibd.DEFAULT_PAGE_SIZE {
+		panic(ErrNotApplicable)
+	}
+	Write_Uint(page, FIL_PAGE_TYPE, 2, page_type)
+	for i := uint64(PAGE_HEADER); i < PAGE_HEADER+PAGE_HEADER_PRIV_END; i++ {
+		page[i] = 0
+	}
+	Write_Uint(page, PAGE_HEADER+PAGE_N_DIR_SLOTS, 2, 2)
+	Write_Uint(page, PAGE_HEADER+PAGE_DIRECTION, 2, PAGE_NO_DIRECTION)
+	infimum, supremum, supremum_end := uint64(PAGE_OLD_INFIMUM), uint64(PAGE_OLD_SUPREMUM), uint64(PAGE_OLD_SUPREMUM_END)
+	records := INFIMUM_SUPREMUM_REDUNDANT
+	n_heap := uint64(PAGE_HEAP_NO_USER_LOW)
+	if comp {
+		infimum, supremum, supremum_end = PAGE_NEW_INFIMUM, PAGE_NEW_SUPREMUM, PAGE_NEW_SUPREMUM_END
+		records = INFIMUM_SUPREMUM_COMPACT
+		n_heap = n_heap | PAGE_N_HEAP_COMPACT
+	}
+	Write_Uint(page, PAGE_HEADER+PAGE_N_HEAP, 2, n_heap)
+	Write_Uint(page, PAGE_HEADER+PAGE_HEAP_TOP, 2, supremum_end)
+	copy(page[PAGE_DATA:], records)
+	for i := supremum_end; i < size-PAGE_DIR; i++ {
+		page[i] = 0
+	}
+	Write_Uint(page, size-PAGE_DIR-2*gibd.PAGE_DIR_SLOT_SIZE, 2, supremum)
+	Write_Uint(page, size-PAGE_DIR-gibd.PAGE_DIR_SLOT_SIZE, 2, infimum)
+}
+
+// 一个索引页和修改它的日志中带的索引信息, 所有读写都检查边界, 页的结构不对的时候panic ErrNotApplicable
+type indexPage struct {
+	data  []byte
+	index *LogIndex
+}
+
+func newIndexPage(data []byte, index *LogIndex) *indexPage {
+	if index == nil || uint64(len(data)) < gibd.DEFAULT_PAGE_SIZE {
+		panic(ErrNotApplicable)
+	}
+	page := &indexPage{data: data, index: index}
+	if (page.header(PAGE_N_HEAP)&PAGE_N_HEAP_COMPACT != 0) != index.Comp {
+		panic(ErrNotApplicable)
+	}
+	return page
+}
+
+func (page *indexPage) uint(pos uint64, n uint64) uint64 {
+	return Page_Read_Uint(page.data, pos, n)
+}
+
+func (page *indexPage) set_uint(pos uint64, n uint64, value uint64) {
+	if pos >= uint64(len(page.data)) {
+		panic(ErrNotApplicable)
+	}
+	Page_Write_Uint(page.data, pos, n, value)
+}
+
+func (page *indexPage) header(field uint64) uint64 {
+	return page.uint(PAGE_HEADER+field, 2)
+}
+
+func (page *indexPage) set_header(field uint64, value uint64) {
+	page.set_uint(PAGE_HEADER+field, 2, value)
+}
+
+func (page *indexPage) n_heap() uint64 {
+	return page.header(PAGE_N_HEAP) &^ PAGE_N_HEAP_COMPACT
+}
+
+func (page *indexPage) set_n_heap(n_heap uint64) {
+	page.set_header(PAGE_N_HEAP, n_heap|page.header(PAGE_N_HEAP)&PAGE_N_HEAP_COMPACT)
+}
+
+func (page *indexPage) set_direction(direction uint64, n_direction uint64) {
+	page.set_header(PAGE_DIRECTION, direction)
+	page.set_header(PAGE_N_DIRECTION, n_direction)
+}
+
+func (page *indexPage) infimum() uint64 {
+	if page.index.Comp {
+		return PAGE_NEW_INFIMUM
+	}
+	return PAGE_OLD_INFIMUM
+}
+
+func (page *indexPage) supremum() uint64 {
+	if page.index.Comp {
+		return PAGE_NEW_SUPREMUM
+	}
+	return PAGE_OLD_SUPREMUM
+}
+
+func (page *indexPage) supremum_end() uint64 {
+	if page.index.Comp {
+		return PAGE_NEW_SUPREMUM_END
+	}
+	return PAGE_OLD_SUPREMUM_END
+}
+
+// rec_get_next_offs: 新格式是相对位置, 老格式是页内偏移, 0表示没有下一条
+func (page *indexPage) next(rec uint64) uint64 {
+	next := page.uint(rec-REC_NEXT, 2)
+	if !page.index.Comp || next == 0 {
+		return next
+	}
+	return (rec + next) % uint64(len(page.data))
+}
+
+// 链表中的下一条记录, 链表断了的时候panic
+func (page *indexPage) next_rec(rec uint64) uint64 {
+	next := page.next(rec)
+	if next < page.infimum() || next >= uint64(len(page.data))-PAGE_DIR {
+		panic(ErrNotApplicable)
+	}
+	return next
+}
+
+// rec_set_next_offs_new/old
+func (page *indexPage) set_next(rec uint64, next uint64) {
+	if page.index.Comp && next != 0 {
+		next = (next - rec) & 0xFFFF
+	}
+	page.set_uint(rec-REC_NEXT, 2, next)
+}
+
+// 从infimum开始找rec的前一条记录, 走的步数超过堆中的记录数说明链表有环
+func (page *indexPage) prev(rec uint64) uint64 {
+	if rec == page.infimum() {
+		panic(ErrNotApplicable)
+	}
+	prev := page.infimum()
+	for i := uint64(0); ; i++ {
+		next := page.next_rec(prev)
+		if next == rec {
+			return prev
+		}
+		if next == page.supremum() || i > page.n_heap() {
+			panic(ErrNotApplicable)
+		}
+		prev = next
+	}
+}
+
+func (page *indexPage) n_owned_pos(rec uint64) uint64 {
+	if page.index.Comp {
+		return rec - REC_NEW_N_OWNED
+	}
+	return rec - REC_OLD_N_OWNED
+}
+
+func (page *indexPage) n_owned(rec uint64) uint64 {
+	return page.uint(page.n_owned_pos(rec), 1) & REC_N_OWNED_MASK
+}
+
+func (page *indexPage) set_n_owned(rec uint64, n_owned uint64) {
+	pos := page.n_owned_pos(rec)
+	page.set_uint(pos, 1, page.uint(pos, 1)&^REC_N_OWNED_MASK|n_owned&REC_N_OWNED_MASK)
+}
+
+func (page *indexPage) heap_no_pos(rec uint64) uint64 {
+	if page.index.Comp {
+		return rec - REC_NEW_HEAP_NO
+	}
+	return rec - REC_OLD_HEAP_NO
+}
+
+func (page *indexPage) heap_no(rec uint64) uint64 {
+	return page.uint(page.heap_no_pos(rec), 2) >> REC_HEAP_NO_SHIFT
+}
+
+func (page *indexPage) set_heap_no(rec uint64, heap_no uint64) {
+	pos := page.heap_no_pos(rec)
+	page.set_uint(pos, 2, page.uint(pos, 2)&(1<<REC_HEAP_NO_SHIFT-1)|heap_no<<REC_HEAP_NO_SHIFT)
+}
+
+// rec_get_info_and_status_bits: 老格式没有status
+func (page *indexPage) info_and_status_bits(rec uint64) uint64 {
+	bits := page.uint(Rec_Info_Pos(rec, page.index.Comp), 1) & REC_INFO_BITS_MASK
+	if page.index.Comp {
+		bits = bits | page.uint(rec-REC_NEW_STATUS, 1)&REC_NEW_STATUS_MASK
+	}
+	return bits
+}
+
+func (page *indexPage) set_info_and_status_bits(rec uint64, bits uint64) {
+	pos := Rec_Info_Pos(rec, page.index.Comp)
+	page.set_uint(pos, 1, page.uint(pos, 1)&^REC_INFO_BITS_MASK|bits&REC_INFO_BITS_MASK)
+	if page.index.Comp {
+		page.set_uint(rec-REC_NEW_STATUS, 1, page.uint(rec-REC_NEW_STATUS, 1)&^REC_NEW_STATUS_MASK|bits&REC_NEW_STATUS_MASK)
+	}
+}
+
+// rec_offs_extra_size和rec_offs_data_size
+func (page *indexPage) rec_size(rec uint64) (uint64, uint64) {
+	offsets, extra := Rec_Field_Offsets(page.data, rec, page.index)
+	if len(offsets) == 0 {
+		return extra, 0
+	}
+	last := offsets[len(offsets)-1]
+	return extra, last.Start + last.Length
+}
+
+// 整条记录(记录头加数据)和记录头的长度
+func (page *indexPage) rec_bytes(rec uint64) ([]byte, uint64) {
+	extra, data := page.rec_size(rec)
+	if extra > rec || rec+data > uint64(len(page.data)) {
+		panic(ErrNotApplicable)
+	}
+	return page.data[rec-extra : rec+data], extra
+}
+
+// 第n个槽的位置, 第0个槽在最后
+func (page *indexPage) slot_pos(n uint64) uint64 {
+	size := uint64(len(page.data))
+	if n >= (size-PAGE_DIR-PAGE_DATA)/gibd.PAGE_DIR_SLOT_SIZE {
+		panic(ErrNotApplicable)
+	}
+	return size - PAGE_DIR - (n+1)*gibd.PAGE_DIR_SLOT_SIZE
+}
+
+func (page *indexPage) slot(n uint64) uint64 {
+	return page.uint(page.slot_pos(n), gibd.PAGE_DIR_SLOT_SIZE)
+}
+
+func (page *indexPage) set_slot(n uint64, rec uint64) {
+	page.set_uint(page.slot_pos(n), gibd.PAGE_DIR_SLOT_SIZE, rec)
+}
+
+func (page *indexPage) n_slots() uint64 {
+	return page.header(PAGE_N_DIR_SLOTS)
+}
+
+// page_rec_find_owner_rec: 组里最后一条记录的n_owned不是0
+func (page *indexPage) find_owner_rec(rec uint64) uint64 {
+	for i := uint64(0); page.n_owned(rec) == 0; i++ {
+		if i > gibd.PAGE_DIR_SLOT_MAX_N_OWNED {
+			panic(ErrNotApplicable)
+		}
+		rec = page.next_rec(rec)
+	}
+	return rec
+}
+
+// page_dir_find_owner_slot
+func (page *indexPage) find_owner_slot(rec uint64) uint64 {
+	owner := page.find_owner_rec(rec)
+	for i := page.n_slots(); i > 0; i-- {
+		if page.slot(i-1) == owner {
+			return i - 1
+		}
+	}
+	panic(ErrNotApplicable)
+}
+
+// page_dir_add_slot: start后面加一个空槽, 后面的槽往页头方向移
+func (page *indexPage) add_slot(start uint64) {
+	n_slots := page.n_slots()
+	if start+1 >= n_slots {
+		panic(ErrNotApplicable)
+	}
+	page.set_header(PAGE_N_DIR_SLOTS, n_slots+1)
+	pos := page.slot_pos(n_slots)
+	copy(page.data[pos:], page.data[pos+gibd.PAGE_DIR_SLOT_SIZE:pos+gibd.PAGE_DIR_SLOT_SIZE+(n_slots-1-start)*gibd.PAGE_DIR_SLOT_SIZE])
+}
+
+// page_dir_split_slot: 槽拥有的记录超过PAGE_DIR_SLOT_MAX_N_OWNED的时候, 前一半给新加的槽
+func (page *indexPage) split_slot(slot_no uint64) {
+	if slot_no == 0 {
+		panic(ErrNotApplicable)
+	}
+	n_owned := page.n_owned(page.slot(slot_no))
+	rec := page.slot(slot_no - 1)
+	for i := uint64(0); i < n_owned/2; i++ {
+		rec = page.next_rec(rec)
+	}
+	page.add_slot(slot_no - 1)
+	page.set_slot(slot_no, rec)
+	page.set_n_owned(rec, n_owned/2)
+	page.set_n_owned(page.slot(slot_no+1), n_owned-n_owned/2)
+}
+
+// page_dir_delete_slot: 和上面的槽合并
+func (page *indexPage) delete_slot(slot_no uint64) {
+	n_slots := page.n_slots()
+	if slot_no == 0 || slot_no+1 >= n_slots {
+		panic(ErrNotApplicable)
+	}
+	n_owned := page.n_owned(page.slot(slot_no))
+	page.set_n_owned(page.slot(slot_no), 0)
+	up := page.slot(slot_no + 1)
+	page.set_n_owned(up, n_owned+page.n_owned(up))
+	for i := slot_no + 1; i < n_slots; i++ {
+		page.set_slot(i-1, page.slot(i))
+	}
+	page.set_slot(n_slots-1, 0)
+	page.set_header(PAGE_N_DIR_SLOTS, n_slots-1)
+}
+
+// page_dir_balance_slot: 槽拥有的记录少于PAGE_DIR_SLOT_MIN_N_OWNED的时候从上面的槽借一条, 借不到就合并
+func (page *indexPage) balance_slot(slot_no uint64) {
+	if slot_no+1 == page.n_slots() {
+		return
+	}
+	rec := page.slot(slot_no)
+	n_owned := page.n_owned(rec)
+	up := page.slot(slot_no + 1)
+	up_n_owned := page.n_owned(up)
+	if up_n_owned <= gibd.PAGE_DIR_SLOT_MIN_N_OWNED {
+		page.delete_slot(slot_no)
+		return
+	}
+	next := page.next_rec(rec)
+	page.set_n_owned(rec, 0)
+	page.set_n_owned(next, n_owned+1)
+	page.set_slot(slot_no, next)
+	page.set_n_owned(up, up_n_owned-1)
+}
+
+// page_get_max_insert_size: 插入n_recs条记录以后还能用的空间, 包括页目录要用的
+func (page *indexPage) max_insert_size(n_recs uint64) uint64 {
+	n_dir := n_recs + page.n_heap() - 2
+	occupied := page.header(PAGE_HEAP_TOP) - page.supremum_end() +
+		(gibd.PAGE_DIR_SLOT_SIZE*n_dir+gibd.PAGE_DIR_SLOT_MIN_N_OWNED-1)/gibd.PAGE_DIR_SLOT_MIN_N_OWNED
+	free_space := uint64(len(page.data)) - page.supremum_end() - PAGE_DIR - 2*gibd.PAGE_DIR_SLOT_SIZE
+	if occupied > free_space {
+		return 0
+	}
+	return free_space - occupied
+}
+
+// page_cur_insert_rec_low: 把rec(记录头长extra)插到current后面, 返回插入的记录
+// PAGE_FREE链表的第一条记录够大的时候用它的空间和heap no, 否则从PAGE_HEAP_TOP分配
+func (page *indexPage) insert_rec(current uint64, rec []byte, extra uint64) uint64 {
+	if current == page.supremum() {
+		panic(ErrNotApplicable)
+	}
+	size := uint64(len(rec))
+	var buf, heap_no uint64
+	if free := page.header(PAGE_FREE); free != 0 {
+		free_extra, free_data := page.rec_size(free)
+		if free_extra+free_data >= size {
+			buf = free - free_extra
+			heap_no = page.heap_no(free)
+			// page_mem_alloc_free
+			page.set_header(PAGE_FREE, page.next(free))
+			page.set_header(PAGE_GARBAGE, page.header(PAGE_GARBAGE)-size)
+		}
+	}
+	if buf == 0 {
+		// page_mem_alloc_heap
+		if page.max_insert_size(1) < size {
+			panic(ErrNotApplicable)
+		}
+		buf = page.header(PAGE_HEAP_TOP)
+		page.set_header(PAGE_HEAP_TOP, buf+size)
+		heap_no = page.n_heap()
+		page.set_n_heap(heap_no + 1)
+	}
+	Page_Write_Bytes(page.data, buf, rec)
+	insert := buf + extra
+
+	page.set_next(insert, page.next_rec(current))
+	page.set_next(current, insert)
+	page.set_header(PAGE_N_RECS, page.header(PAGE_N_RECS)+1)
+	page.set_n_owned(insert, 0)
+	page.set_heap_no(insert, heap_no)
+
+	// 恢复的时候用的索引不是空间索引, 都要更新插入方向
+	last_insert := page.header(PAGE_LAST_INSERT)
+	direction := page.header(PAGE_DIRECTION)
+	switch {
+	case last_insert == 0:
+		page.set_direction(PAGE_NO_DIRECTION, 0)
+	case last_insert == current && direction != PAGE_LEFT:
+		page.set_direction(PAGE_RIGHT, page.header(PAGE_N_DIRECTION)+1)
+	case page.next(insert) == last_insert && direction != PAGE_RIGHT:
+		page.set_direction(PAGE_LEFT, page.header(PAGE_N_DIRECTION)+1)
+	default:
+		page.set_direction(PAGE_NO_DIRECTION, 0)
+	}
+	page.set_header(PAGE_LAST_INSERT, insert)
+
+	owner := page.find_owner_rec(insert)
+	n_owned := page.n_owned(owner)
+	page.set_n_owned(owner, n_owned+1)
+	if n_owned == gibd.PAGE_DIR_SLOT_MAX_N_OWNED {
+		page.split_slot(page.find_owner_slot(owner))
+	}
+	return insert
+}
+
+// page_cur_parse_insert_rec: 插入的记录前mismatch_index个字节和游标记录一样, 日志里只有后面的部分
+// end_seg_len最低位是0的时候info bits和记录头长度也和游标记录一样
+// short是LIST_END_COPY_CREATED中的记录, 没有游标记录的位置, 插在最后一条记录后面
+func (page *indexPage) parse_insert_rec(reader *MlogReader, short bool) {
+	var cursor uint64
+	if short {
+		cursor = page.prev(page.supremum())
+	} else {
+		cursor = reader.Read_Uint(2)
+	}
+	end_seg_len := reader.Read_Compressed()
+	var bits, origin, mismatch uint64
+	if end_seg_len&0x1 != 0 {
+		bits = reader.Read_Uint(1)
+		origin = reader.Read_Compressed()
+		mismatch = reader.Read_Compressed()
+	}
+	end_seg := reader.Read_Bytes(end_seg_len >> 1)
+
+	extra, data := page.rec_size(cursor)
+	if end_seg_len&0x1 == 0 {
+		bits = page.info_and_status_bits(cursor)
+		origin = extra
+		mismatch = extra + data - uint64(len(end_seg))
+	}
+	start := cursor - extra
+	if mismatch >= uint64(len(page.data)) || start+mismatch > uint64(len(page.data)) {
+		panic(ErrNotApplicable)
+	}
+	buf := append(append([]byte(nil), page.data[start:start+mismatch]...), end_seg...)
+
+	rec := &indexPage{data: buf, index: page.index}
+	rec.set_info_and_status_bits(origin, bits)
+	insert, insert_extra := rec.rec_bytes(origin)
+	page.insert_rec(cursor, insert, insert_extra)
+}
+
+// page_cur_delete_rec: 把rec从链表中摘掉放到PAGE_FREE链表的开头, 返回下一条记录
+func (page *indexPage) delete_rec(rec uint64) uint64 {
+	if rec == page.infimum() || rec == page.supremum() {
+		panic(ErrNotApplicable)
+	}
+	extra, data := page.rec_size(rec)
+	slot_no := page.find_owner_slot(rec)
+	if slot_no == 0 {
+		panic(ErrNotApplicable)
+	}
+	n_owned := page.n_owned(page.slot(slot_no))
+	page.set_header(PAGE_LAST_INSERT, 0)
+
+	var prev uint64
+	for i, r := uint64(0), page.slot(slot_no-1); r != rec; i++ {
+		if i > 2*gibd.PAGE_DIR_SLOT_MAX_N_OWNED {
+			panic(ErrNotApplicable)
+		}
+		prev = r
+		r = page.next_rec(r)
+	}
+	next := page.next_rec(rec)
+	page.set_next(prev, next)
+	if rec == page.slot(slot_no) {
+		page.set_slot(slot_no, prev)
+	}
+	page.set_n_owned(page.slot(slot_no), n_owned-1)
+
+	// page_mem_free
+	page.set_next(rec, page.header(PAGE_FREE))
+	page.set_header(PAGE_FREE, rec)
+	page.set_header(PAGE_GARBAGE, page.header(PAGE_GARBAGE)+extra+data)
+	page.set_header(PAGE_N_RECS, page.header(PAGE_N_RECS)-1)
+
+	if n_owned <= gibd.PAGE_DIR_SLOT_MIN_N_OWNED {
+		page.balance_slot(slot_no)
+	}
+	return next
+}
+
+// page_delete_rec_list_end: rec和后面的记录整段接到PAGE_FREE链表的开头, 拥有rec的槽改成supremum的槽
+func (page *indexPage) delete_list_end(rec uint64) {
+	supremum := page.supremum()
+	if rec == supremum {
+		return
+	}
+	page.set_header(PAGE_LAST_INSERT, 0)
+	prev := page.prev(rec)
+	last := page.prev(supremum)
+
+	var size, n_recs uint64
+	for r := rec; r != supremum; r = page.next_rec(r) {
+		if n_recs > page.n_heap() {
+			panic(ErrNotApplicable)
+		}
+		extra, data := page.rec_size(r)
+		size = size + extra + data
+		n_recs++
+	}
+
+	owner := page.find_owner_rec(rec)
+	var count uint64
+	for r := rec; r != owner; r = page.next_rec(r) {
+		count++
+	}
+	if page.n_owned(owner) <= count || n_recs > page.header(PAGE_N_RECS) {
+		panic(ErrNotApplicable)
+	}
+	n_owned := page.n_owned(owner) - count
+	slot_no := page.find_owner_slot(owner)
+	page.set_slot(slot_no, supremum)
+	page.set_n_owned(supremum, n_owned)
+	page.set_header(PAGE_N_DIR_SLOTS, slot_no+1)
+
+	page.set_next(prev, supremum)
+	page.set_next(last, page.header(PAGE_FREE))
+	page.set_header(PAGE_FREE, rec)
+	page.set_header(PAGE_GARBAGE, page.header(PAGE_GARBAGE)+size)
+	page.set_header(PAGE_N_RECS, page.header(PAGE_N_RECS)-n_recs)
+}
+
+// page_delete_rec_list_start: 一条一条删除rec前面的用户记录
+func (page *indexPage) delete_list_start(rec uint64) {
+	if rec == page.infimum() {
+		return
+	}
+	// 删除所有记录的时候MySQL重建空页, 不会记这条日志
+	if rec == page.supremum() {
+		panic(ErrNotApplicable)
+	}
+	for r := page.next_rec(page.infimum()); r != rec; {
+		r = page.delete_rec(r)
+	}
+}
+
+// btr_page_reorganize_low: 建一个同类型的空页, 按链表顺序把记录重新插进去, 页上的碎片和PAGE_FREE链表都没了
+// 恢复用的索引信息中n_uniq和n一样的(二级索引)叶子页保留PAGE_MAX_TRX_ID
+func (page *indexPage) reorganize() {
+	temp := &indexPage{data: append([]byte(nil), page.data...), index: page.index}
+	Page_Create(page.data, page.index.Comp, page.uint(FIL_PAGE_TYPE, 2))
+	current := page.infimum()
+	for i, rec := uint64(0), temp.next_rec(temp.infimum()); rec != temp.supremum(); i, rec = i+1, temp.next_rec(rec) {
+		if i > temp.n_heap() {
+			panic(ErrNotApplicable)
+		}
+		data, extra := temp.rec_bytes(rec)
+		current = page.insert_rec(current, data, extra)
+	}
+	if !page.index.Is_Clustered() && page.header(PAGE_LEVEL) == 0 {
+		page.set_uint(PAGE_HEADER+PAGE_MAX_TRX_ID, 8, temp.uint(PAGE_HEADER+PAGE_MAX_TRX_ID, 8))
+	}
+}
@@ -0,0 +1,680 @@
+package redo
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/pretty"
+)
+
+// mini-transaction日志记录的类型 mtr0types.h
+const MLOG_SINGLE_REC_FLAG = 0x80
+
+const MLOG_1BYTE = 1
+const MLOG_2BYTES = 2
+const MLOG_4BYTES = 4
+const MLOG_8BYTES = 8
+const MLOG_REC_INSERT = 9
+const MLOG_REC_CLUST_DELETE_MARK = 10
+const MLOG_REC_SEC_DELETE_MARK = 11
+const MLOG_REC_UPDATE_IN_PLACE = 13
+const MLOG_REC_DELETE = 14
+const MLOG_LIST_END_DELETE = 15
+const MLOG_LIST_START_DELETE = 16
+const MLOG_LIST_END_COPY_CREATED = 17
+const MLOG_PAGE_REORGANIZE = 18
+const MLOG_PAGE_CREATE = 19
+const MLOG_UNDO_INSERT = 20
+const MLOG_UNDO_ERASE_END = 21
+const MLOG_UNDO_INIT = 22
+const MLOG_UNDO_HDR_DISCARD = 23
+const MLOG_UNDO_HDR_REUSE = 24
+const MLOG_UNDO_HDR_CREATE = 25
+const MLOG_REC_MIN_MARK = 26
+const MLOG_IBUF_BITMAP_INIT = 27
+const MLOG_LSN = 28
+const MLOG_INIT_FILE_PAGE = 29
+const MLOG_WRITE_STRING = 30
+const MLOG_MULTI_REC_END = 31
+const MLOG_DUMMY_RECORD = 32
+const MLOG_FILE_CREATE = 33
+const MLOG_FILE_RENAME = 34
+const MLOG_FILE_DELETE = 35
+const MLOG_COMP_REC_MIN_MARK = 36
+const MLOG_COMP_PAGE_CREATE = 37
+const MLOG_COMP_REC_INSERT = 38
+const MLOG_COMP_REC_CLUST_DELETE_MARK = 39
+const MLOG_COMP_REC_SEC_DELETE_MARK = 40
+const MLOG_COMP_REC_UPDATE_IN_PLACE = 41
+const MLOG_COMP_REC_DELETE = 42
+const MLOG_COMP_LIST_END_DELETE = 43
+const MLOG_COMP_LIST_START_DELETE = 44
+const MLOG_COMP_LIST_END_COPY_CREATED = 45
+const MLOG_COMP_PAGE_REORGANIZE = 46
+const MLOG_FILE_CREATE2 = 47
+const MLOG_ZIP_WRITE_NODE_PTR = 48
+const MLOG_ZIP_WRITE_BLOB_PTR = 49
+const MLOG_ZIP_WRITE_HEADER = 50
+const MLOG_ZIP_PAGE_COMPRESS = 51
+const MLOG_ZIP_PAGE_COMPRESS_NO_DATA = 52
+const MLOG_ZIP_PAGE_REORGANIZE = 53
+const MLOG_FILE_RENAME2 = 54
+const MLOG_FILE_NAME = 55
+const MLOG_CHECKPOINT = 56
+const MLOG_PAGE_CREATE_RTREE = 57
+const MLOG_COMP_PAGE_CREATE_RTREE = 58
+const MLOG_INIT_FILE_PAGE2 = 59
+const MLOG_TRUNCATE = 60
+const MLOG_INDEX_LOAD = 61
+const MLOG_TABLE_DYNAMIC_META = 62
+const MLOG_PAGE_CREATE_SDI = 63
+const MLOG_FILE_EXTEND = 64
+const MLOG_TEST = 65
+
+// 8.0.28以后带版本化索引信息的记录类型, 内容和对应的COMP类型一样, 只是索引信息的格式不同
+const MLOG_REC_INSERT_8028 = 66
+const MLOG_REC_CLUST_DELETE_MARK_8028 = 67
+const MLOG_REC_DELETE_8028 = 68
+const MLOG_LIST_END_DELETE_8028 = 69
+const MLOG_LIST_START_DELETE_8028 = 70
+const MLOG_LIST_END_COPY_CREATED_8028 = 71
+const MLOG_PAGE_REORGANIZE_8028 = 72
+const MLOG_ZIP_PAGE_REORGANIZE_8028 = 73
+const MLOG_ZIP_PAGE_COMPRESS_NO_DATA_8028 = 74
+const MLOG_REC_UPDATE_IN_PLACE_8028 = 75
+
+// 8.0.28以后索引信息中flags字节的位
+const INDEX_LOG_COMPACT = 0x01
+const INDEX_LOG_VERSIONED = 0x02
+const INDEX_LOG_INSTANT = 0x04
+
+// 8.0.12~8.0.27的COMP索引信息中, n的最高位表示后面有instant ADD之前的字段数
+const INDEX_LOG_INSTANT_N = 0x8000
+
+// MLOG_TABLE_DYNAMIC_META中持久化的元数据类型, dict0dict.h persistent_type_t
+const PM_INDEX_CORRUPTED = 0
+const PM_TABLE_AUTO_INC = 1
+
+var MLOG_TYPES = map[uint64]string{
+	1:  "MLOG_1BYTE",
+	2:  "MLOG_2BYTES",
+	4:  "MLOG_4BYTES",
+	8:  "MLOG_8BYTES",
+	9:  "MLOG_REC_INSERT",
+	10: "MLOG_REC_CLUST_DELETE_MARK",
+	11: "MLOG_REC_SEC_DELETE_MARK",
+	13: "MLOG_REC_UPDATE_IN_PLACE",
+	14: "MLOG_REC_DELETE",
+	15: "MLOG_LIST_END_DELETE",
+	16: "MLOG_LIST_START_DELETE",
+	17: "MLOG_LIST_END_COPY_CREATED",
+	18: "MLOG_PAGE_REORGANIZE",
+	19: "MLOG_PAGE_CREATE",
+	20: "MLOG_UNDO_INSERT",
+	21: "MLOG_UNDO_ERASE_END",
+	22: "MLOG_UNDO_INIT",
+	23: "MLOG_UNDO_HDR_DISCARD",
+	24: "MLOG_UNDO_HDR_REUSE",
+	25: "MLOG_UNDO_HDR_CREATE",
+	26: "MLOG_REC_MIN_MARK",
+	27: "MLOG_IBUF_BITMAP_INIT",
+	28: "MLOG_LSN",
+	29: "MLOG_INIT_FILE_PAGE",
+	30: "MLOG_WRITE_STRING",
+	31: "MLOG_MULTI_REC_END",
+	32: "MLOG_DUMMY_RECORD",
+	33: "MLOG_FILE_CREATE",
+	34: "MLOG_FILE_RENAME",
+	35: "MLOG_FILE_DELETE",
+	36: "MLOG_COMP_REC_MIN_MARK",
+	37: "MLOG_COMP_PAGE_CREATE",
+	38: "MLOG_COMP_REC_INSERT",
+	39: "MLOG_COMP_REC_CLUST_DELETE_MARK",
+	40: "MLOG_COMP_REC_SEC_DELETE_MARK",
+	41: "MLOG_COMP_REC_UPDATE_IN_PLACE",
+	42: "MLOG_COMP_REC_DELETE",
+	43: "MLOG_COMP_LIST_END_DELETE",
+	44: "MLOG_COMP_LIST_START_DELETE",
+	45: "MLOG_COMP_LIST_END_COPY_CREATED",
+	46: "MLOG_COMP_PAGE_REORGANIZE",
+	47: "MLOG_FILE_CREATE2",
+	48: "MLOG_ZIP_WRITE_NODE_PTR",
+	49: "MLOG_ZIP_WRITE_BLOB_PTR",
+	50: "MLOG_ZIP_WRITE_HEADER",
+	51: "MLOG_ZIP_PAGE_COMPRESS",
+	52: "MLOG_ZIP_PAGE_COMPRESS_NO_DATA",
+	53: "MLOG_ZIP_PAGE_REORGANIZE",
+	54: "MLOG_FILE_RENAME2",
+	55: "MLOG_FILE_NAME",
+	56: "MLOG_CHECKPOINT",
+	57: "MLOG_PAGE_CREATE_RTREE",
+	58: "MLOG_COMP_PAGE_CREATE_RTREE",
+	59: "MLOG_INIT_FILE_PAGE2",
+	60: "MLOG_TRUNCATE",
+	61: "MLOG_INDEX_LOAD",
+	62: "MLOG_TABLE_DYNAMIC_META",
+	63: "MLOG_PAGE_CREATE_SDI",
+	64: "MLOG_FILE_EXTEND",
+	65: "MLOG_TEST",
+	66: "MLOG_REC_INSERT_8028",
+	67: "MLOG_REC_CLUST_DELETE_MARK_8028",
+	68: "MLOG_REC_DELETE_8028",
+	69: "MLOG_LIST_END_DELETE_8028",
+	70: "MLOG_LIST_START_DELETE_8028",
+	71: "MLOG_LIST_END_COPY_CREATED_8028",
+	72: "MLOG_PAGE_REORGANIZE_8028",
+	73: "MLOG_ZIP_PAGE_REORGANIZE_8028",
+	74: "MLOG_ZIP_PAGE_COMPRESS_NO_DATA_8028",
+	75: "MLOG_REC_UPDATE_IN_PLACE_8028",
+}
+
+// UPDATE_IN_PLACE中NULL字段的长度
+const UNIV_SQL_NULL = 0xFFFFFFFF
+
+var ErrTruncated = errors.New("log record is truncated")
+var ErrUnsupported = errors.New("log record type is not supported")
+
+type HexBytes []byte
+
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+// 一条日志记录, 没有space id和page no的类型(MULTI_REC_END, DUMMY, CHECKPOINT, TABLE_DYNAMIC_META)这两个字段是0
+type LogRecord struct {
+	Lsn         uint64   `json:"lsn"`
+	End_lsn     uint64   `json:"end_lsn"` // 下一条记录的lsn, 跳过了block头和尾
+	Type        uint64   `json:"type"`
+	Type_name   string   `json:"type_name"`
+	Single      bool     `json:"single"` // 这个mtr只有一条记录, 后面没有MULTI_REC_END
+	Space_id    uint64   `json:"space_id"`
+	Page_number uint64   `json:"page_number"`
+	Length      uint64   `json:"length"` // 整条记录的长度
+	Body        HexBytes `json:"body"`
+	Error       string   `json:"error,omitempty"` // 解析失败, 跳过了到下一个mtr开始之间的数据
+	// MLOG_TABLE_DYNAMIC_META的表id, 元数据的版本和Body中解析出来的元数据
+	Table_id     uint64           `json:"table_id,omitempty"`
+	Version      uint64           `json:"version,omitempty"`
+	Dynamic_meta *DynamicMetadata `json:"dynamic_meta,omitempty"`
+}
+
+// dict0dict.cc PersistentTableMetadata: 标记为损坏的索引和自增值
+type DynamicMetadata struct {
+	Corrupted_indexes []CorruptedIndex `json:"corrupted_indexes,omitempty"`
+	Autoinc           *uint64          `json:"autoinc,omitempty"`
+}
+
+type CorruptedIndex struct {
+	Space_id uint64 `json:"space_id"`
+	Index_id uint64 `json:"index_id"`
+}
+
+// 记录中带的索引信息, mlog_parse_index, 只有紧凑格式或者有row version的时候有字段信息
+type LogIndex struct {
+	Comp         bool             `json:"comp"`
+	Versioned    bool             `json:"versioned"`    // 8.0.29以后instant ADD/DROP过, 记录的物理字段顺序和索引不一样
+	Instant_cols uint64           `json:"instant_cols"` // 8.0.12以后instant ADD之前的字段数, 0表示没有instant ADD
+	N_uniq       uint64           `json:"n_uniq"`
+	Fields       []IndexFieldInfo `json:"fields"`
+}
+
+func (record *LogRecord) Has_Page() bool {
+	switch record.Type {
+	case MLOG_MULTI_REC_END, MLOG_DUMMY_RECORD, MLOG_CHECKPOINT, MLOG_TABLE_DYNAMIC_META:
+		return false
+	}
+	return record.Error == ""
+}
+
+// 带边界检查的读取, 越界的时候panic ErrTruncated, 由Parse_Record恢复
+type MlogReader struct {
+	Data []byte
+	Pos  uint64
+}
+
+func NewMlogReader(data []byte, pos uint64) *MlogReader {
+	return &MlogReader{Data: data, Pos: pos}
+}
+
+func (reader *MlogReader) Read_Bytes(n uint64) []byte {
+	if reader.Pos+n > uint64(len(reader.Data)) {
+		panic(ErrTruncated)
+	}
+	b := reader.Data[reader.Pos : reader.Pos+n]
+	reader.Pos = reader.Pos + n
+	return b
+}
+
+func (reader *MlogReader) Read_Uint(n uint64) uint64 {
+	return Read_Uint(reader.Read_Bytes(n), 0, n)
+}
+
+// mach_parse_compressed
+func (reader *MlogReader) Read_Compressed() uint64 {
+	flag := reader.Read_Bytes(1)[0]
+	reader.Pos--
+	switch {
+	case flag < 0x80:
+		return reader.Read_Uint(1)
+	case flag < 0xC0:
+		return reader.Read_Uint(2) & 0x3FFF
+	case flag < 0xE0:
+		return reader.Read_Uint(3) & 0x1FFFFF
+	case flag < 0xF0:
+		return reader.Read_Uint(4) & 0xFFFFFFF
+	default:
+		reader.Pos++
+		return reader.Read_Uint(4)
+	}
+}
+
+// mach_u64_parse_compressed: 高32位压缩, 低32位固定4字节
+func (reader *MlogReader) Read_U64_Compressed() uint64 {
+	high := reader.Read_Compressed()
+	return high<<32 | reader.Read_Uint(4)
+}
+
+// mach_u64_parse_much_compressed: 第一个字节是0xFF的时候高32位和低32位都是压缩的, 否则只有低32位
+func (reader *MlogReader) Read_U64_Much_Compressed() uint64 {
+	if reader.Read_Bytes(1)[0] != 0xFF {
+		reader.Pos--
+		return reader.Read_Compressed()
+	}
+	high := reader.Read_Compressed()
+	return high<<32 | reader.Read_Compressed()
+}
+
+// 8.0.28以后的类型对应的COMP类型, 其他类型不变
+func Unversioned_Type(mlog_type uint64) uint64 {
+	switch mlog_type {
+	case MLOG_REC_INSERT_8028:
+		return MLOG_COMP_REC_INSERT
+	case MLOG_REC_CLUST_DELETE_MARK_8028:
+		return MLOG_COMP_REC_CLUST_DELETE_MARK
+	case MLOG_REC_DELETE_8028:
+		return MLOG_COMP_REC_DELETE
+	case MLOG_LIST_END_DELETE_8028:
+		return MLOG_COMP_LIST_END_DELETE
+	case MLOG_LIST_START_DELETE_8028:
+		return MLOG_COMP_LIST_START_DELETE
+	case MLOG_LIST_END_COPY_CREATED_8028:
+		return MLOG_COMP_LIST_END_COPY_CREATED
+	case MLOG_PAGE_REORGANIZE_8028:
+		return MLOG_COMP_PAGE_REORGANIZE
+	case MLOG_ZIP_PAGE_REORGANIZE_8028:
+		return MLOG_ZIP_PAGE_REORGANIZE
+	case MLOG_ZIP_PAGE_COMPRESS_NO_DATA_8028:
+		return MLOG_ZIP_PAGE_COMPRESS_NO_DATA
+	case MLOG_REC_UPDATE_IN_PLACE_8028:
+		return MLOG_COMP_REC_UPDATE_IN_PLACE
+	}
+	return mlog_type
+}
+
+// mlog_parse_index建的索引n_uniq和n不一样的时候是聚簇索引
+func (index *LogIndex) Is_Clustered() bool {
+	return index.N_uniq != uint64(len(index.Fields))
+}
+
+// dict_index_get_n_unique_in_tree_nonleaf: 节点指针记录中子页号前面的字段数
+func (index *LogIndex) N_Node_Ptr_Fields() uint64 {
+	if index.Is_Clustered() && index.N_uniq < uint64(len(index.Fields)) {
+		return index.N_uniq
+	}
+	return uint64(len(index.Fields))
+}
+
+// 类型带的索引信息, 不带索引信息的类型返回nil
+// 老格式(8.0.27以前)只有COMP类型有字段信息, 8.0.28以后的类型都有log version和flags
+func (reader *MlogReader) Read_Log_Index(mlog_type uint64) *LogIndex {
+	switch mlog_type {
+	case MLOG_REC_INSERT, MLOG_REC_CLUST_DELETE_MARK, MLOG_REC_SEC_DELETE_MARK, MLOG_REC_UPDATE_IN_PLACE,
+		MLOG_REC_DELETE, MLOG_LIST_END_DELETE, MLOG_LIST_START_DELETE, MLOG_LIST_END_COPY_CREATED:
+		return &LogIndex{}
+	case MLOG_COMP_REC_INSERT, MLOG_COMP_REC_CLUST_DELETE_MARK, MLOG_COMP_REC_SEC_DELETE_MARK, MLOG_COMP_REC_UPDATE_IN_PLACE,
+		MLOG_COMP_REC_DELETE, MLOG_COMP_LIST_END_DELETE, MLOG_COMP_LIST_START_DELETE, MLOG_COMP_LIST_END_COPY_CREATED,
+		MLOG_COMP_PAGE_REORGANIZE, MLOG_ZIP_PAGE_REORGANIZE, MLOG_ZIP_PAGE_COMPRESS_NO_DATA:
+		// n(2) [instant ADD之前的字段数(2)] n_uniq(2) 每个字段(2)
+		index := &LogIndex{Comp: true}
+		n := reader.Read_Uint(2)
+		if n&INDEX_LOG_INSTANT_N != 0 {
+			n &^= INDEX_LOG_INSTANT_N
+			index.Instant_cols = reader.Read_Uint(2)
+		}
+		index.N_uniq = reader.Read_Uint(2)
+		index.Fields = reader.Read_Index_Fields(n)
+		return index
+	}
+	if Unversioned_Type(mlog_type) == mlog_type {
+		return nil
+	}
+	// log version(1) flags(1), 紧凑格式或者有row version的时候:
+	// [instant ADD之前的字段数(2)] n(2) n_uniq(2) 每个字段(2), 有row version的时候
+	// 后面是物理位置变了的字段数(2), 每个字段: 在索引中的位置(2) 物理位置(2) 加入和删除时的row version(1+1)
+	reader.Read_Uint(1)
+	flags := reader.Read_Uint(1)
+	index := &LogIndex{Comp: flags&INDEX_LOG_COMPACT != 0, Versioned: flags&INDEX_LOG_VERSIONED != 0}
+	if !index.Comp && !index.Versioned {
+		return index
+	}
+	if flags&INDEX_LOG_INSTANT != 0 {
+		index.Instant_cols = reader.Read_Uint(2)
+	}
+	n := reader.Read_Uint(2)
+	index.N_uniq = reader.Read_Uint(2)
+	index.Fields = reader.Read_Index_Fields(n)
+	if index.Versioned {
+		reader.Read_Bytes(reader.Read_Uint(2) * (2 + 2 + 1 + 1))
+	}
+	return index
+}
+
+// 每个字段2个字节: 最高位是NOT NULL, 低15位是定长字段的长度, 0或者0x7FFF是变长字段
+func (reader *MlogReader) Read_Index_Fields(n uint64) []IndexFieldInfo {
+	fields := make([]IndexFieldInfo, n)
+	for i := range fields {
+		length := reader.Read_Uint(2)
+		fields[i].Not_null = length&0x8000 != 0
+		length = length & 0x7FFF
+		if length == 0x7FFF {
+			fields[i].Big = true
+		} else {
+			fields[i].Fixed_len = length
+		}
+	}
+	return fields
+}
+
+// 8.0以后MLOG_FILE_CREATE(33)带flags, 5.6没有
+func Is_Format_8_0(format uint64) bool {
+	return format > LOG_HEADER_FORMAT_5_7_9
+}
+
+// 解析pos开始的一条记录, 返回记录和它的长度
+func Parse_Record(data []byte, pos uint64, format uint64) (record *LogRecord, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok && e == ErrTruncated {
+				record, err = nil, ErrTruncated
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	reader := NewMlogReader(data, pos)
+	type_byte := reader.Read_Uint(1)
+	record = &LogRecord{Type: type_byte &^ MLOG_SINGLE_REC_FLAG, Single: type_byte&MLOG_SINGLE_REC_FLAG != 0}
+	record.Type_name = MLOG_TYPES[record.Type]
+
+	switch record.Type {
+	case MLOG_MULTI_REC_END, MLOG_DUMMY_RECORD:
+		record.Length = 1
+		return record, nil
+	case MLOG_CHECKPOINT:
+		record.Body = reader.Read_Bytes(8)
+		record.Length = reader.Pos - pos
+		return record, nil
+	case MLOG_TABLE_DYNAMIC_META:
+		// 头部是表id和版本, 后面是长度(2)和持久化的元数据, MetadataRecover::parseMetadataLog
+		record.Table_id = reader.Read_U64_Much_Compressed()
+		record.Version = reader.Read_U64_Much_Compressed()
+		record.Body = reader.Read_Bytes(reader.Read_Uint(2))
+		record.Length = reader.Pos - pos
+		meta, err := Parse_Dynamic_Metadata(record.Body)
+		if err != nil {
+			return nil, err
+		}
+		record.Dynamic_meta = meta
+		return record, nil
+	case MLOG_TEST:
+		// 头部不是space id和page no
+		return nil, ErrUnsupported
+	}
+	if record.Type_name == "" {
+		return nil, ErrUnsupported
+	}
+
+	record.Space_id = reader.Read_Compressed()
+	record.Page_number = reader.Read_Compressed()
+	body_start := reader.Pos
+	if err := reader.Skip_Body(record.Type, format); err != nil {
+		return nil, err
+	}
+	record.Body = data[body_start:reader.Pos]
+	record.Length = reader.Pos - pos
+	return record, nil
+}
+
+// 跳过各类型记录的内容, 对应recv_parse_or_apply_log_rec_body中各个parse函数
+func (reader *MlogReader) Skip_Body(mlog_type uint64, format uint64) error {
+	reader.Read_Log_Index(mlog_type)
+	switch Unversioned_Type(mlog_type) {
+	case MLOG_1BYTE, MLOG_2BYTES, MLOG_4BYTES:
+		reader.Read_Uint(2)
+		reader.Read_Compressed()
+	case MLOG_8BYTES:
+		reader.Read_Uint(2)
+		reader.Read_U64_Compressed()
+	case MLOG_WRITE_STRING:
+		reader.Read_Uint(2)
+		reader.Read_Bytes(reader.Read_Uint(2))
+
+	case MLOG_REC_INSERT, MLOG_COMP_REC_INSERT:
+		reader.Read_Uint(2)
+		reader.Skip_Insert()
+	case MLOG_REC_CLUST_DELETE_MARK, MLOG_COMP_REC_CLUST_DELETE_MARK:
+		reader.Read_Bytes(2) // flags, val
+		reader.Skip_Sys_Vals()
+		reader.Read_Uint(2)
+	case MLOG_REC_SEC_DELETE_MARK, MLOG_COMP_REC_SEC_DELETE_MARK:
+		reader.Read_Bytes(1)
+		reader.Read_Uint(2)
+	case MLOG_REC_UPDATE_IN_PLACE, MLOG_COMP_REC_UPDATE_IN_PLACE:
+		reader.Read_Bytes(1)
+		reader.Skip_Sys_Vals()
+		reader.Read_Uint(2)
+		reader.Skip_Update()
+	case MLOG_REC_DELETE, MLOG_COMP_REC_DELETE,
+		MLOG_LIST_END_DELETE, MLOG_COMP_LIST_END_DELETE,
+		MLOG_LIST_START_DELETE, MLOG_COMP_LIST_START_DELETE:
+		reader.Read_Uint(2)
+	case MLOG_LIST_END_COPY_CREATED, MLOG_COMP_LIST_END_COPY_CREATED:
+		reader.Read_Bytes(reader.Read_Uint(4))
+	case MLOG_PAGE_REORGANIZE, MLOG_COMP_PAGE_REORGANIZE:
+	case MLOG_ZIP_PAGE_REORGANIZE, MLOG_ZIP_PAGE_COMPRESS_NO_DATA:
+		reader.Read_Bytes(1) // level
+	case MLOG_REC_MIN_MARK, MLOG_COMP_REC_MIN_MARK:
+		reader.Read_Uint(2)
+
+	case MLOG_PAGE_CREATE, MLOG_COMP_PAGE_CREATE, MLOG_PAGE_CREATE_RTREE, MLOG_COMP_PAGE_CREATE_RTREE,
+		MLOG_PAGE_CREATE_SDI, MLOG_INIT_FILE_PAGE, MLOG_INIT_FILE_PAGE2, MLOG_IBUF_BITMAP_INIT,
+		MLOG_UNDO_ERASE_END, MLOG_UNDO_HDR_DISCARD:
+
+	case MLOG_UNDO_INSERT:
+		reader.Read_Bytes(reader.Read_Uint(2))
+	case MLOG_UNDO_INIT:
+		reader.Read_Compressed()
+	case MLOG_UNDO_HDR_REUSE, MLOG_UNDO_HDR_CREATE:
+		reader.Read_U64_Compressed()
+
+	case MLOG_ZIP_WRITE_NODE_PTR:
+		reader.Read_Bytes(2 + 2 + 4)
+	case MLOG_ZIP_WRITE_BLOB_PTR:
+		reader.Read_Bytes(2 + 2 + 20)
+	case MLOG_ZIP_WRITE_HEADER:
+		reader.Read_Bytes(1)
+		reader.Read_Bytes(reader.Read_Uint(1))
+	case MLOG_ZIP_PAGE_COMPRESS:
+		size := reader.Read_Uint(2)
+		trailer_size := reader.Read_Uint(2)
+		reader.Read_Bytes(8 + size + trailer_size)
+
+	case MLOG_FILE_NAME, MLOG_FILE_DELETE:
+		reader.Read_Bytes(reader.Read_Uint(2))
+	case MLOG_FILE_CREATE:
+		if Is_Format_8_0(format) {
+			reader.Read_Bytes(4)
+		}
+		reader.Read_Bytes(reader.Read_Uint(2))
+	case MLOG_FILE_CREATE2:
+		reader.Read_Bytes(4)
+		reader.Read_Bytes(reader.Read_Uint(2))
+	case MLOG_FILE_RENAME, MLOG_FILE_RENAME2:
+		reader.Read_Bytes(reader.Read_Uint(2))
+		reader.Read_Bytes(reader.Read_Uint(2))
+	case MLOG_FILE_EXTEND:
+		reader.Read_Bytes(8 + 8)
+	case MLOG_TRUNCATE, MLOG_INDEX_LOAD:
+		reader.Read_Bytes(8)
+	default:
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// 持久化的元数据: 每一项是类型(1)加内容, 损坏的索引: 个数(1) 每个索引space id(压缩) index id(much压缩), 自增值: much压缩
+func Parse_Dynamic_Metadata(data []byte) (meta *DynamicMetadata, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok && e == ErrTruncated {
+				meta, err = nil, errors.New("dynamic metadata is truncated")
+				return
+			}
+			panic(r)
+		}
+	}()
+	meta = &DynamicMetadata{}
+	reader := NewMlogReader(data, 0)
+	for reader.Pos < uint64(len(data)) {
+		switch reader.Read_Uint(1) {
+		case PM_INDEX_CORRUPTED:
+			n := reader.Read_Uint(1)
+			for i := uint64(0); i < n; i++ {
+				space_id := reader.Read_Compressed()
+				meta.Corrupted_indexes = append(meta.Corrupted_indexes, CorruptedIndex{Space_id: space_id, Index_id: reader.Read_U64_Much_Compressed()})
+			}
+		case PM_TABLE_AUTO_INC:
+			autoinc := reader.Read_U64_Much_Compressed()
+			meta.Autoinc = &autoinc
+		default:
+			return nil, fmt.Errorf("unknown dynamic metadata type %d", data[reader.Pos-1])
+		}
+	}
+	return meta, nil
+}
+
+// page_cur_parse_insert_rec: end_seg_len最低位是1的时候带info bits, origin offset和mismatch index
+func (reader *MlogReader) Skip_Insert() {
+	end_seg_len := reader.Read_Compressed()
+	if end_seg_len&0x1 != 0 {
+		reader.Read_Bytes(1)
+		reader.Read_Compressed()
+		reader.Read_Compressed()
+	}
+	reader.Read_Bytes(end_seg_len >> 1)
+}
+
+// row_upd_parse_sys_vals: pos roll_ptr(7) trx_id
+func (reader *MlogReader) Skip_Sys_Vals() {
+	reader.Read_Compressed()
+	reader.Read_Bytes(7)
+	reader.Read_U64_Compressed()
+}
+
+// row_upd_index_parse: info_bits(1) n_fields, 每个字段 field_no len data
+func (reader *MlogReader) Skip_Update() {
+	reader.Read_Bytes(1)
+	n_fields := reader.Read_Compressed()
+	for i := uint64(0); i < n_fields; i++ {
+		reader.Read_Compressed()
+		length := reader.Read_Compressed()
+		if length != UNIV_SQL_NULL {
+			reader.Read_Bytes(length)
+		}
+	}
+}
+
+// start_lsn到end_lsn(0表示到日志结尾)之间的所有日志记录
+// start_lsn要是一个mtr的开始(比如checkpoint lsn), 否则从下一个mtr开始解析
+func (log *Log) Each_Record(start_lsn uint64, end_lsn uint64) []*LogRecord {
+	blocks := log.Each_Block(start_lsn, end_lsn)
+	if len(blocks) == 0 {
+		return nil
+	}
+	format := log.Files[0].Header.Format
+
+	//所有block的数据部分连起来, 第i个block的数据从i*LOG_BLOCK_DATA_SIZE开始
+	var stream []byte
+	for _, block := range blocks {
+		payload := block.Payload()
+		stream = append(stream, payload...)
+		if uint64(len(payload)) < LOG_BLOCK_DATA_SIZE {
+			break
+		}
+	}
+	first_lsn := blocks[0].Lsn
+	stream_lsn := func(pos uint64) uint64 {
+		return first_lsn + pos/LOG_BLOCK_DATA_SIZE*OS_FILE_LOG_BLOCK_SIZE + LOG_BLOCK_HDR_SIZE + pos%LOG_BLOCK_DATA_SIZE
+	}
+	//下一个有mtr开始的block
+	next_group := func(pos uint64) (uint64, bool) {
+		for i := pos/LOG_BLOCK_DATA_SIZE + 1; i < uint64(len(blocks)); i++ {
+			if blocks[i].First_rec_group >= LOG_BLOCK_HDR_SIZE {
+				return i*LOG_BLOCK_DATA_SIZE + blocks[i].First_rec_group - LOG_BLOCK_HDR_SIZE, true
+			}
+		}
+		return 0, false
+	}
+
+	var pos uint64
+	if in_block := start_lsn - first_lsn; in_block >= LOG_BLOCK_HDR_SIZE {
+		pos = in_block - LOG_BLOCK_HDR_SIZE
+	} else if blocks[0].First_rec_group >= LOG_BLOCK_HDR_SIZE {
+		pos = blocks[0].First_rec_group - LOG_BLOCK_HDR_SIZE
+	} else {
+		var ok bool
+		if pos, ok = next_group(0); !ok {
+			return nil
+		}
+	}
+
+	var records []*LogRecord
+	for pos < uint64(len(stream)) {
+		lsn := stream_lsn(pos)
+		if end_lsn != 0 && lsn >= end_lsn {
+			break
+		}
+		record, err := Parse_Record(stream, pos, format)
+		if err == nil {
+			record.Lsn = lsn
+			record.End_lsn = stream_lsn(pos + record.Length)
+			records = append(records, record)
+			pos = pos + record.Length
+			continue
+		}
+		next, ok := next_group(pos)
+		if !ok {
+			if err != ErrTruncated {
+				mlog_type := uint64(stream[pos]) &^ MLOG_SINGLE_REC_FLAG
+				records = append(records, &LogRecord{Lsn: lsn, Type: mlog_type, Type_name: MLOG_TYPES[mlog_type], Error: err.Error()})
+			}
+			//日志写到这里就结束了
+			break
+		}
+		mlog_type := uint64(stream[pos]) &^ MLOG_SINGLE_REC_FLAG
+		records = append(records, &LogRecord{Lsn: lsn, Type: mlog_type, Type_name: MLOG_TYPES[mlog_type], End_lsn: stream_lsn(next), Length: next - pos, Error: err.Error()})
+		pos = next
+	}
+	return records
+}
+
+func (record *LogRecord) Dump() {
+	println("log record:")
+
+	data, _ := json.Marshal(record)
+	outStr := pretty.Pretty(data)
+	fmt.Printf("%s\n", outStr)
+}
@@ -0,0 +1,79 @@
+package redo
+
+import "testing"
+
+// 8.0.28以后的MLOG_REC_INSERT_8028, 索引信息带instant ADD之前的字段数和物理位置变了的字段
+func TestParseVersionedInsert(t *testing.T) {
+	b := []byte{
+		MLOG_REC_INSERT_8028 | MLOG_SINGLE_REC_FLAG,
+		0x05, 0x04, // space id, page no
+		0x01, // log version
+		INDEX_LOG_COMPACT | INDEX_LOG_VERSIONED | INDEX_LOG_INSTANT, // flags
+		0x00, 0x02, // instant ADD之前的字段数
+		0x00, 0x03, // n
+		0x00, 0x01, // n_uniq
+		0x80, 0x04, 0x80, 0x06, 0x7F, 0xFF, // id int NOT NULL, DB_TRX_ID, 变长字段
+		0x00, 0x01, // 物理位置变了的字段数
+		0x00, 0x02, 0x00, 0x03, 0x01, 0x00,
+		0x00, 0x70, // cursor rec
+		0x04, 0xAA, 0xBB, // end_seg_len, 数据
+	}
+	record, err := Parse_Record(append(b, MLOG_MULTI_REC_END), 0, LOG_HEADER_FORMAT_8_0_30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Length != uint64(len(b)) || record.Space_id != 5 || record.Page_number != 4 {
+		t.Fatalf("got length %d space %d page %d", record.Length, record.Space_id, record.Page_number)
+	}
+	index := NewMlogReader(record.Body, 0).Read_Log_Index(record.Type)
+	if !index.Comp || !index.Versioned || index.Instant_cols != 2 || index.N_uniq != 1 || len(index.Fields) != 3 {
+		t.Fatalf("got index %+v", index)
+	}
+	if !index.Fields[2].Big || index.Fields[0].Fixed_len != 4 || !index.Fields[0].Not_null {
+		t.Fatalf("got fields %+v", index.Fields)
+	}
+	if Unversioned_Type(record.Type) != MLOG_COMP_REC_INSERT {
+		t.Fatalf("got unversioned type %d", Unversioned_Type(record.Type))
+	}
+}
+
+// 8.0.12~8.0.27的COMP索引信息, n的最高位表示后面有instant ADD之前的字段数
+func TestParseInstantCompIndex(t *testing.T) {
+	b := []byte{
+		0x80, 0x02, // n, 带INDEX_LOG_INSTANT_N
+		0x00, 0x01, // instant ADD之前的字段数
+		0x00, 0x01, // n_uniq
+		0x80, 0x04, 0x00, 0x00,
+	}
+	reader := NewMlogReader(b, 0)
+	index := reader.Read_Log_Index(MLOG_COMP_REC_DELETE)
+	if reader.Pos != uint64(len(b)) || index.Instant_cols != 1 || len(index.Fields) != 2 || index.Fields[1].Not_null {
+		t.Fatalf("got index %+v pos %d", index, reader.Pos)
+	}
+}
+
+func TestParseDynamicMeta(t *testing.T) {
+	b := []byte{
+		MLOG_TABLE_DYNAMIC_META | MLOG_SINGLE_REC_FLAG,
+		0x81, 0x2C, // table id 300
+		0x03,       // version
+		0x00, 0x00, // 长度, 后面填
+		PM_INDEX_CORRUPTED, 0x01, 0x05, 0xFF, 0x01, 0x07, // space 5, index 0x100000007
+		PM_TABLE_AUTO_INC, 0x80, 0xC8, // 200
+	}
+	b[5] = byte(len(b) - 6)
+	record, err := Parse_Record(b, 0, LOG_HEADER_FORMAT_8_0_30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Length != uint64(len(b)) || record.Table_id != 300 || record.Version != 3 || record.Has_Page() {
+		t.Fatalf("got record %+v", record)
+	}
+	meta := record.Dynamic_meta
+	if len(meta.Corrupted_indexes) != 1 || meta.Corrupted_indexes[0] != (CorruptedIndex{Space_id: 5, Index_id: 0x100000007}) {
+		t.Fatalf("got corrupted indexes %+v", meta.Corrupted_indexes)
+	}
+	if meta.Autoinc == nil || *meta.Autoinc != 200 {
+		t.Fatalf("got autoinc %v", meta.Autoinc)
+	}
+}